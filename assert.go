@@ -0,0 +1,181 @@
+package exifremover
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// structure is one top-level segment or chunk of an image file
+type structure struct {
+	Kind string // segment or chunk name, e.g. "APP1", "COM", "SOS", "tEXt"
+	Data []byte // payload, or for a JPEG "SOS" everything from the marker on
+}
+
+// jpegSegmentName returns the conventional name of a JPEG marker
+func jpegSegmentName(marker byte) string {
+	switch {
+	case marker >= 0xE0 && marker <= 0xEF:
+		return fmt.Sprintf("APP%d", marker-0xE0)
+	case marker >= 0xD0 && marker <= 0xD7:
+		return fmt.Sprintf("RST%d", marker-0xD0)
+	case isSOF(marker):
+		return fmt.Sprintf("SOF%d", marker-0xC0)
+	}
+	switch marker {
+	case 0xC4:
+		return "DHT"
+	case 0xCC:
+		return "DAC"
+	case 0xD8:
+		return "SOI"
+	case 0xD9:
+		return "EOI"
+	case 0xDA:
+		return "SOS"
+	case 0xDB:
+		return "DQT"
+	case 0xDD:
+		return "DRI"
+	case 0xFE:
+		return "COM"
+	}
+	return fmt.Sprintf("0x%02X", marker)
+}
+
+// jpegStructure lists the segments of a JPEG up to and including the first
+// scan, which holds the rest of the file
+func jpegStructure(data []byte) []structure {
+	var items []structure
+	pos := 2 // SOI
+	for pos+1 < len(data) {
+		if data[pos] != 0xFF {
+			return append(items, structure{"garbage", data[pos:]})
+		}
+		marker := data[pos+1]
+		switch {
+		case marker == 0xFF: // fill byte
+			pos++
+			continue
+		case marker == 0xDA:
+			return append(items, structure{"SOS", data[pos:]})
+		case marker == 0x01 || marker == 0xD9 || (marker >= 0xD0 && marker <= 0xD7):
+			items = append(items, structure{jpegSegmentName(marker), nil})
+			pos += 2
+			continue
+		}
+		if pos+4 > len(data) {
+			return append(items, structure{"garbage", data[pos:]})
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:pos+4]))
+		if end > len(data) || end < pos+4 {
+			return append(items, structure{"garbage", data[pos:]})
+		}
+		items = append(items, structure{jpegSegmentName(marker), data[pos+4 : end]})
+		pos = end
+	}
+	return items
+}
+
+// pngStructure lists the chunks of a PNG. A chunk with an invalid type or
+// running past the end is listed as garbage up to the next chunk whose CRC
+// checks out, where Salvage resynchronizes too.
+func pngStructure(data []byte) []structure {
+	var items []structure
+	pos := 8 // signature
+	for pos+12 <= len(data) {
+		length := int64(binary.BigEndian.Uint32(data[pos : pos+4]))
+		end := int64(pos) + 12 + length
+		if end > int64(len(data)) || !validChunkType(data[pos+4:pos+8]) {
+			next := nextPNGChunk(data, pos+1)
+			items = append(items, structure{"garbage", data[pos:next]})
+			pos = next
+			continue
+		}
+		items = append(items, structure{string(data[pos+4 : pos+8]), data[pos+8 : end-4]})
+		pos = int(end)
+	}
+	if pos < len(data) {
+		items = append(items, structure{"garbage", data[pos:]})
+	}
+	return items
+}
+
+// nextPNGChunk returns the offset of the first chunk at or after pos with
+// a valid type and CRC, or len(data) if there is none
+func nextPNGChunk(data []byte, pos int) int {
+	for ; pos+12 <= len(data); pos++ {
+		length := binary.BigEndian.Uint32(data[pos:])
+		if length > uint32(len(data)-pos-12) || !validChunkType(data[pos+4:pos+8]) {
+			continue
+		}
+		end := pos + 8 + int(length)
+		if crc32.ChecksumIEEE(data[pos+4:end]) == binary.BigEndian.Uint32(data[end:]) {
+			return pos
+		}
+	}
+	return len(data)
+}
+
+// errMetadataAdded is returned by the AssertNoAdditions check
+var errMetadataAdded = errors.New("output contains a structure not present in the input")
+
+// checkNoAdditions verifies that every segment or chunk of output is either
+// one of the input's, kept in order and no larger than the original, or a
+// structure config explicitly asked the library to synthesize.
+func checkNoAdditions(f Format, input, output []byte, config Config) error {
+	var in, out []structure
+	switch f {
+	case FormatJPEG:
+		in, out = jpegStructure(input), jpegStructure(output)
+	case FormatPNG:
+		in, out = pngStructure(input), pngStructure(output)
+	default:
+		return nil
+	}
+
+	i := 0
+	for _, o := range out {
+		if synthesized(o, config) {
+			continue
+		}
+		for i < len(in) && !derivedFrom(o, in[i], config) {
+			i++
+		}
+		if i == len(in) {
+			return fmt.Errorf("%w: %s", errMetadataAdded, o.Kind)
+		}
+		i++
+	}
+	return nil
+}
+
+// synthesized reports whether a structure is one the library writes itself
+// when config asks for it
+func synthesized(o structure, config Config) bool {
+	switch o.Kind {
+	case "COM", "tEXt":
+		return config.StampProcessed && isStamp(o.Data)
+	case "IEND":
+		return config.RepairStructure
+	}
+	return false
+}
+
+// derivedFrom reports whether an output structure can be the processed form
+// of an input one. Edits happen in place, so sizes may only shrink, with two
+// exceptions: a repaired scan gains its EOI and a scrubbed iCCP profile is
+// recompressed.
+func derivedFrom(o, in structure, config Config) bool {
+	if o.Kind != in.Kind {
+		return false
+	}
+	switch {
+	case o.Kind == "SOS" && config.RepairStructure:
+		return len(o.Data) <= len(in.Data)+2
+	case o.Kind == "iCCP" && scrubICC(config):
+		return true
+	}
+	return len(o.Data) <= len(in.Data)
+}
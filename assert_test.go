@@ -0,0 +1,50 @@
+package exifremover
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+func TestCheckNoAdditions(t *testing.T) {
+	jpeg := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	png := fixture.WithChunks(fixture.PNG(4, 4), fixture.Chunk("tEXt", []byte("Comment\x00text")))
+	stamp := stampText(Config{})
+
+	cases := []struct {
+		name          string
+		input, output []byte
+		config        Config
+		added         bool
+	}{
+		{"identity", jpeg, jpeg, Config{}, false},
+		{"removed segment", jpeg, fixture.JPEG(16, 8), Config{}, false},
+		{"injected segment", jpeg, fixture.WithSegment(jpeg, 0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00")), Config{}, true},
+		{"grown segment", fixture.WithSegment(fixture.JPEG(16, 8), 0xFE, []byte("short")), fixture.WithSegment(fixture.JPEG(16, 8), 0xFE, []byte("much longer")), Config{}, true},
+		{"unrequested stamp", jpeg, fixture.WithSegment(jpeg, 0xFE, stamp), Config{}, true},
+		{"requested stamp", jpeg, fixture.WithSegment(jpeg, 0xFE, stamp), Config{StampProcessed: true}, false},
+		{"injected chunk", png, fixture.WithChunks(png, fixture.Chunk("iTXt", []byte("Author\x00\x00\x00\x00\x00x"))), Config{}, true},
+		{"reordered chunks", fixture.WithChunks(fixture.PNG(4, 4), fixture.Chunk("tEXt", []byte("a\x00b")), fixture.Chunk("tIME", make([]byte, 7))),
+			fixture.WithChunks(fixture.PNG(4, 4), fixture.Chunk("tIME", make([]byte, 7)), fixture.Chunk("tEXt", []byte("a\x00b"))), Config{}, true},
+	}
+	for _, c := range cases {
+		err := checkNoAdditions(detectFormat(c.input), c.input, c.output, c.config)
+		if added := errors.Is(err, errMetadataAdded); added != c.added {
+			t.Errorf("%s: err %v, want added %v", c.name, err, c.added)
+		}
+	}
+}
+
+func TestAssertNoAdditionsOption(t *testing.T) {
+	in := fixture.WithSegment(fixture.EXIFJPEG(fixture.Sample().Bytes()), 0xFE, []byte("comment"))
+	for _, config := range []Config{
+		{AssertNoAdditions: true, RemoveAll: true},
+		{AssertNoAdditions: true, RemoveGPSInfo: true, StampProcessed: true},
+		{AssertNoAdditions: true, RemoveComments: true, RepairStructure: true},
+	} {
+		if _, err := RemoveEXIFFromBytes(in, config); err != nil {
+			t.Errorf("%+v: %v", config, err)
+		}
+	}
+}
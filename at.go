@@ -0,0 +1,61 @@
+package exifremover
+
+import (
+	"errors"
+	"io"
+)
+
+// RemoveEXIFAt sanitizes the size-byte image readable from src and writes
+// the result to dst starting at offset 0, returning the number of bytes
+// written. It suits storage exposing positional IO, such as database BLOB
+// handles, without copying the image into a file first. In-place edits keep
+// the output no larger than the input; if an option makes it grow past size
+// (a stamp, a repaired EOI), RemoveEXIFAt fails rather than writing beyond
+// the destination.
+//
+// An *os.File works as either side:
+//
+//	tmp, _ := os.CreateTemp("", "sanitized")
+//	n, err := exifremover.RemoveEXIFAt(blob, blobSize, tmp, config)
+//	// tmp now holds n sanitized bytes
+func RemoveEXIFAt(src io.ReaderAt, size int64, dst io.WriterAt, config Config) (int64, error) {
+	s, err := New(config)
+	if err != nil {
+		return 0, err
+	}
+	return s.RemoveAt(src, size, dst)
+}
+
+// RemoveAt is RemoveEXIFAt with the Sanitizer's Config
+func (s *Sanitizer) RemoveAt(src io.ReaderAt, size int64, dst io.WriterAt) (int64, error) {
+	if s.config.UseMmap {
+		return 0, &OptionsError{"UseMmap", "stream input"}
+	}
+	header := make([]byte, 12)
+	n, err := src.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	w := &sectionWriter{dst: dst, limit: size}
+	if _, err := process(io.NewSectionReader(src, 0, size), header[:n], w, s.config); err != nil {
+		return w.off, err
+	}
+	return w.off, nil
+}
+
+// sectionWriter writes sequentially to a WriterAt, refusing to pass limit
+type sectionWriter struct {
+	dst   io.WriterAt
+	off   int64
+	limit int64
+}
+
+func (w *sectionWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.limit-w.off {
+		return 0, errors.New("sanitized output larger than destination")
+	}
+	n, err := w.dst.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
@@ -0,0 +1,50 @@
+package exifremover
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+func TestRemoveEXIFAt(t *testing.T) {
+	in := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	// An override makes Model a GPS tag, which only a resolved Config knows
+	config := Config{
+		RemoveGPSInfo:          true,
+		CategoryOverrides:      map[Category][]uint16{CategoryGPSInfo: {0x0110}},
+		MergeCategoryOverrides: true,
+	}
+	dst, err := os.Create(filepath.Join(t.TempDir(), "out.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	n, err := RemoveEXIFAt(bytes.NewReader(in), int64(len(in)), dst, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(dst.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := sanitize(t, in, config)
+	if int64(len(out)) != n || !bytes.Equal(out, want) {
+		t.Errorf("RemoveEXIFAt wrote %d bytes differing from RemoveEXIFFromBytes", n)
+	}
+	if m := inspect(t, out); m.hasTag("IFD0", 0x0110) {
+		t.Error("CategoryOverrides not applied")
+	}
+}
+
+func TestRemoveEXIFAtValidates(t *testing.T) {
+	in := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	_, err := RemoveEXIFAt(bytes.NewReader(in), int64(len(in)), nil, Config{UseMmap: true})
+	if !errors.Is(err, ErrIncompatibleOptions) {
+		t.Errorf("UseMmap: err %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// gainMapJPEG is a secondary image whose XMP marks it as an HDR gain map
+func gainMapJPEG() []byte {
+	return fixture.WithSegment(fixture.JPEG(8, 8), 0xE1, fixture.XMP(`xmlns:hdrgm="http://ns.adobe.com/hdr-gain-map/1.0/" hdrgm:Version="1.0"`, ""))
+}
+
+// portraitJPEG returns a JPEG with a depth map, a gain map and a preview
+// listed in its MPF index, returning the secondary images too
+func portraitJPEG() (data []byte, images []fixture.MPImage) {
+	images = []fixture.MPImage{
+		{Type: 0x020002, Data: fixture.JPEG(4, 4)},
+		{Data: gainMapJPEG()},
+		{Type: 0x010001, Data: fixture.JPEG(6, 6)},
+	}
+	return fixture.MPF(fixture.JPEG(16, 8), images...), images
+}
+
+// removedSizes returns the sizes of the removed auxiliary images by name
+func removedSizes(report *Report) map[string]int64 {
+	sizes := make(map[string]int64)
+	for _, item := range report.Removed {
+		if item.Carrier == CarrierAuxiliaryImage {
+			sizes[item.Name] += item.Size
+		}
+	}
+	return sizes
+}
+
+func TestMPFImagesRemovedByRole(t *testing.T) {
+	in, images := portraitJPEG()
+	out, report := sanitize(t, in, Config{RemoveAuxiliaryImages: true})
+
+	sizes := removedSizes(report)
+	for name, image := range map[string]fixture.MPImage{"MPF depth map": images[0], "MPF gain map": images[1], "MPF preview": images[2]} {
+		if sizes[name] != int64(len(image.Data)) {
+			t.Errorf("%s: removed %d bytes, want %d", name, sizes[name], len(image.Data))
+		}
+	}
+	if sizes["MPF index"] == 0 {
+		t.Error("MPF index not reported")
+	}
+	if _, ok := sizes["data after the primary image"]; ok {
+		t.Error("data outside the listed images reported")
+	}
+	if bytes.Contains(out, mpfPrefix) || bytes.Count(out, []byte{0xFF, 0xD8}) != 1 || !bytes.HasSuffix(out, []byte{0xFF, 0xD9}) {
+		t.Error("output holds more than the primary image")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("primary image no longer decodes: %v", err)
+	}
+}
+
+// TestMPFRemovedSizes checks that every auxiliary removal carries its size
+// and that they add up to what left the file: the images and the index
+// segment, less that segment's marker and length
+func TestMPFRemovedSizes(t *testing.T) {
+	in, _ := portraitJPEG()
+	out, report := sanitize(t, in, Config{RemoveAuxiliaryImages: true})
+	var total int64
+	for _, item := range report.Removed {
+		if item.Carrier != CarrierAuxiliaryImage {
+			continue
+		}
+		if item.Size <= 0 {
+			t.Errorf("%s removed with size %d", item.Name, item.Size)
+		}
+		total += item.Size
+	}
+	if want := int64(len(in)-len(out)) - 4; total != want {
+		t.Errorf("removals add up to %d bytes, want %d", total, want)
+	}
+}
+
+func TestMPFPreserveGainMaps(t *testing.T) {
+	in, images := portraitJPEG()
+	out, report := sanitize(t, in, Config{RemoveAuxiliaryImages: true, PreserveGainMaps: true, RemoveCameraInfo: true})
+
+	sizes := removedSizes(report)
+	if _, ok := sizes["MPF gain map"]; ok {
+		t.Error("gain map removed")
+	}
+	if sizes["MPF depth map"] == 0 || sizes["MPF preview"] == 0 {
+		t.Errorf("other images kept: %v", sizes)
+	}
+
+	at := bytes.Index(out, mpfPrefix)
+	if at < 0 {
+		t.Fatal("MPF index dropped")
+	}
+	index := parseMPF(out[at:])
+	if len(index.images) != 2 {
+		t.Fatalf("index lists %d images, want 2", len(index.images))
+	}
+	primary, gainMap := index.images[0], index.images[1]
+	if int(primary.Size) != bytes.Index(out, images[1].Data) {
+		t.Errorf("primary size %d doesn't end at the gain map", primary.Size)
+	}
+	start := at + len(mpfPrefix) + int(gainMap.Offset)
+	if start+int(gainMap.Size) != len(out) || !bytes.Equal(out[start:], images[1].Data) {
+		t.Errorf("gain map entry at %d+%d doesn't match the output", start, gainMap.Size)
+	}
+}
+
+func TestMPFIndexMismatchCutsAtEOI(t *testing.T) {
+	in, _ := portraitJPEG()
+	// Point the primary's size into the middle of its scan
+	at := bytes.Index(in, mpfPrefix)
+	index := parseMPF(in[at:])
+	in = append([]byte(nil), in...)
+	index.order.PutUint32(in[at+index.entries+4:], 100)
+
+	out, report := sanitize(t, in, Config{RemoveAuxiliaryImages: true})
+	if sizes := removedSizes(report); sizes["data after the primary image"] == 0 {
+		t.Errorf("trailing images not reported: %v", sizes)
+	}
+	if bytes.Count(out, []byte{0xFF, 0xD8}) != 1 || !bytes.HasSuffix(out, []byte{0xFF, 0xD9}) {
+		t.Error("output holds more than the primary image")
+	}
+}
+
+func TestAuxiliaryXMPRemoved(t *testing.T) {
+	xmp := fixture.XMP(
+		`xmlns:GDepth="http://ns.google.com/photos/1.0/depthmap/" GDepth:Format="RangeInverse" GDepth:Data="REVQVEgtREFUQQ==" `+
+			`xmlns:hdrgm="http://ns.adobe.com/hdr-gain-map/1.0/" hdrgm:Version="1.0" dc:format="image/jpeg"`,
+		`<Container:Directory><rdf:Seq><rdf:li Item:Semantic="GainMap"/></rdf:Seq></Container:Directory>`,
+	)
+	extended := append(append([]byte(nil), xmpExtensionPrefix...), bytes.Repeat([]byte("A"), 32)...)
+	extended = append(extended, 0, 0, 0, 64, 0, 0, 0, 0)
+	extended = append(extended, `<x:xmpmeta xmlns:GImage="http://ns.google.com/photos/1.0/image/" GImage:Data="SU1BR0U="`...)
+	in := fixture.WithSegment(fixture.WithSegment(fixture.JPEG(8, 8), 0xE1, extended), 0xE1, xmp)
+
+	out, _ := sanitize(t, in, Config{RemoveAuxiliaryImages: true})
+	assertAbsent(t, out, "REVQVEgtREFUQQ==", "RangeInverse", "SU1BR0U=", "hdrgm:Version", "Item:Semantic")
+	if !bytes.Contains(out, []byte("image/jpeg")) {
+		t.Error("unrelated XMP removed")
+	}
+
+	out, _ = sanitize(t, in, Config{RemoveAuxiliaryImages: true, PreserveGainMaps: true})
+	assertAbsent(t, out, "REVQVEgtREFUQQ==", "SU1BR0U=")
+	if !bytes.Contains(out, []byte("hdrgm:Version")) || !bytes.Contains(out, []byte("Item:Semantic")) {
+		t.Error("gain map description removed under PreserveGainMaps")
+	}
+}
+
+func TestHEICAuxiliaryItems(t *testing.T) {
+	in := fixture.HEICItems(false,
+		fixture.HEICItem{Type: "hvc1", Data: []byte("PRIMARY-PIXELS")},
+		fixture.HEICItem{Type: "hvc1", Data: []byte("DEPTH-PIXELS"), AuxOf: 1, Aux: "urn:mpeg:hevc:2015:auxid:2"},
+		fixture.HEICItem{Type: "hvc1", Data: []byte("ALPHA-PIXELS"), AuxOf: 1, Aux: "urn:mpeg:hevc:2015:auxid:1"},
+		fixture.HEICItem{Type: "hvc1", Data: []byte("GAINMAP-PIXELS"), AuxOf: 1, Aux: "urn:com:apple:photo:2020:aux:hdrgainmap"},
+	)
+	hidden := func(data []byte) map[uint32]bool {
+		m, err := findHEIFMeta(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items, err := m.items(data, func(heifItem) bool { return true })
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := make(map[uint32]bool)
+		for _, item := range items {
+			h[item.ID] = data[item.flags]&1 != 0
+		}
+		return h
+	}
+
+	out, report := sanitize(t, in, Config{RemoveAuxiliaryImages: true})
+	if len(out) != len(in) {
+		t.Errorf("size changed from %d to %d", len(in), len(out))
+	}
+	assertAbsent(t, out, "DEPTH-PIXELS", "GAINMAP-PIXELS")
+	for _, kept := range []string{"PRIMARY-PIXELS", "ALPHA-PIXELS"} {
+		if !bytes.Contains(out, []byte(kept)) {
+			t.Errorf("%s removed", kept)
+		}
+	}
+	if got, want := hidden(out), map[uint32]bool{1: false, 2: true, 3: false, 4: true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("hidden flags %v, want %v", got, want)
+	}
+	sizes := removedSizes(report)
+	if sizes["HEIF depth map"] != int64(len("DEPTH-PIXELS")) || sizes["HEIF gain map"] != int64(len("GAINMAP-PIXELS")) {
+		t.Errorf("removed %v", sizes)
+	}
+
+	out, _ = sanitize(t, in, Config{RemoveAuxiliaryImages: true, PreserveGainMaps: true})
+	if !bytes.Contains(out, []byte("GAINMAP-PIXELS")) || bytes.Contains(out, []byte("DEPTH-PIXELS")) {
+		t.Error("PreserveGainMaps didn't keep only the gain map")
+	}
+}
+
+// repeatExtents returns a HEIF file built by fixture.HEICItems with idat
+// set, with the single extent of item id listed n times in its iloc box
+func repeatExtents(t *testing.T, heic []byte, id uint16, n int) []byte {
+	t.Helper()
+	iloc := bytes.Index(heic, []byte("iloc")) - 4
+	meta := bytes.Index(heic, []byte("meta")) - 4
+	size := int(binary.BigEndian.Uint32(heic[iloc:]))
+	payload := heic[iloc+8 : iloc+size]
+	// Version 1 entries: item_ID, construction_method, data_reference_index,
+	// extent_count and one 4-byte offset and length
+	rebuilt := append([]byte(nil), payload[:8]...)
+	for entry := payload[8:]; len(entry) >= 16; entry = entry[16:] {
+		count := 1
+		if binary.BigEndian.Uint16(entry) == id {
+			count = n
+		}
+		rebuilt = append(rebuilt, entry[:6]...)
+		rebuilt = binary.BigEndian.AppendUint16(rebuilt, uint16(count))
+		for i := 0; i < count; i++ {
+			rebuilt = append(rebuilt, entry[8:16]...)
+		}
+	}
+	out := append(append(append([]byte(nil), heic[:iloc]...), fixture.Box("iloc", rebuilt)...), heic[iloc+size:]...)
+	metaSize := binary.BigEndian.Uint32(out[meta:]) + uint32(len(rebuilt)-len(payload))
+	binary.BigEndian.PutUint32(out[meta:], metaSize)
+	return out
+}
+
+// TestHEICAuxiliaryRepeatedExtents checks that an auxiliary item listing
+// one extent thousands of times, and referenced twice, is overwritten once
+func TestHEICAuxiliaryRepeatedExtents(t *testing.T) {
+	pixels := bytes.Repeat([]byte("DEPTH-PIXELS"), 1<<10)
+	in := fixture.HEICItems(true,
+		fixture.HEICItem{Type: "hvc1", Data: []byte("PRIMARY-PIXELS")},
+		fixture.HEICItem{Type: "hvc1", Data: pixels, AuxOf: 1, Aux: "urn:mpeg:hevc:2015:auxid:2"},
+	)
+	in = repeatExtents(t, in, 2, 0xFFFF)
+	refs := bytes.Index(in, []byte("auxl"))
+	// A second auxl box for the same item, growing the iref and meta boxes
+	// by one copy of the first
+	auxl := in[refs-4 : refs+10]
+	iref := bytes.Index(in, []byte("iref")) - 4
+	meta := bytes.Index(in, []byte("meta")) - 4
+	in = append(append(append([]byte(nil), in[:refs+10]...), auxl...), in[refs+10:]...)
+	for _, box := range []int{iref, meta} {
+		binary.BigEndian.PutUint32(in[box:], binary.BigEndian.Uint32(in[box:])+uint32(len(auxl)))
+	}
+
+	start := time.Now()
+	out, report := sanitize(t, in, Config{RemoveAuxiliaryImages: true})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v", elapsed)
+	}
+	assertAbsent(t, out, "DEPTH-PIXELS")
+	if !bytes.Contains(out, []byte("PRIMARY-PIXELS")) {
+		t.Error("primary removed")
+	}
+	if sizes := removedSizes(report); sizes["HEIF depth map"] != int64(len(pixels)) {
+		t.Errorf("removed %v, want the depth map's %d bytes once", sizes, len(pixels))
+	}
+}
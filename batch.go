@@ -0,0 +1,393 @@
+package exifremover
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// BatchOptions controls RemoveEXIFBatch
+type BatchOptions struct {
+	Recursive bool // descend into subdirectories
+	// Workers is how many files are processed at once; zero means
+	// runtime.GOMAXPROCS(0)
+	Workers int
+	// CopyUnsupported copies files in formats the package doesn't handle
+	// to the output verbatim instead of skipping them
+	CopyUnsupported bool
+	// StateFile names a file recording, for every file processed, the
+	// hashes of its source, the policy and its output. Later runs leave a
+	// file's output alone when all three still match, checking the
+	// source's size and modification time before hashing anything. The
+	// state is rewritten atomically at the end of each run; a missing or
+	// unreadable state file, or one from another library version, makes
+	// the run process every file.
+	StateFile string
+	// MaxOpenFiles bounds the descriptors the batch holds at once, two
+	// per file being processed, so at most MaxOpenFiles/2 files (and at
+	// least one) are open however many Workers there are. Zero means no
+	// bound.
+	MaxOpenFiles int
+	// MaxBytesPerSecond caps the combined rate at which all workers read
+	// inputs and write outputs. Zero means no cap. Files are streamed
+	// rather than mapped under a cap, and a file waiting on it when ctx
+	// ends stops with ctx.Err() as its FileResult.Err.
+	MaxBytesPerSecond int64
+	// FollowSymlinks makes a Recursive batch descend into symbolic links
+	// to directories, which are otherwise listed like files and skipped as
+	// not regular. A directory already walked, recognized by its device
+	// and inode, isn't walked again, so a link back to an ancestor ends
+	// the descent instead of looping. Where the platform has no inode
+	// numbers, links to directories are never followed.
+	FollowSymlinks bool
+	// SegmentStats records the SegmentStats of every JPEG and PNG input
+	// and of its output in FileResult.Segments and OutputSegments, so a
+	// run shows which segment and chunk kinds a policy shrinks and by how
+	// much. Both files are read once more for it, under MaxBytesPerSecond.
+	SegmentStats bool
+}
+
+// FileResult is the outcome of one file of a batch
+type FileResult struct {
+	Path string // relative to the input directory
+	// Report is nil for skipped, copied and unchanged files, and may be
+	// partial (Report.Incomplete) for files that failed
+	Report *Report
+	// BytesRemoved is the input size less the output size
+	BytesRemoved int64
+	Skipped      string // why the file was left out of the output, if it was
+	Copied       bool   // the file was copied through unmodified
+	// Capabilities is what this build could do with the file's format,
+	// so results read later show which carriers were out of scope. It is
+	// nil for unchanged files and files that couldn't be read.
+	Capabilities CapabilitySet
+	// Unchanged is set when the output of an earlier run was kept, the
+	// StateFile showing that neither the source nor the policy changed
+	Unchanged bool
+	// Segments and OutputSegments total the segments or chunks of the
+	// input and the output under BatchOptions.SegmentStats. They are nil
+	// for other formats and for files that weren't sanitized.
+	Segments, OutputSegments map[string]SegStat
+	// Retries is how many times the file was retried after a transient
+	// error: EAGAIN, too many open files, or a stale NFS handle
+	Retries int
+	Err     error
+
+	state *batchFileState // what to record in the StateFile
+}
+
+// RemoveEXIFBatch sanitizes every file in inputDir into the same relative
+// path under outputDir, creating directories as needed, and returns a
+// result for each file in lexical path order. Per-file failures are
+// recorded in the results rather than stopping the batch; the error is
+// only for an invalid config, failures to list inputDir or write the
+// StateFile, and ctx ending. Once ctx ends no further files are started,
+// files already started are finished, and their results are returned
+// along with ctx.Err(), so a run can be resumed after the last of them.
+// A started file that is still waiting for MaxOpenFiles or
+// MaxBytesPerSecond, or backing off before a retry, when ctx ends stops
+// with ctx.Err() instead.
+func RemoveEXIFBatch(ctx context.Context, inputDir, outputDir string, config Config, opts BatchOptions) ([]FileResult, error) {
+	s, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	w := &batchWalker{dir: inputDir, opts: opts}
+	if opts.Recursive && opts.FollowSymlinks {
+		if w.visited, err = visitDir(inputDir, nil); err != nil {
+			return nil, err
+		}
+	}
+	paths, err := w.files("")
+	if err != nil {
+		return nil, err
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if opts.MaxBytesPerSecond > 0 {
+		s.limiter = newLimiter(ctx, opts.MaxBytesPerSecond)
+	}
+	b := &batch{inputDir: inputDir, outputDir: outputDir, sanitizer: s, opts: opts, ctx: ctx}
+	if opts.MaxOpenFiles > 0 {
+		files := opts.MaxOpenFiles / 2
+		if files < 1 {
+			files = 1
+		}
+		b.open = make(chan struct{}, files)
+	}
+	if opts.StateFile != "" {
+		b.state = loadBatchState(opts.StateFile)
+		if b.policy, err = configHash(s.config); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]FileResult, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results[j] = b.file(paths[j])
+			}
+		}()
+	}
+	// Jobs are handed out in order, so once the workers are done the
+	// results of every started file form a prefix of results
+	started := 0
+feed:
+	for started < len(paths) && ctx.Err() == nil {
+		select {
+		case jobs <- started:
+			started++
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if b.state != nil {
+		if err := b.saveState(opts.StateFile, paths[started:], results[:started]); err != nil {
+			return results[:started], err
+		}
+	}
+	if started < len(paths) {
+		return results[:started], ctx.Err()
+	}
+	return results, nil
+}
+
+// batch holds what every file of a RemoveEXIFBatch run shares
+type batch struct {
+	inputDir, outputDir string
+	sanitizer           *Sanitizer
+	opts                BatchOptions
+	state               *batchState // from the last run; nil without a StateFile
+	policy              string      // configHash of the sanitizer's Config
+	ctx                 context.Context
+	open                chan struct{} // a slot per file open under MaxOpenFiles
+}
+
+// batchWalker lists the files of a batch
+type batchWalker struct {
+	dir  string
+	opts BatchOptions
+	// visited holds the device and inode of every directory walked under
+	// FollowSymlinks; nil otherwise
+	visited map[fileKey]bool
+}
+
+// fileKey identifies a file by device and inode
+type fileKey struct{ dev, ino uint64 }
+
+// visitDir adds the directory at path to visited, creating it if nil,
+// and returns it, or nil when the directory was already there or the
+// platform can't tell
+func visitDir(path string, visited map[fileKey]bool) (map[fileKey]bool, error) {
+	info, err := fsys.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	id, ok := fileID(info)
+	if !ok || visited[id] {
+		return nil, nil
+	}
+	if visited == nil {
+		visited = make(map[fileKey]bool)
+	}
+	visited[id] = true
+	return visited, nil
+}
+
+// files lists the files under dir/rel, relative to dir. Symbolic links
+// are listed and left for resolveInput to follow or reject, except links
+// to directories under FollowSymlinks, which are walked.
+func (w *batchWalker) files(rel string) ([]string, error) {
+	entries, err := fsys.ReadDir(filepath.Join(w.dir, rel))
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		path := filepath.Join(rel, entry.Name())
+		dir := entry.IsDir()
+		if entry.Type()&fs.ModeSymlink != 0 && w.visited != nil {
+			info, err := fsys.Stat(filepath.Join(w.dir, path))
+			dir = err == nil && info.IsDir()
+		}
+		switch {
+		case dir:
+			if !w.opts.Recursive {
+				continue
+			}
+			if w.visited != nil {
+				visited, err := visitDir(filepath.Join(w.dir, path), w.visited)
+				if err != nil {
+					return nil, err
+				}
+				if visited == nil {
+					continue // walked already, or a cycle
+				}
+			}
+			sub, err := w.files(path)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, sub...)
+		case entry.Type().IsRegular() || entry.Type()&fs.ModeSymlink != 0:
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// file processes one file of a batch
+func (b *batch) file(rel string) FileResult {
+	result := FileResult{Path: rel}
+	inputPath := filepath.Join(b.inputDir, rel)
+	outputPath := filepath.Join(b.outputDir, rel)
+
+	if b.open != nil {
+		select {
+		case b.open <- struct{}{}:
+			defer func() { <-b.open }()
+		case <-b.ctx.Done():
+			result.Err = b.ctx.Err()
+			return result
+		}
+	}
+
+	if b.state != nil && b.state.unchanged(rel, inputPath, outputPath, b.policy) {
+		prev := b.state.Files[rel]
+		result.Unchanged = true
+		result.state = &prev
+		return result
+	}
+
+	var format Format
+	var info fs.FileInfo
+	err := b.retry(&result, func() (err error) {
+		format, info, err = sniffFile(inputPath, b.sanitizer.config)
+		return err
+	})
+	if errors.Is(err, ErrNotRegularFile) {
+		result.Skipped = "not a regular file"
+		return result
+	}
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Capabilities = Capabilities(format)
+	if format == FormatUnknown && !b.opts.CopyUnsupported {
+		result.Skipped = "unsupported format"
+		return result
+	}
+	if err := fsys.MkdirAll(filepath.Dir(outputPath), 0o755); err != nil {
+		result.Err = err
+		return result
+	}
+
+	if format == FormatUnknown {
+		result.Copied = true
+		result.Err = b.retry(&result, func() error {
+			return copyFile(inputPath, outputPath, b.sanitizer.limiter)
+		})
+	} else {
+		result.Err = b.retry(&result, func() (err error) {
+			result.Report, err = b.sanitizer.RemoveFile(inputPath, outputPath)
+			return err
+		})
+		if result.Err == nil {
+			result.BytesRemoved = info.Size() - result.Report.BytesWritten
+		}
+		if result.Err == nil && b.opts.SegmentStats && (format == FormatJPEG || format == FormatPNG) {
+			result.Err = b.retry(&result, func() (err error) {
+				if result.Segments, err = b.segmentStats(inputPath); err != nil {
+					return err
+				}
+				result.OutputSegments, err = b.segmentStats(outputPath)
+				return err
+			})
+		}
+	}
+	if result.Err == nil && b.state != nil {
+		result.state = recordFile(inputPath, outputPath, info, b.policy)
+	}
+	return result
+}
+
+// segmentStats returns the SegmentStats of the file at path
+func (b *batch) segmentStats(path string) (map[string]SegStat, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return SegmentStats(b.sanitizer.limiter.reader(f))
+}
+
+// retry runs attempt, running it again after a jittered backoff while it
+// fails with a transient error, up to batchRetries times
+func (b *batch) retry(result *FileResult, attempt func() error) error {
+	for {
+		err := attempt()
+		if !transientError(err) || result.Retries == batchRetries {
+			return err
+		}
+		result.Retries++
+		if err := backoff(b.ctx, result.Retries); err != nil {
+			return err
+		}
+	}
+}
+
+// sniffFile returns the format and file information of the file at path
+func sniffFile(path string, config Config) (Format, fs.FileInfo, error) {
+	path, err := resolveInput(path, config)
+	if err != nil {
+		return FormatUnknown, nil, err
+	}
+	f, err := fsys.Open(path)
+	if err != nil {
+		return FormatUnknown, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return FormatUnknown, nil, err
+	}
+	header := make([]byte, 12)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return FormatUnknown, nil, err
+	}
+	return detectFormat(header[:n]), info, nil
+}
+
+// copyFile copies src to dst, throttled by l when it isn't nil
+func copyFile(src, dst string, l *limiter) error {
+	in, err := fsys.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := fsys.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(l.writer(out), l.reader(in)); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,450 @@
+package exifremover
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// batchDirs returns input and output directories, the input holding two
+// JPEGs with metadata and a file in a format the package doesn't handle
+func batchDirs(t *testing.T) (in, out string) {
+	t.Helper()
+	in, out = t.TempDir(), t.TempDir()
+	files := map[string][]byte{
+		"a.jpg":   fixture.EXIFJPEG(fixture.Sample().Bytes()),
+		"b.jpg":   fixture.EXIFJPEG(fixture.TIFF{IFD0: []fixture.Entry{fixture.ASCII(0x010f, "OtherMake")}}.Bytes()),
+		"c.txt":   []byte("not an image"),
+		"sub/d.j": fixture.EXIFJPEG(fixture.Sample().Bytes()),
+	}
+	for name, data := range files {
+		path := filepath.Join(in, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return in, out
+}
+
+// runBatch runs RemoveEXIFBatch and returns its results by path
+func runBatch(t *testing.T, in, out string, config Config, opts BatchOptions) map[string]FileResult {
+	t.Helper()
+	results, err := RemoveEXIFBatch(context.Background(), in, out, config, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPath := map[string]FileResult{}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Path, r.Err)
+		}
+		byPath[r.Path] = r
+	}
+	return byPath
+}
+
+func TestBatchStateFileSkipsUnchanged(t *testing.T) {
+	in, out := batchDirs(t)
+	state := filepath.Join(t.TempDir(), "state.json")
+	config := Config{RemoveCameraInfo: true}
+	opts := BatchOptions{Recursive: true, CopyUnsupported: true, StateFile: state}
+
+	first := runBatch(t, in, out, config, opts)
+	for path, r := range first {
+		if r.Unchanged {
+			t.Errorf("%s unchanged on the first run", path)
+		}
+	}
+	second := runBatch(t, in, out, config, opts)
+	for path, r := range second {
+		if !r.Unchanged || r.Report != nil {
+			t.Errorf("%s reprocessed with nothing changed: %+v", path, r)
+		}
+	}
+
+	// Same size and modification time but different content must still
+	// be caught by the hash
+	a := filepath.Join(in, "a.jpg")
+	info, _ := os.Stat(a)
+	data, _ := os.ReadFile(a)
+	data[len(data)-3] ^= 0xff
+	os.WriteFile(a, data, 0o644)
+	os.Chtimes(a, info.ModTime(), info.ModTime())
+	// A tampered output is redone too
+	os.WriteFile(filepath.Join(out, "b.jpg"), []byte("tampered"), 0o644)
+
+	third := runBatch(t, in, out, config, opts)
+	for path, r := range third {
+		want := path != "a.jpg" && path != "b.jpg"
+		if r.Unchanged != want {
+			t.Errorf("%s: Unchanged = %v, want %v", path, r.Unchanged, want)
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(out, "b.jpg")); bytes.Equal(got, []byte("tampered")) {
+		t.Error("tampered output kept")
+	}
+
+	if fourth := runBatch(t, in, out, Config{RemoveGPSInfo: true}, opts); fourth["sub/d.j"].Unchanged {
+		t.Error("a file was skipped after the policy changed")
+	}
+}
+
+// TestBatchStateFilePolicyChange checks that every file is processed again
+// once the policy changes, even between configs JSON can't encode whole,
+// and that an operational field changing doesn't force a full run
+func TestBatchStateFilePolicyChange(t *testing.T) {
+	in, out := batchDirs(t)
+	state := filepath.Join(t.TempDir(), "state.json")
+	opts := BatchOptions{Recursive: true, CopyUnsupported: true, StateFile: state}
+	gauge := make(chanGauge)
+
+	runBatch(t, in, out, Config{RemoveGPSInfo: true, MemoryGauge: gauge}, opts)
+	changed := Config{RemoveCameraInfo: true, RemoveAll: true, MemoryGauge: gauge}
+	second := runBatch(t, in, out, changed, opts)
+	if len(second) != 4 {
+		t.Fatalf("%d results, want 4", len(second))
+	}
+	for path, r := range second {
+		if r.Unchanged {
+			t.Errorf("%s skipped after the policy changed", path)
+		}
+	}
+	assertAbsent(t, mustRead(t, filepath.Join(out, "a.jpg")), "CanonMake")
+
+	changed.Trace = true
+	changed.ReadTimeout = time.Minute
+	for path, r := range runBatch(t, in, out, changed, opts) {
+		if !r.Unchanged {
+			t.Errorf("%s reprocessed after only Trace and ReadTimeout changed", path)
+		}
+	}
+}
+
+// mustRead returns the contents of the file at path
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestBatchStateFileCorruptMeansFullRun(t *testing.T) {
+	in, out := batchDirs(t)
+	state := filepath.Join(t.TempDir(), "state.json")
+	opts := BatchOptions{Recursive: true, StateFile: state}
+	runBatch(t, in, out, Config{RemoveCameraInfo: true}, opts)
+
+	for _, content := range []string{"{not json", `{"version":"0.0.0","files":{}}`, ""} {
+		if err := os.WriteFile(state, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for path, r := range runBatch(t, in, out, Config{RemoveCameraInfo: true}, opts) {
+			if r.Unchanged {
+				t.Errorf("state %q: %s skipped", content, path)
+			}
+		}
+	}
+
+	// The state written after the full run is valid again
+	loaded := loadBatchState(state)
+	if len(loaded.Files) != 3 {
+		t.Errorf("state holds %d files, want 3", len(loaded.Files))
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(state)); len(entries) != 1 {
+		t.Errorf("temporary state files left behind: %v", entries)
+	}
+}
+
+func TestBatchStateFileModTimeChecked(t *testing.T) {
+	in, out := batchDirs(t)
+	state := filepath.Join(t.TempDir(), "state.json")
+	opts := BatchOptions{StateFile: state}
+	runBatch(t, in, out, Config{RemoveCameraInfo: true}, opts)
+
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(in, "a.jpg"), later, later)
+	if r := runBatch(t, in, out, Config{RemoveCameraInfo: true}, opts); r["a.jpg"].Unchanged || !r["b.jpg"].Unchanged {
+		t.Errorf("modification time not checked: %+v", r)
+	}
+}
+
+func TestBatchReportsCapabilities(t *testing.T) {
+	in, out := batchDirs(t)
+	results := runBatch(t, in, out, Config{RemoveCameraInfo: true}, BatchOptions{Recursive: true, CopyUnsupported: true})
+	if got := results["a.jpg"].Capabilities; !reflect.DeepEqual(got, Capabilities(FormatJPEG)) {
+		t.Errorf("a.jpg capabilities %v, want the JPEG set", got)
+	}
+	if got := results["c.txt"].Capabilities; len(got) != 0 {
+		t.Errorf("copied file capabilities %v, want none", got)
+	}
+}
+
+func TestLimiterRefundsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := newLimiter(ctx, 1000)
+	if err := l.wait(1000); err != nil {
+		t.Fatalf("a full bucket waited: %v", err)
+	}
+	start := time.Now()
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if err := l.wait(5000); err != context.Canceled {
+		t.Fatalf("wait on a cancelled context returned %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled wait took %v", elapsed)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.tokens < -100 {
+		t.Errorf("cancelled reservation stranded: %.0f tokens", l.tokens)
+	}
+}
+
+func TestLimiterPaces(t *testing.T) {
+	l := newLimiter(context.Background(), 10000)
+	start := time.Now()
+	// The bucket starts full, so two buckets' worth take a second
+	for i := 0; i < 4; i++ {
+		if err := l.wait(5000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("20000 bytes at 10000/s took %v", elapsed)
+	}
+}
+
+func TestBatchMaxBytesPerSecondCancel(t *testing.T) {
+	in, out := batchDirs(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	results, err := RemoveEXIFBatch(ctx, in, out, Config{RemoveAll: true}, BatchOptions{Workers: 1, MaxBytesPerSecond: 16})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("batch returned %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("cancelled batch took %v", elapsed)
+	}
+	if len(results) != 1 || results[0].Err != context.DeadlineExceeded {
+		t.Fatalf("results %+v, want one file stopped by the deadline", results)
+	}
+	if _, err := os.Stat(filepath.Join(out, results[0].Path)); !os.IsNotExist(err) {
+		t.Errorf("stopped file left its output: %v", err)
+	}
+}
+
+// faultFS is osFS with a hook run before every Open and Create
+type faultFS struct {
+	osFS
+	before func(name string) error
+}
+
+func (f faultFS) Open(name string) (file, error) {
+	if err := f.before(name); err != nil {
+		return nil, err
+	}
+	return f.osFS.Open(name)
+}
+
+func (f faultFS) Create(name string) (file, error) {
+	if err := f.before(name); err != nil {
+		return nil, err
+	}
+	return f.osFS.Create(name)
+}
+
+// useFS replaces fsys for the rest of the test
+func useFS(t *testing.T, f fileSystem) {
+	saved := fsys
+	fsys = f
+	t.Cleanup(func() { fsys = saved })
+}
+
+func TestBatchRetriesTransientErrors(t *testing.T) {
+	in, out := batchDirs(t)
+	var mu sync.Mutex
+	failed := map[string]bool{}
+	useFS(t, faultFS{before: func(name string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if filepath.Base(name) == "a.jpg" && !failed[name] {
+			failed[name] = true
+			return &os.PathError{Op: "open", Path: name, Err: syscall.EMFILE}
+		}
+		if filepath.Base(name) == "b.jpg" {
+			return &os.PathError{Op: "open", Path: name, Err: syscall.ESTALE}
+		}
+		return nil
+	}})
+	results, err := RemoveEXIFBatch(context.Background(), in, out, Config{RemoveAll: true}, BatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		switch r.Path {
+		case "a.jpg":
+			// The input, then the output, each fail once
+			if r.Err != nil || r.Retries != 2 {
+				t.Errorf("a.jpg: err %v after %d retries, want success after 2", r.Err, r.Retries)
+			}
+		case "b.jpg":
+			if !errors.Is(r.Err, syscall.ESTALE) || r.Retries != batchRetries {
+				t.Errorf("b.jpg: err %v after %d retries, want ESTALE after %d", r.Err, r.Retries, batchRetries)
+			}
+		case "c.txt":
+			if r.Retries != 0 {
+				t.Errorf("c.txt retried %d times", r.Retries)
+			}
+		}
+	}
+}
+
+// countingFS is osFS recording the most files it has had open at once
+type countingFS struct {
+	osFS
+	mu         sync.Mutex
+	open, peak int
+}
+
+func (c *countingFS) track(f file, err error) (file, error) {
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if c.open++; c.open > c.peak {
+		c.peak = c.open
+	}
+	c.mu.Unlock()
+	// Hold the file a little so concurrent workers would overlap
+	time.Sleep(5 * time.Millisecond)
+	return &countedFile{file: f, fs: c}, nil
+}
+
+func (c *countingFS) Open(name string) (file, error)   { return c.track(c.osFS.Open(name)) }
+func (c *countingFS) Create(name string) (file, error) { return c.track(c.osFS.Create(name)) }
+
+type countedFile struct {
+	file
+	fs *countingFS
+}
+
+func (f *countedFile) Close() error {
+	f.fs.mu.Lock()
+	f.fs.open--
+	f.fs.mu.Unlock()
+	return f.file.Close()
+}
+
+func TestBatchMaxOpenFiles(t *testing.T) {
+	in, out := batchDirs(t)
+	counting := &countingFS{}
+	useFS(t, counting)
+	runBatch(t, in, out, Config{RemoveAll: true}, BatchOptions{Recursive: true, Workers: 8, MaxOpenFiles: 2, CopyUnsupported: true})
+	if counting.peak > 2 {
+		t.Errorf("%d files open at once, want at most 2", counting.peak)
+	}
+}
+
+// TestBatchSegmentStats checks that SegmentStats totals the input and the
+// output of every sanitized file, the difference being what was removed
+func TestBatchSegmentStats(t *testing.T) {
+	in, out := batchDirs(t)
+	png := fixture.WithChunks(fixture.PNG(8, 8), fixture.Chunk("tEXt", []byte("Author\x00John Artist")))
+	if err := os.WriteFile(filepath.Join(in, "e.png"), png, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	results := runBatch(t, in, out, Config{RemoveAll: true, RemoveTextChunks: true}, BatchOptions{SegmentStats: true, CopyUnsupported: true})
+
+	a := results["a.jpg"]
+	if a.Segments["APP1"].Count != 1 || a.OutputSegments["APP1"].Count != 0 {
+		t.Errorf("a.jpg APP1 = %+v in, %+v out", a.Segments["APP1"], a.OutputSegments["APP1"])
+	}
+	e := results["e.png"]
+	if e.Segments["tEXt"].Count != 1 || e.OutputSegments["tEXt"].Count != 0 {
+		t.Errorf("e.png tEXt = %+v in, %+v out", e.Segments["tEXt"], e.OutputSegments["tEXt"])
+	}
+	for _, r := range []FileResult{a, e} {
+		if removed := statsTotal(r.Segments) - statsTotal(r.OutputSegments); removed != r.BytesRemoved {
+			t.Errorf("%s: stats differ by %d bytes, BytesRemoved is %d", r.Path, removed, r.BytesRemoved)
+		}
+	}
+	if c := results["c.txt"]; c.Segments != nil || c.OutputSegments != nil {
+		t.Error("stats for a copied file")
+	}
+
+	out = t.TempDir()
+	if a := runBatch(t, in, out, Config{RemoveAll: true}, BatchOptions{})["a.jpg"]; a.Segments != nil {
+		t.Error("stats without SegmentStats")
+	}
+}
+
+func TestSymlinkLoopInput(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.jpg"), filepath.Join(dir, "b.jpg")
+	if err := os.Symlink(b, a); err != nil {
+		t.Skipf("symlink: %v", err)
+	}
+	if err := os.Symlink(a, b); err != nil {
+		t.Fatal(err)
+	}
+	err := RemoveEXIFSelective(a, filepath.Join(dir, "out.jpg"), Config{RemoveGPSInfo: true})
+	if err == nil || !strings.Contains(err.Error(), "symbolic links") {
+		t.Errorf("err = %v, want the link limit", err)
+	}
+}
+
+// TestBatchFollowSymlinks checks that links to directories are skipped by
+// default and walked under FollowSymlinks, where a link back to an
+// ancestor and a second link to one directory are each walked once
+func TestBatchFollowSymlinks(t *testing.T) {
+	in, _ := batchDirs(t)
+	shared := t.TempDir()
+	if err := os.WriteFile(filepath.Join(shared, "e.jpg"), fixture.EXIFJPEG(fixture.Sample().Bytes()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"sub/loop": in,
+		"shared":   shared,
+		"again":    shared,
+	} {
+		if err := os.Symlink(target, filepath.Join(in, link)); err != nil {
+			t.Skipf("symlink: %v", err)
+		}
+	}
+
+	results := runBatch(t, in, t.TempDir(), Config{RemoveGPSInfo: true}, BatchOptions{Recursive: true})
+	for _, link := range []string{"sub/loop", "shared", "again"} {
+		if r, ok := results[link]; !ok || r.Skipped != "not a regular file" {
+			t.Errorf("%s without FollowSymlinks: %+v", link, r)
+		}
+	}
+
+	results = runBatch(t, in, t.TempDir(), Config{RemoveGPSInfo: true}, BatchOptions{Recursive: true, FollowSymlinks: true})
+	var paths []string
+	for path := range results {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	// "again" sorts first, so it is the link to shared that gets walked
+	want := []string{"a.jpg", "again/e.jpg", "b.jpg", "c.txt", "sub/d.j"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths = %q, want %q", paths, want)
+	}
+}
@@ -0,0 +1,129 @@
+package exifremover
+
+import (
+	"encoding/json"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// batchState is the content of a BatchOptions.StateFile
+type batchState struct {
+	Version string                    `json:"version"` // the library Version that wrote it
+	Files   map[string]batchFileState `json:"files"`   // by path relative to the input directory
+}
+
+// batchFileState records how one file of a batch was processed
+type batchFileState struct {
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mtime"`
+	SourceHash string    `json:"source"` // FileHash of the input
+	PolicyHash string    `json:"policy"` // configHash of the Config
+	OutputHash string    `json:"output"` // FileHash of the output
+}
+
+// loadBatchState reads the state file at path. Any problem reading it
+// yields an empty state, so that the run processes every file rather than
+// trusting records it can't verify.
+func loadBatchState(path string) *batchState {
+	empty := &batchState{Version: Version, Files: map[string]batchFileState{}}
+	f, err := fsys.Open(path)
+	if err != nil {
+		return empty
+	}
+	defer f.Close()
+	var state batchState
+	if err := json.NewDecoder(f).Decode(&state); err != nil || state.Version != Version || state.Files == nil {
+		return empty
+	}
+	return &state
+}
+
+// unchanged reports whether the output of the last run for rel can be kept:
+// the policy is the same, the input has the recorded size and modification
+// time and, that being so, the input and output still have the recorded
+// hashes
+func (s *batchState) unchanged(rel, inputPath, outputPath, policy string) bool {
+	prev, ok := s.Files[rel]
+	if !ok || prev.PolicyHash != policy {
+		return false
+	}
+	info, err := fsys.Stat(inputPath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != prev.Size || !info.ModTime().Equal(prev.ModTime) {
+		return false
+	}
+	return hashFile(inputPath) == prev.SourceHash && hashFile(outputPath) == prev.OutputHash
+}
+
+// recordFile returns the state to record for a file just processed. info
+// is from before processing, so a source modified meanwhile fails the
+// modification time check on the next run. It returns nil, recording
+// nothing, if either file can't be hashed.
+func recordFile(inputPath, outputPath string, info fs.FileInfo, policy string) *batchFileState {
+	source, output := hashFile(inputPath), hashFile(outputPath)
+	if source == "" || output == "" {
+		return nil
+	}
+	return &batchFileState{Size: info.Size(), ModTime: info.ModTime(), SourceHash: source, PolicyHash: policy, OutputHash: output}
+}
+
+// hashFile returns the FileHash of the file at path, or "" if it can't be
+// read
+func hashFile(path string) string {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	hash, err := FileHash(f)
+	if err != nil {
+		return ""
+	}
+	return hash
+}
+
+// saveState writes the state after a run: the records of the files the
+// run processed successfully, and the previous records of the files it
+// didn't get to. Files that failed or were skipped lose their record.
+func (b *batch) saveState(path string, unstarted []string, results []FileResult) error {
+	state := batchState{Version: Version, Files: make(map[string]batchFileState, len(b.state.Files))}
+	for _, rel := range unstarted {
+		if prev, ok := b.state.Files[rel]; ok {
+			state.Files[rel] = prev
+		}
+	}
+	for _, result := range results {
+		if result.state != nil {
+			state.Files[result.Path] = *result.state
+		}
+	}
+	encoded, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, encoded)
+}
+
+// writeFileAtomic replaces the file at path with data through a synced
+// temporary file in the same directory, so readers see either the old
+// content or the new
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := fsys.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = fsys.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		fsys.Remove(tmp.Name())
+	}
+	return err
+}
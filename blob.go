@@ -0,0 +1,53 @@
+package exifremover
+
+import "bytes"
+
+// SanitizeEXIFBlob applies config to an EXIF payload extracted from its
+// container, for callers that handle the container themselves. data may be
+// an APP1-style payload starting with "Exif\0\0" or a bare TIFF structure;
+// the result has the same form. data is never modified, and the result is
+// always a newly allocated slice, even when nothing was removed.
+func SanitizeEXIFBlob(data []byte, config Config) ([]byte, Report, error) {
+	var report Report
+	if err := validateConfig(config); err != nil {
+		return nil, report, err
+	}
+	config = prepare(config)
+	out, err := modifyEXIFBlob(data, config, &report)
+	if err != nil {
+		return nil, report, err
+	}
+	report.WeakestRemoval = report.weakestRemoval()
+	report.BytesWritten = int64(len(out))
+	return out, report, nil
+}
+
+// modifyEXIFBlob is modifyEXIF for payloads that may lack the "Exif\0\0"
+// prefix, such as PNG eXIf chunks, which by spec hold a bare TIFF
+// structure. The result has the same form as data and never aliases it.
+func modifyEXIFBlob(data []byte, config Config, report *Report) ([]byte, error) {
+	prefixed := bytes.HasPrefix(data, exifPrefix)
+
+	blob := make([]byte, 0, len(exifPrefix)+len(data))
+	if !prefixed {
+		blob = append(blob, exifPrefix...)
+	}
+	blob = append(blob, data...)
+
+	out, err := modifyEXIF(blob, config, report)
+	if err != nil {
+		return nil, err
+	}
+	if !prefixed {
+		out = out[len(exifPrefix):]
+	}
+	return out, nil
+}
+
+// isEmptyEXIF reports whether an EXIF payload, prefixed or bare, is a husk
+// with no TIFF structure in it: just the "Exif\0\0" signature, as some
+// messaging apps leave behind, a partial header, or only zero bytes
+func isEmptyEXIF(data []byte) bool {
+	tiff := bytes.TrimPrefix(data, exifPrefix)
+	return len(tiff) < 8 || len(bytes.Trim(tiff, "\x00")) == 0
+}
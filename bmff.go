@@ -0,0 +1,105 @@
+package exifremover
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// box is one ISO-BMFF box, as used by HEIC, AVIF and MP4
+type box struct {
+	Type   string
+	Path   string // slash-separated types from the top level, e.g. "moov/udta/meta"
+	Start  int    // offset of the box header within the walked data
+	Header int    // length of the header, which Data follows
+	Data   []byte // payload after the header
+}
+
+// maxBoxDepth bounds how deeply walkBoxes descends into container boxes
+const maxBoxDepth = 16
+
+var (
+	// ErrBoxTooDeep is wrapped in a BoxError for boxes nested more than
+	// maxBoxDepth levels deep
+	ErrBoxTooDeep = errors.New("boxes nested too deeply")
+	// ErrBoxExtent is wrapped in a BoxError for a box whose declared size
+	// is smaller than its header or runs past its parent
+	ErrBoxExtent = errors.New("box size outside its parent")
+)
+
+// BoxError reports an invalid ISO-BMFF box and where it sits in the file
+type BoxError struct {
+	Path string
+	Err  error
+}
+
+func (e *BoxError) Error() string { return e.Path + ": " + e.Err.Error() }
+func (e *BoxError) Unwrap() error { return e.Err }
+
+// containerBoxes maps the box types whose payload is a sequence of child
+// boxes to the number of bytes before the first child (version and flags
+// for full boxes)
+var containerBoxes = map[string]int{
+	"moov": 0, "trak": 0, "mdia": 0, "minf": 0, "stbl": 0, "dinf": 0,
+	"edts": 0, "udta": 0, "iprp": 0, "ipco": 0, "meta": 4,
+}
+
+// walkBoxes calls fn for every box in data, parents before their children.
+// Every box must lie within its parent; a size of 0 extends a box to the
+// end of its parent and a size of 1 means a 64-bit size follows the type.
+func walkBoxes(data []byte, fn func(b box) error) error {
+	return walkBoxLevel(data, 0, "", 0, fn)
+}
+
+func walkBoxLevel(data []byte, base int, parent string, depth int, fn func(b box) error) error {
+	for pos := 0; pos < len(data); {
+		if len(data)-pos < 8 {
+			return &BoxError{Path: boxPath(parent, "?"), Err: ErrBoxExtent}
+		}
+		size := uint64(binary.BigEndian.Uint32(data[pos : pos+4]))
+		typ := string(data[pos+4 : pos+8])
+		path := boxPath(parent, typ)
+		header := 8
+		switch size {
+		case 0:
+			size = uint64(len(data) - pos)
+		case 1:
+			if len(data)-pos < 16 {
+				return &BoxError{Path: path, Err: ErrBoxExtent}
+			}
+			size = binary.BigEndian.Uint64(data[pos+8 : pos+16])
+			header = 16
+		}
+		if typ == "uuid" {
+			header += 16 // extended type
+		}
+		if size < uint64(header) || size > uint64(len(data)-pos) {
+			return &BoxError{Path: path, Err: ErrBoxExtent}
+		}
+
+		b := box{Type: typ, Path: path, Start: base + pos, Header: header, Data: data[pos+header : pos+int(size)]}
+		if err := fn(b); err != nil {
+			return err
+		}
+		if skip, ok := containerBoxes[typ]; ok {
+			if depth+1 > maxBoxDepth {
+				return &BoxError{Path: path, Err: ErrBoxTooDeep}
+			}
+			if len(b.Data) < skip {
+				return &BoxError{Path: path, Err: ErrBoxExtent}
+			}
+			childBase := b.Start + header + skip
+			if err := walkBoxLevel(b.Data[skip:], childBase, path, depth+1, fn); err != nil {
+				return err
+			}
+		}
+		pos += int(size)
+	}
+	return nil
+}
+
+func boxPath(parent, typ string) string {
+	if parent == "" {
+		return typ
+	}
+	return parent + "/" + typ
+}
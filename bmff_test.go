@@ -0,0 +1,115 @@
+package exifremover
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// nestedBoxes returns depth moov boxes, each holding the next
+func nestedBoxes(depth int) []byte {
+	b := fixture.Box("free")
+	for i := 0; i < depth; i++ {
+		b = fixture.Box("moov", b)
+	}
+	return b
+}
+
+func TestWalkBoxes(t *testing.T) {
+	large := make([]byte, 16, 20)
+	binary.BigEndian.PutUint32(large, 1)
+	copy(large[4:], "mdat")
+	binary.BigEndian.PutUint64(large[8:], 20)
+	large = append(large, "data"...)
+
+	for _, c := range []struct {
+		name  string
+		data  []byte
+		paths []string
+		err   error
+		path  string
+	}{
+		{"nested", fixture.Box("moov", fixture.Box("udta", fixture.Box("meta", make([]byte, 4)))), []string{"moov", "moov/udta", "moov/udta/meta"}, nil, ""},
+		{"largesize", large, []string{"mdat"}, nil, ""},
+		{"to end", append(fixture.Box("ftyp"), 0, 0, 0, 0, 'm', 'd', 'a', 't', 1, 2, 3), []string{"ftyp", "mdat"}, nil, ""},
+		{"max depth", nestedBoxes(maxBoxDepth), nil, nil, ""},
+		{"too deep", nestedBoxes(maxBoxDepth + 1), nil, ErrBoxTooDeep, strings.Repeat("moov/", maxBoxDepth) + "moov"},
+		{"past parent", fixture.Box("moov", []byte{0, 0, 0, 64, 'u', 'd', 't', 'a'}), nil, ErrBoxExtent, "moov/udta"},
+		{"under header", []byte{0, 0, 0, 4, 'f', 'r', 'e', 'e'}, nil, ErrBoxExtent, "free"},
+		{"short largesize", []byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0}, nil, ErrBoxExtent, "mdat"},
+		{"trailing bytes", append(fixture.Box("free"), 0, 0), nil, ErrBoxExtent, "?"},
+		{"meta without version", fixture.Box("meta", []byte{0, 0}), nil, ErrBoxExtent, "meta"},
+	} {
+		var paths []string
+		err := walkBoxes(c.data, func(b box) error {
+			paths = append(paths, b.Path)
+			return nil
+		})
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.err)
+			continue
+		}
+		var boxErr *BoxError
+		if c.err != nil && (!errors.As(err, &boxErr) || boxErr.Path != c.path) {
+			t.Errorf("%s: error %v, want path %q", c.name, err, c.path)
+		}
+		if c.paths != nil && strings.Join(paths, " ") != strings.Join(c.paths, " ") {
+			t.Errorf("%s: walked %q, want %q", c.name, paths, c.paths)
+		}
+	}
+}
+
+func TestWalkBoxesStops(t *testing.T) {
+	stop := errors.New("stop")
+	n := 0
+	err := walkBoxes(append(fixture.Box("ftyp"), fixture.Box("free")...), func(b box) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("got %v after %d boxes, want stop after 1", err, n)
+	}
+}
+
+// FuzzWalkBoxes checks the walker on its own, as HEIC, AVIF and MP4 share
+// it: it must not panic, every box it reports lies within the data and its
+// parent, nothing is nested past maxBoxDepth, and every failure is a
+// BoxError wrapping one of its sentinels
+func FuzzWalkBoxes(f *testing.F) {
+	f.Add(fixture.HEIC([]byte("\x00\x00\x00\x00II*\x00"), false))
+	f.Add(fixture.HEIC([]byte("\x00\x00\x00\x00MM\x00*"), true))
+	f.Add(nestedBoxes(maxBoxDepth + 1))
+	f.Add([]byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0, 0, 0, 0, 0, 0, 16})
+	f.Add([]byte{0, 0, 0, 0, 'u', 'u', 'i', 'd'})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ends := map[string]int{"": len(data)}
+		err := walkBoxes(data, func(b box) error {
+			end := b.Start + b.Header + len(b.Data)
+			if b.Start < 0 || end > len(data) || string(data[b.Start+4:b.Start+8]) != b.Type {
+				t.Fatalf("box %s at %d+%d outside the data", b.Path, b.Start, b.Header+len(b.Data))
+			}
+			parent := ""
+			if i := strings.LastIndexByte(b.Path, '/'); i >= 0 {
+				parent = b.Path[:i]
+			}
+			if parentEnd, ok := ends[parent]; ok && end > parentEnd {
+				t.Fatalf("box %s ends at %d, past its parent at %d", b.Path, end, parentEnd)
+			}
+			if depth := strings.Count(b.Path, "/"); depth > maxBoxDepth {
+				t.Fatalf("box %s nested %d deep", b.Path, depth)
+			}
+			ends[b.Path] = end
+			return nil
+		})
+		if err == nil {
+			return
+		}
+		var boxErr *BoxError
+		if !errors.As(err, &boxErr) || !errors.Is(err, ErrBoxTooDeep) && !errors.Is(err, ErrBoxExtent) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package exifremover
+
+import "bytes"
+
+// Format identifies an image container format
+type Format int
+
+const (
+	FormatUnknown Format = iota
+	FormatJPEG
+	FormatPNG
+	FormatWebP
+	FormatHEIC
+	FormatTIFF
+)
+
+// String returns the conventional name of the format
+func (f Format) String() string {
+	switch f {
+	case FormatJPEG:
+		return "JPEG"
+	case FormatPNG:
+		return "PNG"
+	case FormatWebP:
+		return "WebP"
+	case FormatHEIC:
+		return "HEIC"
+	case FormatTIFF:
+		return "TIFF"
+	}
+	return "unknown"
+}
+
+// detectFormat identifies the container from its leading signature bytes
+func detectFormat(header []byte) Format {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8}):
+		return FormatJPEG
+	case bytes.HasPrefix(header, []byte{0x89, 0x50, 0x4E, 0x47}):
+		return FormatPNG
+	case len(header) >= 12 && bytes.HasPrefix(header, []byte("RIFF")) && string(header[8:12]) == "WEBP":
+		return FormatWebP
+	case bytes.HasPrefix(header, []byte("II*\x00")) || bytes.HasPrefix(header, []byte("MM\x00*")):
+		return FormatTIFF
+	case len(header) >= 12 && string(header[4:8]) == "ftyp" && heifBrands[string(header[8:12])]:
+		return FormatHEIC
+	}
+	return FormatUnknown
+}
+
+// Carrier identifies a kind of metadata an image can hold
+type Carrier int
+
+// MarshalText encodes the carrier by name
+func (c Carrier) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+const (
+	CarrierEXIF Carrier = iota
+	CarrierXMP
+	CarrierIPTC
+	CarrierText
+	CarrierThumbnail
+	CarrierMakerNote
+	CarrierAuxiliaryImage
+	CarrierComment
+	CarrierAudio
+	CarrierICC
+	CarrierOther // chunks of types the package doesn't know
+)
+
+// String returns a short name for the carrier
+func (c Carrier) String() string {
+	switch c {
+	case CarrierEXIF:
+		return "EXIF"
+	case CarrierXMP:
+		return "XMP"
+	case CarrierIPTC:
+		return "IPTC"
+	case CarrierText:
+		return "text"
+	case CarrierThumbnail:
+		return "thumbnail"
+	case CarrierMakerNote:
+		return "maker note"
+	case CarrierAuxiliaryImage:
+		return "auxiliary image"
+	case CarrierComment:
+		return "comment"
+	case CarrierAudio:
+		return "embedded audio"
+	case CarrierICC:
+		return "ICC profile"
+	case CarrierOther:
+		return "unknown chunk"
+	}
+	return "unknown"
+}
+
+// Capability describes what the library can do with one metadata carrier
+type Capability struct {
+	Readable           bool // found and reported during processing
+	RemovableInPlace   bool // neutralized without changing the container layout
+	RemovableByRebuild bool // dropped by rewriting the container around it
+	// Option names the Config field that removes the carrier. It is empty
+	// for EXIF and XMP, whose contents are removed per category.
+	Option string
+}
+
+// CapabilitySet maps each carrier to what this build can do with it.
+// Carriers missing from the set are passed through untouched.
+type CapabilitySet map[Carrier]Capability
+
+// Capabilities reports which metadata carriers this build can handle for f.
+// The maker note is only ever removed whole, as the MakerNote EXIF entry
+// under RemoveUserInfo, since its vendor format isn't parsed. Every entry
+// is checked against the format handlers by a probe in the tests.
+func Capabilities(f Format) CapabilitySet {
+	set := formatCapabilities(f)
+	for c, capability := range set {
+		capability.Option = carrierFlags[c]
+		set[c] = capability
+	}
+	return set
+}
+
+// formatCapabilities is the table behind Capabilities
+func formatCapabilities(f Format) CapabilitySet {
+	switch f {
+	case FormatJPEG:
+		return CapabilitySet{
+			CarrierEXIF:           {Readable: true, RemovableInPlace: true, RemovableByRebuild: true},
+			CarrierXMP:            {Readable: true, RemovableInPlace: true},
+			CarrierIPTC:           {Readable: true, RemovableByRebuild: true},
+			CarrierMakerNote:      {Readable: true, RemovableInPlace: true},
+			CarrierThumbnail:      {Readable: true, RemovableInPlace: true},
+			CarrierAuxiliaryImage: {Readable: true, RemovableByRebuild: true},
+			CarrierComment:        {Readable: true, RemovableByRebuild: true},
+			CarrierAudio:          {Readable: true, RemovableByRebuild: true},
+			CarrierICC:            {Readable: true, RemovableByRebuild: true},
+		}
+	case FormatPNG:
+		return CapabilitySet{
+			CarrierEXIF:      {Readable: true, RemovableInPlace: true, RemovableByRebuild: true},
+			CarrierText:      {Readable: true, RemovableByRebuild: true},
+			CarrierICC:       {Readable: true, RemovableByRebuild: true},
+			CarrierMakerNote: {Readable: true, RemovableInPlace: true},
+			CarrierThumbnail: {Readable: true, RemovableInPlace: true},
+			CarrierOther:     {Readable: true, RemovableByRebuild: true},
+		}
+	case FormatTIFF:
+		return CapabilitySet{
+			CarrierEXIF:      {Readable: true, RemovableInPlace: true},
+			CarrierMakerNote: {Readable: true, RemovableInPlace: true},
+			CarrierThumbnail: {Readable: true, RemovableInPlace: true},
+		}
+	case FormatWebP:
+		return CapabilitySet{
+			CarrierEXIF:      {Readable: true, RemovableInPlace: true, RemovableByRebuild: true},
+			CarrierXMP:       {Readable: true, RemovableInPlace: true},
+			CarrierICC:       {Readable: true, RemovableByRebuild: true},
+			CarrierMakerNote: {Readable: true, RemovableInPlace: true},
+			CarrierThumbnail: {Readable: true, RemovableInPlace: true},
+		}
+	case FormatHEIC:
+		return CapabilitySet{
+			CarrierEXIF:           {Readable: true, RemovableInPlace: true},
+			CarrierXMP:            {Readable: true, RemovableInPlace: true},
+			CarrierMakerNote:      {Readable: true, RemovableInPlace: true},
+			CarrierThumbnail:      {Readable: true, RemovableInPlace: true},
+			CarrierAuxiliaryImage: {Readable: true, RemovableInPlace: true},
+		}
+	}
+	return CapabilitySet{}
+}
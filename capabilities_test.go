@@ -0,0 +1,157 @@
+package exifremover
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// probeTIFF returns the sample TIFF structure with a maker note and an
+// IFD1 thumbnail
+func probeTIFF() []byte {
+	le := binary.LittleEndian
+	thumbnail := fixture.JPEG(8, 8)
+	build := func(offset uint32) []byte {
+		t := fixture.Sample()
+		t.Exif = append(t.Exif, fixture.Undefined(0x927c, []byte("VENDOR-NOTE-0001")))
+		t.IFD1 = []fixture.Entry{
+			fixture.Long(le, 0x0201, offset),
+			fixture.Long(le, 0x0202, uint32(len(thumbnail))),
+		}
+		t.Tail = thumbnail
+		return t.Bytes()
+	}
+	return build(uint32(len(build(0)) - len(thumbnail)))
+}
+
+// probeXMP is an XMP packet holding a location property
+var probeXMP = fixture.XMP(`xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/" photoshop:City="Shelbyville"`, "")
+
+// capabilityProbe exercises one carrier of one format. inPlace and rebuild
+// are the configs that remove it without and with a change of layout, or
+// nil when the format can't do that.
+type capabilityProbe struct {
+	format           Format
+	carrier          Carrier
+	input            []byte
+	inPlace, rebuild *Config
+}
+
+func capabilityProbes() []capabilityProbe {
+	tiff := probeTIFF()
+	jpeg := fixture.EXIFJPEG(tiff)
+	png := fixture.WithChunks(fixture.PNG(8, 8), fixture.Chunk("eXIf", tiff))
+	webp := fixture.WebP(fixture.WebPChunk("EXIF", tiff))
+	heic := fixture.HEIC(append([]byte{0, 0, 0, 0}, tiff...), false)
+
+	wave := append([]byte("RIFF\x04\x00\x00\x00WAVE"), make([]byte, 4)...)
+	mpf := fixture.MPF(fixture.JPEG(8, 8), fixture.MPImage{Type: 0x020002, Data: fixture.JPEG(8, 8)})
+	iptc := fixture.Photoshop(fixture.Resource(0x0404, fixture.Dataset(2, 120, "Caption")))
+	icc := append([]byte("ICC_PROFILE\x00\x01\x01"), make([]byte, 128)...)
+
+	exif := map[Format][]byte{FormatJPEG: jpeg, FormatPNG: png, FormatTIFF: tiff, FormatWebP: webp, FormatHEIC: heic}
+	var probes []capabilityProbe
+	for f, input := range exif {
+		p := capabilityProbe{format: f, carrier: CarrierEXIF, input: input, inPlace: &Config{RemoveGPSInfo: true}}
+		if f == FormatJPEG || f == FormatPNG || f == FormatWebP {
+			p.rebuild = &Config{RemoveAll: true}
+		}
+		probes = append(probes,
+			p,
+			capabilityProbe{format: f, carrier: CarrierMakerNote, input: input, inPlace: &Config{RemoveUserInfo: true}},
+			capabilityProbe{format: f, carrier: CarrierThumbnail, input: input, inPlace: &Config{RemoveThumbnail: true}},
+		)
+	}
+	return append(probes,
+		capabilityProbe{format: FormatJPEG, carrier: CarrierXMP, input: fixture.WithSegment(fixture.JPEG(8, 8), 0xE1, probeXMP), inPlace: &Config{RemoveGPSInfo: true}},
+		capabilityProbe{format: FormatJPEG, carrier: CarrierIPTC, input: fixture.WithSegment(fixture.JPEG(8, 8), 0xED, iptc), rebuild: &Config{RemoveIPTC: true}},
+		capabilityProbe{format: FormatJPEG, carrier: CarrierAuxiliaryImage, input: mpf, rebuild: &Config{RemoveAuxiliaryImages: true}},
+		capabilityProbe{format: FormatJPEG, carrier: CarrierComment, input: fixture.WithSegment(fixture.JPEG(8, 8), 0xFE, []byte("comment")), rebuild: &Config{RemoveComments: true}},
+		capabilityProbe{format: FormatJPEG, carrier: CarrierAudio, input: fixture.WithSegment(fixture.JPEG(8, 8), 0xE4, wave), rebuild: &Config{RemoveVendorSegments: true}},
+		capabilityProbe{format: FormatJPEG, carrier: CarrierICC, input: fixture.WithSegment(fixture.JPEG(8, 8), 0xE2, icc), rebuild: &Config{RemoveICCProfile: true}},
+		capabilityProbe{format: FormatPNG, carrier: CarrierText, input: fixture.WithChunks(fixture.PNG(8, 8), fixture.Chunk("tEXt", []byte("Author\x00Someone"))), rebuild: &Config{RemoveTextChunks: true}},
+		capabilityProbe{format: FormatPNG, carrier: CarrierICC, input: fixture.WithChunks(fixture.PNG(8, 8), fixture.Chunk("iCCP", []byte("icc\x00\x00x"))), rebuild: &Config{RemoveICCProfile: true}},
+		capabilityProbe{format: FormatPNG, carrier: CarrierOther, input: fixture.WithChunks(fixture.PNG(8, 8), fixture.Chunk("prVt", []byte("private"))), rebuild: &Config{RemoveUnknownChunks: true}},
+		capabilityProbe{format: FormatWebP, carrier: CarrierXMP, input: fixture.WebP(fixture.WebPChunk("XMP ", probeXMP[len(xmpSegmentPrefix):])), inPlace: &Config{RemoveGPSInfo: true}},
+		capabilityProbe{format: FormatWebP, carrier: CarrierICC, input: fixture.WebP(fixture.WebPChunk("ICCP", icc[14:])), rebuild: &Config{RemoveICCProfile: true}},
+		capabilityProbe{format: FormatHEIC, carrier: CarrierAuxiliaryImage, input: fixture.HEICItems(false,
+			fixture.HEICItem{Type: "hvc1", Data: []byte("primary")},
+			fixture.HEICItem{Type: "hvc1", Data: []byte("depth"), AuxOf: 1, Aux: "urn:mpeg:hevc:2015:auxid:2"},
+		), inPlace: &Config{RemoveAuxiliaryImages: true}},
+		capabilityProbe{format: FormatHEIC, carrier: CarrierXMP, input: fixture.HEICItems(false, fixture.HEICItem{Type: "mime", Content: "application/rdf+xml", Data: probeXMP[len(xmpSegmentPrefix):]}), inPlace: &Config{RemoveGPSInfo: true}},
+	)
+}
+
+// removedCarrier reports whether report records a removal from carrier.
+// The maker note is removed as an EXIF entry.
+func removedCarrier(report *Report, carrier Carrier) bool {
+	for _, item := range report.Removed {
+		if item.Carrier == carrier || carrier == CarrierMakerNote && item.Carrier == CarrierEXIF && item.Tag == 0x927c {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCapabilitiesMatchHandlers(t *testing.T) {
+	probed := map[Format]map[Carrier]bool{}
+	for _, p := range capabilityProbes() {
+		if probed[p.format] == nil {
+			probed[p.format] = map[Carrier]bool{}
+		}
+		probed[p.format][p.carrier] = true
+
+		capability, ok := Capabilities(p.format)[p.carrier]
+		if !ok {
+			t.Errorf("%s %s: probe for a carrier missing from Capabilities", p.format, p.carrier)
+			continue
+		}
+		if !capability.Readable || capability.RemovableInPlace != (p.inPlace != nil) || capability.RemovableByRebuild != (p.rebuild != nil) {
+			t.Errorf("%s %s: Capabilities says %+v, probes in place %v and by rebuild %v",
+				p.format, p.carrier, capability, p.inPlace != nil, p.rebuild != nil)
+		}
+		if detectFormat(p.input) != p.format {
+			t.Fatalf("%s %s: fixture detected as %s", p.format, p.carrier, detectFormat(p.input))
+		}
+		if p.inPlace != nil {
+			out, report := sanitize(t, p.input, *p.inPlace)
+			if !removedCarrier(report, p.carrier) {
+				t.Errorf("%s %s: nothing removed in place", p.format, p.carrier)
+			}
+			if len(out) != len(p.input) {
+				t.Errorf("%s %s: in-place removal changed the size from %d to %d", p.format, p.carrier, len(p.input), len(out))
+			}
+		}
+		if p.rebuild != nil {
+			out, report := sanitize(t, p.input, *p.rebuild)
+			if !removedCarrier(report, p.carrier) {
+				t.Errorf("%s %s: nothing removed by rebuild", p.format, p.carrier)
+			}
+			if len(out) >= len(p.input) {
+				t.Errorf("%s %s: rebuild left %d of %d bytes", p.format, p.carrier, len(out), len(p.input))
+			}
+		}
+	}
+
+	for _, f := range []Format{FormatJPEG, FormatPNG, FormatTIFF, FormatWebP, FormatHEIC} {
+		for c := range Capabilities(f) {
+			if !probed[f][c] {
+				t.Errorf("%s %s: no probe keeps the capability honest", f, c)
+			}
+		}
+	}
+}
+
+func TestCapabilitiesOption(t *testing.T) {
+	set := Capabilities(FormatJPEG)
+	if got := set[CarrierMakerNote].Option; got != "RemoveUserInfo" {
+		t.Errorf("maker note option %q, want RemoveUserInfo", got)
+	}
+	if got := set[CarrierEXIF].Option; got != "" {
+		t.Errorf("EXIF option %q, want none", got)
+	}
+	if got := set[CarrierIPTC].Option; got != "RemoveIPTC" {
+		t.Errorf("IPTC option %q, want RemoveIPTC", got)
+	}
+}
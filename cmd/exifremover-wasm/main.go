@@ -0,0 +1,64 @@
+//go:build js && wasm
+
+// Command exifremover-wasm exposes the sanitizer to JavaScript, so a
+// browser strips metadata with exactly the code the server runs. Build it
+// with
+//
+//	GOOS=js GOARCH=wasm go build -o exifremover.wasm ./cmd/exifremover-wasm
+//
+// and load it with the wasm_exec.js that ships with the Go toolchain. Once
+// running it defines a global exifremover object:
+//
+//	exifremover.sanitize(bytes: Uint8Array, policyJSON?: string)
+//	    // → {bytes: Uint8Array, report: string} or {error: string}
+//
+// policyJSON is a Config in the format ParsePolicy reads; when it is
+// omitted or empty the zero Config applies. report is the Report as JSON.
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"syscall/js"
+
+	"github.com/renix-codex/exifremover"
+)
+
+func main() {
+	js.Global().Set("exifremover", map[string]any{
+		"sanitize": js.FuncOf(sanitize),
+	})
+	select {} // keep the exported function callable
+}
+
+func sanitize(this js.Value, args []js.Value) any {
+	if len(args) == 0 || args[0].Type() != js.TypeObject {
+		return failure("sanitize expects a Uint8Array")
+	}
+	data := make([]byte, args[0].Get("length").Int())
+	js.CopyBytesToGo(data, args[0])
+
+	var config exifremover.Config
+	if len(args) > 1 && args[1].Type() == js.TypeString && args[1].String() != "" {
+		var err error
+		if config, err = exifremover.ParsePolicy(strings.NewReader(args[1].String())); err != nil {
+			return failure(err.Error())
+		}
+	}
+
+	out, report, err := exifremover.RemoveEXIFFromBytesReport(data, config)
+	if err != nil {
+		return failure(err.Error())
+	}
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return failure(err.Error())
+	}
+	result := js.Global().Get("Uint8Array").New(len(out))
+	js.CopyBytesToJS(result, out)
+	return map[string]any{"bytes": result, "report": string(reportJSON)}
+}
+
+func failure(message string) any {
+	return map[string]any{"error": message}
+}
@@ -0,0 +1,45 @@
+// Command exifremover inspects and sanitizes image metadata from the shell.
+//
+// Usage:
+//
+//	exifremover verify [flags] dir
+//	exifremover verify --policy policy.json --explain
+//	exifremover selftest
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/renix-codex/exifremover"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "verify":
+		os.Exit(verify(os.Args[2:]))
+	case "selftest":
+		os.Exit(selftest(os.Args[2:]))
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: exifremover verify [flags] dir")
+	fmt.Fprintln(os.Stderr, "       exifremover selftest")
+	os.Exit(2)
+}
+
+// loadPolicy reads and validates a policy file
+func loadPolicy(path string) (exifremover.Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return exifremover.Config{}, err
+	}
+	defer f.Close()
+	return exifremover.ParsePolicy(f)
+}
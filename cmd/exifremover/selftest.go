@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/renix-codex/exifremover"
+)
+
+// selftest runs the library self test and prints its results. It returns
+// 0 when every check passed and 1 otherwise.
+func selftest(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: exifremover selftest")
+		return 2
+	}
+	fmt.Printf("exifremover %s\n\n", exifremover.Version)
+
+	formats := []exifremover.Format{exifremover.FormatJPEG, exifremover.FormatPNG, exifremover.FormatTIFF, exifremover.FormatWebP, exifremover.FormatHEIC}
+	for _, f := range formats {
+		var carriers []string
+		for c := range exifremover.Capabilities(f) {
+			carriers = append(carriers, c.String())
+		}
+		sort.Strings(carriers)
+		fmt.Printf("%-5s %s\n", f, strings.Join(carriers, ", "))
+	}
+	fmt.Println()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FORMAT\tPRESET\tRESULT")
+	failed := false
+	for _, r := range exifremover.SelfTestReport() {
+		result := "pass"
+		if r.Err != nil {
+			result, failed = "FAIL: "+r.Err.Error(), true
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Format, r.Preset, result)
+	}
+	tw.Flush()
+	if failed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/renix-codex/exifremover"
+)
+
+// violationRecord is one line of --jsonl output
+type violationRecord struct {
+	File     string               `json:"file"`
+	Carrier  exifremover.Carrier  `json:"carrier"`
+	Tag      uint16               `json:"tag,omitempty"`
+	Name     string               `json:"name"`
+	Severity exifremover.Severity `json:"severity"`
+}
+
+// verify checks every file under a directory against a policy. It returns
+// 0 when no unallowed violations were found, 1 when some were, and 2 when
+// the check could not be completed.
+func verify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	policyPath := flags.String("policy", "", "policy file (JSON-encoded Config)")
+	allowPath := flags.String("allowlist", "", "allowlist file of known-acceptable violations")
+	jsonl := flags.Bool("jsonl", false, "print violations as JSON lines")
+	failFast := flags.Bool("fail-fast", false, "stop at the first unallowed violation")
+	trace := flags.Bool("trace", false, "print the decision for every metadata item of each file")
+	explain := flags.Bool("explain", false, "print what the policy removes and exit without checking anything")
+	flags.Parse(args)
+	if flags.NArg() != 1 && !*explain || *policyPath == "" {
+		fmt.Fprintln(os.Stderr, "usage: exifremover verify --policy policy.json [--allowlist file] [--jsonl] [--fail-fast] [--trace] dir")
+		fmt.Fprintln(os.Stderr, "       exifremover verify --policy policy.json --explain")
+		return 2
+	}
+
+	config, err := loadPolicy(*policyPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "exifremover: policy:", err)
+		return 2
+	}
+	if *explain {
+		e := exifremover.ExplainPolicy(config)
+		fmt.Print(e.Text("en", nil))
+		for _, w := range e.Warnings {
+			fmt.Fprintln(os.Stderr, "exifremover: policy:", w)
+		}
+		return 0
+	}
+	var allow *exifremover.Allowlist
+	if *allowPath != "" {
+		f, err := os.Open(*allowPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "exifremover: allowlist:", err)
+			return 2
+		}
+		allow, err = exifremover.LoadAllowlist(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "exifremover: allowlist:", err)
+			return 2
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	found := false
+	errStop := errors.New("stop")
+	err = filepath.WalkDir(flags.Arg(0), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if *trace {
+			if err := traceFile(path, config); err != nil {
+				fmt.Fprintf(os.Stderr, "exifremover: %s: %v\n", path, err)
+			}
+		}
+		violations, hash, err := verifyFile(path, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "exifremover: %s: %v\n", path, err)
+			return nil
+		}
+		for _, v := range violations {
+			if allow.Allows(hash, v) {
+				continue
+			}
+			found = true
+			if *jsonl {
+				enc.Encode(violationRecord{path, v.Carrier, v.Tag, v.Name, v.Severity})
+			} else {
+				fmt.Printf("%s: %s %s (%s)\n", path, v.Carrier, v.Name, v.Severity)
+			}
+			if *failFast {
+				return errStop
+			}
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		fmt.Fprintln(os.Stderr, "exifremover:", err)
+		return 2
+	}
+	if found {
+		return 1
+	}
+	return 0
+}
+
+// verifyFile returns the violations in one file and the file's hash
+func verifyFile(path string, config exifremover.Config) ([]exifremover.Violation, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	violations, err := exifremover.VerifyClean(f, config)
+	if err != nil || len(violations) == 0 {
+		return violations, "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	hash, err := exifremover.FileHash(f)
+	return violations, hash, err
+}
+
+// traceFile prints the decision trace of processing one file with config
+func traceFile(path string, config exifremover.Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	config.Trace = true
+	report, err := exifremover.RemoveReport(f, io.Discard, config)
+	if report == nil {
+		return err
+	}
+	for _, e := range report.Trace {
+		fmt.Printf("%s: trace: %s\n", path, e)
+	}
+	if report.TraceTruncated {
+		fmt.Printf("%s: trace: truncated\n", path)
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// captureStdout returns what fn prints to os.Stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	fn()
+	w.Close()
+	return string(<-done)
+}
+
+// TestVerifyTrace checks that --trace prints the kept items of every
+// carrier, not only EXIF entries
+func TestVerifyTrace(t *testing.T) {
+	dir := t.TempDir()
+	policy := filepath.Join(dir, "policy.json")
+	images := filepath.Join(dir, "images")
+	if err := os.Mkdir(images, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	jpeg := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	jpeg = fixture.WithSegment(jpeg, 0xE1, fixture.XMP(`xmlns:dc="http://purl.org/dc/elements/1.1/" dc:format="image/jpeg"`, ""))
+	jpeg = fixture.WithSegment(jpeg, 0xED, fixture.Photoshop(fixture.Resource(0x0404, bytes.Join([][]byte{
+		fixture.Dataset(2, 120, "Kept caption"),
+		fixture.Dataset(2, 90, "Springfield"),
+	}, nil))))
+	png := fixture.WithChunks(fixture.PNG(8, 8), fixture.Chunk("tEXt", []byte("Comment\x00hello")))
+	for path, data := range map[string][]byte{
+		policy:                         []byte(`{"RemoveGPSInfo": true}`),
+		filepath.Join(images, "a.jpg"): jpeg,
+		filepath.Join(images, "b.png"): png,
+	} {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var code int
+	out := captureStdout(t, func() {
+		code = verify([]string{"--policy", policy, "--trace", images})
+	})
+	if code != 1 {
+		t.Errorf("exit code %d, want 1 for the GPS position left in a.jpg\n%s", code, out)
+	}
+	a, b := filepath.Join(images, "a.jpg"), filepath.Join(images, "b.png")
+	for _, want := range []string{
+		a + ": trace: EXIF IFD0 Make: preserve (no enabled category)",
+		a + ": trace: XMP dc:format: preserve (in no category)",
+		a + ": trace: IPTC dataset 2:120: preserve (in no category)",
+		a + ": trace: IPTC City: remove (RemoveGPSInfo)",
+		b + ": trace: text tEXt Comment: preserve (in no category)",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
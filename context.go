@@ -0,0 +1,102 @@
+package exifremover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrFileTooLarge is returned for input larger than Config.MaxFileSize
+var ErrFileTooLarge = errors.New("file too large")
+
+// RemoveEXIFSelectiveContext is RemoveEXIFSelective, stopping with
+// ctx.Err() once ctx ends. The output file is removed when processing
+// fails, so a cancelled call leaves no partial image at outputPath.
+func RemoveEXIFSelectiveContext(ctx context.Context, inputPath, outputPath string, config Config) error {
+	_, err := RemoveEXIFSelectiveReportContext(ctx, inputPath, outputPath, config)
+	return err
+}
+
+// RemoveEXIFSelectiveReportContext is RemoveEXIFSelectiveContext,
+// additionally returning a Report describing the image that was processed
+func RemoveEXIFSelectiveReportContext(ctx context.Context, inputPath, outputPath string, config Config) (*Report, error) {
+	s, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	return s.RemoveFileContext(ctx, inputPath, outputPath)
+}
+
+// RemoveContext is Remove, stopping with ctx.Err() once ctx ends. As with
+// any failure of Remove, w may then hold part of an image.
+func RemoveContext(ctx context.Context, r io.Reader, w io.Writer, config Config) error {
+	_, err := RemoveReportContext(ctx, r, w, config)
+	return err
+}
+
+// RemoveReportContext is RemoveContext, additionally returning a Report
+// describing the image that was processed
+func RemoveReportContext(ctx context.Context, r io.Reader, w io.Writer, config Config) (*Report, error) {
+	s, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	return s.RemoveContext(ctx, r, w)
+}
+
+// contextReader returns r checking ctx before every read. The handlers
+// read each segment, chunk and box, and copy image data, through their
+// reader, so cancellation is seen between segments and within one read
+// of any copy. A ctx that can't end is given no wrapper.
+func contextReader(ctx context.Context, r io.Reader) io.Reader {
+	if ctx.Done() == nil {
+		return r
+	}
+	return &ctxReader{ctx: ctx, r: r}
+}
+
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// sizeLimitReader fails with ErrFileTooLarge once more than limit bytes
+// have been read
+type sizeLimitReader struct {
+	r     io.Reader
+	read  int64
+	limit int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrFileTooLarge, l.limit)
+	}
+	return n, err
+}
+
+// checkFileSize fails a regular file larger than Config.MaxFileSize
+// before any of it is read
+func checkFileSize(path string, config Config) error {
+	if config.MaxFileSize <= 0 {
+		return nil
+	}
+	info, err := fsys.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().IsRegular() && info.Size() > config.MaxFileSize {
+		return fmt.Errorf("%s: %w: %d bytes", path, ErrFileTooLarge, info.Size())
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package exifremover
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// DateTimePolicy is what removing the capture dates does with them
+type DateTimePolicy int
+
+const (
+	// DateTimeRemove overwrites the date tags
+	DateTimeRemove DateTimePolicy = iota
+	// DateTimeTruncateToDate keeps the day and sets the time to midnight
+	DateTimeTruncateToDate
+	// DateTimeShift adds Config.DateTimeShiftBy, which hides the real time
+	// but keeps photos in order
+	DateTimeShift
+	// DateTimeSetFixed writes Config.DateTimeFixed
+	DateTimeSetFixed
+
+	lastDateTimePolicy = DateTimeSetFixed
+)
+
+// String returns a lower-case name for the policy
+func (p DateTimePolicy) String() string {
+	switch p {
+	case DateTimeRemove:
+		return "remove"
+	case DateTimeTruncateToDate:
+		return "truncate-to-date"
+	case DateTimeShift:
+		return "shift"
+	case DateTimeSetFixed:
+		return "set-fixed"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the policy by name
+func (p DateTimePolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes a policy name as returned by String
+func (p *DateTimePolicy) UnmarshalText(text []byte) error {
+	for policy := DateTimeRemove; policy <= lastDateTimePolicy; policy++ {
+		if policy.String() == string(text) {
+			*p = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown date/time policy %q", text)
+}
+
+// exifDateLayout is the format of the EXIF date tags, 19 characters
+// followed by a NUL
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// dateTimeTags are the tags DateTimePolicy rewrites, all of them in
+// CategoryDateTime: the dates, and the SubSecTime and OffsetTime tags that
+// qualify them, which DateTimeRemove removes along with the dates
+var dateTimeTags = map[uint16]string{
+	0x0132: "date", 0x9003: "date", 0x9004: "date",
+	0x9290: "subsec", 0x9291: "subsec", 0x9292: "subsec",
+	0x9010: "offset", 0x9011: "offset", 0x9012: "offset",
+}
+
+// validateDateTime checks the date/time policy
+func validateDateTime(config Config) error {
+	switch {
+	case config.DateTimePolicy < DateTimeRemove || config.DateTimePolicy > lastDateTimePolicy:
+		return fmt.Errorf("unknown date/time policy %d", int(config.DateTimePolicy))
+	case config.DateTimePolicy == DateTimeSetFixed && config.DateTimeFixed.IsZero():
+		return &OptionsError{"DateTimeSetFixed", "no DateTimeFixed"}
+	case config.DateTimePolicy == DateTimeSetFixed && config.DateTimeFixed.Year() > 9999:
+		return fmt.Errorf("DateTimeFixed %v past the years EXIF can store", config.DateTimeFixed)
+	}
+	return nil
+}
+
+// rewriteDateTime applies a DateTimePolicy other than DateTimeRemove to the
+// entry at pos and reports whether it did, rewriting the value in place at
+// the same length. Dates are rewritten only where they would otherwise be
+// removed; SubSecTime values are zeroed, since the shifted or truncated
+// time has no fraction, and OffsetTime values are set to the zone of
+// DateTimeFixed under DateTimeSetFixed and kept otherwise. Values that
+// don't parse are left for the caller to remove.
+func rewriteDateTime(data []byte, pos int, order binary.ByteOrder, config Config, report *Report) bool {
+	if config.DateTimePolicy == DateTimeRemove {
+		return false
+	}
+	tag := order.Uint16(data[pos : pos+2])
+	kind, ok := dateTimeTags[tag]
+	if !ok || !removeEntry(data, pos, order, config) {
+		return false
+	}
+	value := asciiValue(data, pos, order)
+	if value == nil {
+		return false
+	}
+
+	out := append([]byte(nil), value...)
+	switch kind {
+	case "date":
+		if len(value) < len(exifDateLayout) {
+			return false
+		}
+		t, err := time.Parse(exifDateLayout, string(value[:len(exifDateLayout)]))
+		if err != nil {
+			return false
+		}
+		switch config.DateTimePolicy {
+		case DateTimeTruncateToDate:
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		case DateTimeShift:
+			t = t.Add(config.DateTimeShiftBy)
+		case DateTimeSetFixed:
+			t = config.DateTimeFixed
+		}
+		if t.Year() < 1 || t.Year() > 9999 {
+			return false
+		}
+		copy(out, t.Format(exifDateLayout))
+	case "subsec":
+		for i, c := range out {
+			if c >= '0' && c <= '9' {
+				out[i] = '0'
+			}
+		}
+	case "offset":
+		if config.DateTimePolicy != DateTimeSetFixed {
+			return true
+		}
+		if len(out) < len("-07:00") {
+			return false
+		}
+		copy(out, config.DateTimeFixed.Format("-07:00"))
+	}
+	if string(out) == string(value) {
+		return true // already rewritten, so a sanitized file verifies clean
+	}
+
+	t := exifTag(tag, config)
+	item := RemovedItem{Carrier: CarrierEXIF, Tag: tag, Name: t.Name, Categories: t.Categories, Strength: RemovalOverwritten, Size: int64(len(value))}
+	item.Value, _ = entryString(data, pos, order)
+	copy(value, out)
+	report.remove(item)
+	return true
+}
+
+// asciiValue returns the value of the ASCII entry at pos, inline or at the
+// offset it points to, for editing in place, or nil if its extent can't
+// be trusted
+func asciiValue(data []byte, pos int, order binary.ByteOrder) []byte {
+	if order.Uint16(data[pos+2:pos+4]) != 2 {
+		return nil
+	}
+	count := int64(order.Uint32(data[pos+4 : pos+8]))
+	if count <= 4 {
+		return data[pos+8 : pos+8+int(count)]
+	}
+	offset := int64(order.Uint32(data[pos+8 : pos+12]))
+	if offset < 8 || offset+count > int64(len(data)) ||
+		!(int64(pos)+12 <= offset || offset+count <= int64(pos)) {
+		return nil
+	}
+	return data[offset : offset+count]
+}
@@ -0,0 +1,7 @@
+// Package exifremover selectively removes EXIF and related metadata from
+// JPEG and PNG images.
+//
+// No function in this package modifies a buffer it was given: metadata is
+// edited in copies owned by the library, so inputs may be shared, cached or
+// read-only memory.
+package exifremover
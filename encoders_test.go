@@ -0,0 +1,192 @@
+package exifremover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// encoded is one generated input of the encoder matrix
+type encoded struct {
+	name string
+	data []byte
+}
+
+// testImage is a 40x24 pattern, wide enough for several MCUs and a
+// restart interval, with every channel varying
+func testImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 40; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 6), uint8(y * 10), uint8((x + y) * 4), uint8(255 - x)})
+		}
+	}
+	return img
+}
+
+// stdlibEncodings covers the layouts Go's own encoders write: JPEG at low
+// and high quality, and PNG in every colour type the encoder chooses
+func stdlibEncodings(t *testing.T) []encoded {
+	src := testImage()
+	encode := func(name string, f func(*bytes.Buffer) error) encoded {
+		var b bytes.Buffer
+		if err := f(&b); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return encoded{name, b.Bytes()}
+	}
+	gray := image.NewGray(src.Bounds())
+	draw.Draw(gray, gray.Bounds(), src, image.Point{}, draw.Src)
+	gray16 := image.NewGray16(src.Bounds())
+	draw.Draw(gray16, gray16.Bounds(), src, image.Point{}, draw.Src)
+	nrgba64 := image.NewNRGBA64(src.Bounds())
+	draw.Draw(nrgba64, nrgba64.Bounds(), src, image.Point{}, draw.Src)
+	paletted := image.NewPaletted(src.Bounds(), color.Palette{color.Black, color.White, color.RGBA{255, 0, 0, 255}, color.Transparent})
+	draw.Draw(paletted, paletted.Bounds(), src, image.Point{}, draw.Src)
+
+	return []encoded{
+		encode("stdlib jpeg q50", func(b *bytes.Buffer) error { return jpeg.Encode(b, src, &jpeg.Options{Quality: 50}) }),
+		encode("stdlib jpeg q95", func(b *bytes.Buffer) error { return jpeg.Encode(b, src, &jpeg.Options{Quality: 95}) }),
+		encode("stdlib jpeg gray", func(b *bytes.Buffer) error { return jpeg.Encode(b, gray, nil) }),
+		encode("stdlib png rgba", func(b *bytes.Buffer) error { return png.Encode(b, src) }),
+		encode("stdlib png gray", func(b *bytes.Buffer) error { return png.Encode(b, gray) }),
+		encode("stdlib png gray16", func(b *bytes.Buffer) error { return png.Encode(b, gray16) }),
+		encode("stdlib png nrgba64", func(b *bytes.Buffer) error { return png.Encode(b, nrgba64) }),
+		encode("stdlib png paletted", func(b *bytes.Buffer) error { return png.Encode(b, paletted) }),
+		encode("stdlib png best compression", func(b *bytes.Buffer) error {
+			return (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(b, src)
+		}),
+	}
+}
+
+// toolEncodings runs whichever of ImageMagick, libvips and mozjpeg are
+// installed over a PNG of the test image; the layouts they write, with
+// restart intervals, optimized and progressive Huffman tables and their own
+// APP segments, are what real uploads look like
+func toolEncodings(t *testing.T) []encoded {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.png")
+	var b bytes.Buffer
+	if err := png.Encode(&b, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(source, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ppm := filepath.Join(dir, "source.ppm")
+
+	tools := []struct {
+		name, tool string
+		args       func(out string) []string
+		ext        string
+	}{
+		{"imagemagick jpeg restart", "convert", func(out string) []string { return []string{source, "-define", "jpeg:restart-interval=1", out} }, ".jpg"},
+		{"imagemagick jpeg progressive", "convert", func(out string) []string { return []string{source, "-interlace", "Plane", out} }, ".jpg"},
+		{"imagemagick png", "convert", func(out string) []string { return []string{source, out} }, ".png"},
+		{"libvips jpeg optimized", "vips", func(out string) []string { return []string{"jpegsave", source, out, "--optimize-coding"} }, ".jpg"},
+		{"libvips png", "vips", func(out string) []string { return []string{"pngsave", source, out} }, ".png"},
+		{"mozjpeg", "cjpeg", func(out string) []string { return []string{"-outfile", out, ppm} }, ".jpg"},
+	}
+	var encodings []encoded
+	for i, tool := range tools {
+		path, err := exec.LookPath(tool.tool)
+		if err != nil {
+			t.Logf("%s: %s not installed, skipped", tool.name, tool.tool)
+			continue
+		}
+		if tool.tool == "cjpeg" {
+			// cjpeg reads no PNG; ImageMagick converts when it is around
+			convert, err := exec.LookPath("convert")
+			if err != nil {
+				t.Logf("%s: no converter for cjpeg's input, skipped", tool.name)
+				continue
+			}
+			if out, err := exec.Command(convert, source, ppm).CombinedOutput(); err != nil {
+				t.Fatalf("%s: %v\n%s", tool.name, err, out)
+			}
+		}
+		out := filepath.Join(dir, fmt.Sprintf("%d%s", i, tool.ext))
+		if msg, err := exec.Command(path, tool.args(out)...).CombinedOutput(); err != nil {
+			t.Fatalf("%s: %v\n%s", tool.name, err, msg)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encodings = append(encodings, encoded{tool.name, data})
+	}
+	return encodings
+}
+
+// withMetadata adds the metadata a camera or editor leaves to an encoding
+func withMetadata(e encoded) encoded {
+	tiff := fixture.Sample().Bytes()
+	if detectFormat(e.data) == FormatPNG {
+		return encoded{e.name + " with metadata", fixture.WithChunks(e.data,
+			fixture.Chunk("eXIf", tiff),
+			fixture.Chunk("tEXt", []byte("Author\x00Someone")),
+		)}
+	}
+	data := fixture.WithSegment(e.data, 0xFE, []byte("edited"))
+	data = fixture.WithSegment(data, 0xE1, probeXMP)
+	data = fixture.WithSegment(data, 0xE1, append([]byte("Exif\x00\x00"), tiff...)) // First, where readers look
+	return encoded{e.name + " with metadata", data}
+}
+
+// pixelHash decodes data and hashes its pixels
+func pixelHash(t *testing.T, name string, data []byte) [32]byte {
+	t.Helper()
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s: output doesn't decode: %v", name, err)
+	}
+	h := sha256.New()
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			fmt.Fprintf(h, "%d %d %d %d,", r, g, bl, a)
+		}
+	}
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+func TestEncoderMatrix(t *testing.T) {
+	var inputs []encoded
+	for _, e := range append(stdlibEncodings(t), toolEncodings(t)...) {
+		inputs = append(inputs, e, withMetadata(e))
+	}
+	for _, in := range inputs {
+		// A no-op run passes every byte through
+		if out, _ := sanitize(t, in.data, Config{}); !bytes.Equal(out, in.data) {
+			t.Errorf("%s: no-op run changed the file", in.name)
+		}
+		want := pixelHash(t, in.name, in.data)
+		for _, preset := range selfTestPresets() {
+			name := in.name + ", " + preset.name
+			out, _ := sanitize(t, in.data, preset.config)
+			if pixelHash(t, name, out) != want {
+				t.Errorf("%s: pixels changed", name)
+			}
+			if again, _ := sanitize(t, in.data, preset.config); !bytes.Equal(again, out) {
+				t.Errorf("%s: two runs differ", name)
+			}
+			if violations, err := VerifyClean(bytes.NewReader(out), preset.config); err != nil || len(violations) > 0 {
+				t.Errorf("%s: %v left, err %v", name, violations, err)
+			}
+		}
+	}
+}
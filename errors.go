@@ -0,0 +1,74 @@
+package exifremover
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnsupportedFormat is wrapped in a FormatError for input in a
+	// format the package doesn't handle
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrCorruptImage is wrapped by errors for input whose structure is
+	// invalid or truncated; the error text gives the detail, and for
+	// truncated input io.ErrUnexpectedEOF is wrapped as well
+	ErrCorruptImage = errors.New("corrupt image")
+	// ErrUnhandledMetadata is returned under
+	// Config.FailOnUnhandledMetadata for input holding metadata the
+	// package can see but not sanitize; Report.Warnings lists it
+	ErrUnhandledMetadata = errors.New("unhandled metadata")
+	// ErrInputChanged is returned under Config.UseMmap when the mapped
+	// input was truncated while it was being processed
+	ErrInputChanged = errors.New("input changed while being read")
+)
+
+// FormatError reports input whose format was not recognized
+type FormatError struct {
+	Magic []byte // the leading bytes format detection looked at
+	Guess string // the format Magic suggests, such as "GIF", or empty
+}
+
+func (e *FormatError) Error() string {
+	if e.Guess != "" {
+		return fmt.Sprintf("%v (%s)", ErrUnsupportedFormat, e.Guess)
+	}
+	return fmt.Sprintf("%v (magic % x)", ErrUnsupportedFormat, e.Magic)
+}
+
+func (e *FormatError) Unwrap() error { return ErrUnsupportedFormat }
+
+// formatError returns the FormatError for an unrecognized header
+func formatError(header []byte) error {
+	e := &FormatError{Magic: bytes.Clone(header)}
+	for _, m := range knownMagic {
+		if len(header) >= m.offset+len(m.magic) && bytes.Equal(header[m.offset:m.offset+len(m.magic)], []byte(m.magic)) {
+			e.Guess = m.name
+			break
+		}
+	}
+	return e
+}
+
+// knownMagic names common formats the package doesn't handle, for
+// FormatError.Guess
+var knownMagic = []struct {
+	offset int
+	magic  string
+	name   string
+}{
+	{0, "GIF8", "GIF"},
+	{0, "BM", "BMP"},
+	{0, "%PDF-", "PDF"},
+	{0, "\x00\x00\x00\x0cJXL ", "JPEG XL"},
+	{0, "\xff\x0a", "JPEG XL"},
+	{4, "ftypavif", "AVIF"},
+	{4, "ftyp", "ISO-BMFF"},
+	{0, "RIFF", "RIFF"},
+	{0, "8BPS", "PSD"},
+}
+
+// corrupt returns an error wrapping ErrCorruptImage with detail
+func corrupt(detail string) error {
+	return fmt.Errorf("%w: %s", ErrCorruptImage, detail)
+}
@@ -0,0 +1,89 @@
+package exifremover
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// TestErrorSentinels checks that each kind of failure matches its sentinel
+// with errors.Is, through every layer that wraps it
+func TestErrorSentinels(t *testing.T) {
+	jpeg := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	png := fixture.WithChunks(fixture.PNG(8, 8), fixture.Chunk("eXIf", fixture.Sample().Bytes()))
+	eXIf := bytes.Index(png, []byte("eXIf"))
+	for _, tt := range []struct {
+		name   string
+		input  []byte
+		config Config
+		want   []error
+	}{
+		{"unknown format", []byte("not an image at all"), Config{}, []error{ErrUnsupportedFormat}},
+		{"GIF", []byte("GIF89a\x01\x00\x01\x00"), Config{}, []error{ErrUnsupportedFormat}},
+		{"truncated JPEG", jpeg[:40], Config{RemoveGPSInfo: true}, []error{ErrCorruptImage, io.ErrUnexpectedEOF}},
+		{"JPEG segment length 1", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01}, Config{}, []error{ErrCorruptImage}},
+		{"JPEG without a marker", []byte{0xFF, 0xD8, 0x00, 0x00}, Config{}, []error{ErrCorruptImage}},
+		{"truncated PNG", png[:eXIf+20], Config{RemoveGPSInfo: true}, []error{ErrCorruptImage, io.ErrUnexpectedEOF}},
+		{"JPEG APP1 segment too large", jpeg, Config{MaxMetadataSize: 16}, []error{ErrMetadataTooLarge}},
+		{"PNG chunk too large", png, Config{MaxMetadataSize: 16}, []error{ErrMetadataTooLarge}},
+		{"PNG chunk past the input", append(append([]byte(nil), png[:8]...), 0, 0, 0x10, 0, 'e', 'X', 'I', 'f'), Config{}, []error{ErrCorruptImage, io.ErrUnexpectedEOF}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RemoveEXIFFromBytes(tt.input, tt.config)
+			for _, want := range tt.want {
+				if !errors.Is(err, want) {
+					t.Errorf("RemoveEXIFFromBytes: %v, want %v", err, want)
+				}
+			}
+			err = Remove(bytes.NewReader(tt.input), io.Discard, tt.config)
+			for _, want := range tt.want {
+				if !errors.Is(err, want) {
+					t.Errorf("Remove: %v, want %v", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	_, err := RemoveEXIFFromBytes([]byte("GIF89a\x01\x00\x01\x00"), Config{})
+	var formatErr *FormatError
+	if !errors.As(err, &formatErr) {
+		t.Fatalf("%v is not a FormatError", err)
+	}
+	if formatErr.Guess != "GIF" || !bytes.HasPrefix(formatErr.Magic, []byte("GIF89a")) {
+		t.Errorf("FormatError %+v, want Guess GIF and the magic", formatErr)
+	}
+
+	_, err = RemoveEXIFFromBytes([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13}, Config{})
+	if !errors.As(err, &formatErr) || formatErr.Guess != "" || len(formatErr.Magic) == 0 {
+		t.Errorf("unknown magic: %v, want a FormatError with the magic and no guess", err)
+	}
+}
+
+// errReader fails every read with err
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// TestIOErrorsWrapped checks that I/O errors come back so errors.Is still
+// finds them
+func TestIOErrorsWrapped(t *testing.T) {
+	jpeg := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	errBroken := errors.New("connection reset")
+	r := io.MultiReader(bytes.NewReader(jpeg[:30]), errReader{errBroken})
+	if err := Remove(r, io.Discard, Config{RemoveGPSInfo: true}); !errors.Is(err, errBroken) {
+		t.Errorf("failing reader: %v, want %v", err, errBroken)
+	}
+
+	dir := t.TempDir()
+	err := RemoveEXIFSelective(filepath.Join(dir, "missing.jpg"), filepath.Join(dir, "out.jpg"), Config{})
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing input: %v, want fs.ErrNotExist", err)
+	}
+}
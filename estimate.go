@@ -0,0 +1,283 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// EstimateOutputSize returns the size processing r with config would
+// produce, for callers that preallocate storage, and rewinds r afterwards.
+// It writes nothing and reads little: JPEG segments and PNG chunks are
+// walked by their lengths, reading of each only the identifier or keyword
+// its fate depends on. exact reports whether the size is exact. It is
+// false when an edit's size depends on the metadata itself, such as an
+// IPTC block losing some of its datasets or a packet being minified, and
+// for the other formats, for which the input size is returned.
+func EstimateOutputSize(r io.ReadSeeker, config Config) (size int64, exact bool, err error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false, err
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false, err
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return 0, false, err
+	}
+	header := make([]byte, 12)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return 0, false, err
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return 0, false, err
+	}
+
+	e := &estimate{r: r, size: end - start, exact: true}
+	switch detectFormat(header[:n]) {
+	case FormatJPEG:
+		err = e.jpeg(config)
+	case FormatPNG:
+		err = e.png(config)
+	case FormatUnknown:
+		return 0, false, formatError(header[:n])
+	default:
+		e.exact = false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		// A truncated file fails processing; its size is a guess
+		err, e.exact = nil, false
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return 0, false, err
+	}
+	return e.size, e.exact, nil
+}
+
+// estimate walks an input for EstimateOutputSize, taking the bytes
+// processing would drop off size
+type estimate struct {
+	r     io.ReadSeeker
+	size  int64
+	exact bool
+}
+
+// estimatePrefix is how much of a segment is read to classify it: enough
+// for the identifier and the RIFF header isAudioSegment looks past it
+const estimatePrefix = 64
+
+// prefix reads up to limit bytes of an n-byte body and skips the rest of it
+// and then skip more
+func (e *estimate) prefix(n int64, limit int, skip int64) ([]byte, error) {
+	p := make([]byte, limit)
+	if n < int64(len(p)) {
+		p = p[:n]
+	}
+	if _, err := io.ReadFull(e.r, p); err != nil {
+		return nil, err
+	}
+	_, err := e.r.Seek(n-int64(len(p))+skip, io.SeekCurrent)
+	return p, err
+}
+
+// jpeg mirrors the segment decisions of processJPEG
+func (e *estimate) jpeg(config Config) error {
+	if _, err := e.r.Seek(2, io.SeekCurrent); err != nil { // SOI
+		return err
+	}
+	sawEOI := false
+	b := make([]byte, 2)
+	var mpf bool // an MPF index whose images are cut after the scan
+	for {
+		if _, err := io.ReadFull(e.r, b[:1]); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if b[0] != 0xFF {
+			e.exact = false // processing fails
+			return nil
+		}
+		marker := byte(0xFF)
+		for marker == 0xFF {
+			if _, err := io.ReadFull(e.r, b[:1]); err != nil {
+				return err
+			}
+			marker = b[0]
+			if marker == 0xFF {
+				e.size-- // Fill bytes are dropped
+			}
+		}
+
+		if marker == 0xDA {
+			if config.StampProcessed {
+				e.size += int64(len(jpegStamp(config)))
+			}
+			if mpf {
+				e.exact = false
+			}
+			if config.RepairStructure {
+				// Whether the scan ends in an EOI is only known by
+				// scanning it, save for the common case of it ending
+				// the file
+				if _, err := e.r.Seek(-2, io.SeekEnd); err != nil {
+					return err
+				}
+				if _, err := io.ReadFull(e.r, b); err != nil {
+					return err
+				}
+				if b[0] != 0xFF || b[1] != 0xD9 {
+					e.exact = false
+				}
+			}
+			return nil
+		}
+		if marker == 0xD9 {
+			sawEOI = true
+			break
+		}
+		if marker == 0x01 || marker == 0xD8 || (marker >= 0xD0 && marker <= 0xD7) {
+			continue
+		}
+
+		if _, err := io.ReadFull(e.r, b); err != nil {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint16(b))
+		if length < 2 || marker >= 0xE0 && marker <= 0xEF && int(length-2) > config.maxMetadataSize() {
+			e.exact = false // processing fails
+			return nil
+		}
+		data, err := e.prefix(length-2, estimatePrefix, 0)
+		if err != nil {
+			return err
+		}
+		dropped, known := jpegSegmentFate(marker, data, config)
+		if bytes.HasPrefix(data, mpfPrefix) && marker == 0xE2 && config.RemoveAuxiliaryImages {
+			mpf = true
+		}
+		if !known {
+			e.exact = false
+		}
+		if dropped {
+			e.size -= 2 + length
+		}
+	}
+	if !sawEOI && config.RepairStructure {
+		e.size += 2
+	}
+	return nil
+}
+
+// jpegSegmentFate reports whether processJPEG drops the segment whose
+// payload starts with data, and whether that and its new size are known
+// without reading the rest; a segment not dropped is otherwise kept at its
+// size, as every other edit is made in place
+func jpegSegmentFate(marker byte, data []byte, config Config) (dropped, known bool) {
+	isEXIF := bytes.HasPrefix(data, exifPrefix)
+	isXMP := bytes.HasPrefix(data, xmpSegmentPrefix)
+	switch {
+	case marker == 0xE1:
+		switch {
+		case config.DropEmptyMetadata && (isEXIF || isXMP):
+			return false, false
+		case isEXIF && config.RemoveAll:
+			return true, !config.PreserveOrientation
+		case isEXIF && config.Permissive:
+			order := data[len(exifPrefix):]
+			return len(order) < 2 || string(order[:2]) != "II" && string(order[:2]) != "MM", true
+		case isXMP:
+			return false, !config.Minify
+		case bytes.HasPrefix(data, xmpExtensionPrefix) && config.RemoveAuxiliaryImages:
+			return false, false
+		}
+	case marker == 0xFE:
+		return isStamp(data) || config.RemoveComments, true
+	case marker >= 0xE2 && marker <= 0xEF && isAudioSegment(data):
+		return config.RemoveVendorSegments, true
+	case marker == 0xE2 && config.RemoveICCProfile && bytes.HasPrefix(data, iccSegmentPrefix):
+		return true, true
+	case marker == 0xE2 && bytes.HasPrefix(data, mpfPrefix) && config.RemoveAuxiliaryImages:
+		return false, false
+	case marker == 0xED && modifiesIPTC(config):
+		return false, false
+	}
+	return false, true
+}
+
+// png mirrors the chunk decisions of processPNG
+func (e *estimate) png(config Config) error {
+	if _, err := e.r.Seek(8, io.SeekCurrent); err != nil { // Signature
+		return err
+	}
+	sawIEND := false
+	head := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(e.r, head); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		length := int64(binary.BigEndian.Uint32(head))
+		typ := string(head[4:])
+		if !validChunkType(head[4:]) {
+			e.exact = false // resynced under Salvage, or failed
+			return nil
+		}
+		chunk := 12 + length
+
+		switch {
+		case typ == "eXIf" && config.DropEmptyMetadata, typ == "eXIf" && config.Permissive:
+			e.exact = false
+		case typ == "eXIf" && config.RemoveAll:
+			e.size -= chunk
+			if config.PreserveOrientation {
+				e.exact = false
+			}
+		case typ == "tEXt" || typ == "zTXt" || typ == "iTXt":
+			prefix, err := e.prefix(length, pngKeywordPrefix, 4) // And the CRC
+			if err != nil {
+				return err
+			}
+			_, mode, remove := removeTextChunk(prefix, config)
+			i := bytes.IndexByte(prefix, 0)
+			switch {
+			case typ == "tEXt" && isStamp(prefix), remove && (mode == TextDrop || i < 0):
+				e.size -= chunk
+			case remove && typ == "tEXt":
+				e.size -= length - int64(i+1)
+			case remove && typ == "zTXt":
+				e.size -= length - int64(i+2+len(emptyZlib))
+			case remove:
+				e.exact = false // iTXt keeps fields past the prefix
+			}
+			continue
+		case typ == "iCCP" && config.RemoveICCProfile:
+			e.size -= chunk
+		case typ == "iCCP" && scrubICC(config):
+			e.exact = false
+		case typ == "IEND":
+			sawIEND = true
+			if config.StampProcessed {
+				e.size += int64(len(pngStamp(config)))
+			}
+		case !knownPNGChunks[typ] && pngAncillary(head[4:]) && config.RemoveUnknownChunks:
+			e.size -= chunk
+		}
+		if _, err := e.r.Seek(length+4, io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+	if !sawIEND && config.RepairStructure {
+		e.size += 12
+	}
+	return nil
+}
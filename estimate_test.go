@@ -0,0 +1,89 @@
+package exifremover
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// readCounter counts the bytes read through it
+type readCounter struct {
+	io.ReadSeeker
+	n int64
+}
+
+func (r *readCounter) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func TestEstimateOutputSize(t *testing.T) {
+	tiff := fixture.Sample().Bytes()
+	xmp := fixture.XMP(`xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/" photoshop:City="Shelbyville"`, "")
+	jpeg := fixture.WithSegment(fixture.EXIFJPEG(tiff), 0xE1, xmp)
+	jpeg = fixture.WithSegment(jpeg, 0xFE, []byte("comment"))
+	iptc := fixture.WithSegment(jpeg, 0xED, fixture.Photoshop(fixture.Resource(0x0404, fixture.Dataset(2, 120, "Caption"))))
+	png := fixture.WithChunks(fixture.PNG(8, 8),
+		fixture.Chunk("eXIf", tiff),
+		fixture.Chunk("tEXt", []byte("Author\x00Someone")),
+		fixture.Chunk("zTXt", append([]byte("Comment\x00\x00"), zlibBytes(t, "a comment")...)),
+		fixture.Chunk("iTXt", []byte("Title\x00\x00\x00en\x00Title\x00A title")),
+		fixture.Chunk("prVt", []byte("private")),
+	)
+
+	for _, c := range []struct {
+		name   string
+		input  []byte
+		config Config
+		exact  bool
+	}{
+		{"jpeg in place", jpeg, Config{RemoveGPSInfo: true, RemoveCameraInfo: true}, true},
+		{"jpeg remove all", jpeg, Config{RemoveAll: true, RemoveComments: true}, true},
+		{"jpeg stamp", jpeg, Config{RemoveGPSInfo: true, StampProcessed: true}, true},
+		{"jpeg minify", jpeg, Config{Minify: true}, false},
+		{"jpeg iptc", iptc, Config{RemoveIPTC: true}, false},
+		{"jpeg iptc kept", iptc, Config{RemoveComments: true}, true},
+		{"png in place", png, Config{RemoveGPSInfo: true}, true},
+		{"png drop", png, Config{RemoveAll: true, RemoveTextChunks: true, RemoveUnknownChunks: true}, true},
+		{"png blank", png, Config{TextKeyModes: map[string]TextMode{"Author": TextBlank, "Comment": TextBlank}}, true},
+		{"png blank iTXt", png, Config{RemoveTextChunks: true, TextMode: TextBlank}, false},
+		{"png stamp", png, Config{StampProcessed: true}, true},
+		{"tiff", tiff, Config{RemoveGPSInfo: true}, false},
+	} {
+		out, err := RemoveEXIFFromBytes(c.input, c.config)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		r := bytes.NewReader(c.input)
+		size, exact, err := EstimateOutputSize(r, c.config)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if exact != c.exact {
+			t.Errorf("%s: exact %v, want %v", c.name, exact, c.exact)
+		}
+		if exact && size != int64(len(out)) {
+			t.Errorf("%s: estimated %d bytes, wrote %d", c.name, size, len(out))
+		}
+		if r.Len() != len(c.input) {
+			t.Errorf("%s: reader not rewound", c.name)
+		}
+	}
+}
+
+func TestEstimateOutputSizeReadsLittle(t *testing.T) {
+	// Only segment headers and identifiers are read, not the metadata
+	xmp := fixture.XMP(`xmlns:dc="http://purl.org/dc/elements/1.1/"`, string(bytes.Repeat([]byte(" "), 60000)))
+	in := fixture.WithSegment(fixture.EXIFJPEG(fixture.Sample().Bytes()), 0xE1, xmp)
+	r := &readCounter{ReadSeeker: bytes.NewReader(in)}
+	size, exact, err := EstimateOutputSize(r, Config{RemoveGPSInfo: true})
+	if err != nil || !exact || size != int64(len(in)) {
+		t.Fatalf("got %d, %v, %v; want %d exactly", size, exact, err, len(in))
+	}
+	if r.n > 1024 {
+		t.Errorf("read %d of %d bytes", r.n, len(in))
+	}
+}
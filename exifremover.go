@@ -2,10 +2,17 @@ package exifremover
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
-	"os"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"strconv"
+	"time"
 )
 
 // Config specifies which EXIF properties to remove
@@ -16,259 +23,1575 @@ type Config struct {
 	RemoveDateTime        bool
 	RemoveUserInfo        bool
 	RemoveTechnicalDetail bool
+	RemoveEditingInfo     bool
+	RemoveFaceRegions     bool
+
+	// StampProcessed writes a marker recording that the file was sanitized
+	StampProcessed bool
+
+	// RepairStructure appends the JPEG EOI marker or PNG IEND chunk when the
+	// input ends without one. Truncated files are otherwise passed through
+	// with EOF treated as the end of the image. Either way the Report
+	// carries a WarnMissingEOI or WarnMissingIEND warning.
+	RepairStructure bool
+
+	// RemoveAuxiliaryImages drops secondary images stored alongside the
+	// primary one, such as the depth maps, mattes and gain maps portrait
+	// and HDR modes write: the JPEG images an MPF index lists after the
+	// primary, together with the index, HEIF items referenced as auxl,
+	// whose data is zeroed and which are marked hidden, and the XMP that
+	// embeds or describes them (GDepth, GImage, the Container directory
+	// and gain map parameters). The report names each image by role.
+	RemoveAuxiliaryImages bool
+
+	// PreserveGainMaps keeps HDR gain maps under RemoveAuxiliaryImages,
+	// since dropping them changes how HDR displays render the image. In a
+	// JPEG the MPF index is rewritten to list only the primary image and
+	// its gain maps, which holds the output from the index on in memory.
+	// HEIF alpha planes are always kept: they are part of the image.
+	PreserveGainMaps bool
+
+	// ScrubICCProfile extends RemoveDateTime to the creation date in the
+	// header of embedded ICC profiles, keeping the profile itself usable
+	ScrubICCProfile bool
+
+	// BlankICCDescription blanks the ICC profile description, which often
+	// names the company and machine that created the profile
+	BlankICCDescription bool
+
+	// RemoveICCProfile drops embedded color profiles: every JPEG APP2
+	// ICC_PROFILE segment of a profile split across several, the PNG iCCP
+	// chunk and the WebP ICCP chunk. Images then display in sRGB, which
+	// shifts the colors of wide-gamut photos. Without it and the two ICC
+	// options above, profiles are passed through byte for byte.
+	RemoveICCProfile bool
+
+	// AssertNoAdditions checks before anything is written that every
+	// segment or chunk of the output comes from the input or was explicitly
+	// requested (StampProcessed, RepairStructure), failing otherwise
+	AssertNoAdditions bool
+
+	// DropEmptyMetadata drops metadata containers with nothing in them,
+	// such as XMP packets holding only the xpacket wrapper and padding, or
+	// EXIF segments and chunks holding no TIFF structure. Otherwise such
+	// husks are passed through unchanged.
+	DropEmptyMetadata bool
+
+	// MinRemovalStrength, when set, fails files where any removal was
+	// weaker than it, before anything is written. See Report.WeakestRemoval.
+	MinRemovalStrength RemovalStrength
+
+	// FailOnUnhandledMetadata fails files for which Report.Warnings lists
+	// metadata with ErrUnhandledMetadata, before anything is written, so
+	// a strict pipeline never ships metadata it didn't know how to touch.
+	// Warnings about the file's structure, such as a missing EOI, don't
+	// count.
+	FailOnUnhandledMetadata bool
+
+	// ValueRules override the category decision for tags whose value
+	// matches, e.g. to keep an agency's canonical Artist credit
+	ValueRules []ValueRule
+
+	// CategoryOverrides replaces the built-in IFD0 and EXIF IFD tag list of
+	// a category with the given tag IDs, or adds to it when
+	// MergeCategoryOverrides is set. GPS IFD entries are not affected.
+	CategoryOverrides      map[Category][]uint16
+	MergeCategoryOverrides bool
+
+	// MaxChunks, when set, fails PNG files with more chunks than this
+	// with ErrTooManyChunks
+	MaxChunks int
+
+	// AllowFIFO lets the path-based API read from named pipes, which are
+	// otherwise refused with ErrNotRegularFile
+	AllowFIFO bool
+
+	// RemoveAll drops EXIF entirely: every EXIF APP1 segment of a JPEG
+	// and the eXIf chunk of a PNG. XMP APP1 segments are kept. The Exif item
+	// of a HEIC can't be dropped without re-muxing and is overwritten with
+	// an empty TIFF structure instead. It cannot be combined with
+	// ValueRules that keep tags.
+	RemoveAll bool
+
+	// PreserveOrientation keeps the Orientation tag (0x0112), so rotated
+	// photos still display upright, even where CategoryOverrides or
+	// ValueRules would remove it. Under RemoveAll each EXIF container is
+	// replaced by a minimal one holding only the orientation.
+	PreserveOrientation bool
+
+	// UseMmap lets the path-based API map the input read-only instead of
+	// reading it, where the platform supports it. Files modified in the
+	// last two seconds, which may still be growing, are read instead, as
+	// are files that fail to map; a mapped file truncated while it is
+	// processed fails with ErrInputChanged. The stream and buffer APIs have no file to
+	// map and fail with ErrIncompatibleOptions.
+	UseMmap bool
+
+	// RemoveIPTC removes IPTC-IIM data (byline, caption, city, keywords)
+	// from the Photoshop resources in a JPEG APP13 segment, keeping other
+	// resources such as clipping paths. A segment left empty is dropped.
+	// Without it, RemoveGPSInfo still removes the location datasets (city,
+	// sub-location, province and country) from the record.
+	RemoveIPTC bool
+
+	// MaxMetadataSize bounds the metadata chunks read into memory, and
+	// the profiles inflated from them, to guard against crafted lengths
+	// on untrusted input, JPEG APPn segments among them. Larger chunks
+	// fail with ErrMetadataTooLarge. Zero means 16MB; JPEG segments
+	// can't exceed 64KB regardless.
+	MaxMetadataSize int
+
+	// MemoryGauge, when set, is charged for every JPEG segment and PNG
+	// chunk buffered in memory before it is read, so a service can cap the
+	// metadata held across all of its calls in flight; see MemoryGauge.
+	// WebP, TIFF and HEIC input is read whole and is bounded by
+	// MaxFileSize instead.
+	MemoryGauge MemoryGauge
+
+	// Progress, when set, is called as the image data of each call is
+	// copied, with the input bytes consumed so far and the input size, or
+	// -1 for a stream of unknown length. done never decreases, and a call
+	// that succeeds ends with done equal to total. It's called on the
+	// goroutine processing the file, so a Config shared by concurrent
+	// calls, as in a batch, needs a callback safe for concurrent use. It
+	// takes no part in the policy, so StampProcessed hashes without it.
+	Progress func(done, total int64) `json:"-"`
+
+	// RemoveStructuralTags lets category removal take ExifVersion,
+	// ComponentsConfiguration and FlashpixVersion, which EXIF requires in
+	// every EXIF IFD and which are otherwise kept so strict readers still
+	// accept the metadata
+	RemoveStructuralTags bool
+
+	// Minify trims the whitespace padding of XMP packets, which some
+	// writers leave at hundreds of kilobytes, down to 2KB. It removes no
+	// content and needs no removal category; Report.Minified records the
+	// bytes saved.
+	Minify bool
+
+	// RemoveTextChunks drops every PNG tEXt, zTXt and iTXt chunk, including
+	// XMP carried in iTXt. TextKeysToRemove instead drops only the chunks
+	// with the given keywords, such as "Description", for pipelines that
+	// rely on other entries. The registered keywords with a category, such
+	// as "Author" or "Creation Time", also go with their category.
+	RemoveTextChunks bool
+	TextKeysToRemove []string
+
+	// TextMode is how the chunks chosen by RemoveTextChunks or
+	// TextKeysToRemove are removed: dropped, or under TextBlank kept with
+	// their keyword and an empty text. TextKeyModes chooses the mode per
+	// keyword, removing the chunks it names whatever the other fields say,
+	// so a policy can blank "Software" while dropping "parameters".
+	TextMode     TextMode
+	TextKeyModes map[string]TextMode
+
+	tags     map[uint16]*tagInfo // resolved CategoryOverrides, set by New
+	memory   *memoryAccount      // the call's share of MemoryGauge, set per call
+	progress *progress           // the call's context and Progress, set per call
+
+	// CustomTagsToRemove lists tag IDs removed in addition to the
+	// category flags, such as BodySerialNumber (0xA431) or Software
+	// (0x0131). IDs are matched in IFD0, the EXIF IFD and the GPS IFD,
+	// whose tags are 0x0000 to 0x001F; values are overwritten like any
+	// other removal. PreserveOrientation, the structural tags and the
+	// print-safe tags, such as InkSet and TransferFunction, still take
+	// precedence.
+	CustomTagsToRemove []uint16
+
+	// RemoveComments drops JPEG COM segments, where editors and some
+	// cameras write free-text comments
+	RemoveComments bool
+
+	// RemoveThumbnail overwrites the JPEG thumbnail that IFD1 references,
+	// a miniature of the original that can show what cropping or
+	// redaction hid, and empties the entries pointing at it
+	RemoveThumbnail bool
+
+	// RemoveEmbeddedPreviews blanks the JPEG previews a TIFF or raw file
+	// holds in the IFDs after IFD0 and in SubIFDs, which can show the
+	// whole unedited scene: each becomes a 1×1 gray JPEG padded with zeros
+	// to its length, or only zeros when it is smaller than that. Without
+	// it each preview is sanitized in place under the same Config.
+	RemoveEmbeddedPreviews bool
+
+	// GPSAction chooses what removing the GPS IFD does with the position
+	// in it. The default removes the IFD; GPSTruncate and GPSReplace keep
+	// a coarse or substitute latitude and longitude for apps that need
+	// one, removing the altitude, timestamps and every other entry but the
+	// version and datum. GPSPrecision is the decimal places of a degree
+	// GPSTruncate keeps, 0 to 7 (2 is about a kilometre), and
+	// GPSReplaceLatitude and GPSReplaceLongitude the signed decimal
+	// degrees GPSReplace writes. A position that can't be rewritten safely
+	// is removed instead.
+	GPSAction                               GPSAction
+	GPSPrecision                            int
+	GPSReplaceLatitude, GPSReplaceLongitude float64
+
+	// RemoveVendorSegments drops vendor JPEG APPn segments carrying
+	// recorded audio, the voice memos some cameras attach to a shot.
+	// RemoveUserInfo covers only RelatedSoundFile (0xA004), the EXIF tag
+	// naming a separate sound file.
+	RemoveVendorSegments bool
+
+	// DateTimePolicy chooses what removing the capture dates does with
+	// them. The default removes them; the other policies rewrite each date
+	// in place so photo managers can still sort by it, truncated to the
+	// day, shifted by DateTimeShiftBy or set to DateTimeFixed, written as
+	// its wall-clock time. Dates that don't parse are removed instead.
+	DateTimePolicy  DateTimePolicy
+	DateTimeShiftBy time.Duration
+	DateTimeFixed   time.Time
+
+	// Permissive recovers from malformed EXIF that would otherwise fail
+	// the whole file, in the direction that removes more: a TIFF byte
+	// order marker in the wrong case ("ii", "Mm") is read as meant and
+	// corrected with a warning, and an EXIF container whose marker can't
+	// be recognized at all is dropped, or overwritten in a HEIC
+	Permissive bool
+
+	// Salvage resynchronizes a PNG whose chunk stream breaks. A chunk
+	// type that isn't four ASCII letters usually means a corrupt length
+	// field left the scanner mid-chunk, which otherwise fails the file with
+	// ErrCorruptImage. Under Salvage the scanner looks up to 1MiB ahead for
+	// the next chunk with a valid type, a length that fits and a matching
+	// CRC, and drops everything before it with a WarnResynced warning. The
+	// chunk before the break has already been written as read. Unknown
+	// ancillary chunks that aren't safe to copy are dropped after a
+	// resynchronization, since the image data they may depend on was hit.
+	Salvage bool
+
+	// RemoveUnknownChunks drops PNG ancillary chunks of types the package
+	// doesn't know, those whose type starts with a lowercase letter.
+	// Unknown critical chunks are kept, since a decoder can't render the
+	// image without them.
+	RemoveUnknownChunks bool
+
+	// MaxFileSize, when set, fails input larger than this many bytes
+	// with ErrFileTooLarge, bounding the work a single file can cause.
+	// Files are checked before they're opened; streams fail once they
+	// exceed it, having been partly read.
+	MaxFileSize int64
+
+	// ReadTimeout, when set, fails with ErrReadTimeout any read of the
+	// input that takes longer than this, so a stalled network peer can't
+	// hang the call. Readers with SetReadDeadline, such as a net.Conn, are
+	// given a fresh deadline before each read and have it cleared
+	// afterwards; other readers are read on a goroutine that a timeout
+	// abandons and that lingers until the read returns. Regular files are
+	// never timed.
+	ReadTimeout time.Duration
+
+	// Trace records in Report.Trace the decision made for each metadata
+	// item and the Config field behind it, for answering why something
+	// was or wasn't removed from one file
+	Trace bool
+
+	// PreserveModTime keeps the modification time of files sanitized in
+	// place, for photo libraries that sort by it
+	PreserveModTime bool
+
+	// InPlaceNonAtomic writes files sanitized in place over the original
+	// instead of renaming a temporary file onto it, for directories that
+	// fail with ErrCannotWriteAtomically. The output is built in memory
+	// first, but a failure while writing it leaves the original damaged.
+	InPlaceNonAtomic bool
+}
+
+// errByteOrder is returned for an EXIF container whose TIFF byte order
+// marker is neither "II" nor "MM"; under Config.Permissive the container
+// is dropped instead
+var errByteOrder = corrupt("invalid TIFF byte order")
+
+// ErrTooManyChunks is returned for a PNG with more than Config.MaxChunks
+// chunks
+var ErrTooManyChunks = errors.New("too many PNG chunks")
+
+// ErrMetadataTooLarge is returned for a metadata chunk larger than
+// Config.MaxMetadataSize
+var ErrMetadataTooLarge = errors.New("metadata too large")
+
+// defaultMaxMetadataSize applies when Config.MaxMetadataSize is zero
+const defaultMaxMetadataSize = 16 << 20
+
+func (c Config) maxMetadataSize() int {
+	if c.MaxMetadataSize > 0 {
+		return c.MaxMetadataSize
+	}
+	return defaultMaxMetadataSize
+}
+
+// readMetadata reads a chunk or segment payload processed in memory, checking
+// its declared length against the limit before allocating for it
+func readMetadata(r io.Reader, length int, what string, config Config) ([]byte, error) {
+	if length > config.maxMetadataSize() {
+		return nil, fmt.Errorf("%w: %d-byte %s", ErrMetadataTooLarge, length, what)
+	}
+	if err := config.memory.acquire(length); err != nil {
+		return nil, fmt.Errorf("%d-byte %s: %w", length, what, err)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
-// RemoveEXIFSelective removes specific EXIF properties from various image formats
+// RemoveEXIFSelective removes specific EXIF properties from various image
+// formats. Its signature is stable. It is exactly
+//
+//	s, err := New(config)
+//	if err == nil {
+//		_, err = s.RemoveFile(inputPath, outputPath)
+//	}
+//
+// so callers processing many files with one Config can build the Sanitizer
+// once and get identical output. A Config with only the category flags set
+// behaves as it did before the other fields existed, since their zero
+// values leave processing unchanged.
 func RemoveEXIFSelective(inputPath, outputPath string, config Config) error {
-	inputFile, err := os.Open(inputPath)
+	_, err := RemoveEXIFSelectiveReport(inputPath, outputPath, config)
+	return err
+}
+
+// RemoveEXIFSelectiveReport is RemoveEXIFSelective, additionally returning a
+// Report describing the image that was processed. An outputPath naming the
+// input file is handled as by RemoveEXIFInPlace rather than truncating the
+// input before it's read. If processing fails, a regular output file is
+// removed rather than left holding part of an image.
+func RemoveEXIFSelectiveReport(inputPath, outputPath string, config Config) (*Report, error) {
+	s, err := New(config)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer inputFile.Close()
+	return s.RemoveFile(inputPath, outputPath)
+}
+
+// Remove is RemoveEXIFSelective for streams: it reads an image from r and
+// writes the sanitized image to w. r need not be seekable, so images can be
+// piped straight from a network upload. JPEG and PNG output is written as
+// the input is read, so on error w may hold part of an image;
+// AssertNoAdditions, MinRemovalStrength and FailOnUnhandledMetadata hold
+// all output back until their checks pass.
+func Remove(r io.Reader, w io.Writer, config Config) error {
+	_, err := RemoveReport(r, w, config)
+	return err
+}
 
-	outputFile, err := os.Create(outputPath)
+// RemoveReport is Remove, additionally returning a Report describing the
+// image that was processed. If processing fails partway through the image,
+// the Report of what was found so far is returned alongside the error, with
+// Incomplete set.
+func RemoveReport(r io.Reader, w io.Writer, config Config) (*Report, error) {
+	s, err := New(config)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer outputFile.Close()
+	return s.Remove(r, w)
+}
 
-	// Determine file format based on signature
-	header := make([]byte, 12) // Enough to identify most formats
-	_, err = inputFile.Read(header)
+// RemoveEXIFFromBytes is Remove for images already in memory. It returns a
+// newly allocated slice even when nothing was removed, so the result never
+// aliases data, and fails on truncated input exactly as Remove does.
+func RemoveEXIFFromBytes(data []byte, config Config) ([]byte, error) {
+	out, _, err := RemoveEXIFFromBytesReport(data, config)
+	return out, err
+}
+
+// RemoveEXIFFromBytesReport is RemoveEXIFFromBytes, additionally returning
+// a Report describing the image that was processed, which like
+// RemoveReport's may be partial when err is non-nil
+func RemoveEXIFFromBytesReport(data []byte, config Config) ([]byte, *Report, error) {
+	s, err := New(config)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	_, err = inputFile.Seek(0, io.SeekStart)
-	if err != nil {
-		return err
+	return s.RemoveBytes(data)
+}
+
+// maxSymlinks bounds how many symbolic links resolveInput follows
+const maxSymlinks = 40
+
+// ErrNotRegularFile is returned for inputs that are not regular files,
+// such as directories, devices and, unless Config.AllowFIFO is set, FIFOs
+var ErrNotRegularFile = errors.New("not a regular file")
+
+// resolveInput follows symbolic links from path and checks that it ends
+// at a file processing can read
+func resolveInput(path string, config Config) (string, error) {
+	for i := 0; ; i++ {
+		info, err := fsys.Lstat(path)
+		if err != nil {
+			return "", err
+		}
+		mode := info.Mode()
+		switch {
+		case mode.IsRegular():
+			return path, nil
+		case mode&fs.ModeNamedPipe != 0 && config.AllowFIFO:
+			return path, nil
+		case mode&fs.ModeSymlink == 0:
+			return "", fmt.Errorf("%s: %w", path, ErrNotRegularFile)
+		case i == maxSymlinks:
+			return "", fmt.Errorf("%s: more than %d symbolic links", path, maxSymlinks)
+		}
+
+		target, err := fsys.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
 	}
+}
 
-	switch {
-	case bytes.HasPrefix(header, []byte{0xFF, 0xD8}): // JPEG
-		return processJPEG(inputFile, outputFile, config)
-	case bytes.HasPrefix(header, []byte{0x89, 0x50, 0x4E, 0x47}): // PNG
-		return processPNG(inputFile, outputFile, config)
+// process runs the handler for the format identified by header over r,
+// which must be positioned at the start of the image. Callers validate
+// config first.
+func process(r io.Reader, header []byte, w io.Writer, config Config) (*Report, error) {
+	if config.memory == nil {
+		var release func()
+		config, release = withMemory(context.Background(), config)
+		defer release()
+	}
+	if config.progress == nil {
+		config = withProgress(context.Background(), config, -1)
+	}
+	if config.MaxFileSize > 0 {
+		r = &sizeLimitReader{r: r, limit: config.MaxFileSize}
+	}
+	consumed := &countingReader{r: r}
+	r = consumed
+	counter := &countingWriter{w: w}
+	w = counter
+
+	// Output is held back when a check must pass before anything is written
+	dst := w
+	buffered := config.AssertNoAdditions || config.MinRemovalStrength != 0 || config.FailOnUnhandledMetadata
+	var input, output bytes.Buffer
+	if config.AssertNoAdditions {
+		r = io.TeeReader(r, &input)
+	}
+	if buffered {
+		w = &output
+	}
+
+	var err error
+	report := &Report{Format: detectFormat(header)}
+	if config.Trace {
+		report.tracer = &tracer{config: config}
+	}
+	switch report.Format {
+	case FormatJPEG:
+		err = processJPEG(r, w, config, report)
+	case FormatPNG:
+		err = processPNG(r, w, config, report)
+	case FormatWebP:
+		err = processWebP(r, w, config, report)
+	case FormatTIFF:
+		err = processTIFF(r, w, config, report)
+	case FormatHEIC:
+		err = processHEIC(r, w, config, report)
 
 	default:
-		return errors.New("unsupported image format")
+		return nil, formatError(header)
+	}
+	if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("%w: truncated %s: %w", ErrCorruptImage, report.Format, io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		// What was found before the failure is still worth reporting
+		report.Incomplete = true
+		report.WeakestRemoval = report.weakestRemoval()
+		report.BytesWritten = counter.n
+		return report, err
 	}
+
+	report.WeakestRemoval = report.weakestRemoval()
+	if config.AssertNoAdditions {
+		if err := checkNoAdditions(report.Format, input.Bytes(), output.Bytes(), config); err != nil {
+			return nil, err
+		}
+	}
+	if config.MinRemovalStrength != 0 && report.WeakestRemoval != 0 && report.WeakestRemoval < config.MinRemovalStrength {
+		return nil, fmt.Errorf("removal strength %s is below the required %s", report.WeakestRemoval, config.MinRemovalStrength)
+	}
+	if config.FailOnUnhandledMetadata {
+		for _, w := range report.Warnings {
+			if !w.Code.structural() {
+				return nil, fmt.Errorf("%w: %s", ErrUnhandledMetadata, w)
+			}
+		}
+	}
+	if buffered {
+		if _, err := output.WriteTo(dst); err != nil {
+			return nil, err
+		}
+	}
+	report.BytesWritten = counter.n
+	config.progress.finish(consumed.n)
+	return report, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
 
 // processJPEG handles JPEG files
-func processJPEG(r io.Reader, w io.Writer, config Config) error {
-	var output bytes.Buffer
-	header := make([]byte, 2)
-	if _, err := r.Read(header); err != nil {
+func processJPEG(r io.Reader, w io.Writer, config Config, report *Report) error {
+	// Segments are written as they are processed, so memory is bounded by
+	// the largest segment rather than the file
+	out := &countingWriter{w: w}
+	output := getWriter(out)
+	defer putWriter(output)
+	in := &countingReader{r: r} // input offsets locate the MPF images
+	r = in
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(r, soi); err != nil {
 		return err
 	}
-	output.Write(header)
+	output.Write(soi)
 
+	var mpf *mpfIndex // under RemoveAuxiliaryImages, once an MPF segment is seen
+	extensions := auxiliaryExtensions{}
+	sawEOI := false
+	lengthBytes := make([]byte, 2)
 	for {
-		_, err := r.Read(header)
+		marker, err := readJPEGMarker(r)
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
 			return err
 		}
+		header := []byte{0xFF, marker}
 
-		if header[0] == 0xFF && header[1] == 0xE1 {
-			lengthBytes := make([]byte, 2)
-			if _, err := r.Read(lengthBytes); err != nil {
-				return err
+		if marker == 0xDA {
+			if config.StampProcessed {
+				output.Write(jpegStamp(config))
 			}
-			length := int(binary.BigEndian.Uint16(lengthBytes))
-			exifData := make([]byte, length-2)
-			if _, err := io.ReadFull(r, exifData); err != nil {
+			scan := &eoiWriter{w: output}
+			scan.Write(header)
+			if mpf != nil {
+				if err := mpf.copyImages(in, scan, output, config, report); err != nil {
+					return err
+				}
+			} else if _, err := copyWithProgress(config.progress.ctx, scan, r, -1, config.progress); err != nil {
 				return err
 			}
-
-			modifiedExif, err := modifyEXIF(exifData, config)
-			if err != nil {
-				return err
-			}
-			output.Write(header)
-			binary.BigEndian.PutUint16(lengthBytes, uint16(len(modifiedExif)+2))
-			output.Write(lengthBytes)
-			output.Write(modifiedExif)
-			continue
+			sawEOI = scan.seen
+			break
 		}
-
-		output.Write(header)
-		if header[0] == 0xFF && header[1] == 0xDA {
-			if _, err := io.Copy(&output, r); err != nil {
+		if marker == 0xD9 {
+			// EOI without a scan: whatever follows is not ours to parse
+			sawEOI = true
+			output.Write(header)
+			if _, err := copyWithProgress(config.progress.ctx, output, r, -1, config.progress); err != nil {
 				return err
 			}
 			break
 		}
+		if marker == 0x01 || marker == 0xD8 || (marker >= 0xD0 && marker <= 0xD7) {
+			output.Write(header) // TEM, SOI and RST carry no length
+			continue
+		}
 
-		lengthBytes := make([]byte, 2)
-		if _, err := r.Read(lengthBytes); err != nil {
+		if _, err := io.ReadFull(r, lengthBytes); err != nil {
 			return err
 		}
 		length := int(binary.BigEndian.Uint16(lengthBytes))
-		output.Write(lengthBytes)
-		data := make([]byte, length-2)
-		if _, err := io.ReadFull(r, data); err != nil {
+		if length < 2 {
+			return corrupt(fmt.Sprintf("invalid length %d in JPEG %s segment", length, jpegSegmentName(marker)))
+		}
+		var data []byte
+		if marker >= 0xE0 && marker <= 0xEF {
+			// APPn segments hold the metadata, bounded by MaxMetadataSize
+			// like the PNG chunks
+			data, err = readMetadata(r, length-2, "JPEG "+jpegSegmentName(marker)+" segment", config)
+		} else if err = config.memory.acquire(length - 2); err != nil {
+			err = fmt.Errorf("JPEG %s segment: %w", jpegSegmentName(marker), err)
+		} else {
+			data = make([]byte, length-2)
+			_, err = io.ReadFull(r, data)
+		}
+		if err != nil {
 			return err
 		}
+
+		switch {
+		case marker == 0xE1:
+			// An APP1 segment holds EXIF or XMP according to its prefix;
+			// files often carry one of each, and some carry several EXIF
+			// segments, all of which are processed. Any other APP1 payload
+			// is passed through untouched.
+			isEXIF := bytes.HasPrefix(data, exifPrefix)
+			switch {
+			case config.DropEmptyMetadata && isEmptyXMP(data):
+				report.remove(RemovedItem{Carrier: CarrierXMP, Name: "empty packet", Strength: RemovalEliminated, Size: int64(len(data))})
+				continue
+			case isEXIF && config.DropEmptyMetadata && isEmptyEXIF(data):
+				report.remove(RemovedItem{Carrier: CarrierEXIF, Name: "empty segment", Strength: RemovalEliminated, Size: int64(len(data))})
+				continue
+			case isEXIF && config.RemoveAll:
+				report.remove(RemovedItem{Carrier: CarrierEXIF, Name: "EXIF segment", Strength: RemovalEliminated, Size: int64(len(data))})
+				kept := orientationEXIF(data)
+				if !config.PreserveOrientation || kept == nil {
+					continue
+				}
+				data = kept
+			case isEXIF:
+				modified, err := modifyEXIF(data, config, report)
+				if err == errByteOrder && config.Permissive {
+					report.remove(RemovedItem{Carrier: CarrierEXIF, Name: "EXIF segment", Strength: RemovalEliminated, Size: int64(len(data))})
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				data = modified
+			case bytes.HasPrefix(data, xmpSegmentPrefix):
+				modifyXMP(data[len(xmpSegmentPrefix):], config, report)
+				if config.Minify {
+					data = minifyXMP(data, len(xmpSegmentPrefix), report)
+				}
+			case bytes.HasPrefix(data, xmpExtensionPrefix) && config.RemoveAuxiliaryImages && extensions.auxiliary(data):
+				report.remove(RemovedItem{Carrier: CarrierAuxiliaryImage, Name: "extended XMP chunk", Strength: RemovalEliminated, Size: int64(len(data))})
+				continue
+			default:
+				report.warn(WarnUnknownAPP1, fmt.Sprintf("APP1 segment %q passed through", segmentIdentifier(data)))
+			}
+		case marker == 0xFE: // COM
+			if isStamp(data) {
+				continue
+			}
+			if config.RemoveComments {
+				report.remove(RemovedItem{Carrier: CarrierComment, Name: "COM segment", Strength: RemovalEliminated, Size: int64(len(data))})
+				continue
+			}
+		case isSOF(marker):
+			if report.Width == 0 {
+				report.readSOF(data)
+			}
+		case marker >= 0xE2 && marker <= 0xEF && isAudioSegment(data):
+			if config.RemoveVendorSegments {
+				report.remove(RemovedItem{Carrier: CarrierAudio, Name: jpegSegmentName(marker) + " segment", Strength: RemovalEliminated, Size: int64(len(data))})
+				continue
+			}
+			report.warn(WarnAudio, fmt.Sprintf("%s segment with embedded audio passed through", jpegSegmentName(marker)))
+		case marker == 0xE2 && config.RemoveICCProfile && bytes.HasPrefix(data, iccSegmentPrefix):
+			// Every chunk of a profile goes, whatever its sequence number,
+			// so no partial profile is left behind
+			report.remove(RemovedItem{Carrier: CarrierICC, Name: "ICC_PROFILE segment", Strength: RemovalEliminated, Size: int64(len(data))})
+			continue
+		case marker == 0xE2:
+			if scrubICC(config) {
+				scrubICCSegment(data, config)
+			}
+			if bytes.HasPrefix(data, []byte("FPXR\x00")) {
+				report.warn(WarnFPXR, "APP2 FlashPix segment passed through")
+			}
+			if bytes.HasPrefix(data, mpfPrefix) && config.RemoveAuxiliaryImages && mpf == nil {
+				mpf = parseMPF(data)
+				mpf.base = in.n - int64(len(data)) + int64(len(mpfPrefix))
+				if !config.PreserveGainMaps || mpf.images == nil {
+					report.remove(RemovedItem{Carrier: CarrierAuxiliaryImage, Name: "MPF index", Strength: RemovalEliminated, Size: int64(len(data))})
+					continue
+				}
+				if err := mpf.hold(output, out); err != nil {
+					return err
+				}
+				mpf.segment = len(data) + 4
+			}
+		case marker == 0xED && modifiesIPTC(config):
+			if kept, empty, ok := removeIPTCResources(data, config, report); ok {
+				if empty {
+					continue
+				}
+				data = kept
+			}
+		case marker == 0xED && report.tracer != nil:
+			traceIPTC(data, config, report)
+		}
+		binary.BigEndian.PutUint16(lengthBytes, uint16(len(data)+2))
+		output.Write(header)
+		output.Write(lengthBytes)
 		output.Write(data)
 	}
+	if !sawEOI {
+		// Decoders treat the end of the data as the end of the image
+		report.warn(WarnMissingEOI, "JPEG ends without an EOI marker")
+		if config.RepairStructure {
+			output.Write([]byte{0xFF, 0xD9})
+		}
+	}
+	return output.Flush()
+}
 
-	_, err := w.Write(output.Bytes())
-	return err
+// eoiWriter passes the data from a JPEG's first SOS marker on through, and
+// tracks whether it held the EOI that ends the image. Segments between
+// progressive scans are skipped by their declared length so that bytes
+// inside them are never mistaken for markers. Anything after the EOI, such as trailing garbage
+// or a secondary image, is written but not scanned, or with cut set is
+// dropped and counted.
+type eoiWriter struct {
+	w       io.Writer
+	state   eoiState
+	high    byte // first byte of a segment length
+	skip    int  // bytes left of a length-coded segment
+	seen    bool
+	cut     bool
+	dropped int64 // bytes cut after the EOI
+}
+
+type eoiState int
+
+const (
+	eoiData    eoiState = iota // entropy-coded data
+	eoiMarker                  // after 0xFF
+	eoiLength1                 // reading a segment length
+	eoiLength2
+	eoiSkip // inside a length-coded segment
+)
+
+func (e *eoiWriter) Write(p []byte) (int, error) {
+	if e.seen && e.cut {
+		e.dropped += int64(len(p))
+		return len(p), nil
+	}
+	end := len(p)
+	for i := 0; i < len(p) && !e.seen; i++ {
+		switch e.state {
+		case eoiData:
+			j := bytes.IndexByte(p[i:], 0xFF)
+			if j < 0 {
+				i = len(p)
+				continue
+			}
+			i += j
+			e.state = eoiMarker
+		case eoiMarker:
+			switch c := p[i]; {
+			case c == 0xFF: // fill byte
+			case c == 0x00 || c >= 0xD0 && c <= 0xD7: // stuffed byte or RSTn
+				e.state = eoiData
+			case c == 0xD9:
+				e.seen, end = true, i+1
+			default:
+				e.state = eoiLength1
+			}
+		case eoiLength1:
+			e.high, e.state = p[i], eoiLength2
+		case eoiLength2:
+			e.skip, e.state = int(e.high)<<8|int(p[i])-2, eoiSkip
+			if e.skip <= 0 {
+				e.state = eoiData
+			}
+		case eoiSkip:
+			n := len(p) - i
+			if n > e.skip {
+				n = e.skip
+			}
+			e.skip -= n
+			i += n - 1
+			if e.skip == 0 {
+				e.state = eoiData
+			}
+		}
+	}
+	if !e.cut || end == len(p) {
+		return e.w.Write(p)
+	}
+	e.dropped += int64(len(p) - end)
+	if _, err := e.w.Write(p[:end]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// readJPEGMarker reads the next marker code, skipping the 0xFF fill bytes
+// the spec allows before any marker. It returns io.EOF only at a clean end
+// of input between segments.
+func readJPEGMarker(r io.Reader) (byte, error) {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, err
+	}
+	if b[0] != 0xFF {
+		return 0, corrupt(fmt.Sprintf("expected JPEG marker, found 0x%02X", b[0]))
+	}
+	for b[0] == 0xFF {
+		if _, err := io.ReadFull(r, b); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+	return b[0], nil
 }
 
 // processPNG handles PNG files
-func processPNG(r io.Reader, w io.Writer, config Config) error {
-	var output bytes.Buffer
-	_, err := io.CopyN(&output, r, 8) // PNG signature
+func processPNG(r io.Reader, w io.Writer, config Config, report *Report) error {
+	// Chunks are written as they are processed, as for JPEG
+	output := getWriter(w)
+	defer putWriter(output)
+	_, err := io.CopyN(output, r, 8) // PNG signature
 	if err != nil {
 		return err
 	}
 
-	for {
-		lengthBytes := make([]byte, 4)
-		_, err := r.Read(lengthBytes)
+	sawIEND, resynced := false, false
+	lengthBytes := make([]byte, 4)
+	typeBytes := make([]byte, 4)
+	keywordBuf := make([]byte, pngKeywordPrefix) // reused by every text chunk
+	for chunks := 0; ; chunks++ {
+		_, err := io.ReadFull(r, lengthBytes)
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
 			return err
 		}
+		if config.MaxChunks > 0 && chunks == config.MaxChunks {
+			return fmt.Errorf("%w (%d)", ErrTooManyChunks, config.MaxChunks)
+		}
+		if binary.BigEndian.Uint32(lengthBytes) > math.MaxInt32 {
+			return corrupt("PNG chunk length exceeds 2^31-1")
+		}
 		length := int(binary.BigEndian.Uint32(lengthBytes))
 
-		typeBytes := make([]byte, 4)
-		_, err = r.Read(typeBytes)
+		_, err = io.ReadFull(r, typeBytes)
 		if err != nil {
 			return err
 		}
+		if !validChunkType(typeBytes) {
+			// A garbage type almost always means the previous length field
+			// was corrupt and we are no longer on a chunk boundary
+			if !config.Salvage {
+				return corrupt("invalid PNG chunk type")
+			}
+			resumed, skipped, err := resyncPNG(r, append(append([]byte(nil), lengthBytes...), typeBytes...))
+			if err != nil {
+				return err
+			}
+			report.warn(WarnResynced, fmt.Sprintf("%d bytes skipped to the next valid PNG chunk", skipped))
+			r, resynced = resumed, true
+			continue
+		}
 
 		if string(typeBytes) == "eXIf" {
-			exifData := make([]byte, length)
-			_, err = io.ReadFull(r, exifData)
+			exifData, err := readMetadata(r, length, string(typeBytes)+" chunk", config)
+			if err != nil {
+				return err
+			}
+			_, err = io.CopyN(io.Discard, r, 4) // CRC, recomputed below
+			if err != nil {
+				return err
+			}
+			if config.DropEmptyMetadata && isEmptyEXIF(exifData) {
+				report.remove(RemovedItem{Carrier: CarrierEXIF, Name: "empty chunk", Strength: RemovalEliminated, Size: int64(len(exifData))})
+				continue
+			}
+			if config.RemoveAll {
+				report.remove(RemovedItem{Carrier: CarrierEXIF, Name: "eXIf chunk", Strength: RemovalEliminated, Size: int64(len(exifData))})
+				if kept := orientationEXIF(exifData); config.PreserveOrientation && kept != nil {
+					output.Write(pngChunk("eXIf", kept))
+				}
+				continue
+			}
+			modifiedExif, err := modifyEXIFBlob(exifData, config, report)
+			if err == errByteOrder && config.Permissive {
+				report.remove(RemovedItem{Carrier: CarrierEXIF, Name: "eXIf chunk", Strength: RemovalEliminated, Size: int64(len(exifData))})
+				continue
+			}
 			if err != nil {
 				return err
 			}
-			modifiedExif, err := modifyEXIF(exifData, config)
+			output.Write(pngChunk("eXIf", modifiedExif))
+			continue
+		}
+
+		if chunkType := string(typeBytes); chunkType == "tEXt" || chunkType == "zTXt" || chunkType == "iTXt" {
+			// Every rule is decided by the keyword, so only the prefix
+			// holding it is read and the text itself is streamed
+			prefix := keywordBuf
+			if length < len(prefix) {
+				prefix = prefix[:length]
+			}
+			if _, err := io.ReadFull(r, prefix); err != nil {
+				return err
+			}
+			stamp := chunkType == "tEXt" && isStamp(prefix)
+			keyword, mode, remove := removeTextChunk(prefix, config)
+			rest := length - len(prefix)
+			if remove && !stamp && mode == TextBlank {
+				data, read, ok, err := blankText(chunkType, prefix, r, rest, config.maxMetadataSize())
+				if err != nil {
+					return err
+				}
+				if ok {
+					if _, err := copyWithProgress(config.progress.ctx, io.Discard, r, int64(rest-read)+4, config.progress); err != nil { // Text + CRC
+						return err
+					}
+					output.Write(pngChunk(chunkType, data))
+					report.removeChunk(chunkType, RemovedItem{Carrier: CarrierText, Name: chunkType + " " + keyword, Categories: textKeyCategories(keyword), Strength: RemovalOverwritten, Size: int64(length - len(data))})
+					continue
+				}
+				rest -= read // Too short to hold its fields, so dropped
+			}
+			if stamp || remove {
+				if _, err := copyWithProgress(config.progress.ctx, io.Discard, r, int64(rest)+4, config.progress); err != nil { // Rest + CRC
+					return err
+				}
+				if !stamp {
+					report.removeChunk(chunkType, RemovedItem{Carrier: CarrierText, Name: chunkType + " " + keyword, Categories: textKeyCategories(keyword), Strength: RemovalEliminated, Size: int64(length)})
+				}
+				continue
+			}
+			if report.tracer != nil {
+				report.traceKept(CarrierText, 0, chunkType+" "+pngKeyword(prefix), textKeptReason(pngKeyword(prefix)))
+			}
+			output.Write(lengthBytes)
+			output.Write(typeBytes)
+			output.Write(prefix)
+			if _, err := copyWithProgress(config.progress.ctx, output, r, int64(length-len(prefix))+4, config.progress); err != nil { // Rest + CRC
+				return err
+			}
+			continue
+		}
+
+		if string(typeBytes) == "IHDR" {
+			ihdr, err := readMetadata(r, length, string(typeBytes)+" chunk", config)
 			if err != nil {
 				return err
 			}
-			binary.BigEndian.PutUint32(lengthBytes, uint32(len(modifiedExif)))
+			report.readIHDR(ihdr)
 			output.Write(lengthBytes)
 			output.Write(typeBytes)
-			output.Write(modifiedExif)
-			_, err = io.CopyN(&output, r, 4) // CRC
+			output.Write(ihdr)
+			_, err = io.CopyN(output, r, 4) // CRC
 			if err != nil {
 				return err
 			}
 			continue
 		}
 
+		if string(typeBytes) == "iCCP" && config.RemoveICCProfile {
+			if _, err := copyWithProgress(config.progress.ctx, io.Discard, r, int64(length)+4, config.progress); err != nil { // Data + CRC
+				return err
+			}
+			report.remove(RemovedItem{Carrier: CarrierICC, Name: "iCCP chunk", Strength: RemovalEliminated, Size: int64(length)})
+			continue
+		}
+
+		if string(typeBytes) == "iCCP" && scrubICC(config) {
+			iccData, err := readMetadata(r, length, string(typeBytes)+" chunk", config)
+			if err != nil {
+				return err
+			}
+			_, err = io.CopyN(io.Discard, r, 4) // CRC, recomputed below
+			if err != nil {
+				return err
+			}
+			output.Write(pngChunk("iCCP", scrubICCChunk(iccData, config)))
+			report.traceKept(CarrierICC, 0, "iCCP chunk", "scrubbed without RemoveICCProfile")
+			continue
+		}
+
+		if string(typeBytes) == "IEND" {
+			sawIEND = true
+			if config.StampProcessed {
+				output.Write(pngStamp(config))
+			}
+		}
+
+		if typ := string(typeBytes); !knownPNGChunks[typ] && pngAncillary(typeBytes) &&
+			(config.RemoveUnknownChunks || resynced && !pngSafeToCopy(typeBytes)) {
+			if _, err := copyWithProgress(config.progress.ctx, io.Discard, r, int64(length)+4, config.progress); err != nil { // Data + CRC
+				return err
+			}
+			report.removeChunk(typ, RemovedItem{Carrier: CarrierOther, Name: typ + " chunk", Strength: RemovalEliminated, Size: int64(length)})
+			continue
+		}
+
+		if typ := string(typeBytes); pngAncillary(typeBytes) {
+			report.traceKept(pngChunkCarrier(typ), 0, typ+" chunk", pngKeptReason(typ))
+		}
 		output.Write(lengthBytes)
 		output.Write(typeBytes)
-		_, err = io.CopyN(&output, r, int64(length)+4) // Data + CRC
+		_, err = copyWithProgress(config.progress.ctx, output, r, int64(length)+4, config.progress) // Data + CRC
 		if err != nil {
 			return err
 		}
 	}
 
-	_, err = w.Write(output.Bytes())
-	return err
+	if !sawIEND {
+		report.warn(WarnMissingIEND, "PNG ends without an IEND chunk")
+		if config.RepairStructure {
+			output.Write([]byte{0, 0, 0, 0, 'I', 'E', 'N', 'D', 0xAE, 0x42, 0x60, 0x82})
+		}
+	}
+	return output.Flush()
+}
+
+// validChunkType reports whether every byte of a PNG chunk type is an ASCII
+// letter, as the PNG spec requires
+func validChunkType(typ []byte) bool {
+	for _, c := range typ {
+		if !('A' <= c && c <= 'Z' || 'a' <= c && c <= 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// knownPNGChunks are the chunk types of the PNG specification and its
+// registered extensions
+var knownPNGChunks = map[string]bool{
+	"IHDR": true, "PLTE": true, "IDAT": true, "IEND": true,
+	"tRNS": true, "cHRM": true, "gAMA": true, "iCCP": true, "sBIT": true, "sRGB": true,
+	"cICP": true, "mDCV": true, "cLLI": true, "tEXt": true, "zTXt": true, "iTXt": true,
+	"bKGD": true, "hIST": true, "pHYs": true, "sPLT": true, "eXIf": true, "tIME": true,
+	"acTL": true, "fcTL": true, "fdAT": true,
+	"oFFs": true, "pCAL": true, "sCAL": true, "sTER": true, "gIFg": true, "gIFx": true, "gIFt": true, "dSIG": true,
+}
+
+// pngAncillary reports whether a chunk type is ancillary, which bit 5 of
+// its first byte (a lowercase letter) marks: decoders may ignore it
+func pngAncillary(typ []byte) bool {
+	return typ[0]&0x20 != 0
+}
+
+// pngSafeToCopy reports whether a chunk type is safe to copy, which bit 5
+// of its fourth byte marks: it doesn't depend on the image data, so an
+// editor may keep it when the critical chunks have changed
+func pngSafeToCopy(typ []byte) bool {
+	return typ[3]&0x20 != 0
+}
+
+// salvageWindow is how far past a broken chunk header Config.Salvage looks
+// for the next valid chunk
+const salvageWindow = 1 << 20
+
+// resyncPNG looks in what follows header, the length and type of a chunk
+// whose type is invalid, for the first offset holding a chunk with a valid
+// type, data that fits in the window and a matching CRC. It returns a
+// reader resuming there and the number of bytes skipped.
+func resyncPNG(r io.Reader, header []byte) (io.Reader, int, error) {
+	window := make([]byte, len(header)+salvageWindow)
+	copy(window, header)
+	n, err := io.ReadFull(r, window[len(header):])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, 0, err
+	}
+	window = window[:len(header)+n]
+	for p := 1; p+12 <= len(window); p++ {
+		length := binary.BigEndian.Uint32(window[p:])
+		if length > uint32(len(window)-p-12) || !validChunkType(window[p+4:p+8]) {
+			continue
+		}
+		end := p + 8 + int(length)
+		if crc32.ChecksumIEEE(window[p+4:end]) == binary.BigEndian.Uint32(window[end:]) {
+			return io.MultiReader(bytes.NewReader(window[p:]), r), p, nil
+		}
+	}
+	return nil, 0, corrupt("invalid PNG chunk type, and no valid chunk follows")
+}
+
+// pngChunk returns a complete PNG chunk with its length and CRC computed
+func pngChunk(typ string, data []byte) []byte {
+	chunk := make([]byte, 4, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// pngKeywordPrefix is how much of a PNG textual chunk is read to learn its
+// keyword: up to 79 bytes and the NUL ending it
+const pngKeywordPrefix = 80
+
+// removeTextChunk decides whether a PNG textual chunk should be removed,
+// returning its keyword and how: by TextKeyModes, RemoveTextChunks,
+// TextKeysToRemove or the categories pngTextKeys gives the keyword. Every textual chunk type starts with a
+// Latin-1 keyword of up to 79 bytes ended by a NUL; keywords are matched
+// exactly, as the spec makes them case-sensitive.
+func removeTextChunk(data []byte, config Config) (string, TextMode, bool) {
+	keyword := pngKeyword(data)
+	if mode, ok := config.TextKeyModes[keyword]; ok {
+		return keyword, mode, true
+	}
+	if config.RemoveTextChunks {
+		return keyword, config.TextMode, true
+	}
+	for _, k := range config.TextKeysToRemove {
+		if k == keyword {
+			return k, config.TextMode, true
+		}
+	}
+	for _, k := range pngTextKeys {
+		if k.Keyword == keyword && k.removed(config) {
+			return keyword, config.TextMode, true
+		}
+	}
+	return "", TextDrop, false
 }
 
-// modifyEXIF processes EXIF data (shared across formats)
-func modifyEXIF(data []byte, config Config) ([]byte, error) {
-	if !bytes.HasPrefix(data, []byte("Exif\x00\x00")) {
+// pngKeyword returns the keyword that starts the data of a textual chunk
+func pngKeyword(data []byte) string {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return latin1(data)
+}
+
+// textKeptReason names why a textual chunk with keyword was kept
+func textKeptReason(keyword string) string {
+	for _, k := range pngTextKeys {
+		if k.Keyword == keyword {
+			return "no enabled category"
+		}
+	}
+	return "in no category"
+}
+
+// pngChunkCarrier returns the carrier of a kept ancillary chunk type
+func pngChunkCarrier(typ string) Carrier {
+	if typ == "iCCP" {
+		return CarrierICC
+	}
+	return CarrierOther
+}
+
+// pngKeptReason names why an ancillary chunk of typ was passed through
+func pngKeptReason(typ string) string {
+	switch {
+	case typ == "iCCP":
+		return "ICC profile without RemoveICCProfile"
+	case knownPNGChunks[typ]:
+		return "registered chunk type"
+	}
+	return "unknown chunk without RemoveUnknownChunks"
+}
+
+// segmentIdentifier returns the NUL-terminated identifier that starts most
+// APPn payloads, truncated to 32 bytes
+func segmentIdentifier(data []byte) []byte {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	if len(data) > 32 {
+		data = data[:32]
+	}
+	return data
+}
+
+// isAudioSegment reports whether an APPn payload is a vendor segment
+// carrying a RIFF WAVE recording, either at its start or after a
+// NUL-terminated identifier such as a maker name
+func isAudioSegment(data []byte) bool {
+	isWAVE := func(b []byte) bool {
+		return len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WAVE"
+	}
+	if isWAVE(data) {
+		return true
+	}
+	id := segmentIdentifier(data)
+	return len(id) < len(data) && data[len(id)] == 0 && isWAVE(data[len(id)+1:])
+}
+
+// modifyEXIF processes EXIF data (shared across formats). Edits are made to
+// a copy, so data is never modified and may be caller-owned or read-only.
+func modifyEXIF(data []byte, config Config, report *Report) ([]byte, error) {
+	if !bytes.HasPrefix(data, exifPrefix) || len(data) < 14 {
 		return data, nil
 	}
+	data = append([]byte(nil), data...)
+
+	// IFD offsets are relative to the TIFF header, which follows the
+	// six-byte "Exif\0\0" prefix
+	tiff := data[6:]
 
 	var order binary.ByteOrder
-	if bytes.Equal(data[6:8], []byte("II")) {
+	if config.Permissive && !bytes.Equal(tiff[0:2], []byte("II")) && !bytes.Equal(tiff[0:2], []byte("MM")) &&
+		(bytes.EqualFold(tiff[0:2], []byte("II")) || bytes.EqualFold(tiff[0:2], []byte("MM"))) {
+		report.warn(WarnByteOrder, fmt.Sprintf("TIFF byte order %q read as %q", tiff[0:2], bytes.ToUpper(tiff[0:2])))
+		copy(tiff, bytes.ToUpper(tiff[0:2]))
+	}
+	if bytes.Equal(tiff[0:2], []byte("II")) {
 		order = binary.LittleEndian
-	} else if bytes.Equal(data[6:8], []byte("MM")) {
+	} else if bytes.Equal(tiff[0:2], []byte("MM")) {
 		order = binary.BigEndian
 	} else {
-		return nil, errors.New("invalid byte order")
+		return nil, errByteOrder
 	}
 
-	offset := int(order.Uint32(data[8:12]))
-	if offset+4 > len(data) {
-		return data, nil
+	// IFD0 and any IFDs chained after it (the thumbnail's IFD1, further
+	// pages of a TIFF) get the same treatment
+	visited := make(map[int]bool)
+	offset := int(order.Uint32(tiff[4:8]))
+	for len(visited) < maxIFDs && offset >= 8 && offset+2 <= len(tiff) && !visited[offset] {
+		visited[offset] = true
+		if len(visited) > 1 && config.RemoveThumbnail {
+			removeThumbnail(tiff, offset, order, report)
+		}
+		next, err := modifyIFD(tiff, offset, "IFD"+strconv.Itoa(len(visited)-1), order, config, report)
+		if err != nil {
+			return nil, err
+		}
+		offset = next
 	}
+	return data, nil
+}
+
+// maxIFDs bounds how long an IFD chain modifyEXIF follows
+const maxIFDs = 64
 
+// warnDuplicateTags warns once for each tag that appears more than once in
+// the IFD at offset, as broken writers repeat Artist or DateTime with
+// different values. The walkers decide every entry on its own, so all
+// copies of a removed tag go, not just the first.
+func warnDuplicateTags(data []byte, offset int, ifd string, order binary.ByteOrder, report *Report) {
 	numEntries := int(order.Uint16(data[offset : offset+2]))
+	if numEntries < 2 {
+		return
+	}
+	counts := make(map[uint16]int)
+	var repeated []uint16
+	for i, pos := 0, offset+2; i < numEntries && pos+12 <= len(data); i, pos = i+1, pos+12 {
+		tag := order.Uint16(data[pos : pos+2])
+		if counts[tag]++; counts[tag] == 2 {
+			repeated = append(repeated, tag)
+		}
+	}
+	for _, tag := range repeated {
+		report.warn(WarnDuplicateTag, fmt.Sprintf("%s has %d entries for %s", ifd, counts[tag], TagName(tag)))
+	}
+}
+
+// modifyIFD modifies the tags of the top-level IFD at offset and returns
+// the offset of the next IFD in the chain, or 0 at its end
+func modifyIFD(tiff []byte, offset int, ifd string, order binary.ByteOrder, config Config, report *Report) (int, error) {
+	numEntries := int(order.Uint16(tiff[offset : offset+2]))
 	pos := offset + 2
+	warnDuplicateTags(tiff, offset, ifd, order, report)
 
-	for i := 0; i < numEntries && pos+12 <= len(data); i++ {
-		tag := order.Uint16(data[pos : pos+2])
+	for i := 0; i < numEntries && pos+12 <= len(tiff); i++ {
+		tag := order.Uint16(tiff[pos : pos+2])
 		switch tag {
-		case 0x0132, 0x9003, 0x9004: // DateTime
-			if config.RemoveDateTime {
-				data[pos+4] = 0
-				data[pos+5] = 0
-				data[pos+6] = 0
-				data[pos+7] = 0
-			}
-		case 0x9286, 0x927c, 0x8298: // User Info
-			if config.RemoveUserInfo || (config.RemoveCopyright && tag == 0x8298) {
-				data[pos+4] = 0
-				data[pos+5] = 0
-				data[pos+6] = 0
-				data[pos+7] = 0
-			}
 		case 0x8769: // EXIF IFD
-			if err := modifyExifIFD(data, int(order.Uint32(data[pos+8:pos+12])), order, config); err != nil {
-				return nil, err
+			if ifd, ok := ifdPointer(tiff, pos, order); ok {
+				if err := modifyExifIFD(tiff, ifd, order, config, report); err != nil {
+					return 0, err
+				}
 			}
 		case 0x8825: // GPS IFD
-			if config.RemoveGPSInfo {
-				data[pos+8] = 0
-				data[pos+9] = 0
-				data[pos+10] = 0
-				data[pos+11] = 0
+			if order.Uint32(tiff[pos+8:pos+12]) != 0 && report.decideTag(ifd, tag, config) {
+				if config.GPSAction != GPSRemove && redactGPS(tiff, pos, order, config, report) {
+					break // the position stays, coarsened or replaced
+				}
+				strength, size := RemovalUnlinked, int64(0)
+				if ifd, ok := ifdPointer(tiff, pos, order); ok {
+					var wiped bool
+					if wiped, size = modifyGPSIFD(tiff, ifd, order, config, report); wiped {
+						strength = RemovalOverwritten
+					}
+				}
+				tiff[pos+8] = 0
+				tiff[pos+9] = 0
+				tiff[pos+10] = 0
+				tiff[pos+11] = 0
+				report.removeTag(exifTag(tag, config), strength, size)
+			} else if len(config.CustomTagsToRemove) > 0 {
+				if ifd, ok := ifdPointer(tiff, pos, order); ok {
+					modifyCustomGPSTags(tiff, ifd, order, config, report)
+				}
+			}
+		default:
+			switch {
+			case isEmptyEntry(tiff, pos, order):
+			case rewriteDateTime(tiff, pos, order, config, report):
+			case report.decideEntry(ifd, tiff, pos, order, config):
+				report.removeEntry(exifTag(tag, config), tiff, pos, order)
+			case tag == 0x0201: // JPEGInterchangeFormat
+				report.warn(WarnThumbnail, "thumbnail image kept")
 			}
 		}
 		pos += 12
 	}
-	return data, nil
+	if pos != offset+2+12*numEntries || pos+4 > len(tiff) {
+		return 0, nil
+	}
+	return int(order.Uint32(tiff[pos : pos+4])), nil
+}
+
+// removeThumbnail overwrites the JPEG thumbnail an IFD after IFD0 points to
+// with JPEGInterchangeFormat (0x0201) and JPEGInterchangeFormatLength
+// (0x0202), then empties both entries. In IFD0 of a standalone TIFF the
+// same tags can locate the image itself, so IFD0 is never passed here.
+func removeThumbnail(tiff []byte, offset int, order binary.ByteOrder, report *Report) {
+	numEntries := int(order.Uint16(tiff[offset : offset+2]))
+	var start, length int64
+	var entries []int
+	for i, pos := 0, offset+2; i < numEntries && pos+12 <= len(tiff); i, pos = i+1, pos+12 {
+		tag := order.Uint16(tiff[pos : pos+2])
+		if (tag != 0x0201 && tag != 0x0202) || isEmptyEntry(tiff, pos, order) {
+			continue
+		}
+		value, ok := inlineUint(tiff, pos, order)
+		if !ok {
+			continue
+		}
+		if tag == 0x0201 {
+			start = value
+		} else {
+			length = value
+		}
+		entries = append(entries, pos)
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	strength := RemovalUnlinked
+	if start >= 8 && length > 0 && start+length <= int64(len(tiff)) {
+		thumbnail := tiff[start : start+length]
+		for i := range thumbnail {
+			thumbnail[i] = 0
+		}
+		strength = RemovalOverwritten
+	}
+	for _, pos := range entries {
+		removeValue(tiff, pos, order)
+	}
+	report.remove(RemovedItem{Carrier: CarrierThumbnail, Name: "IFD1 thumbnail", Strength: strength, Size: length})
+}
+
+// inlineUint returns the value of a SHORT or LONG entry with count 1
+func inlineUint(data []byte, pos int, order binary.ByteOrder) (int64, bool) {
+	if order.Uint32(data[pos+4:pos+8]) != 1 {
+		return 0, false
+	}
+	switch order.Uint16(data[pos+2 : pos+4]) {
+	case 3:
+		return int64(order.Uint16(data[pos+8 : pos+10])), true
+	case 4:
+		return int64(order.Uint32(data[pos+8 : pos+12])), true
+	}
+	return 0, false
+}
+
+// ifdPointer returns the IFD offset stored in the pointer entry at pos. The
+// spec says LONG with count 1, but some firmwares write SHORT (value in the
+// first half of the field) or a count above 1, in which case the first
+// element is taken. Offsets of 0, into the header, or past the blob are
+// refused instead of walking garbage.
+func ifdPointer(data []byte, pos int, order binary.ByteOrder) (int, bool) {
+	typ := order.Uint16(data[pos+2 : pos+4])
+	count := order.Uint32(data[pos+4 : pos+8])
+	var offset int64
+	switch {
+	case typ == 3: // SHORT
+		offset = int64(order.Uint16(data[pos+8 : pos+10]))
+	case (typ == 4 || typ == 13) && count <= 1: // LONG, IFD
+		offset = int64(order.Uint32(data[pos+8 : pos+12]))
+	case typ == 4 || typ == 13:
+		array := int64(order.Uint32(data[pos+8 : pos+12]))
+		if array+4 > int64(len(data)) {
+			return 0, false
+		}
+		offset = int64(order.Uint32(data[array : array+4]))
+	default:
+		return 0, false
+	}
+	if offset < 8 || offset+2 > int64(len(data)) {
+		return 0, false
+	}
+	return int(offset), true
 }
 
 // modifyExifIFD modifies EXIF IFD tags
-func modifyExifIFD(data []byte, offset int, order binary.ByteOrder, config Config) error {
+func modifyExifIFD(data []byte, offset int, order binary.ByteOrder, config Config, report *Report) error {
 	if offset+2 > len(data) {
 		return nil
 	}
 
 	numEntries := int(order.Uint16(data[offset : offset+2]))
 	pos := offset + 2
+	warnDuplicateTags(data, offset, "EXIF", order, report)
 
 	for i := 0; i < numEntries && pos+12 <= len(data); i++ {
 		tag := order.Uint16(data[pos : pos+2])
-		switch tag {
-		case 0x010f, 0x0110, 0x9000, 0xa000: // Camera Info
-			if config.RemoveCameraInfo {
-				data[pos+4] = 0
-				data[pos+5] = 0
-				data[pos+6] = 0
-				data[pos+7] = 0
-			}
-		case 0x9207, 0x9209, 0x829a, 0x829d, 0x8822, 0x9204, 0x8827, 0x9201, 0x9202, 0x9205, 0x9206, 0x920a, 0xa405: // Technical Details
-			if config.RemoveTechnicalDetail {
-				data[pos+4] = 0
-				data[pos+5] = 0
-				data[pos+6] = 0
-				data[pos+7] = 0
-			}
-		case 0x9003, 0x9004: // DateTime in EXIF IFD
-			if config.RemoveDateTime {
-				data[pos+4] = 0
-				data[pos+5] = 0
-				data[pos+6] = 0
-				data[pos+7] = 0
+		switch {
+		case isEmptyEntry(data, pos, order):
+		case rewriteDateTime(data, pos, order, config, report):
+		case report.decideEntry("EXIF", data, pos, order, config):
+			report.removeEntry(exifTag(tag, config), data, pos, order)
+		case tag == 0x927c:
+			if !config.RemoveGPSInfo || !modifyDJIMakerNote(data, pos, order, report) {
+				report.warn(WarnMakerNote, "maker note kept unparsed")
 			}
 		}
 		pos += 12
 	}
 	return nil
 }
+
+// modifyGPSIFD overwrites the values of every GPS IFD entry before the GPS
+// IFD is unlinked, so coordinates don't survive in the value area. The
+// free-text tags GPSProcessingMethod and GPSAreaInformation, which can carry
+// place names, are reported individually. It returns whether every value
+// could be overwritten, and the size of the values.
+func modifyGPSIFD(data []byte, offset int, order binary.ByteOrder, config Config, report *Report) (bool, int64) {
+	if offset+2 > len(data) {
+		return false, 0
+	}
+
+	numEntries := int(order.Uint16(data[offset : offset+2]))
+	pos := offset + 2
+
+	wiped, total := true, int64(0)
+	for i := 0; i < numEntries && pos+12 <= len(data); i++ {
+		tag := order.Uint16(data[pos : pos+2])
+		size, empty := entrySize(data, pos, order), isEmptyEntry(data, pos, order)
+		ok := wipeValue(data, pos, order)
+		if removeGPSTag(tag, config) && !empty {
+			strength := RemovalOverwritten
+			if !ok {
+				strength = RemovalUnlinked
+			}
+			report.removeTag(gpsTag(tag), strength, size)
+		}
+		wiped = wiped && ok
+		total += size
+		pos += 12
+	}
+	return wiped, total
+}
+
+// modifyCustomGPSTags removes the GPS IFD entries listed in
+// config.CustomTagsToRemove when the GPS IFD itself is kept
+func modifyCustomGPSTags(data []byte, offset int, order binary.ByteOrder, config Config, report *Report) {
+	numEntries := int(order.Uint16(data[offset : offset+2]))
+	pos := offset + 2
+	for i := 0; i < numEntries && pos+12 <= len(data); i++ {
+		tag := order.Uint16(data[pos : pos+2])
+		if customTag(tag, config) && !isEmptyEntry(data, pos, order) {
+			report.removeEntry(gpsTag(tag), data, pos, order)
+		}
+		pos += 12
+	}
+}
+
+// tiffTypeSize is the size in bytes of one value of each TIFF field type
+var tiffTypeSize = map[uint16]int64{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4,
+}
+
+// entrySize returns the size in bytes of the value of the IFD entry at pos,
+// or 0 for an unknown type
+func entrySize(data []byte, pos int, order binary.ByteOrder) int64 {
+	size := tiffTypeSize[order.Uint16(data[pos+2:pos+4])]
+	return size * int64(order.Uint32(data[pos+4:pos+8]))
+}
+
+// wipeValue zeroes the value of the IFD entry at pos, whether stored inline
+// in the entry or at the offset it points to, leaving the count alone. It
+// reports false when the value's extent can't be trusted: an unknown type,
+// a size that overflows, or an offset outside the blob or onto the entry
+// itself. Only the inline field is cleared then.
+func wipeValue(data []byte, pos int, order binary.ByteOrder) bool {
+	field := data[pos+8 : pos+12]
+	size, known := tiffTypeSize[order.Uint16(data[pos+2:pos+4])]
+	count := int64(order.Uint32(data[pos+4 : pos+8]))
+	length := size * count // at most 8 * (2^32-1), no overflow in int64
+
+	if known && length > 4 {
+		offset := int64(order.Uint32(field))
+		if offset >= 8 && offset+length <= int64(len(data)) &&
+			(int64(pos)+12 <= offset || offset+length <= int64(pos)) {
+			value := data[offset : offset+length]
+			for i := range value {
+				value[i] = 0
+			}
+		} else {
+			known = false
+		}
+	}
+	for i := range field {
+		field[i] = 0
+	}
+	return known
+}
+
+// isEmptyEntry reports whether the IFD entry at pos holds nothing: a zero
+// count, the state zeroValue leaves it in, or an ASCII value of only NUL
+// bytes. Such entries are treated as absent, so sanitizing a sanitized file
+// finds nothing left to remove and an empty Artist isn't reported as
+// removed. Their bytes are left as they are.
+func isEmptyEntry(data []byte, pos int, order binary.ByteOrder) bool {
+	count := int64(order.Uint32(data[pos+4 : pos+8]))
+	if count == 0 {
+		return true
+	}
+	if order.Uint16(data[pos+2:pos+4]) != 2 { // ASCII
+		return false
+	}
+	value := data[pos+8 : pos+12]
+	if count > 4 {
+		offset := int64(order.Uint32(value))
+		if offset < 8 || offset+count > int64(len(data)) {
+			return false
+		}
+		value = data[offset : offset+count]
+	} else {
+		value = value[:count]
+	}
+	for _, b := range value {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// removeValue overwrites the value of the IFD entry at pos and then empties
+// the entry, returning how thoroughly the value is gone
+func removeValue(data []byte, pos int, order binary.ByteOrder) RemovalStrength {
+	strength := RemovalUnlinked
+	if wipeValue(data, pos, order) {
+		strength = RemovalOverwritten
+	}
+	zeroValue(data, pos)
+	return strength
+}
+
+// zeroValue clears the count field of the IFD entry at pos, so readers see
+// an empty value
+func zeroValue(data []byte, pos int) {
+	data[pos+4] = 0
+	data[pos+5] = 0
+	data[pos+6] = 0
+	data[pos+7] = 0
+}
@@ -0,0 +1,537 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// categoryConfig returns a Config removing only category c
+func categoryConfig(c Category) Config {
+	var config Config
+	switch c {
+	case CategoryCameraInfo:
+		config.RemoveCameraInfo = true
+	case CategoryGPSInfo:
+		config.RemoveGPSInfo = true
+	case CategoryCopyright:
+		config.RemoveCopyright = true
+	case CategoryDateTime:
+		config.RemoveDateTime = true
+	case CategoryUserInfo:
+		config.RemoveUserInfo = true
+	case CategoryTechnicalDetail:
+		config.RemoveTechnicalDetail = true
+	case CategoryEditingInfo:
+		config.RemoveEditingInfo = true
+	case CategoryFaceRegions:
+		config.RemoveFaceRegions = true
+	}
+	return config
+}
+
+// TestCategoriesInEveryIFD puts every tag of the decision table in IFD0, the
+// EXIF IFD and IFD1 at once and checks that each of its categories removes
+// all three copies
+func TestCategoriesInEveryIFD(t *testing.T) {
+	for _, info := range exifTags {
+		if info.ID == 0x8825 {
+			continue // the GPS IFD pointer, covered by the GPS tests
+		}
+		for _, c := range info.Categories {
+			t.Run(fmt.Sprintf("%s/%s", info.Name, c), func(t *testing.T) {
+				value := fmt.Sprintf("LEAK-%04X", info.ID)
+				in := fixture.EXIFJPEG(fixture.TIFF{
+					IFD0: []fixture.Entry{fixture.ASCII(info.ID, value+"-IFD0")},
+					Exif: []fixture.Entry{fixture.ASCII(info.ID, value+"-EXIF")},
+					IFD1: []fixture.Entry{fixture.ASCII(info.ID, value+"-IFD1")},
+				}.Bytes())
+				if m := inspect(t, in); !m.hasTag("IFD0", info.ID) || !m.hasTag("EXIF", info.ID) || !m.hasTag("IFD1", info.ID) {
+					t.Fatalf("fixture is missing a copy: %+v", m.Tags)
+				}
+
+				config := categoryConfig(c)
+				config.RemoveStructuralTags = structuralTags[info.ID]
+				out, report := sanitize(t, in, config)
+				if m := inspect(t, out); m.hasTag("", info.ID) {
+					t.Errorf("a copy survived: %+v", m.Tags)
+				}
+				assertAbsent(t, out, value)
+				if n := len(report.Removed); n != 3 {
+					t.Errorf("reported %d removals, want 3: %v", n, report.Removed)
+				}
+			})
+		}
+	}
+}
+
+// TestDuplicatedCameraTags covers the round-trip case of Make and Model in
+// both IFD0 and the EXIF IFD next to the capture dates
+func TestDuplicatedCameraTags(t *testing.T) {
+	in := fixture.EXIFJPEG(fixture.TIFF{
+		IFD0: []fixture.Entry{
+			fixture.ASCII(0x010f, "CanonMake"),
+			fixture.ASCII(0x0110, "EOS Model 5D"),
+			fixture.ASCII(0x0132, "2023:06:14 18:42:07"),
+		},
+		Exif: []fixture.Entry{
+			fixture.ASCII(0x010f, "CopiedMake"),
+			fixture.ASCII(0x0110, "CopiedModel"),
+			fixture.ASCII(0x9003, "2023:06:14 18:42:07"),
+		},
+	}.Bytes())
+
+	out, _ := sanitize(t, in, Config{RemoveCameraInfo: true})
+	assertAbsent(t, out, "CanonMake", "EOS Model 5D", "CopiedMake", "CopiedModel")
+	m := inspect(t, out)
+	if !m.hasTag("IFD0", 0x0132) || !m.hasTag("EXIF", 0x9003) {
+		t.Errorf("dates removed without RemoveDateTime: %+v", m.Tags)
+	}
+}
+
+// TestEditingInfo covers a Lightroom export: the IFD0 rating tags and the
+// XMP rating, edit history and document ancestry
+func TestEditingInfo(t *testing.T) {
+	le := binary.LittleEndian
+	tiff := fixture.TIFF{IFD0: []fixture.Entry{
+		fixture.ASCII(0x010f, "KeptMake"),
+		fixture.Short(le, 0x4746, 4),
+		fixture.Short(le, 0x4749, 75),
+	}}.Bytes()
+	xmp := fixture.XMP(
+		`xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:xmpMM="http://ns.adobe.com/xap/1.0/mm/" `+
+			`xmlns:stRef="http://ns.adobe.com/xap/1.0/sType/ResourceRef#" xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/" `+
+			`xmlns:MicrosoftPhoto="http://ns.microsoft.com/photo/1.0/" xmp:Rating="4" MicrosoftPhoto:Rating="75" xmp:CreatorTool="KeptTool"`,
+		`<xmpMM:History><rdf:Seq><rdf:li stEvt:action="saved" stEvt:softwareAgent="Lightroom" stEvt:when="2023-06-14T18:42:07" stEvt:changed="jdoe-laptop"/></rdf:Seq></xmpMM:History>`+
+			`<xmpMM:DerivedFrom stRef:documentID="xmp.did:ORIGINAL-ASSET" stRef:filePath="IMG_0042.CR3"/>`+
+			`<photoshop:DocumentAncestors><rdf:Bag><rdf:li>adobe:docid:photoshop:CLOUD-ASSET-ID</rdf:li></rdf:Bag></photoshop:DocumentAncestors>`,
+	)
+	in := fixture.WithSegment(fixture.EXIFJPEG(tiff), 0xE1, xmp)
+
+	out, report := sanitize(t, in, Config{RemoveEditingInfo: true})
+	assertAbsent(t, out, "jdoe-laptop", "IMG_0042.CR3", "ORIGINAL-ASSET", "CLOUD-ASSET-ID", `xmp:Rating="4"`, `MicrosoftPhoto:Rating="75"`)
+	if !bytes.Contains(out, []byte("KeptMake")) || !bytes.Contains(out, []byte("KeptTool")) {
+		t.Error("RemoveEditingInfo removed more than editing info")
+	}
+	m := inspect(t, out)
+	if m.hasTag("IFD0", 0x4746) || m.hasTag("IFD0", 0x4749) {
+		t.Errorf("rating tags kept: %+v", m.Tags)
+	}
+	xmpRemoved := 0
+	for _, item := range report.Removed {
+		if item.Carrier == CarrierXMP {
+			xmpRemoved++
+		}
+	}
+	if xmpRemoved != 5 {
+		t.Errorf("reported %d XMP removals, want 5: %v", xmpRemoved, report.Removed)
+	}
+}
+
+// pointerTIFF returns a TIFF whose only IFD0 entry is the EXIF IFD
+// pointer, rewritten by patch, and whose EXIF IFD holds a Make
+func pointerTIFF(order binary.ByteOrder, patch func(tiff, entry []byte)) []byte {
+	tiff := fixture.TIFF{Order: order, Exif: []fixture.Entry{fixture.ASCII(0x010f, "PointedMake")}}.Bytes()
+	patch(tiff, tiff[10:22])
+	return tiff
+}
+
+func TestIFDPointerTypes(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		short := pointerTIFF(order, func(tiff, entry []byte) {
+			offset := order.Uint32(entry[8:])
+			order.PutUint16(entry[2:], 3)
+			order.PutUint32(entry[8:], 0)
+			order.PutUint16(entry[8:], uint16(offset))
+		})
+		// A LONG pointer with count 2 points at an array whose first
+		// element is the IFD offset
+		array := pointerTIFF(order, func(tiff, entry []byte) {})
+		offset := order.Uint32(array[18:])
+		array = append(array, make([]byte, 8)...)
+		order.PutUint32(array[len(array)-8:], offset)
+		order.PutUint32(array[14:], 2)
+		order.PutUint32(array[18:], uint32(len(array)-8))
+
+		for name, tiff := range map[string][]byte{"SHORT": short, "LONG count 2": array} {
+			t.Run(fmt.Sprintf("%v %s", order, name), func(t *testing.T) {
+				in := fixture.EXIFJPEG(tiff)
+				if m := inspect(t, in); !m.hasTag("EXIF", 0x010f) {
+					t.Fatalf("EXIF IFD not found through the pointer: %+v", m.Tags)
+				}
+				out, _ := sanitize(t, in, Config{RemoveCameraInfo: true, CustomTagsToRemove: []uint16{0xa431}})
+				assertAbsent(t, out, "PointedMake")
+			})
+		}
+	}
+}
+
+func TestIFDPointerInvalid(t *testing.T) {
+	le := binary.LittleEndian
+	for name, offset := range map[string]uint32{"zero": 0, "into header": 4, "past end": 1 << 20} {
+		t.Run(name, func(t *testing.T) {
+			in := fixture.EXIFJPEG(pointerTIFF(le, func(tiff, entry []byte) { le.PutUint32(entry[8:], offset) }))
+			out, _ := sanitize(t, in, Config{RemoveCameraInfo: true, CustomTagsToRemove: []uint16{0xa431}})
+			if m := inspect(t, out); m.hasTag("EXIF", 0x010f) {
+				t.Errorf("followed an invalid pointer: %+v", m.Tags)
+			}
+		})
+	}
+	// An unknown type isn't read as an offset at all
+	in := fixture.EXIFJPEG(pointerTIFF(le, func(tiff, entry []byte) { le.PutUint16(entry[2:], 2) }))
+	if m := inspect(t, in); m.hasTag("EXIF", 0x010f) {
+		t.Errorf("followed an ASCII-typed pointer: %+v", m.Tags)
+	}
+}
+
+func TestRemovedValuesScrubbed(t *testing.T) {
+	// Strings and rationals longer than the entry's four bytes live at an
+	// offset; removing the tag must overwrite them there, in either byte
+	// order
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		tiff := fixture.Sample()
+		tiff.Order = order
+		tiff.GPS = []fixture.Entry{
+			fixture.ASCII(0x0001, "N"),
+			fixture.Rational(order, 0x0002, 51, 1, 30, 1, 1234, 100),
+		}
+		in := fixture.EXIFJPEG(tiff.Bytes())
+		out, report := sanitize(t, in, Config{RemoveCameraInfo: true, RemoveGPSInfo: true, RemoveDateTime: true})
+		for _, s := range []string{"CanonMake", "EOS Model 5D", "2023:06:14 18:42:07"} {
+			assertAbsent(t, out, s)
+		}
+		latitude := fixture.Rational(order, 0x0002, 51, 1, 30, 1, 1234, 100).Value
+		if bytes.Contains(out, latitude) {
+			t.Errorf("%v: GPS latitude rationals left in the output", order)
+		}
+		for _, item := range report.Removed {
+			if item.Strength != RemovalOverwritten && item.Strength != RemovalEliminated {
+				t.Errorf("%v: %s only %s", order, item.Name, item.Strength)
+			}
+		}
+	}
+}
+
+func TestWipeValueUntrustedExtent(t *testing.T) {
+	le := binary.LittleEndian
+	entry := func(typ uint16, count, offset uint32) []byte {
+		data := make([]byte, 64)
+		for i := 20; i < len(data); i++ {
+			data[i] = 0xAA
+		}
+		pos := 8
+		le.PutUint16(data[pos:], 0x010f)
+		le.PutUint16(data[pos+2:], typ)
+		le.PutUint32(data[pos+4:], count)
+		le.PutUint32(data[pos+8:], offset)
+		return data
+	}
+	for _, c := range []struct {
+		name   string
+		data   []byte
+		wiped  bool
+		intact bool // the bytes past the entry are untouched
+	}{
+		{"in range", entry(2, 10, 30), true, false},
+		{"inline", entry(2, 3, 0x414141), true, true},
+		{"past the end", entry(2, 10, 60), false, true},
+		{"offset overflow", entry(2, 10, 0xFFFFFFF8), false, true},
+		{"count overflow", entry(5, 0xFFFFFFFF, 30), false, true},
+		{"onto the entry", entry(2, 10, 4), false, true},
+		{"unknown type", entry(99, 10, 30), false, true},
+	} {
+		data := c.data
+		if got := wipeValue(data, 8, le); got != c.wiped {
+			t.Errorf("%s: wipeValue %v, want %v", c.name, got, c.wiped)
+		}
+		if !bytes.Equal(data[16:20], make([]byte, 4)) {
+			t.Errorf("%s: inline field not cleared", c.name)
+		}
+		if intact := bytes.Count(data[20:], []byte{0xAA}) == len(data)-20; intact != c.intact {
+			t.Errorf("%s: bytes past the entry intact %v, want %v", c.name, intact, c.intact)
+		}
+	}
+}
+
+func FuzzRemoveJPEG(f *testing.F) {
+	tiff := fixture.Sample().Bytes()
+	f.Add(fixture.EXIFJPEG(tiff), uint8(0))
+	f.Add(fixture.EXIFJPEG(tiff), uint8(1))
+	f.Add(fixture.EXIFJPEG(tiff)[:200], uint8(2))
+	f.Add(fixture.WithSegment(fixture.JPEG(8, 8), 0xE1, fixture.XMP(`xmlns:exif="http://ns.adobe.com/exif/1.0/" exif:GPSLatitude="51,30N"`, "")), uint8(0))
+	f.Add(fixture.WithSegment(fixture.JPEG(8, 8), 0xED, fixture.Photoshop(fixture.Resource(0x0404, fixture.Dataset(2, 90, "City")))), uint8(0))
+	f.Add([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01}, uint8(0))
+	f.Add([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF, 'E', 'x', 'i', 'f', 0, 0}, uint8(2))
+	f.Fuzz(func(t *testing.T, data []byte, mode uint8) {
+		fuzzRemove(t, data, mode)
+	})
+}
+
+// TestFarOffsets places the out-of-line values far past the directories,
+// so any offset not resolved from the TIFF header lands in the zero gap:
+// values must read as written, removal must overwrite exactly the bytes
+// of the removed values, and exiftool, where installed, must agree
+func TestFarOffsets(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, gap := range []int{6, 4096, 60000} {
+			tiff := fixture.Sample()
+			tiff.Order = order
+			tiff.GPS = []fixture.Entry{
+				fixture.ASCII(0x0001, "N"),
+				fixture.Rational(order, 0x0002, 51, 1, 30, 1, 1234, 100),
+				fixture.ASCII(0x0003, "W"),
+				fixture.Rational(order, 0x0004, 0, 1, 7, 1, 3901, 100),
+			}
+			tiff.Gap = gap
+			in := fixture.EXIFJPEG(tiff.Bytes())
+
+			m := inspect(t, in)
+			want := map[uint16]string{0x010f: "CanonMake", 0x0110: "EOS Model 5D", 0x013b: "John Artist", 0xa431: "SERIAL-0042"}
+			for _, tag := range m.Tags {
+				if v, ok := want[tag.Tag]; ok && tag.Value != v {
+					t.Errorf("%v, gap %d: %s reads %q, want %q", order, gap, tag.Name, tag.Value, v)
+				}
+			}
+			if lat := 51 + 30.0/60 + 12.34/3600; !m.HasPosition || m.Latitude < lat-1e-9 || m.Latitude > lat+1e-9 {
+				t.Errorf("%v, gap %d: latitude %v, want %v", order, gap, m.Latitude, lat)
+			}
+
+			out, _ := sanitize(t, in, Config{RemoveCameraInfo: true, CustomTagsToRemove: []uint16{0xa431}})
+			if len(out) != len(in) {
+				t.Fatalf("%v, gap %d: output %d bytes, want the input's %d", order, gap, len(out), len(in))
+			}
+			// Only the bytes of the removed values, and the entries naming
+			// them, may differ
+			allowed := make([]bool, len(in))
+			for _, v := range []string{"CanonMake\x00", "EOS Model 5D\x00", "SERIAL-0042\x00"} {
+				i := bytes.Index(in, []byte(v))
+				if i < 0 {
+					t.Fatalf("%q not in the input", v)
+				}
+				for j := i; j < i+len(v); j++ {
+					allowed[j] = true
+				}
+			}
+			for i := 0; i < len(in) && i < 2+4+6+8+64*12; i++ {
+				allowed[i] = true // the header and directories
+			}
+			for i := range in {
+				if in[i] != out[i] && !allowed[i] {
+					t.Errorf("%v, gap %d: byte %d changed outside the removed values", order, gap, i)
+					break
+				}
+			}
+			assertAbsent(t, out, "CanonMake", "EOS Model 5D", "SERIAL-0042")
+			for _, keep := range []string{"John Artist", "2023:06:14 18:42:07"} {
+				if !bytes.Contains(out, []byte(keep)) {
+					t.Errorf("%v, gap %d: kept value %q damaged", order, gap, keep)
+				}
+			}
+			checkExiftool(t, out, map[string]string{"Artist": "John Artist", "GPSLatitudeRef": "N"})
+		}
+	}
+}
+
+// checkExiftool checks the named tags of a JPEG as exiftool reads them,
+// when it is installed
+func checkExiftool(t *testing.T, jpeg []byte, want map[string]string) {
+	t.Helper()
+	exiftool, err := exec.LookPath("exiftool")
+	if err != nil {
+		return
+	}
+	path := filepath.Join(t.TempDir(), "out.jpg")
+	if err := os.WriteFile(path, jpeg, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"-s", "-s", "-n"}
+	for name := range want {
+		args = append(args, "-"+name)
+	}
+	got, err := exec.Command(exiftool, append(args, path)...).Output()
+	if err != nil {
+		t.Fatalf("exiftool: %v", err)
+	}
+	for name, v := range want {
+		if !bytes.Contains(got, []byte(name+": "+v)) {
+			t.Errorf("exiftool reads %s as other than %q:\n%s", name, v, got)
+		}
+	}
+}
+
+func TestCustomTagsToRemove(t *testing.T) {
+	le := binary.LittleEndian
+	tiff := fixture.TIFF{
+		IFD0: []fixture.Entry{
+			fixture.ASCII(0x010f, "CanonMake"),
+			fixture.ASCII(0x0131, "Firmware 1.2.3"),
+			fixture.ASCII(0x013c, "studio-pc.local"),
+		},
+		Exif: []fixture.Entry{
+			fixture.ASCII(0xa430, "Jane Owner"),
+			fixture.ASCII(0xa431, "BODY-0042"),
+			fixture.ASCII(0xa435, "LENS-0099"),
+			fixture.ASCII(0x9003, "2023:06:14 18:42:07"),
+		},
+		GPS: []fixture.Entry{
+			fixture.ASCII(0x0001, "N"),
+			fixture.Rational(le, 0x0002, 51, 1, 30, 1, 1234, 100),
+			fixture.ASCII(0x0012, "WGS-84-DATUM"),
+		},
+	}.Bytes()
+	values := map[uint16]string{
+		0x010f: "CanonMake", 0x0131: "Firmware 1.2.3", 0x013c: "studio-pc.local",
+		0xa430: "Jane Owner", 0xa431: "BODY-0042", 0xa435: "LENS-0099",
+		0x9003: "2023:06:14 18:42:07", 0x0012: "WGS-84-DATUM",
+	}
+	for _, tt := range []struct {
+		name    string
+		config  Config
+		removed []uint16
+	}{
+		{"serial numbers", Config{CustomTagsToRemove: []uint16{0xa431, 0xa435}}, []uint16{0xa431, 0xa435}},
+		{"IFD0 tags", Config{CustomTagsToRemove: []uint16{0x0131, 0x013c}}, []uint16{0x0131, 0x013c}},
+		{"GPS tag", Config{CustomTagsToRemove: []uint16{0x0012}}, []uint16{0x0012}},
+		{"with a category", Config{RemoveDateTime: true, CustomTagsToRemove: []uint16{0xa430}}, []uint16{0xa430, 0x9003}},
+		{"tag absent from the file", Config{CustomTagsToRemove: []uint16{0xa434}}, nil},
+		{"none", Config{}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out, report, err := SanitizeEXIFBlob(tiff, tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			removed := make(map[uint16]bool)
+			for _, tag := range tt.removed {
+				removed[tag] = true
+			}
+			for tag, v := range values {
+				if got := bytes.Contains(out, []byte(v)); got == removed[tag] {
+					t.Errorf("tag 0x%04x: value present %v, want %v", tag, got, !removed[tag])
+				}
+			}
+			m := inspect(t, fixture.EXIFJPEG(out))
+			for tag := range values {
+				if m.hasTag("", tag) == removed[tag] {
+					t.Errorf("tag 0x%04x: entry present %v, want %v", tag, m.hasTag("", tag), !removed[tag])
+				}
+			}
+			if len(report.Removed) != len(tt.removed) {
+				t.Errorf("%d removals reported, want %d: %v", len(report.Removed), len(tt.removed), report.Removed)
+			}
+		})
+	}
+}
+
+// TestDateTimeQualifiers checks that the SubSecTime and OffsetTime tags go
+// with the dates they qualify: removed with them by default, and rewritten
+// to match under the other policies
+func TestDateTimeQualifiers(t *testing.T) {
+	tiff := fixture.Sample()
+	tiff.Exif = []fixture.Entry{
+		fixture.ASCII(0x9003, "2023:06:14 18:42:07"),
+		fixture.ASCII(0x9011, "+02:00"),
+		fixture.ASCII(0x9291, "417"),
+	}
+	in := fixture.EXIFJPEG(tiff.Bytes())
+
+	m := inspect(t, in)
+	for _, tag := range []uint16{0x9011, 0x9291} {
+		if !m.hasTag("EXIF", tag) {
+			t.Fatalf("input has no tag 0x%04x", tag)
+		}
+	}
+	if got := TagName(0x9291); got != "SubSecTimeOriginal" {
+		t.Errorf("TagName(0x9291) = %q", got)
+	}
+
+	out, report := sanitize(t, in, Config{RemoveDateTime: true})
+	assertAbsent(t, out, "2023:06:14", "+02:00", "417")
+	removed := map[string]bool{}
+	for _, item := range report.Removed {
+		removed[item.Name] = true
+	}
+	for _, name := range []string{"DateTimeOriginal", "OffsetTimeOriginal", "SubSecTimeOriginal"} {
+		if !removed[name] {
+			t.Errorf("report doesn't list %s as removed", name)
+		}
+	}
+
+	out, _ = sanitize(t, in, Config{RemoveDateTime: true, DateTimePolicy: DateTimeShift, DateTimeShiftBy: time.Hour})
+	assertAbsent(t, out, "18:42:07", "417")
+	for _, want := range []string{"2023:06:14 19:42:07", "+02:00\x00", "000\x00"} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("shifted output lacks %q", want)
+		}
+	}
+
+	fixed := time.Date(2000, 1, 1, 0, 0, 0, 0, time.FixedZone("", -5*3600))
+	out, _ = sanitize(t, in, Config{RemoveDateTime: true, DateTimePolicy: DateTimeSetFixed, DateTimeFixed: fixed})
+	assertAbsent(t, out, "+02:00", "417")
+	if !bytes.Contains(out, []byte("-05:00\x00")) {
+		t.Error("fixed output lacks the zone of DateTimeFixed")
+	}
+}
+
+// TestMaxMetadataSizeJPEG checks that MaxMetadataSize bounds the APPn
+// segments of a JPEG and nothing else, so a small limit still passes the
+// tables and frame header of a file without metadata
+func TestMaxMetadataSizeJPEG(t *testing.T) {
+	config := Config{RemoveGPSInfo: true, MaxMetadataSize: 16}
+	plain := fixture.JPEG(8, 8)
+	if out, _ := sanitize(t, plain, config); !bytes.Equal(out, plain) {
+		t.Error("a JPEG without metadata changed")
+	}
+	in := fixture.WithSegment(plain, 0xEC, bytes.Repeat([]byte{'x'}, 17))
+	if _, err := RemoveEXIFFromBytes(in, config); !errors.Is(err, ErrMetadataTooLarge) {
+		t.Errorf("17-byte APP12 segment: %v, want ErrMetadataTooLarge", err)
+	}
+	config.MaxMetadataSize = 17
+	sanitize(t, in, config)
+}
+
+// TestDuplicateTags checks that a tag a broken writer repeated within one
+// IFD is warned about and loses every copy when its category is removed,
+// in IFD0 and in the EXIF IFD
+func TestDuplicateTags(t *testing.T) {
+	tiff := fixture.Sample()
+	tiff.IFD0 = append(tiff.IFD0, fixture.ASCII(0x013b, "Jane Artist"), fixture.ASCII(0x0110, "EOS Model 6D"))
+	tiff.Exif = append(tiff.Exif, fixture.ASCII(0x9003, "2019:01:02 03:04:05"))
+	in := fixture.EXIFJPEG(tiff.Bytes())
+
+	out, report := sanitize(t, in, Config{RemoveUserInfo: true, RemoveDateTime: true})
+	assertAbsent(t, out, "John Artist", "Jane Artist", "2023:06:14 18:42:07", "2019:01:02 03:04:05")
+	for _, kept := range []string{"EOS Model 5D", "EOS Model 6D"} {
+		if !bytes.Contains(out, []byte(kept)) {
+			t.Errorf("kept duplicate %q is gone", kept)
+		}
+	}
+	var details []string
+	for _, w := range report.Warnings {
+		if w.Code == WarnDuplicateTag {
+			details = append(details, w.Detail)
+		}
+	}
+	want := []string{"IFD0 has 2 entries for Artist", "IFD0 has 2 entries for Model", "EXIF has 2 entries for DateTimeOriginal"}
+	if strings.Join(details, "; ") != strings.Join(want, "; ") {
+		t.Errorf("duplicate warnings = %q, want %q", details, want)
+	}
+	artists := 0
+	for _, item := range report.Removed {
+		if item.Name == "Artist" {
+			artists++
+		}
+	}
+	if artists != 2 {
+		t.Errorf("%d Artist removals reported, want 2", artists)
+	}
+}
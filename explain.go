@@ -0,0 +1,301 @@
+package exifremover
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Action is what processing does to one kind of metadata item
+type Action int
+
+const (
+	ActionPreserve    Action = iota // left untouched
+	ActionRemove                    // removed or neutralized
+	ActionUnsupported               // left untouched because this build can't handle it
+)
+
+// String returns a lower-case name for the action
+func (a Action) String() string {
+	switch a {
+	case ActionPreserve:
+		return "preserve"
+	case ActionRemove:
+		return "remove"
+	case ActionUnsupported:
+		return "unsupported"
+	}
+	return "unknown"
+}
+
+// ExplanationItem is the effective decision for one tag or carrier
+type ExplanationItem struct {
+	Carrier    Carrier
+	IFD        string // "IFD0/EXIF" or "GPS" for EXIF tags, empty otherwise
+	Tag        uint16
+	Name       string // tag, XMP element or carrier name
+	Categories []Category
+	Action     Action
+	// Reason names the Config field or rule that decided Action for an
+	// EXIF tag, as Report.Trace does, or a PNG text keyword; it is empty
+	// for other items
+	Reason string
+}
+
+// Explanation lists the effective decision for every metadata item the
+// library knows about
+type Explanation struct {
+	Items    []ExplanationItem
+	Warnings []string // problems with the policy, such as unknown tag IDs
+}
+
+// ExplainPolicy reports what processing with config would do, without
+// processing anything. It is derived from the same decision tables the
+// removal code consults, so the two can't disagree.
+func ExplainPolicy(config Config) Explanation {
+	var e Explanation
+	for _, t := range exifTags {
+		e.Items = append(e.Items, explainTag("IFD0/EXIF", *exifTag(t.ID, config), config))
+	}
+	// The orientation is in no category, but PreserveOrientation and
+	// RemoveAll decide it
+	orientation := *exifTag(tagOrientation, config)
+	orientation.Name = "Orientation"
+	e.Items = append(e.Items, explainTag("IFD0/EXIF", orientation, config))
+	// The print-safe tags are in no category either, and kept whatever
+	// the policy says; they are listed so prepress operators can audit
+	// what is protected
+	seen := make(map[uint16]bool)
+	printSafe := make([]uint16, 0, len(printSafeTags))
+	for id := range printSafeTags {
+		printSafe = append(printSafe, id)
+	}
+	sort.Slice(printSafe, func(i, j int) bool { return printSafe[i] < printSafe[j] })
+	for _, id := range printSafe {
+		seen[id] = true
+		e.Items = append(e.Items, explainTag("IFD0", tagInfo{ID: id, Name: printSafeTags[id]}, config))
+	}
+	for c := CategoryCameraInfo; c <= lastCategory; c++ {
+		for _, id := range config.CategoryOverrides[c] {
+			if lookupTag(id) != nil || id == tagOrientation || seen[id] {
+				continue
+			}
+			seen[id] = true
+			e.Warnings = append(e.Warnings, fmt.Sprintf("%s override lists unknown tag 0x%04X", c, id))
+			e.Items = append(e.Items, explainTag("IFD0/EXIF", *exifTag(id, config), config))
+		}
+	}
+	for _, t := range gpsTags {
+		e.Items = append(e.Items, explainTag("GPS", t, config))
+	}
+	for _, id := range config.CustomTagsToRemove {
+		if lookupTag(id) != nil || gpsTagIndex[id] != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		e.Items = append(e.Items, explainTag("IFD0/EXIF/GPS", *exifTag(id, config), config))
+	}
+
+	for _, p := range xmpProperties {
+		action := ActionPreserve
+		if p.removed(config) {
+			action = ActionRemove
+		}
+		e.Items = append(e.Items, ExplanationItem{Carrier: CarrierXMP, Name: p.Name, Categories: p.Categories, Action: action})
+	}
+
+	aux := ActionPreserve
+	if config.RemoveAuxiliaryImages {
+		aux = ActionRemove
+	}
+	e.Items = append(e.Items, ExplanationItem{Carrier: CarrierAuxiliaryImage, Name: "MPF images", Action: aux})
+	e.Items = append(e.Items, ExplanationItem{Carrier: CarrierAuxiliaryImage, Name: "HEIF auxl items", Action: aux})
+	gainMaps := aux
+	if config.PreserveGainMaps {
+		gainMaps = ActionPreserve
+	}
+	e.Items = append(e.Items, ExplanationItem{Carrier: CarrierAuxiliaryImage, Name: "gain maps", Action: gainMaps})
+	for _, p := range xmpAuxiliaryProperties {
+		action := aux
+		if xmpGainMapProperties[p.Name] {
+			action = gainMaps
+		}
+		e.Items = append(e.Items, ExplanationItem{Carrier: CarrierAuxiliaryImage, Name: p.Name, Action: action})
+	}
+	iptc := ActionPreserve
+	if config.RemoveIPTC {
+		iptc = ActionRemove
+	}
+	e.Items = append(e.Items, ExplanationItem{Carrier: CarrierIPTC, Tag: resourceIPTC, Name: "IPTC-IIM", Action: iptc})
+	for _, d := range iptcDatasets {
+		action := iptc
+		if d.removed(config) {
+			action = ActionRemove
+		}
+		e.Items = append(e.Items, ExplanationItem{Carrier: CarrierIPTC, Tag: d.tag(), Name: d.Name, Categories: d.Categories, Action: action})
+	}
+	text := ActionPreserve
+	if config.RemoveTextChunks {
+		text = ActionRemove
+	}
+	e.Items = append(e.Items, ExplanationItem{Carrier: CarrierText, Name: CarrierText.String(), Action: text})
+	for _, k := range config.TextKeysToRemove {
+		if _, ok := config.TextKeyModes[k]; !ok {
+			e.Items = append(e.Items, ExplanationItem{Carrier: CarrierText, Name: k, Action: ActionRemove, Reason: "TextKeysToRemove, TextMode " + config.TextMode.String()})
+		}
+	}
+	for _, k := range pngTextKeys {
+		if _, ok := config.TextKeyModes[k.Keyword]; ok || config.RemoveTextChunks {
+			continue
+		}
+		action := ActionPreserve
+		if k.removed(config) {
+			action = ActionRemove
+		}
+		e.Items = append(e.Items, ExplanationItem{Carrier: CarrierText, Name: k.Keyword, Categories: k.Categories, Action: action})
+	}
+	keys := make([]string, 0, len(config.TextKeyModes))
+	for k := range config.TextKeyModes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		e.Items = append(e.Items, ExplanationItem{Carrier: CarrierText, Name: k, Action: ActionRemove, Reason: "TextKeyModes " + config.TextKeyModes[k].String()})
+	}
+	thumbnail := ActionPreserve
+	if config.RemoveThumbnail {
+		thumbnail = ActionRemove
+	}
+	e.Items = append(e.Items, ExplanationItem{Carrier: CarrierThumbnail, Name: "IFD1 thumbnail", Action: thumbnail})
+	comments := ActionPreserve
+	if config.RemoveComments {
+		comments = ActionRemove
+	}
+	e.Items = append(e.Items, ExplanationItem{Carrier: CarrierComment, Name: "COM segments", Action: comments})
+	audio := ActionPreserve
+	if config.RemoveVendorSegments {
+		audio = ActionRemove
+	}
+	e.Items = append(e.Items, ExplanationItem{Carrier: CarrierAudio, Name: "vendor audio segments", Action: audio})
+	icc := ActionPreserve
+	if config.RemoveICCProfile {
+		icc = ActionRemove
+	}
+	e.Items = append(e.Items, ExplanationItem{Carrier: CarrierICC, Name: "ICC profiles", Action: icc})
+	unknown := ActionPreserve
+	if config.RemoveUnknownChunks {
+		unknown = ActionRemove
+	}
+	e.Items = append(e.Items, ExplanationItem{Carrier: CarrierOther, Name: "unknown ancillary PNG chunks", Action: unknown})
+	return e
+}
+
+// explainTag decides one tag as processing would: GPS IFD entries by
+// removeGPSTag, others by tagDecision, and everything but a preserved
+// orientation under RemoveAll, which drops the container
+func explainTag(ifd string, t tagInfo, config Config) ExplanationItem {
+	var remove bool
+	var reason string
+	switch {
+	case config.RemoveAll && !(t.ID == tagOrientation && config.PreserveOrientation && ifd != "GPS"):
+		remove, reason = true, "RemoveAll"
+	case ifd == "GPS":
+		remove = removeGPSTag(t.ID, config)
+		reason = "no enabled category"
+		if remove {
+			reason = categoryFlags[CategoryGPSInfo]
+			if customTag(t.ID, config) {
+				reason = "CustomTagsToRemove"
+			}
+		}
+	default:
+		remove, reason = tagDecision(t.ID, config)
+	}
+	action := ActionPreserve
+	if remove {
+		action = ActionRemove
+	}
+	return ExplanationItem{
+		Carrier:    CarrierEXIF,
+		IFD:        ifd,
+		Tag:        t.ID,
+		Name:       t.Name,
+		Categories: t.Categories,
+		Action:     action,
+		Reason:     reason,
+	}
+}
+
+// CategoryMembers lists what one category covers in each carrier
+type CategoryMembers struct {
+	EXIF []uint16 // IFD0 and EXIF IFD tag IDs
+	GPS  []uint16 // GPS IFD tag IDs
+	XMP  []string // XMP element names
+	IPTC []string // IPTC-IIM dataset names
+	Text []string // PNG text chunk keywords
+	BMFF []string // HEIF items edited, by item type
+
+	MakerNote []string // maker note entries, by vendor and name
+}
+
+// CategoryMatrix returns the built-in mapping from each category to the
+// items it covers in every carrier. It is read from the decision tables the
+// removal code consults, so it is the single source of truth for what a
+// category means; ExplainPolicy applies a particular config to it. A HEIF
+// item is listed under every category its carrier covers.
+func CategoryMatrix() map[Category]CategoryMembers {
+	matrix := make(map[Category]CategoryMembers)
+	for c := CategoryCameraInfo; c <= lastCategory; c++ {
+		var m CategoryMembers
+		for _, t := range exifTags {
+			if t.hasCategory(c) {
+				m.EXIF = append(m.EXIF, t.ID)
+			}
+		}
+		for _, t := range gpsTags {
+			if t.hasCategory(c) {
+				m.GPS = append(m.GPS, t.ID)
+			}
+		}
+		for _, p := range xmpProperties {
+			if hasCategory(p.Categories, c) {
+				m.XMP = append(m.XMP, p.Name)
+			}
+		}
+		for _, d := range iptcDatasets {
+			if hasCategory(d.Categories, c) {
+				m.IPTC = append(m.IPTC, d.Name)
+			}
+		}
+		for _, k := range pngTextKeys {
+			if hasCategory(k.Categories, c) {
+				m.Text = append(m.Text, k.Keyword)
+			}
+		}
+		if hasCategory(djiMakerNoteCategories, c) {
+			for _, name := range djiMakerNoteTags {
+				m.MakerNote = append(m.MakerNote, "DJI "+name)
+			}
+			sort.Strings(m.MakerNote)
+		}
+		for _, item := range heifMetadataItems {
+			covered := item.Carrier == CarrierEXIF && len(m.EXIF)+len(m.GPS) > 0 ||
+				item.Carrier == CarrierXMP && len(m.XMP) > 0
+			if covered {
+				m.BMFF = append(m.BMFF, strings.TrimSpace(item.Type+" "+item.Content))
+			}
+		}
+		matrix[c] = m
+	}
+	return matrix
+}
+
+// hasCategory reports whether categories holds c
+func hasCategory(categories []Category, c Category) bool {
+	for _, have := range categories {
+		if have == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package exifremover
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// TestExplainMatchesProcessing puts every table tag in IFD0 and checks,
+// under a range of configs, that ExplainPolicy says it's removed exactly
+// when processing removes it
+func TestExplainMatchesProcessing(t *testing.T) {
+	le := binary.LittleEndian
+	entries := []fixture.Entry{fixture.Short(le, tagOrientation, 6)}
+	for _, info := range exifTags {
+		if info.ID == 0x8825 || info.ID == 0x8769 {
+			continue // IFD pointers, not values
+		}
+		entries = append(entries, fixture.Long(le, info.ID, 1))
+	}
+	in := fixture.EXIFJPEG(fixture.TIFF{IFD0: entries}.Bytes())
+
+	configs := map[string]Config{
+		"all":                  {RemoveAll: true},
+		"all with orientation": {RemoveAll: true, PreserveOrientation: true},
+		"structural":           {RemoveTechnicalDetail: true, RemoveStructuralTags: true},
+		"custom":               {CustomTagsToRemove: []uint16{0x010f, 0x9000}},
+		"override":             {RemoveGPSInfo: true, CategoryOverrides: map[Category][]uint16{CategoryGPSInfo: {0x0110}}, MergeCategoryOverrides: true},
+	}
+	for c := CategoryCameraInfo; c <= lastCategory; c++ {
+		config := categoryConfig(c)
+		config.PreserveOrientation = c == CategoryTechnicalDetail
+		configs[c.String()] = config
+	}
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			out, _ := sanitize(t, in, config)
+			m := inspect(t, out)
+			for _, item := range ExplainPolicy(config).Items {
+				if item.Carrier != CarrierEXIF || item.IFD != "IFD0/EXIF" || item.Tag == 0x8825 || item.Tag == 0x8769 {
+					continue
+				}
+				if removed := !m.hasTag("IFD0", item.Tag); removed != (item.Action == ActionRemove) {
+					t.Errorf("%s: explained as %s (%s), removed %v", item.Name, item.Action, item.Reason, removed)
+				}
+			}
+		})
+	}
+}
+
+func TestExplainReasons(t *testing.T) {
+	e := ExplainPolicy(Config{RemoveAll: true, PreserveOrientation: true, RemoveCameraInfo: true})
+	reasons := map[uint16]string{}
+	for _, item := range e.Items {
+		if item.IFD == "IFD0/EXIF" {
+			reasons[item.Tag] = item.Reason
+		}
+	}
+	if reasons[tagOrientation] != "PreserveOrientation" {
+		t.Errorf("orientation reason %q", reasons[tagOrientation])
+	}
+	if reasons[0x010f] != "RemoveAll" {
+		t.Errorf("Make reason %q", reasons[0x010f])
+	}
+	if text := e.Text("en", nil); !strings.Contains(text, "Orientation: preserved (PreserveOrientation)") {
+		t.Errorf("text doesn't give the reason:\n%s", text)
+	}
+}
+
+// TestCategoryMatrixCoversTables checks that every decision table entry is
+// in the matrix under each of its categories
+func TestCategoryMatrixCoversTables(t *testing.T) {
+	matrix := CategoryMatrix()
+	in := func(list []string, s string) bool {
+		for _, have := range list {
+			if have == s {
+				return true
+			}
+		}
+		return false
+	}
+	inIDs := func(list []uint16, id uint16) bool {
+		for _, have := range list {
+			if have == id {
+				return true
+			}
+		}
+		return false
+	}
+	for _, tag := range exifTags {
+		for _, c := range tag.Categories {
+			if !inIDs(matrix[c].EXIF, tag.ID) {
+				t.Errorf("EXIF %s missing from %s", tag.Name, c)
+			}
+		}
+	}
+	for _, tag := range gpsTags {
+		for _, c := range tag.Categories {
+			if !inIDs(matrix[c].GPS, tag.ID) {
+				t.Errorf("GPS %s missing from %s", tag.Name, c)
+			}
+		}
+	}
+	for _, p := range xmpProperties {
+		for _, c := range p.Categories {
+			if !in(matrix[c].XMP, p.Name) {
+				t.Errorf("XMP %s missing from %s", p.Name, c)
+			}
+		}
+	}
+	for _, d := range iptcDatasets {
+		for _, c := range d.Categories {
+			if !in(matrix[c].IPTC, d.Name) {
+				t.Errorf("IPTC %s missing from %s", d.Name, c)
+			}
+		}
+	}
+	for _, k := range pngTextKeys {
+		for _, c := range k.Categories {
+			if !in(matrix[c].Text, k.Keyword) {
+				t.Errorf("PNG text %s missing from %s", k.Keyword, c)
+			}
+		}
+	}
+	for _, name := range djiMakerNoteTags {
+		for _, c := range djiMakerNoteCategories {
+			if !in(matrix[c].MakerNote, "DJI "+name) {
+				t.Errorf("maker note DJI %s missing from %s", name, c)
+			}
+		}
+	}
+	for _, item := range heifMetadataItems {
+		listed := false
+		for _, m := range matrix {
+			listed = listed || in(m.BMFF, strings.TrimSpace(item.Type+" "+item.Content))
+		}
+		if !listed {
+			t.Errorf("HEIF %s item in no category", item.Type)
+		}
+	}
+}
+
+// TestCategoryMatrixCoversRemovals runs each category over files holding
+// every carrier and checks that whatever processing removes under a
+// category is in the matrix under it, so no removal path can reach past
+// the tables
+func TestCategoryMatrixCoversRemovals(t *testing.T) {
+	tiff := fixture.Sample().Bytes()
+	xmp := fixture.XMP(`xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/" xmlns:exif="http://ns.adobe.com/exif/1.0/" xmlns:xmp="http://ns.adobe.com/xap/1.0/"`+
+		` photoshop:City="Shelbyville" exif:DateTimeOriginal="2023-06-14T18:42:07" xmp:CreatorTool="Editor"`, "")
+	iptc := fixture.Photoshop(fixture.Resource(0x0404, append(fixture.Dataset(2, 90, "Shelbyville"), fixture.Dataset(2, 120, "Caption")...)))
+	var keys [][]byte
+	for _, k := range pngTextKeys {
+		keys = append(keys, fixture.Chunk("tEXt", []byte(k.Keyword+"\x00value")))
+	}
+	inputs := map[string][]byte{
+		"jpeg": fixture.WithSegment(fixture.WithSegment(fixture.EXIFJPEG(tiff), 0xE1, xmp), 0xED, iptc),
+		"png":  fixture.WithChunks(fixture.PNG(4, 4), append(keys, fixture.Chunk("eXIf", tiff))...),
+		"heic": fixture.HEICItems(false, fixture.HEICItem{Type: "mime", Content: "application/rdf+xml", Data: xmp[len(xmpSegmentPrefix):]}),
+	}
+
+	matrix := CategoryMatrix()
+	listed := func(item RemovedItem, c Category) bool {
+		m := matrix[c]
+		switch item.Carrier {
+		case CarrierEXIF:
+			for _, id := range append(append([]uint16(nil), m.EXIF...), m.GPS...) {
+				if id == item.Tag {
+					return true
+				}
+			}
+		case CarrierXMP:
+			for _, name := range m.XMP {
+				if name == item.Name || strings.HasSuffix(name, ":") && strings.HasPrefix(item.Name, name) {
+					return true
+				}
+			}
+		case CarrierIPTC:
+			for _, name := range m.IPTC {
+				if name == item.Name {
+					return true
+				}
+			}
+		case CarrierText:
+			_, keyword, _ := strings.Cut(item.Name, " ")
+			for _, k := range m.Text {
+				if k == keyword {
+					return true
+				}
+			}
+		case CarrierMakerNote:
+			for _, name := range m.MakerNote {
+				if name == item.Name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	carriers := map[Carrier]bool{}
+	for c := CategoryCameraInfo; c <= lastCategory; c++ {
+		for name, input := range inputs {
+			_, report := sanitize(t, input, categoryConfig(c))
+			for _, item := range report.Removed {
+				carriers[item.Carrier] = true
+				if !hasCategory(item.Categories, c) {
+					t.Errorf("%s %s: %s removed without being in the category", c, name, item)
+					continue
+				}
+				if !listed(item, c) {
+					t.Errorf("%s %s: %s removed but not in the matrix", c, name, item)
+				}
+			}
+		}
+	}
+	for _, c := range []Carrier{CarrierEXIF, CarrierXMP, CarrierIPTC, CarrierText} {
+		if !carriers[c] {
+			t.Errorf("nothing removed from %s; the check is vacuous", c)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package exifremover
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// TestFIFOInput checks that a named pipe is refused with ErrNotRegularFile
+// before anything opens it, and read as a stream under AllowFIFO
+func TestFIFOInput(t *testing.T) {
+	dir := t.TempDir()
+	fifo := filepath.Join(dir, "in.jpg")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	out := filepath.Join(dir, "out.jpg")
+
+	// Opening the pipe would block without a writer, so returning at all
+	// shows it was refused from Lstat alone
+	if err := RemoveEXIFSelective(fifo, out, Config{RemoveGPSInfo: true}); !errors.Is(err, ErrNotRegularFile) {
+		t.Fatalf("err = %v, want ErrNotRegularFile", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Error("output created for a refused input")
+	}
+
+	in := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	written := make(chan error, 1)
+	go func() {
+		f, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err != nil {
+			written <- err
+			return
+		}
+		_, err = f.Write(in)
+		f.Close()
+		written <- err
+	}()
+	if err := RemoveEXIFSelective(fifo, out, Config{RemoveGPSInfo: true, AllowFIFO: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertAbsent(t, data, "NETWORK-Somewhere")
+
+	// A batch leaves the pipe out rather than blocking on it
+	results := runBatch(t, dir, t.TempDir(), Config{RemoveGPSInfo: true}, BatchOptions{})
+	if r, ok := results["in.jpg"]; ok {
+		t.Errorf("batch listed the FIFO: %+v", r)
+	}
+	if _, ok := results["out.jpg"]; !ok {
+		t.Error("batch missed the regular file beside the FIFO")
+	}
+}
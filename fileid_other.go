@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package exifremover
+
+import "io/fs"
+
+// fileID reports no identity on platforms without inode numbers, where
+// the batch walker doesn't follow symbolic links to directories
+func fileID(info fs.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
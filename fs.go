@@ -0,0 +1,62 @@
+package exifremover
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// fileSystem is the set of path-based operations the package performs.
+// Every path-based entry point goes through fsys so filesystem edge cases
+// can be exercised against a fault-injecting implementation.
+type fileSystem interface {
+	Open(name string) (file, error)
+	Create(name string) (file, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Chmod(name string, mode fs.FileMode) error
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	Readlink(name string) (string, error)
+	TempFile(dir, pattern string) (file, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	MkdirAll(path string, perm fs.FileMode) error
+}
+
+// file is the subset of *os.File the package uses
+type file interface {
+	io.ReadWriteSeeker
+	io.Closer
+	Name() string
+	Stat() (fs.FileInfo, error)
+	Sync() error
+}
+
+// fsys is the filesystem used by the path-based API
+var fsys fileSystem = osFS{}
+
+// osFS is the fileSystem backed by package os
+type osFS struct{}
+
+func (osFS) Open(name string) (file, error)   { return os.Open(name) }
+func (osFS) Create(name string) (file, error) { return os.Create(name) }
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+func (osFS) Remove(name string) error { return os.Remove(name) }
+func (osFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+func (osFS) Chmod(name string, mode fs.FileMode) error { return os.Chmod(name, mode) }
+func (osFS) Stat(name string) (fs.FileInfo, error)     { return os.Stat(name) }
+func (osFS) Lstat(name string) (fs.FileInfo, error)    { return os.Lstat(name) }
+func (osFS) Readlink(name string) (string, error)      { return os.Readlink(name) }
+func (osFS) TempFile(dir, pattern string) (file, error) {
+	return os.CreateTemp(dir, pattern)
+}
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (osFS) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
@@ -0,0 +1,579 @@
+package exifremover
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// memFS is an in-memory fileSystem with injectable faults. Paths are
+// slash-separated and cleaned; "/" always exists.
+type memFS struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode
+	temps int
+	// Capacity, when set, fails writes that would make the file data
+	// exceed it with ENOSPC
+	Capacity int64
+	// Fault, when set, is called before every operation with its name
+	// ("open", "create", "rename", ...) and path, and a non-nil result
+	// fails the operation
+	Fault func(op, name string) error
+}
+
+type memNode struct {
+	data  []byte
+	mode  fs.FileMode
+	mtime time.Time
+}
+
+func newMemFS() *memFS {
+	return &memFS{nodes: map[string]*memNode{"/": {mode: fs.ModeDir | 0o755}}}
+}
+
+// WriteFile stores data at name, creating its directories
+func (m *memFS) WriteFile(name string, data []byte, perm fs.FileMode) {
+	m.MkdirAll(path.Dir(name), 0o755)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodes[path.Clean(name)] = &memNode{data: bytes.Clone(data), mode: perm, mtime: time.Now()}
+}
+
+// ReadFile returns the contents of name, or nil if there is no such file
+func (m *memFS) ReadFile(name string) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n := m.nodes[path.Clean(name)]; n != nil {
+		return bytes.Clone(n.data)
+	}
+	return nil
+}
+
+// Names lists every path, directories included, in order
+func (m *memFS) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name := range m.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *memFS) fault(op, name string) error {
+	if m.Fault == nil {
+		return nil
+	}
+	if err := m.Fault(op, name); err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+// used is the total size of the file data; m.mu must be held
+func (m *memFS) used() int64 {
+	var n int64
+	for _, node := range m.nodes {
+		n += int64(len(node.data))
+	}
+	return n
+}
+
+func (m *memFS) lookup(op, name string) (*memNode, error) {
+	if err := m.fault(op, name); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	node := m.nodes[path.Clean(name)]
+	if node == nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return node, nil
+}
+
+func (m *memFS) Open(name string) (file, error) {
+	node, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &memFile{fs: m, node: node, name: name, readOnly: true}, nil
+}
+
+func (m *memFS) Create(name string) (file, error) {
+	if err := m.fault("create", name); err != nil {
+		return nil, err
+	}
+	return m.create(name, 0o666)
+}
+
+func (m *memFS) create(name string, perm fs.FileMode) (file, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = path.Clean(name)
+	if dir := m.nodes[path.Dir(name)]; dir == nil || !dir.mode.IsDir() {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrNotExist}
+	}
+	node := m.nodes[name]
+	if node == nil {
+		node = &memNode{mode: perm}
+		m.nodes[name] = node
+	}
+	if node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: syscall.EISDIR}
+	}
+	node.data, node.mtime = nil, time.Now()
+	return &memFile{fs: m, node: node, name: name}, nil
+}
+
+func (m *memFS) Rename(oldpath, newpath string) error {
+	if err := m.fault("rename", oldpath); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errors.Unwrap(err)}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldpath, newpath = path.Clean(oldpath), path.Clean(newpath)
+	node := m.nodes[oldpath]
+	if node == nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+	delete(m.nodes, oldpath)
+	m.nodes[newpath] = node
+	return nil
+}
+
+func (m *memFS) Remove(name string) error {
+	if _, err := m.lookup("remove", name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.nodes, path.Clean(name))
+	return nil
+}
+
+func (m *memFS) Chtimes(name string, atime, mtime time.Time) error {
+	node, err := m.lookup("chtimes", name)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	node.mtime = mtime
+	return nil
+}
+
+func (m *memFS) Chmod(name string, mode fs.FileMode) error {
+	node, err := m.lookup("chmod", name)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	node.mode = node.mode&fs.ModeType | mode.Perm()
+	return nil
+}
+
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	node, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return memInfo{name: path.Base(name), size: int64(len(node.data)), mode: node.mode, mtime: node.mtime}, nil
+}
+
+// Lstat is Stat, since memFS has no symbolic links
+func (m *memFS) Lstat(name string) (fs.FileInfo, error) { return m.Stat(name) }
+
+func (m *memFS) Readlink(name string) (string, error) {
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+}
+
+func (m *memFS) TempFile(dir, pattern string) (file, error) {
+	m.mu.Lock()
+	m.temps++
+	n := m.temps
+	m.mu.Unlock()
+	name := path.Join(dir, strings.Replace(pattern, "*", strconv.Itoa(n), 1))
+	if err := m.fault("tempfile", name); err != nil {
+		return nil, err
+	}
+	return m.create(name, 0o600)
+}
+
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if _, err := m.lookup("readdir", name); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir := path.Clean(name)
+	var entries []fs.DirEntry
+	for p, node := range m.nodes {
+		if p != dir && path.Dir(p) == dir {
+			entries = append(entries, fs.FileInfoToDirEntry(memInfo{name: path.Base(p), size: int64(len(node.data)), mode: node.mode, mtime: node.mtime}))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *memFS) MkdirAll(name string, perm fs.FileMode) error {
+	if err := m.fault("mkdir", name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for p := path.Clean(name); p != "/" && p != "."; p = path.Dir(p) {
+		if node := m.nodes[p]; node != nil {
+			if !node.mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: p, Err: syscall.ENOTDIR}
+			}
+			continue
+		}
+		m.nodes[p] = &memNode{mode: fs.ModeDir | perm}
+	}
+	return nil
+}
+
+// memFile is an open memFS file
+type memFile struct {
+	fs       *memFS
+	node     *memNode
+	name     string
+	off      int64
+	readOnly bool
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.off:])
+	f.off += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.readOnly {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
+	}
+	if err := f.fs.fault("write", f.name); err != nil {
+		return 0, err
+	}
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	grow := f.off + int64(len(p)) - int64(len(f.node.data))
+	if f.fs.Capacity > 0 && grow > 0 && f.fs.used()+grow > f.fs.Capacity {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: syscall.ENOSPC}
+	}
+	for int64(len(f.node.data)) < f.off {
+		f.node.data = append(f.node.data, 0)
+	}
+	n := copy(f.node.data[f.off:], p)
+	f.node.data = append(f.node.data, p[n:]...)
+	f.off += int64(len(p))
+	f.node.mtime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error { return f.fs.fault("close", f.name) }
+func (f *memFile) Name() string { return f.name }
+func (f *memFile) Sync() error  { return f.fs.fault("sync", f.name) }
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return memInfo{name: path.Base(f.name), size: int64(len(f.node.data)), mode: f.node.mode, mtime: f.node.mtime}, nil
+}
+
+// memInfo is the fs.FileInfo of a memFS node
+type memInfo struct {
+	name  string
+	size  int64
+	mode  fs.FileMode
+	mtime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return i.mtime }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
+
+// memPhoto is the file the memFS tests sanitize in place
+const memPhoto = "/photos/a.jpg"
+
+// memFSWithPhoto returns a memFS holding a JPEG with metadata at memPhoto,
+// installed as fsys for the rest of the test
+func memFSWithPhoto(t *testing.T) (*memFS, []byte) {
+	m := newMemFS()
+	in := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	m.WriteFile(memPhoto, in, 0o640)
+	useFS(t, m)
+	return m, in
+}
+
+// assertOnlyPhoto fails the test if memPhoto doesn't hold want or a
+// temporary file was left next to it
+func assertOnlyPhoto(t *testing.T, m *memFS, want []byte) {
+	t.Helper()
+	if got := m.ReadFile(memPhoto); !bytes.Equal(got, want) {
+		t.Errorf("%s holds %d bytes, want the %d expected", memPhoto, len(got), len(want))
+	}
+	if names := m.Names(); len(names) != 3 {
+		t.Errorf("files left behind: %v", names)
+	}
+}
+
+func TestInPlaceAtomicWrite(t *testing.T) {
+	m, in := memFSWithPhoto(t)
+	mtime := time.Date(2023, 6, 14, 18, 42, 7, 0, time.UTC)
+	m.Chtimes(memPhoto, mtime, mtime)
+
+	config := Config{RemoveGPSInfo: true, PreserveModTime: true}
+	if err := RemoveEXIFInPlace(memPhoto, config); err != nil {
+		t.Fatal(err)
+	}
+	want, _, err := RemoveEXIFFromBytesReport(in, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOnlyPhoto(t, m, want)
+	info, err := m.Stat(memPhoto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("mode %v, want the original 0640", info.Mode())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("mtime %v, want the original %v", info.ModTime(), mtime)
+	}
+}
+
+func TestInPlaceFailuresKeepOriginal(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		fault func(op, name string) error
+		want  error
+	}{
+		{"rename", func(op, _ string) error {
+			if op == "rename" {
+				return syscall.EIO
+			}
+			return nil
+		}, syscall.EIO},
+		{"chmod", func(op, _ string) error {
+			if op == "chmod" {
+				return syscall.EPERM
+			}
+			return nil
+		}, syscall.EPERM},
+		{"chtimes", func(op, _ string) error {
+			if op == "chtimes" {
+				return syscall.EPERM
+			}
+			return nil
+		}, syscall.EPERM},
+		{"sync", func(op, name string) error {
+			if op == "sync" && name != memPhoto {
+				return syscall.EIO
+			}
+			return nil
+		}, syscall.EIO},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			m, in := memFSWithPhoto(t)
+			m.Fault = c.fault
+			err := RemoveEXIFInPlace(memPhoto, Config{RemoveGPSInfo: true, PreserveModTime: true})
+			if !errors.Is(err, c.want) {
+				t.Fatalf("err %v, want %v", err, c.want)
+			}
+			m.Fault = nil
+			assertOnlyPhoto(t, m, in)
+		})
+	}
+}
+
+func TestInPlaceNoSpace(t *testing.T) {
+	m, in := memFSWithPhoto(t)
+	// Room for the original and half a copy
+	m.Capacity = int64(len(in)) * 3 / 2
+	err := RemoveEXIFInPlace(memPhoto, Config{RemoveGPSInfo: true})
+	if !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("err %v, want ENOSPC", err)
+	}
+	assertOnlyPhoto(t, m, in)
+}
+
+func TestRemoveFileNoSpaceRemovesOutput(t *testing.T) {
+	m, in := memFSWithPhoto(t)
+	m.Capacity = int64(len(in)) + 100
+	err := RemoveEXIFSelective(memPhoto, "/photos/out.jpg", Config{RemoveGPSInfo: true})
+	if !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("err %v, want ENOSPC", err)
+	}
+	assertOnlyPhoto(t, m, in)
+}
+
+// readOnlyDir fails temporary files in /photos with EROFS, the first
+// failures of them when fails is positive, and renames out of os.TempDir
+// with EXDEV, as if it were on another device
+func readOnlyDir(fails int) func(op, name string) error {
+	return func(op, name string) error {
+		switch {
+		case op == "tempfile" && path.Dir(name) == "/photos" && fails != 0:
+			fails--
+			return syscall.EROFS
+		case op == "rename" && path.Dir(name) == path.Clean(os.TempDir()):
+			return syscall.EXDEV
+		}
+		return nil
+	}
+}
+
+func TestInPlaceReadOnlyDirectory(t *testing.T) {
+	m, in := memFSWithPhoto(t)
+	m.MkdirAll(os.TempDir(), 0o755)
+	m.Fault = readOnlyDir(-1)
+	err := RemoveEXIFInPlace(memPhoto, Config{RemoveGPSInfo: true})
+	var atomicErr *AtomicWriteError
+	if !errors.Is(err, ErrCannotWriteAtomically) || !errors.Is(err, syscall.EROFS) || !errors.As(err, &atomicErr) || atomicErr.Path != memPhoto {
+		t.Fatalf("err %v, want an AtomicWriteError for EROFS", err)
+	}
+	m.Fault = nil
+	if got := m.ReadFile(memPhoto); !bytes.Equal(got, in) {
+		t.Error("original changed")
+	}
+	for _, name := range m.Names() {
+		if strings.Contains(name, ".a.jpg.") {
+			t.Errorf("temporary file %s left behind", name)
+		}
+	}
+
+	// The caller can then ask for the write explicitly
+	config := Config{RemoveGPSInfo: true, InPlaceNonAtomic: true}
+	m.Fault = readOnlyDir(-1)
+	if err := RemoveEXIFInPlace(memPhoto, config); err != nil {
+		t.Fatal(err)
+	}
+	want, _, _ := RemoveEXIFFromBytesReport(in, config)
+	if got := m.ReadFile(memPhoto); !bytes.Equal(got, want) {
+		t.Error("non-atomic write didn't sanitize the file")
+	}
+}
+
+func TestInPlaceCrossDeviceStaging(t *testing.T) {
+	m, in := memFSWithPhoto(t)
+	m.MkdirAll(os.TempDir(), 0o755)
+	// The directory refuses the first temporary file only, so the output
+	// is staged in os.TempDir, can't be renamed from there, and is copied
+	// into a second temporary file beside the photo
+	m.Fault = readOnlyDir(1)
+	config := Config{RemoveGPSInfo: true}
+	if err := RemoveEXIFInPlace(memPhoto, config); err != nil {
+		t.Fatal(err)
+	}
+	m.Fault = nil
+	want, _, _ := RemoveEXIFFromBytesReport(in, config)
+	if got := m.ReadFile(memPhoto); !bytes.Equal(got, want) {
+		t.Error("staged output not moved into place")
+	}
+	if names := m.Names(); len(names) != 3+len(strings.Split(strings.Trim(path.Clean(os.TempDir()), "/"), "/")) {
+		t.Errorf("files left behind: %v", names)
+	}
+}
+
+// TestInPlaceStagedRenameFails checks that a staged output whose rename
+// fails for any reason, not only across devices, is reported as a write
+// that couldn't be made atomic
+func TestInPlaceStagedRenameFails(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		fails int // temporary files refused in /photos
+		err   error
+		from  string // directory of the failing rename
+	}{
+		{"EROFS from os.TempDir", -1, syscall.EROFS, os.TempDir()},
+		{"EACCES from os.TempDir", -1, syscall.EACCES, os.TempDir()},
+		{"EACCES after relocating", 1, syscall.EACCES, "/photos"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			m, in := memFSWithPhoto(t)
+			m.MkdirAll(os.TempDir(), 0o755)
+			refuse := readOnlyDir(c.fails)
+			m.Fault = func(op, name string) error {
+				if op == "rename" && path.Dir(name) == path.Clean(c.from) {
+					return c.err
+				}
+				return refuse(op, name)
+			}
+			err := RemoveEXIFInPlace(memPhoto, Config{RemoveGPSInfo: true})
+			var atomicErr *AtomicWriteError
+			if !errors.Is(err, ErrCannotWriteAtomically) || !errors.Is(err, c.err) || !errors.As(err, &atomicErr) || atomicErr.Path != memPhoto {
+				t.Fatalf("err %v, want an AtomicWriteError for %v", err, c.err)
+			}
+			m.Fault = nil
+			if got := m.ReadFile(memPhoto); !bytes.Equal(got, in) {
+				t.Error("original changed")
+			}
+			for _, name := range m.Names() {
+				if strings.Contains(name, ".a.jpg.") {
+					t.Errorf("temporary file %s left behind", name)
+				}
+			}
+		})
+	}
+}
+
+func TestInPlaceCrossDeviceRename(t *testing.T) {
+	m, in := memFSWithPhoto(t)
+	// A bind-mounted file can't be renamed onto from its directory at all
+	m.Fault = func(op, _ string) error {
+		if op == "rename" {
+			return syscall.EXDEV
+		}
+		return nil
+	}
+	err := RemoveEXIFInPlace(memPhoto, Config{RemoveGPSInfo: true})
+	if !errors.Is(err, ErrCannotWriteAtomically) || !errors.Is(err, syscall.EXDEV) {
+		t.Fatalf("err %v, want an AtomicWriteError for EXDEV", err)
+	}
+	m.Fault = nil
+	assertOnlyPhoto(t, m, in)
+}
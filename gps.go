@@ -0,0 +1,190 @@
+package exifremover
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// GPSAction is what removing the GPS IFD does with the position in it
+type GPSAction int
+
+const (
+	// GPSRemove overwrites every GPS IFD entry and unlinks the IFD
+	GPSRemove GPSAction = iota
+	// GPSTruncate rounds the latitude and longitude to
+	// Config.GPSPrecision decimal places of a degree
+	GPSTruncate
+	// GPSReplace writes Config.GPSReplaceLatitude and
+	// Config.GPSReplaceLongitude over the latitude and longitude
+	GPSReplace
+
+	lastGPSAction = GPSReplace
+)
+
+// String returns a lower-case name for the action
+func (a GPSAction) String() string {
+	switch a {
+	case GPSRemove:
+		return "remove"
+	case GPSTruncate:
+		return "truncate"
+	case GPSReplace:
+		return "replace"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the action by name
+func (a GPSAction) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText decodes an action name as returned by String
+func (a *GPSAction) UnmarshalText(text []byte) error {
+	for action := GPSRemove; action <= lastGPSAction; action++ {
+		if action.String() == string(text) {
+			*a = action
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown GPS action %q", text)
+}
+
+// maxGPSPrecision is the most decimal places GPSTruncate keeps: 180
+// degrees at 10^7 still fits a RATIONAL's numerator, and a degree/10^7 is
+// about a centimetre
+const maxGPSPrecision = 7
+
+// validateGPS checks the GPSTruncate precision and GPSReplace position
+func validateGPS(config Config) error {
+	switch {
+	case config.GPSAction < GPSRemove || config.GPSAction > lastGPSAction:
+		return fmt.Errorf("unknown GPS action %d", int(config.GPSAction))
+	case config.GPSPrecision < 0 || config.GPSPrecision > maxGPSPrecision:
+		return fmt.Errorf("GPSPrecision %d outside 0 to %d", config.GPSPrecision, maxGPSPrecision)
+	case config.GPSAction == GPSReplace && !(math.Abs(config.GPSReplaceLatitude) <= 90 && math.Abs(config.GPSReplaceLongitude) <= 180):
+		return fmt.Errorf("GPS replacement %v, %v outside the valid range", config.GPSReplaceLatitude, config.GPSReplaceLongitude)
+	}
+	return nil
+}
+
+// redactedGPSTags are the GPS IFD entries GPSTruncate and GPSReplace keep:
+// the version, the datum and the position itself. Altitude, timestamps,
+// speed, heading and the rest are removed.
+var redactedGPSTags = map[uint16]bool{0x0000: true, 0x0001: true, 0x0002: true, 0x0003: true, 0x0004: true, 0x0012: true}
+
+// redactGPS rewrites the latitude and longitude of the GPS IFD that the
+// entry at pos points to under GPSTruncate or GPSReplace, and removes the
+// entries redactedGPSTags doesn't list. It reports false, having changed
+// nothing, when the position can't be rewritten safely: either coordinate
+// or, for GPSReplace, either reference is missing or malformed, a
+// denominator is zero, or the two values overlap each other or the IFD.
+// The caller then removes the IFD as GPSRemove would.
+func redactGPS(tiff []byte, pos int, order binary.ByteOrder, config Config, report *Report) bool {
+	offset, ok := ifdPointer(tiff, pos, order)
+	if !ok {
+		return false
+	}
+	numEntries := int(order.Uint16(tiff[offset : offset+2]))
+	end := int64(offset) + 2 + 12*int64(numEntries) + 4
+	if end > int64(len(tiff)) {
+		return false
+	}
+
+	var entries [5]int // positions of tags 1 to 4, 0 when absent
+	for i, p := 0, offset+2; i < numEntries; i, p = i+1, p+12 {
+		if tag := order.Uint16(tiff[p : p+2]); tag >= 1 && tag <= 4 && entries[tag] == 0 {
+			entries[tag] = p
+		}
+	}
+
+	var values [5]int64 // offsets of the latitude and longitude values
+	var position [5]float64
+	for _, tag := range []int{2, 4} {
+		p := entries[tag]
+		if p == 0 || order.Uint16(tiff[p+2:p+4]) != 5 || order.Uint32(tiff[p+4:p+8]) != 3 {
+			return false
+		}
+		values[tag] = int64(order.Uint32(tiff[p+8 : p+12]))
+		if values[tag] < 8 || values[tag]+24 > int64(len(tiff)) ||
+			values[tag] < end && int64(offset) < values[tag]+24 {
+			return false
+		}
+		if position[tag], ok = degrees(tiff, p, order); !ok || position[tag] > 180 {
+			return false
+		}
+	}
+	if values[2] < values[4]+24 && values[4] < values[2]+24 {
+		return false
+	}
+	// Truncating keeps the references as they are; readers assume north
+	// and east for missing ones, as they did before
+	for _, tag := range []int{1, 3} {
+		if config.GPSAction != GPSReplace {
+			break
+		}
+		p := entries[tag]
+		if p == 0 || order.Uint16(tiff[p+2:p+4]) != 2 || order.Uint32(tiff[p+4:p+8]) == 0 || order.Uint32(tiff[p+4:p+8]) > 4 {
+			return false
+		}
+	}
+
+	// Other entries go first, so wiping a value that overlaps the position
+	// can't undo the rewrite
+	for i, p := 0, offset+2; i < numEntries; i, p = i+1, p+12 {
+		tag := order.Uint16(tiff[p : p+2])
+		if (!redactedGPSTags[tag] || customTag(tag, config)) && !isEmptyEntry(tiff, p, order) {
+			report.removeEntry(gpsTag(tag), tiff, p, order)
+		}
+	}
+
+	places := config.GPSPrecision
+	if config.GPSAction == GPSReplace {
+		places = maxGPSPrecision
+		position[2], position[4] = config.GPSReplaceLatitude, config.GPSReplaceLongitude
+		writeGPSRef(tiff, entries[1], hemisphere(position[2], 'N', 'S'), order, report)
+		writeGPSRef(tiff, entries[3], hemisphere(position[4], 'E', 'W'), order, report)
+	}
+	for _, tag := range []int{2, 4} {
+		writeDegrees(tiff, entries[tag], values[tag], math.Abs(position[tag]), places, order, report)
+	}
+	return true
+}
+
+// hemisphere returns the GPS reference letter for a signed coordinate
+func hemisphere(v float64, positive, negative byte) byte {
+	if v < 0 {
+		return negative
+	}
+	return positive
+}
+
+// writeGPSRef stores a one-letter reference in the inline ASCII value of
+// the entry at pos, recording the change if the letter was different
+func writeGPSRef(tiff []byte, pos int, ref byte, order binary.ByteOrder, report *Report) {
+	value := tiff[pos+8 : pos+12]
+	if value[0] == ref && value[1] == 0 {
+		return
+	}
+	value[0], value[1], value[2], value[3] = ref, 0, 0, 0
+	report.removeTag(gpsTag(order.Uint16(tiff[pos:pos+2])), RemovalOverwritten, 2)
+}
+
+// writeDegrees stores v as decimal degrees rounded to places, with zero
+// minutes and seconds, over the three RATIONALs at value, recording the
+// change if the bytes were different. Writing the same position twice
+// gives the same bytes, so a redacted file verifies clean.
+func writeDegrees(tiff []byte, pos int, value int64, v float64, places int, order binary.ByteOrder, report *Report) {
+	scale := math.Pow(10, float64(places))
+	var out [24]byte
+	order.PutUint32(out[0:], uint32(math.Round(v*scale)))
+	order.PutUint32(out[4:], uint32(scale))
+	order.PutUint32(out[12:], 1)
+	order.PutUint32(out[20:], 1)
+	if string(tiff[value:value+24]) == string(out[:]) {
+		return
+	}
+	copy(tiff[value:], out[:])
+	report.removeTag(gpsTag(order.Uint16(tiff[pos:pos+2])), RemovalOverwritten, 24)
+}
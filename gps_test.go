@@ -0,0 +1,89 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// characterCode returns an UNDEFINED value in the UserComment scheme: an
+// 8-byte character code, then s in ASCII or, for "UNICODE", UTF-16 in
+// order
+func characterCode(code string, s string, order binary.ByteOrder) []byte {
+	value := append([]byte(code), make([]byte, 8-len(code))...)
+	if code != "UNICODE" {
+		return append(value, s...)
+	}
+	for _, u := range utf16.Encode([]rune(s)) {
+		value = append(value, 0, 0)
+		order.PutUint16(value[len(value)-2:], u)
+	}
+	return value
+}
+
+// androidGPSTIFF returns EXIF as Android camera apps write it, with
+// GPSProcessingMethod naming the location provider and
+// GPSAreaInformation holding the locality
+func androidGPSTIFF(order binary.ByteOrder, code string) (tiff []byte, method, area []byte) {
+	method = characterCode(code, "fused", order)
+	area = characterCode(code, "Mountain View, CA", order)
+	t := fixture.TIFF{
+		Order: order,
+		IFD0:  []fixture.Entry{fixture.ASCII(0x010f, "Google")},
+		GPS: []fixture.Entry{
+			fixture.ASCII(0x0001, "N"),
+			fixture.Rational(order, 0x0002, 37, 1, 25, 1, 1944, 100),
+			fixture.ASCII(0x0003, "W"),
+			fixture.Rational(order, 0x0004, 122, 1, 5, 1, 1236, 100),
+			fixture.Undefined(0x001b, method),
+			fixture.Undefined(0x001c, area),
+		},
+	}
+	return t.Bytes(), method, area
+}
+
+// TestGPSFreeTextTags checks that RemoveGPSInfo overwrites the whole
+// payload of GPSProcessingMethod and GPSAreaInformation, character code
+// included, in both encodings and byte orders, and reports each
+func TestGPSFreeTextTags(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, code := range []string{"ASCII", "UNICODE"} {
+			t.Run(order.String()+" "+code, func(t *testing.T) {
+				tiff, method, area := androidGPSTIFF(order, code)
+				in := fixture.EXIFJPEG(tiff)
+				m := inspect(t, in)
+				if !m.hasTag("GPS", 0x001b) || !m.hasTag("GPS", 0x001c) {
+					t.Fatal("fixture lacks the free-text GPS tags")
+				}
+
+				out, report := sanitize(t, in, Config{RemoveGPSInfo: true})
+				for _, v := range [][]byte{method, area, method[8:], area[8:]} {
+					if bytes.Contains(out, v) {
+						t.Errorf("output still holds %q", v)
+					}
+				}
+				if !bytes.Contains(out, []byte("Google")) {
+					t.Error("Make was removed")
+				}
+				sizes := map[string]int64{}
+				for _, item := range report.Removed {
+					if item.Strength == RemovalOverwritten {
+						sizes[item.Name] = item.Size
+					}
+				}
+				if sizes["GPSProcessingMethod"] != int64(len(method)) || sizes["GPSAreaInformation"] != int64(len(area)) {
+					t.Errorf("overwritten sizes = %v, want %d and %d", sizes, len(method), len(area))
+				}
+
+				// Coarsening the position still drops the free text
+				out, _ = sanitize(t, in, Config{RemoveGPSInfo: true, GPSAction: GPSTruncate, GPSPrecision: 1})
+				if bytes.Contains(out, area[8:]) || bytes.Contains(out, method[8:]) {
+					t.Error("GPSTruncate kept the free-text tags")
+				}
+			})
+		}
+	}
+}
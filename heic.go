@@ -0,0 +1,527 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// heifBrands are the ftyp major brands of HEIF still images
+var heifBrands = map[string]bool{
+	"heic": true, "heix": true, "heim": true, "heis": true, "mif1": true,
+}
+
+// heifItem is an item of a HEIF meta box whose data is edited in place
+type heifItem struct {
+	ID      uint32
+	Type    string // item_type, e.g. "Exif" or "mime"
+	Content string // content_type of "mime" items
+	extents [][2]int64
+	flags   int // offset of the last infe flags byte, holding the hidden bit
+}
+
+// processHEIC handles HEIF images by editing the Exif and XMP items in
+// place. Removal never changes an item's length, so the iloc offsets stay
+// valid and every box is copied through untouched; RemoveAll replaces the
+// Exif item's TIFF structure with an empty one padded to the same size.
+func processHEIC(r io.Reader, w io.Writer, config Config, report *Report) error {
+	data, err := readWhole(r, config)
+	if err != nil {
+		return err
+	}
+	data = append([]byte(nil), data...)
+
+	items, err := heifItems(data)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if len(item.extents) == 0 {
+			continue // stored by reference to another item
+		}
+		if size := item.size(); size > int64(config.maxMetadataSize()) {
+			return fmt.Errorf("%w: %d-byte %s item", ErrMetadataTooLarge, size, item.Type)
+		}
+		payload := item.read(data)
+		switch {
+		case item.Type == "Exif":
+			if payload, err = modifyHEICExif(payload, config, report); err != nil {
+				return err
+			}
+		case item.Type == "mime" && item.Content == "application/rdf+xml":
+			modifyXMP(payload, config, report)
+		default:
+			continue
+		}
+		item.write(data, payload)
+	}
+	if config.RemoveAuxiliaryImages {
+		if err := removeHEICAuxiliary(data, config, report); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// modifyHEICExif applies config to the payload of an Exif item, which
+// starts with a 4-byte offset to the TIFF header. Apple writes an offset of
+// 6 followed by the JPEG-style "Exif\0\0" prefix. The result has the same
+// length as payload.
+func modifyHEICExif(payload []byte, config Config, report *Report) ([]byte, error) {
+	if len(payload) < 4 {
+		return nil, corrupt("truncated HEIC Exif item")
+	}
+	offset := int64(binary.BigEndian.Uint32(payload))
+	if offset > int64(len(payload)-4) {
+		return nil, corrupt("invalid HEIC Exif header offset")
+	}
+	start := 4 + int(offset)
+	if bytes.HasSuffix(payload[:start], exifPrefix) {
+		start -= len(exifPrefix) // keep the prefix with the TIFF structure
+	}
+	blob := payload[start:]
+
+	if config.RemoveAll {
+		return blankHEICExif(payload, start, config, report), nil
+	}
+
+	modified, err := modifyEXIFBlob(blob, config, report)
+	if err == errByteOrder && config.Permissive {
+		return blankHEICExif(payload, start, config, report), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte(nil), payload[:start]...)
+	return append(out, modified...), nil
+}
+
+// blankHEICExif overwrites the TIFF structure starting at offset start of
+// an Exif item payload with an empty one, keeping the orientation under
+// PreserveOrientation
+func blankHEICExif(payload []byte, start int, config Config, report *Report) []byte {
+	blob := payload[start:]
+	report.remove(RemovedItem{Carrier: CarrierEXIF, Name: "Exif item", Strength: RemovalOverwritten, Size: int64(len(blob))})
+	kept := orientationEXIF(blob)
+	if !config.PreserveOrientation || kept == nil {
+		kept = emptyTIFF(bytes.HasPrefix(blob, exifPrefix))
+	}
+	out := append([]byte(nil), payload[:start]...)
+	out = append(out, make([]byte, len(blob))...)
+	copy(out[start:], kept) // an item too small for it stays zeroed
+	return out
+}
+
+// emptyTIFF returns a TIFF structure with an empty IFD0, prefixed with
+// "Exif\0\0" when prefixed is set
+func emptyTIFF(prefixed bool) []byte {
+	var out []byte
+	if prefixed {
+		out = append(out, exifPrefix...)
+	}
+	return append(out, "II*\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00\x00"...)
+}
+
+// heifMeta holds the boxes of the file-level meta box that describe its
+// items, and the item properties in ipco order
+type heifMeta struct {
+	iinf, iloc, idat, iref, ipma *box
+	properties                   []box
+}
+
+// findHEIFMeta walks data for the boxes describing its items
+func findHEIFMeta(data []byte) (heifMeta, error) {
+	var m heifMeta
+	err := walkBoxes(data, func(b box) error {
+		switch b.Path {
+		case "meta/iinf":
+			m.iinf = &b
+		case "meta/iloc":
+			m.iloc = &b
+		case "meta/idat":
+			m.idat = &b
+		case "meta/iref":
+			m.iref = &b
+		case "meta/iprp/ipma":
+			m.ipma = &b
+		}
+		if strings.HasPrefix(b.Path, "meta/iprp/ipco/") && strings.Count(b.Path, "/") == 3 {
+			m.properties = append(m.properties, b)
+		}
+		return nil
+	})
+	return m, err
+}
+
+// items returns the items of m that keep, with their extents resolved to
+// offsets within data
+func (m heifMeta) items(data []byte, keep func(heifItem) bool) ([]heifItem, error) {
+	if m.iinf == nil || m.iloc == nil {
+		return nil, nil
+	}
+	infos, err := parseIinf(m.iinf.Data, m.iinf.Start+m.iinf.Header)
+	if err != nil {
+		return nil, err
+	}
+	var items []heifItem
+	for _, item := range infos {
+		if keep(item) {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	idatStart := int64(-1)
+	if m.idat != nil {
+		idatStart = int64(m.idat.Start + m.idat.Header)
+	}
+	locations, err := parseIloc(m.iloc.Data, idatStart)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].extents = locations[items[i].ID]
+		for _, e := range items[i].extents {
+			if e[0] < 0 || e[1] < 0 || e[0] > int64(len(data)) || e[1] > int64(len(data))-e[0] {
+				return nil, corrupt("HEIF item extent outside the file")
+			}
+		}
+	}
+	return items, nil
+}
+
+// heifItems returns the Exif and XMP items of the file-level meta box,
+// with their extents resolved to offsets within data
+func heifItems(data []byte) ([]heifItem, error) {
+	m, err := findHEIFMeta(data)
+	if err != nil {
+		return nil, err
+	}
+	return m.items(data, func(item heifItem) bool {
+		for _, meta := range heifMetadataItems {
+			if item.Type == meta.Type && item.Content == meta.Content {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// heifMetadataItems are the HEIF items processHEIC edits, by item type and
+// content type, with the carrier each holds; CategoryMatrix lists them
+// under every category their carrier covers
+var heifMetadataItems = []struct {
+	Type, Content string
+	Carrier       Carrier
+}{
+	{"Exif", "", CarrierEXIF},
+	{"mime", "application/rdf+xml", CarrierXMP},
+}
+
+// parseIinf reads the item_type of every infe entry of an iinf payload
+// found at offset base. Only version 2 and 3 entries, the ones HEIF
+// requires, carry a type.
+func parseIinf(data []byte, base int) ([]heifItem, error) {
+	if len(data) < 6 {
+		return nil, corrupt("truncated iinf box")
+	}
+	skip := 6
+	if data[0] != 0 {
+		if len(data) < 8 {
+			return nil, corrupt("truncated iinf box")
+		}
+		skip = 8
+	}
+
+	var items []heifItem
+	err := walkBoxLevel(data[skip:], base+skip, "meta/iinf", 1, func(b box) error {
+		if b.Type != "infe" || len(b.Data) < 4 || b.Data[0] < 2 {
+			return nil
+		}
+		item := heifItem{flags: b.Start + b.Header + 3}
+		p := b.Data[4:]
+		if b.Data[0] == 2 {
+			if len(p) < 8 {
+				return corrupt("truncated infe box")
+			}
+			item.ID, p = uint32(binary.BigEndian.Uint16(p)), p[2:]
+		} else {
+			if len(p) < 10 {
+				return corrupt("truncated infe box")
+			}
+			item.ID, p = binary.BigEndian.Uint32(p), p[4:]
+		}
+		item.Type, p = string(p[2:6]), p[6:] // after item_protection_index
+		if item.Type == "mime" {
+			if i := bytes.IndexByte(p, 0); i >= 0 { // item_name
+				p = p[i+1:]
+				if j := bytes.IndexByte(p, 0); j >= 0 {
+					item.Content = string(p[:j])
+				}
+			}
+		}
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// parseIloc returns the extents of every item of an iloc payload as
+// absolute offsets and lengths. Construction method 1 resolves against the
+// idat payload at idatStart; items using other methods are left out.
+func parseIloc(data []byte, idatStart int64) (map[uint32][][2]int64, error) {
+	r := ilocReader{data: data}
+	version := r.uint(1)
+	r.uint(3) // flags
+	sizes := r.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0xf)
+	sizes = r.uint(1)
+	baseOffsetSize, indexSize := int(sizes>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0xf)
+	}
+	var count uint64
+	if version < 2 {
+		count = r.uint(2)
+	} else {
+		count = r.uint(4)
+	}
+
+	locations := make(map[uint32][][2]int64)
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		var id uint32
+		if version < 2 {
+			id = uint32(r.uint(2))
+		} else {
+			id = uint32(r.uint(4))
+		}
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = r.uint(2) & 0xf
+		}
+		r.uint(2) // data_reference_index
+		base := int64(r.uint(baseOffsetSize))
+		extents := int(r.uint(2))
+		// Each extent takes entry bytes of the box, so the count must fit
+		// in what is left of it; with every size zero an entry reads
+		// nothing, and only a single extent is taken
+		entry := indexSize + offsetSize + lengthSize
+		if entry == 0 && extents > 1 || extents*entry > len(r.data) {
+			return nil, corrupt(fmt.Sprintf("iloc item %d claims %d extents past the end of the box", id, extents))
+		}
+		for j := 0; j < extents && r.err == nil; j++ {
+			r.uint(indexSize)
+			offset, length := int64(r.uint(offsetSize)), int64(r.uint(lengthSize))
+			if base+offset < base {
+				offset = -1 // overflow, refused with the other bad extents
+			} else {
+				offset += base
+			}
+			switch {
+			case method == 0:
+				locations[id] = append(locations[id], [2]int64{offset, length})
+			case method == 1 && idatStart >= 0:
+				locations[id] = append(locations[id], [2]int64{idatStart + offset, length})
+			}
+		}
+		if method > 1 {
+			delete(locations, id)
+		}
+	}
+	return locations, r.err
+}
+
+// ilocReader reads the variable-width big-endian fields of an iloc box
+type ilocReader struct {
+	data []byte
+	err  error
+}
+
+// uint reads an n-byte field, recording an error past the end of the data
+func (r *ilocReader) uint(n int) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if n > len(r.data) {
+		r.err = corrupt("truncated iloc box")
+		return 0
+	}
+	var v uint64
+	for _, b := range r.data[:n] {
+		v = v<<8 | uint64(b)
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// size returns the total length of the item's extents
+func (item heifItem) size() int64 {
+	var n int64
+	for _, e := range item.extents {
+		n += e[1]
+	}
+	return n
+}
+
+// read returns a copy of the item's data, its extents concatenated
+func (item heifItem) read(data []byte) []byte {
+	var out []byte
+	for _, e := range item.extents {
+		out = append(out, data[e[0]:e[0]+e[1]]...)
+	}
+	return out
+}
+
+// write stores payload, as returned by read and of the same length, back
+// over the item's extents
+func (item heifItem) write(data, payload []byte) {
+	for _, e := range item.extents {
+		payload = payload[copy(data[e[0]:e[0]+e[1]], payload):]
+	}
+}
+
+// heifReference is one reference of an iref box: from an item to others
+type heifReference struct {
+	Type string // e.g. "auxl", "dimg" or "thmb"
+	From uint32
+	To   []uint32
+}
+
+// parseIref reads the references of an iref payload
+func parseIref(data []byte) ([]heifReference, error) {
+	if len(data) < 4 {
+		return nil, corrupt("truncated iref box")
+	}
+	idSize := 2
+	if data[0] != 0 {
+		idSize = 4
+	}
+	var refs []heifReference
+	err := walkBoxLevel(data[4:], 0, "meta/iref", 1, func(b box) error {
+		r := ilocReader{data: b.Data}
+		ref := heifReference{Type: b.Type, From: uint32(r.uint(idSize))}
+		for n := r.uint(2); n > 0 && r.err == nil; n-- {
+			ref.To = append(ref.To, uint32(r.uint(idSize)))
+		}
+		if r.err != nil {
+			return corrupt("truncated iref reference")
+		}
+		refs = append(refs, ref)
+		return nil
+	})
+	return refs, err
+}
+
+// auxiliaryType returns the aux_type of the auxC property associated
+// with item, or "" if it has none
+func (m heifMeta) auxiliaryType(item uint32) string {
+	if m.ipma == nil || len(m.ipma.Data) < 4 {
+		return ""
+	}
+	version, flags := m.ipma.Data[0], m.ipma.Data[3]
+	r := ilocReader{data: m.ipma.Data[4:]}
+	for n := r.uint(4); n > 0 && r.err == nil; n-- {
+		var id uint32
+		if version < 1 {
+			id = uint32(r.uint(2))
+		} else {
+			id = uint32(r.uint(4))
+		}
+		for a := r.uint(1); a > 0 && r.err == nil; a-- {
+			var index int
+			if flags&1 != 0 {
+				index = int(r.uint(2) & 0x7fff)
+			} else {
+				index = int(r.uint(1) & 0x7f)
+			}
+			if id != item || index < 1 || index > len(m.properties) {
+				continue
+			}
+			if p := m.properties[index-1]; p.Type == "auxC" && len(p.Data) > 4 {
+				t := p.Data[4:]
+				if i := bytes.IndexByte(t, 0); i >= 0 {
+					t = t[:i]
+				}
+				return string(t)
+			}
+		}
+	}
+	return ""
+}
+
+// removeHEICAuxiliary removes the items an auxl reference marks as
+// auxiliary to another, such as depth maps, mattes and gain maps, along
+// with the tiles of those that are grids. Their data is overwritten with
+// zeros and their infe boxes are marked hidden, so the layout and every
+// offset stay as they are. Alpha planes are kept, and gain maps under
+// PreserveGainMaps. An item or extent listed more than once is overwritten
+// once, so repeated references cost no more than the bytes they cover.
+func removeHEICAuxiliary(data []byte, config Config, report *Report) error {
+	m, err := findHEIFMeta(data)
+	if err != nil || m.iref == nil {
+		return err
+	}
+	refs, err := parseIref(m.iref.Data)
+	if err != nil {
+		return err
+	}
+	items, err := m.items(data, func(heifItem) bool { return true })
+	if err != nil {
+		return err
+	}
+	byID := make(map[uint32]heifItem, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+	tiles := make(map[uint32][]uint32)
+	for _, ref := range refs {
+		if ref.Type == "dimg" {
+			tiles[ref.From] = append(tiles[ref.From], ref.To...)
+		}
+	}
+
+	done := make(map[uint32]bool)     // items already removed
+	zeroed := make(map[[2]int64]bool) // extents already overwritten
+	for _, ref := range refs {
+		if ref.Type != "auxl" {
+			continue
+		}
+		aux, ok := byID[ref.From]
+		if !ok {
+			continue
+		}
+		role := auxiliaryRole([]byte(m.auxiliaryType(aux.ID)))
+		switch {
+		case role == "alpha plane", role == "gain map" && config.PreserveGainMaps:
+			continue
+		case role == "":
+			role = "auxiliary image"
+		}
+		var size int64
+		for _, id := range append([]uint32{aux.ID}, tiles[aux.ID]...) {
+			item, ok := byID[id]
+			if !ok || done[id] {
+				continue
+			}
+			done[id] = true
+			for _, e := range item.extents {
+				if e[0] < 0 || e[1] < 0 || e[0] > int64(len(data)) || e[1] > int64(len(data))-e[0] {
+					return corrupt("HEIF auxiliary item extent outside the file")
+				}
+				if !zeroed[e] {
+					zeroed[e] = true
+					clear(data[e[0] : e[0]+e[1]])
+					size += e[1]
+				}
+			}
+			data[item.flags] |= 1 // hidden
+		}
+		report.remove(RemovedItem{Carrier: CarrierAuxiliaryImage, Name: "HEIF " + role, Strength: RemovalOverwritten, Size: size})
+	}
+	return nil
+}
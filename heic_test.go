@@ -0,0 +1,129 @@
+package exifremover
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// withBrand returns a HEIF file with its ftyp major brand set to brand
+func withBrand(heic []byte, brand string) []byte {
+	out := append([]byte(nil), heic...)
+	copy(out[8:12], brand)
+	return out
+}
+
+func TestHEICExifInPlace(t *testing.T) {
+	tiff := fixture.Sample().Bytes()
+	for _, tt := range []struct {
+		name    string
+		payload []byte // the Exif item: the header offset and what follows
+		idat    bool
+	}{
+		{"offset 0 in mdat", append([]byte{0, 0, 0, 0}, tiff...), false},
+		{"offset 0 in idat", append([]byte{0, 0, 0, 0}, tiff...), true},
+		{"Apple Exif prefix", append(append([]byte{0, 0, 0, 6}, exifPrefix...), tiff...), false},
+	} {
+		for _, brand := range []string{"heic", "heix", "mif1"} {
+			in := withBrand(fixture.HEIC(tt.payload, tt.idat), brand)
+			if f := detectFormat(in[:12]); f != FormatHEIC {
+				t.Fatalf("%s, %s: detected as %v", tt.name, brand, f)
+			}
+			out, report := sanitize(t, in, Config{RemoveCameraInfo: true, RemoveGPSInfo: true})
+			if len(out) != len(in) {
+				t.Fatalf("%s, %s: %d bytes out of %d", tt.name, brand, len(out), len(in))
+			}
+			assertAbsent(t, out, "CanonMake", "EOS Model 5D", "NETWORK-Somewhere")
+			if !bytes.Contains(out, []byte("John Artist")) {
+				t.Errorf("%s, %s: Artist removed without its category", tt.name, brand)
+			}
+			if len(report.Removed) == 0 {
+				t.Errorf("%s, %s: nothing reported", tt.name, brand)
+			}
+
+			// Everything outside the item's TIFF structure is copied through
+			item := bytes.Index(in, tt.payload)
+			start := item + len(tt.payload) - len(tiff)
+			if !bytes.Equal(out[:start], in[:start]) || !bytes.Equal(out[start+len(tiff):], in[start+len(tiff):]) {
+				t.Errorf("%s, %s: bytes outside the TIFF structure changed", tt.name, brand)
+			}
+			if m := inspect(t, out); m.hasTag("IFD0", 0x010f) || m.HasGPS {
+				t.Errorf("%s, %s: Make or GPS still present", tt.name, brand)
+			}
+		}
+	}
+}
+
+func TestHEICRemoveAll(t *testing.T) {
+	tiff := fixture.Sample().Bytes()
+	payload := append(append([]byte{0, 0, 0, 6}, exifPrefix...), tiff...)
+	in := fixture.HEIC(payload, false)
+	out, _ := sanitize(t, in, Config{RemoveAll: true})
+	if len(out) != len(in) {
+		t.Fatalf("%d bytes out of %d", len(out), len(in))
+	}
+	at := bytes.Index(in, payload)
+	item := out[at : at+len(payload)]
+	if want := append([]byte{0, 0, 0, 6}, emptyTIFF(true)...); !bytes.HasPrefix(item, want) {
+		t.Errorf("Exif item starts % x, want an empty TIFF structure % x", item[:len(want)], want)
+	}
+	if m := inspect(t, out); len(m.Tags) != 0 {
+		t.Errorf("%d tags left", len(m.Tags))
+	}
+}
+
+func TestHEICRejected(t *testing.T) {
+	tiff := fixture.Sample().Bytes()
+	in := withBrand(fixture.HEIC(append([]byte{0, 0, 0, 0}, tiff...), false), "avif")
+	if _, err := RemoveEXIFFromBytes(in, Config{RemoveGPSInfo: true}); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("AVIF brand: %v, want ErrUnsupportedFormat", err)
+	}
+	// An Exif item whose header offset points past its end
+	bad := fixture.HEIC([]byte{0, 0, 0, 0x40, 'I', 'I'}, false)
+	if _, err := RemoveEXIFFromBytes(bad, Config{RemoveGPSInfo: true}); !errors.Is(err, ErrCorruptImage) {
+		t.Errorf("bad header offset: %v, want ErrCorruptImage", err)
+	}
+}
+
+// zeroSizeIloc returns a version 0 iloc payload whose offset, length and
+// base offset sizes are all zero, listing items items of extents extents
+// each: six bytes an item, with nothing read per extent
+func zeroSizeIloc(items, extents int) []byte {
+	b := []byte{0, 0, 0, 0, 0x00, 0x00, byte(items >> 8), byte(items)}
+	for i := 0; i < items; i++ {
+		b = append(b, byte((i+1)>>8), byte(i+1), 0, 0, byte(extents>>8), byte(extents))
+	}
+	return b
+}
+
+func TestHEICIlocExtentCount(t *testing.T) {
+	if _, err := parseIloc(zeroSizeIloc(1, 1), -1); err != nil {
+		t.Errorf("one zero-size extent: %v", err)
+	}
+	for _, payload := range [][]byte{
+		zeroSizeIloc(100, 0xFFFF),
+		zeroSizeIloc(2, 2),
+		// Four-byte offsets and lengths, one extent's worth of box for 0xFFFF
+		{0, 0, 0, 0, 0x44, 0x00, 0, 1, 0, 1, 0, 0, 0xFF, 0xFF, 0, 0, 0, 0, 0, 0, 0, 8},
+	} {
+		var err error
+		allocs := testing.AllocsPerRun(1, func() { _, err = parseIloc(payload, -1) })
+		if !errors.Is(err, ErrCorruptImage) {
+			t.Errorf("parseIloc(% x...): %v, want ErrCorruptImage", payload[:14], err)
+		}
+		if allocs > 10 {
+			t.Errorf("parseIloc(% x...) made %v allocations", payload[:14], allocs)
+		}
+	}
+
+	// The same iloc in a whole file, as a 687-byte input once took 504MB
+	ftyp := fixture.Box("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	hdlr := fixture.Box("hdlr", make([]byte, 4), []byte("\x00\x00\x00\x00pict"), make([]byte, 13))
+	iinf := fixture.Box("iinf", []byte{0, 0, 0, 0, 0, 1}, fixture.Box("infe", []byte("\x02\x00\x00\x00\x00\x01\x00\x00Exif\x00")))
+	in := append(ftyp, fixture.Box("meta", make([]byte, 4), hdlr, iinf, fixture.Box("iloc", zeroSizeIloc(100, 0xFFFF)))...)
+	if _, err := RemoveEXIFFromBytes(in, Config{RemoveGPSInfo: true}); !errors.Is(err, ErrCorruptImage) {
+		t.Errorf("%d-byte HEIC: %v, want ErrCorruptImage", len(in), err)
+	}
+}
@@ -0,0 +1,85 @@
+package exifremover
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+)
+
+// sanitize runs data through RemoveEXIFFromBytesReport and fails the test
+// on an error. Every output is also held to the AssertNoAdditions check,
+// so no test passes with output the library added to.
+func sanitize(t testing.TB, data []byte, config Config) ([]byte, *Report) {
+	t.Helper()
+	out, report, err := RemoveEXIFFromBytesReport(data, config)
+	if err != nil {
+		t.Fatalf("RemoveEXIFFromBytesReport: %v", err)
+	}
+	if err := checkNoAdditions(detectFormat(data), data, out, config); err != nil {
+		t.Errorf("AssertNoAdditions: %v", err)
+	}
+	return out, report
+}
+
+// inspect runs Inspect over data and fails the test on an error
+func inspect(t testing.TB, data []byte) *MetadataReport {
+	t.Helper()
+	m, err := Inspect(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Inspect: %v", err)
+	}
+	return m
+}
+
+// hasTag reports whether Inspect found tag in the named IFD, or in any IFD
+// when ifd is empty
+func (m *MetadataReport) hasTag(ifd string, tag uint16) bool {
+	for _, t := range m.Tags {
+		if t.Tag == tag && (ifd == "" || t.IFD == ifd) {
+			return true
+		}
+	}
+	return false
+}
+
+// assertAbsent fails the test if any of leaks occurs in data
+func assertAbsent(t testing.TB, data []byte, leaks ...string) {
+	t.Helper()
+	for _, leak := range leaks {
+		if bytes.Contains(data, []byte(leak)) {
+			t.Errorf("output still contains %q", leak)
+		}
+	}
+}
+
+// fuzzConfigs are the configs the fuzz tests pick from, covering the
+// in-place edits, whole-container removal and structure repair
+var fuzzConfigs = []Config{
+	{RemoveCameraInfo: true, RemoveGPSInfo: true, RemoveDateTime: true, RemoveUserInfo: true, RemoveIPTC: true, RemoveComments: true, RemoveTextChunks: true, MaxMetadataSize: fuzzMetadataLimit},
+	{RemoveAll: true, RemoveAuxiliaryImages: true, ScrubICCProfile: true, MaxMetadataSize: fuzzMetadataLimit},
+	{RemoveGPSInfo: true, GPSAction: GPSTruncate, GPSPrecision: 1, RemoveDateTime: true, DateTimePolicy: DateTimeTruncateToDate, RepairStructure: true, Salvage: true, Minify: true, MaxMetadataSize: fuzzMetadataLimit},
+}
+
+// fuzzMetadataLimit is the MaxMetadataSize of fuzzConfigs
+const fuzzMetadataLimit = 1 << 16
+
+// fuzzRemove runs data through RemoveEXIFFromBytes under the config mode
+// picks and checks that it allocates in proportion to the input and the
+// metadata limit, whatever lengths the input claims, fails with an error
+// rather than a panic, and produces output that processes again
+func fuzzRemove(t *testing.T, data []byte, mode uint8) {
+	config := fuzzConfigs[int(mode)%len(fuzzConfigs)]
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	out, err := RemoveEXIFFromBytes(data, config)
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > uint64(64*len(data)+16*fuzzMetadataLimit+1<<20) {
+		t.Fatalf("%d bytes allocated for %d bytes of input", alloc, len(data))
+	}
+	if err != nil {
+		return
+	}
+	if _, err := RemoveEXIFFromBytes(out, config); err != nil {
+		t.Fatalf("output doesn't process again: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package exifremover
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"io"
+)
+
+// iccSegmentPrefix starts every JPEG APP2 segment carrying ICC profile data
+var iccSegmentPrefix = []byte("ICC_PROFILE\x00")
+
+// scrubICC reports whether config asks for any change to embedded profiles
+func scrubICC(config Config) bool {
+	return (config.ScrubICCProfile && config.RemoveDateTime) || config.BlankICCDescription
+}
+
+// scrubICCSegment scrubs the profile chunk carried by a JPEG APP2 payload in
+// place. Only the first chunk holds the header; for profiles split across
+// several segments the ID can't be recomputed from one chunk, so it is
+// cleared instead, which the ICC spec defines as "not calculated".
+func scrubICCSegment(data []byte, config Config) {
+	if !bytes.HasPrefix(data, iccSegmentPrefix) || len(data) < len(iccSegmentPrefix)+2 {
+		return
+	}
+	seq, total := data[len(iccSegmentPrefix)], data[len(iccSegmentPrefix)+1]
+	if seq != 1 {
+		return
+	}
+	scrubICCProfile(data[len(iccSegmentPrefix)+2:], config, total == 1)
+}
+
+// scrubICCProfile zeroes the creation date in an ICC profile header and
+// optionally blanks the 'desc' tag text. When the profile ID was set it is
+// recomputed if complete is true, and cleared otherwise, so color management
+// tools don't report a checksum mismatch.
+func scrubICCProfile(profile []byte, config Config, complete bool) {
+	if len(profile) < 128 {
+		return
+	}
+	if config.ScrubICCProfile && config.RemoveDateTime {
+		for i := 24; i < 36; i++ { // dateTimeNumber
+			profile[i] = 0
+		}
+	}
+	if config.BlankICCDescription {
+		blankICCDescription(profile)
+	}
+
+	id := profile[84:100]
+	if bytes.Equal(id, make([]byte, 16)) {
+		return
+	}
+	if !complete {
+		copy(id, make([]byte, 16))
+		return
+	}
+	sum := iccProfileID(profile)
+	copy(id, sum[:])
+}
+
+// blankICCDescription zeroes the body of the 'desc' tag, leaving its type
+// signature in place. All-zero bodies decode as empty strings for both the
+// v2 textDescriptionType and the v4 multiLocalizedUnicodeType.
+func blankICCDescription(profile []byte) {
+	if len(profile) < 132 {
+		return
+	}
+	count := int(binary.BigEndian.Uint32(profile[128:132]))
+	for i := 0; i < count; i++ {
+		entry := 132 + 12*i
+		if entry+12 > len(profile) {
+			return
+		}
+		if string(profile[entry:entry+4]) != "desc" {
+			continue
+		}
+		offset := int64(binary.BigEndian.Uint32(profile[entry+4 : entry+8]))
+		size := int64(binary.BigEndian.Uint32(profile[entry+8 : entry+12]))
+		if size < 8 || offset+size > int64(len(profile)) {
+			continue
+		}
+		body := profile[offset+8 : offset+size]
+		for j := range body {
+			body[j] = 0
+		}
+	}
+}
+
+// iccProfileID computes the profile ID: the MD5 of the profile with the
+// flags, rendering intent and profile ID fields zeroed
+func iccProfileID(profile []byte) [16]byte {
+	clean := make([]byte, len(profile))
+	copy(clean, profile)
+	copy(clean[44:48], make([]byte, 4))
+	copy(clean[64:68], make([]byte, 4))
+	copy(clean[84:100], make([]byte, 16))
+	return md5.Sum(clean)
+}
+
+// scrubICCChunk returns a PNG iCCP chunk payload with its profile scrubbed.
+// The profile is inflated, scrubbed and deflated again, so the payload is
+// returned unchanged when it can't be decoded or inflates past
+// Config.MaxMetadataSize.
+func scrubICCChunk(data []byte, config Config) []byte {
+	nul := bytes.IndexByte(data, 0)
+	if nul < 0 || nul+2 > len(data) || data[nul+1] != 0 { // keyword, NUL, compression method 0
+		return data
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data[nul+2:]))
+	if err != nil {
+		return data
+	}
+	limit := config.maxMetadataSize()
+	profile, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if err != nil || len(profile) > limit {
+		return data
+	}
+	scrubICCProfile(profile, config, true)
+
+	var out bytes.Buffer
+	out.Write(data[:nul+2])
+	zw := zlib.NewWriter(&out)
+	zw.Write(profile)
+	zw.Close()
+	return out.Bytes()
+}
@@ -0,0 +1,145 @@
+package exifremover
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// descProfile returns an ICC profile with a wtpt, a desc and a cprt tag,
+// the desc holding text in the v2 textDescriptionType or the v4
+// multiLocalizedUnicodeType. The tag table is followed by the tags in
+// order; it returns the profile and the desc tag's offset and size.
+func descProfile(v4 bool, text string) (profile []byte, descOffset, descSize int) {
+	var desc []byte
+	if v4 {
+		desc = []byte("mluc\x00\x00\x00\x00")
+		desc = binary.BigEndian.AppendUint32(desc, 1)  // records
+		desc = binary.BigEndian.AppendUint32(desc, 12) // record size
+		desc = append(desc, "enUS"...)
+		desc = binary.BigEndian.AppendUint32(desc, uint32(2*len(text)))
+		desc = binary.BigEndian.AppendUint32(desc, 28)
+		for _, c := range text {
+			desc = binary.BigEndian.AppendUint16(desc, uint16(c))
+		}
+	} else {
+		desc = []byte("desc\x00\x00\x00\x00")
+		desc = binary.BigEndian.AppendUint32(desc, uint32(len(text)+1))
+		desc = append(desc, text...)
+		desc = append(desc, make([]byte, 1+4+4+2+1+67)...) // NUL, Unicode and ScriptCode parts
+	}
+	for len(desc)%4 != 0 {
+		desc = append(desc, 0)
+	}
+	tags := []struct {
+		sig  string
+		body []byte
+	}{
+		{"wtpt", []byte("XYZ \x00\x00\x00\x00\x00\x00\xf6\xd6\x00\x01\x00\x00\x00\x00\xd3\x2d")},
+		{"desc", desc},
+		{"cprt", []byte("text\x00\x00\x00\x00No copyright\x00\x00\x00\x00")},
+	}
+
+	profile = make([]byte, 128)
+	copy(profile[36:], "acsp")
+	copy(profile[24:], []byte{0x07, 0xe7, 0, 6, 0, 14, 18, 42, 7, 0})
+	profile = binary.BigEndian.AppendUint32(profile, uint32(len(tags)))
+	offset := 132 + 12*len(tags)
+	var bodies []byte
+	for _, tag := range tags {
+		profile = append(profile, tag.sig...)
+		profile = binary.BigEndian.AppendUint32(profile, uint32(offset))
+		profile = binary.BigEndian.AppendUint32(profile, uint32(len(tag.body)))
+		if tag.sig == "desc" {
+			descOffset, descSize = offset, len(tag.body)
+		}
+		bodies = append(bodies, tag.body...)
+		offset += len(tag.body)
+	}
+	profile = append(profile, bodies...)
+	binary.BigEndian.PutUint32(profile[0:4], uint32(len(profile)))
+	return profile, descOffset, descSize
+}
+
+// checkBlankedDescription fails the test unless out is in with only the
+// desc tag's body past its type signature zeroed and, when the input
+// had a profile ID, the ID recomputed
+func checkBlankedDescription(t *testing.T, in, out []byte, descOffset, descSize int) {
+	t.Helper()
+	if len(out) != len(in) || binary.BigEndian.Uint32(out[0:4]) != uint32(len(out)) {
+		t.Fatalf("profile of %d bytes with size field %d, want %d", len(out), binary.BigEndian.Uint32(out[0:4]), len(in))
+	}
+	body := out[descOffset+8 : descOffset+descSize]
+	if !bytes.Equal(body, make([]byte, len(body))) {
+		t.Error("desc body not blanked")
+	}
+	if !bytes.Equal(out[descOffset:descOffset+4], in[descOffset:descOffset+4]) {
+		t.Errorf("desc type signature %q changed", out[descOffset:descOffset+4])
+	}
+	if !bytes.Equal(out[128:descOffset+8], in[128:descOffset+8]) || !bytes.Equal(out[descOffset+descSize:], in[descOffset+descSize:]) {
+		t.Error("tag table or another tag changed")
+	}
+	header := func(p []byte) []byte {
+		return append(append([]byte(nil), p[:84]...), p[100:128]...)
+	}
+	if !bytes.Equal(header(out), header(in)) {
+		t.Error("header changed outside the profile ID")
+	}
+	if id := out[84:100]; !bytes.Equal(in[84:100], make([]byte, 16)) {
+		if want := iccProfileID(out); !bytes.Equal(id, want[:]) {
+			t.Errorf("profile ID %x, want %x", id, want)
+		}
+	} else if !bytes.Equal(id, make([]byte, 16)) {
+		t.Error("profile ID set on a profile without one")
+	}
+}
+
+func TestBlankICCDescription(t *testing.T) {
+	const text = "Canon EOS R5 #024021000123"
+	for _, c := range []struct {
+		name   string
+		v4, id bool
+	}{
+		{"v2", false, false},
+		{"v4", true, false},
+		{"v2 with profile ID", false, true},
+		{"v4 with profile ID", true, true},
+	} {
+		profile, descOffset, descSize := descProfile(c.v4, text)
+		if c.id {
+			sum := iccProfileID(profile)
+			copy(profile[84:], sum[:])
+		}
+
+		t.Run(c.name+" JPEG", func(t *testing.T) {
+			segment := append(append([]byte(nil), iccSegmentPrefix...), 1, 1)
+			in := fixture.WithSegment(fixture.JPEG(8, 8), 0xE2, append(segment, profile...))
+			out, _ := sanitize(t, in, Config{BlankICCDescription: true})
+			start := bytes.Index(out, iccSegmentPrefix) + len(iccSegmentPrefix) + 2
+			checkBlankedDescription(t, profile, out[start:start+len(profile)], descOffset, descSize)
+			if !bytes.Equal(out[start+len(profile):], in[start+len(profile):]) {
+				t.Error("image data changed")
+			}
+		})
+
+		t.Run(c.name+" PNG", func(t *testing.T) {
+			in := fixture.WithChunks(fixture.PNG(8, 8), fixture.Chunk("iCCP", append([]byte("icc\x00\x00"), zlibBytes(t, string(profile))...)))
+			out, _ := sanitize(t, in, Config{BlankICCDescription: true})
+			start := bytes.Index(out, []byte("iCCPicc\x00\x00")) + 9
+			zr, err := zlib.NewReader(bytes.NewReader(out[start:]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkBlankedDescription(t, profile, got, descOffset, descSize)
+			checkPNGCRCs(t, out)
+		})
+	}
+}
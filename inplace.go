@@ -0,0 +1,225 @@
+package exifremover
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// RemoveEXIFInPlace sanitizes the file at path where it sits. The result is
+// written to a temporary file in the same directory, synced, and renamed
+// over the original, so a failure at any point leaves the original intact.
+// Where that can't be done atomically it fails with an AtomicWriteError,
+// unless Config.InPlaceNonAtomic is set.
+// Symbolic links are followed and their target is replaced. The original's
+// permission bits are kept, and its modification time too under
+// Config.PreserveModTime.
+func RemoveEXIFInPlace(path string, config Config) error {
+	_, err := RemoveEXIFInPlaceReport(path, config)
+	return err
+}
+
+// RemoveEXIFInPlaceReport is RemoveEXIFInPlace, additionally returning a
+// Report describing the image that was processed
+func RemoveEXIFInPlaceReport(path string, config Config) (*Report, error) {
+	s, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	return s.RemoveInPlace(path)
+}
+
+// RemoveInPlace is RemoveEXIFInPlaceReport with the Sanitizer's Config
+func (s *Sanitizer) RemoveInPlace(path string) (*Report, error) {
+	path, err := resolveInput(path, s.config)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFileSize(path, s.config); err != nil {
+		return nil, err
+	}
+	return s.removeInPlace(context.Background(), path)
+}
+
+// ErrCannotWriteAtomically is wrapped in an AtomicWriteError when a file
+// can't be replaced atomically where it sits, such as on a read-only bind
+// mount or across devices. Config.InPlaceNonAtomic writes it anyway.
+var ErrCannotWriteAtomically = errors.New("cannot write atomically")
+
+// AtomicWriteError reports the in-place write that couldn't be made atomic
+// and the error that prevented it
+type AtomicWriteError struct {
+	Path string
+	Err  error
+}
+
+func (e *AtomicWriteError) Error() string {
+	return e.Path + ": " + ErrCannotWriteAtomically.Error() + ": " + e.Err.Error()
+}
+
+func (e *AtomicWriteError) Unwrap() []error { return []error{ErrCannotWriteAtomically, e.Err} }
+
+// removeInPlace replaces the file at path, already resolved, with its
+// sanitized version. The output goes to a temporary file beside it, or in
+// os.TempDir when the directory refuses one; a staged file that can't be
+// renamed across devices is copied into a second temporary file beside
+// path and renamed from there. When no temporary file can be made, or the
+// final rename fails, the original is left alone and an AtomicWriteError
+// returned.
+func (s *Sanitizer) removeInPlace(ctx context.Context, path string) (*Report, error) {
+	info, err := fsys.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotRegularFile)
+	}
+	inputFile, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer inputFile.Close()
+	if s.config.InPlaceNonAtomic {
+		return s.overwrite(ctx, path, inputFile, info)
+	}
+
+	dir := filepath.Dir(path)
+	pattern := "." + filepath.Base(path) + ".*"
+	tmp, err := fsys.TempFile(dir, pattern)
+	staged := err != nil
+	if staged {
+		if tmp, err = fsys.TempFile(os.TempDir(), pattern); err != nil {
+			return nil, &AtomicWriteError{path, err}
+		}
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			tmp.Close()
+			fsys.Remove(tmp.Name())
+		}
+	}()
+
+	report, err := s.removeFile(ctx, inputFile, tmp)
+	if err != nil {
+		return report, err
+	}
+	if err := s.finishTemp(tmp, info); err != nil {
+		return nil, err
+	}
+	// A rename that fails, whatever the reason, leaves no atomic way to
+	// put the output in place
+	err = fsys.Rename(tmp.Name(), path)
+	switch {
+	case err == nil:
+		renamed = true
+	case staged && errors.Is(err, syscall.EXDEV):
+		if err := s.relocate(tmp.Name(), dir, pattern, path, info); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, &AtomicWriteError{path, err}
+	}
+
+	// Make the rename itself durable where directories can be synced
+	if d, err := fsys.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return report, nil
+}
+
+// finishTemp makes a closed, durable copy of tmp with the original's mode,
+// and its modification time under PreserveModTime
+func (s *Sanitizer) finishTemp(tmp file, info fs.FileInfo) error {
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := fsys.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if s.config.PreserveModTime {
+		return fsys.Chtimes(tmp.Name(), time.Now(), info.ModTime())
+	}
+	return nil
+}
+
+// relocate moves the output staged at staged onto path, on another device,
+// by copying it into a temporary file in dir and renaming that
+func (s *Sanitizer) relocate(staged, dir, pattern, path string, info fs.FileInfo) error {
+	src, err := fsys.Open(staged)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp, err := fsys.TempFile(dir, pattern)
+	if err != nil {
+		return &AtomicWriteError{path, err}
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		fsys.Remove(tmp.Name())
+		return err
+	}
+	if err := s.finishTemp(tmp, info); err != nil {
+		tmp.Close()
+		fsys.Remove(tmp.Name())
+		return err
+	}
+	if err := fsys.Rename(tmp.Name(), path); err != nil {
+		fsys.Remove(tmp.Name())
+		return &AtomicWriteError{path, err}
+	}
+	return nil
+}
+
+// overwrite is removeInPlace under InPlaceNonAtomic: the output is built in
+// memory and written over the original
+func (s *Sanitizer) overwrite(ctx context.Context, path string, inputFile file, info fs.FileInfo) (*Report, error) {
+	var output bytes.Buffer
+	report, err := s.removeFile(ctx, inputFile, &output)
+	if err != nil {
+		return report, err
+	}
+	out, err := fsys.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(output.Bytes()); err != nil {
+		out.Close()
+		return nil, err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	if s.config.PreserveModTime {
+		if err := fsys.Chtimes(path, time.Now(), info.ModTime()); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// sameFile reports whether outputPath names the existing file at inputPath
+func sameFile(inputPath, outputPath string) bool {
+	in, err := fsys.Stat(inputPath)
+	if err != nil {
+		return false
+	}
+	out, err := fsys.Stat(outputPath)
+	return err == nil && os.SameFile(in, out)
+}
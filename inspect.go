@@ -0,0 +1,270 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+)
+
+// MetadataReport describes the metadata an image holds, as found by Inspect
+type MetadataReport struct {
+	Format Format
+	// Containers lists the metadata containers present, in file order,
+	// e.g. "APP1 EXIF", "APP1 XMP", "APP13 Photoshop", "eXIf", "tEXt"
+	Containers []string
+	Tags       []InspectedTag // every EXIF entry found, pointers excluded
+	// Categories lists the categories with at least one tag present
+	Categories []Category
+
+	HasGPS      bool // a GPS IFD with entries is present
+	HasPosition bool // Latitude and Longitude are set
+	Latitude    float64
+	Longitude   float64
+}
+
+// InspectedTag is one EXIF entry found by Inspect
+type InspectedTag struct {
+	IFD        string // "IFD0", "IFD1", ..., "EXIF" or "GPS"
+	Tag        uint16
+	Name       string
+	Categories []Category
+	Value      string // decoded text for string tags, empty otherwise
+}
+
+// Inspect reads an image from r and reports the metadata it holds, without
+// writing anything. It uses the same segment scanners and IFD layout rules
+// as removal, so what it finds is what a Config can act on.
+func Inspect(r io.Reader) (*MetadataReport, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	header := data
+	if len(header) > 12 {
+		header = header[:12]
+	}
+	m := &MetadataReport{Format: detectFormat(header)}
+
+	switch m.Format {
+	case FormatJPEG:
+		for _, s := range jpegStructure(data) {
+			switch {
+			case s.Kind == "APP1" && bytes.HasPrefix(s.Data, exifPrefix):
+				m.Containers = append(m.Containers, "APP1 EXIF")
+				m.inspectEXIF(s.Data[len(exifPrefix):])
+			case s.Kind == "APP1" && bytes.HasPrefix(s.Data, xmpSegmentPrefix):
+				m.Containers = append(m.Containers, "APP1 XMP")
+			case s.Kind == "APP2" && bytes.HasPrefix(s.Data, iccSegmentPrefix):
+				m.Containers = append(m.Containers, "APP2 ICC")
+			case s.Kind == "APP2" && bytes.HasPrefix(s.Data, []byte("MPF\x00")):
+				m.Containers = append(m.Containers, "APP2 MPF")
+			case s.Kind == "APP13" && bytes.HasPrefix(s.Data, photoshopPrefix):
+				m.Containers = append(m.Containers, "APP13 Photoshop")
+			case s.Kind == "COM":
+				m.Containers = append(m.Containers, "COM")
+			}
+		}
+	case FormatPNG:
+		for _, s := range pngStructure(data) {
+			switch s.Kind {
+			case "eXIf":
+				m.inspectEXIF(bytes.TrimPrefix(s.Data, exifPrefix))
+				fallthrough
+			case "tEXt", "zTXt", "iTXt", "iCCP":
+				m.Containers = append(m.Containers, s.Kind)
+			}
+		}
+	case FormatWebP:
+		for pos := 12; pos+8 <= len(data); {
+			fourCC := string(data[pos : pos+4])
+			size := int64(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+			if size > int64(len(data)-pos-8) {
+				break
+			}
+			switch fourCC {
+			case "EXIF":
+				m.inspectEXIF(bytes.TrimPrefix(data[pos+8:pos+8+int(size)], exifPrefix))
+				fallthrough
+			case "XMP ", "ICCP":
+				m.Containers = append(m.Containers, fourCC)
+			}
+			pos += 8 + int(size+size&1)
+		}
+	case FormatTIFF:
+		m.inspectEXIF(data)
+	case FormatHEIC:
+		items, err := heifItems(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if item.size() > defaultMaxMetadataSize {
+				continue
+			}
+			payload := item.read(data)
+			if item.Type != "Exif" {
+				m.Containers = append(m.Containers, "XMP item")
+				continue
+			}
+			m.Containers = append(m.Containers, "Exif item")
+			if tiff, err := exifTIFF(FormatHEIC, payload); err == nil {
+				m.inspectEXIF(bytes.TrimPrefix(tiff, exifPrefix))
+			}
+		}
+	default:
+		return nil, formatError(header)
+	}
+	return m, nil
+}
+
+// inspectEXIF records the entries of a bare TIFF structure
+func (m *MetadataReport) inspectEXIF(tiff []byte) {
+	if len(tiff) < 8 {
+		return
+	}
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+
+	visited := make(map[int]bool)
+	offset := int(order.Uint32(tiff[4:8]))
+	for i := 0; i < maxIFDs && offset >= 8 && offset+2 <= len(tiff) && !visited[offset]; i++ {
+		visited[offset] = true
+		offset = m.inspectIFD(tiff, offset, order, ifdName(i), visited)
+	}
+}
+
+func ifdName(i int) string {
+	return "IFD" + strconv.Itoa(i)
+}
+
+// inspectIFD records the entries of one IFD, descending into the EXIF and
+// GPS IFDs, and returns the offset of the next IFD in the chain
+func (m *MetadataReport) inspectIFD(tiff []byte, offset int, order binary.ByteOrder, ifd string, visited map[int]bool) int {
+	numEntries := int(order.Uint16(tiff[offset : offset+2]))
+	pos := offset + 2
+	for i := 0; i < numEntries && pos+12 <= len(tiff); i, pos = i+1, pos+12 {
+		tag := order.Uint16(tiff[pos : pos+2])
+		if isEmptyEntry(tiff, pos, order) {
+			continue
+		}
+		switch {
+		case ifd != "GPS" && ifd != "EXIF" && tag == 0x8769:
+			if sub, ok := ifdPointer(tiff, pos, order); ok && !visited[sub] {
+				visited[sub] = true
+				m.inspectIFD(tiff, sub, order, "EXIF", visited)
+			}
+			continue
+		case ifd != "GPS" && ifd != "EXIF" && tag == 0x8825:
+			if sub, ok := ifdPointer(tiff, pos, order); ok && !visited[sub] {
+				visited[sub] = true
+				m.inspectIFD(tiff, sub, order, "GPS", visited)
+			}
+			continue
+		}
+
+		t := exifTag(tag, Config{})
+		if ifd == "GPS" {
+			m.HasGPS = true
+			t = gpsTag(tag)
+		}
+		value, _ := entryString(tiff, pos, order)
+		m.Tags = append(m.Tags, InspectedTag{IFD: ifd, Tag: tag, Name: t.Name, Categories: t.Categories, Value: value})
+		for _, c := range t.Categories {
+			m.addCategory(c)
+		}
+	}
+	if ifd == "GPS" {
+		m.addCategory(CategoryGPSInfo)
+		m.readPosition(tiff, offset, order)
+	}
+	if pos != offset+2+12*numEntries || pos+4 > len(tiff) {
+		return 0
+	}
+	return int(order.Uint32(tiff[pos : pos+4]))
+}
+
+func (m *MetadataReport) addCategory(c Category) {
+	for _, have := range m.Categories {
+		if have == c {
+			return
+		}
+	}
+	m.Categories = append(m.Categories, c)
+}
+
+// gpsTagNames names the GPS IFD entries that aren't in gpsTags
+var gpsTagNames = map[uint16]string{
+	0x0000: "GPSVersionID", 0x0001: "GPSLatitudeRef", 0x0002: "GPSLatitude",
+	0x0003: "GPSLongitudeRef", 0x0004: "GPSLongitude", 0x0005: "GPSAltitudeRef",
+	0x0006: "GPSAltitude", 0x0007: "GPSTimeStamp", 0x0012: "GPSMapDatum",
+	0x001d: "GPSDateStamp",
+}
+
+// gpsTag returns the table entry for a GPS IFD tag, or a bare entry for
+// tags outside the table. Every GPS entry belongs to GPSInfo.
+func gpsTag(tag uint16) *tagInfo {
+	if t, ok := gpsTagIndex[tag]; ok {
+		return t
+	}
+	name, ok := gpsTagNames[tag]
+	if !ok {
+		name = string(appendHexTag(nil, tag))
+	}
+	return &tagInfo{ID: tag, Name: name, Categories: []Category{CategoryGPSInfo}}
+}
+
+// readPosition decodes the latitude and longitude of a GPS IFD
+func (m *MetadataReport) readPosition(tiff []byte, offset int, order binary.ByteOrder) {
+	var ref [5]string
+	var deg [5]float64
+	var ok [5]bool
+	numEntries := int(order.Uint16(tiff[offset : offset+2]))
+	for i, pos := 0, offset+2; i < numEntries && pos+12 <= len(tiff); i, pos = i+1, pos+12 {
+		switch tag := order.Uint16(tiff[pos : pos+2]); tag {
+		case 1, 3:
+			ref[tag], _ = entryString(tiff, pos, order)
+		case 2, 4:
+			deg[tag], ok[tag] = degrees(tiff, pos, order)
+		}
+	}
+	if !ok[2] || !ok[4] {
+		return
+	}
+	m.HasPosition = true
+	m.Latitude, m.Longitude = deg[2], deg[4]
+	if ref[1] == "S" {
+		m.Latitude = -m.Latitude
+	}
+	if ref[3] == "W" {
+		m.Longitude = -m.Longitude
+	}
+}
+
+// degrees decodes a degrees/minutes/seconds triple of RATIONALs
+func degrees(tiff []byte, pos int, order binary.ByteOrder) (float64, bool) {
+	if order.Uint16(tiff[pos+2:pos+4]) != 5 || order.Uint32(tiff[pos+4:pos+8]) != 3 {
+		return 0, false
+	}
+	offset := int64(order.Uint32(tiff[pos+8 : pos+12]))
+	if offset+24 > int64(len(tiff)) {
+		return 0, false
+	}
+	var v [3]float64
+	for i := range v {
+		num := order.Uint32(tiff[offset+8*int64(i):])
+		den := order.Uint32(tiff[offset+8*int64(i)+4:])
+		if den == 0 {
+			return 0, false
+		}
+		v[i] = float64(num) / float64(den)
+	}
+	return v[0] + v[1]/60 + v[2]/3600, true
+}
@@ -0,0 +1,138 @@
+// Command regen-golden rewrites the golden corpus in testdata/golden after
+// an intended change in behavior, and prints what changed for review: the
+// output hashes and sizes, and every item removed differently. Run it from
+// the repository root:
+//
+//	go run ./internal/cmd/regen-golden
+//
+// Fixtures added to golden.Inputs are written to inputs; ones already
+// there are never rewritten. With -n nothing is written.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/renix-codex/exifremover/internal/golden"
+)
+
+func main() {
+	dir := flag.String("dir", filepath.Join("testdata", "golden"), "corpus directory")
+	dryRun := flag.Bool("n", false, "print the changes without writing them")
+	flag.Parse()
+	changed, err := regen(*dir, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "regen-golden:", err)
+		os.Exit(1)
+	}
+	if changed == 0 {
+		fmt.Println("golden corpus unchanged")
+	}
+}
+
+// regen brings the corpus at dir up to date and returns how many golden
+// files it changed
+func regen(dir string, dryRun bool) (int, error) {
+	write := func(path string, data []byte) error {
+		if dryRun {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, 0o644)
+	}
+
+	inputs := golden.Inputs()
+	names := make([]string, 0, len(inputs))
+	for name := range inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(dir, "inputs", name)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		fmt.Printf("new fixture %s\n", name)
+		if err := write(path, inputs[name]); err != nil {
+			return 0, err
+		}
+	}
+
+	fixtures, err := golden.Fixtures(dir)
+	if os.IsNotExist(err) && dryRun {
+		fixtures, err = names, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	current := make(map[string]bool)
+	for _, name := range fixtures {
+		input, err := os.ReadFile(filepath.Join(dir, "inputs", name))
+		if os.IsNotExist(err) && dryRun {
+			input, err = inputs[name], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		for _, p := range golden.Presets {
+			path := golden.Path(dir, name, p.Name)
+			current[filepath.Base(path)] = true
+			got, err := golden.Run(input, p)
+			if err != nil {
+				return 0, err
+			}
+			data, err := golden.Marshal(got)
+			if err != nil {
+				return 0, err
+			}
+			old, err := os.ReadFile(path)
+			switch {
+			case os.IsNotExist(err):
+				fmt.Printf("%s under %s: new\n", name, p.Name)
+			case err != nil:
+				return 0, err
+			case bytes.Equal(old, data):
+				continue
+			default:
+				want, err := golden.Load(path)
+				if err != nil {
+					return 0, err
+				}
+				fmt.Printf("%s under %s:\n", name, p.Name)
+				for _, line := range golden.Diff(want, got) {
+					fmt.Println("\t" + line)
+				}
+			}
+			changed++
+			if err := write(path, data); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// Goldens of fixtures or presets that are gone
+	entries, err := os.ReadDir(filepath.Join(dir, "expected"))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	for _, e := range entries {
+		if current[e.Name()] {
+			continue
+		}
+		fmt.Printf("%s: removed\n", e.Name())
+		changed++
+		if !dryRun {
+			if err := os.Remove(filepath.Join(dir, "expected", e.Name())); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return changed, nil
+}
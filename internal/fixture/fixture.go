@@ -0,0 +1,444 @@
+// Package fixture builds small synthetic images for the tests and the
+// self test: TIFF structures with chosen IFD entries, and JPEG, PNG and
+// HEIF files carrying them. Everything is generated in memory with the
+// standard library encoders, so no test data has to ship with the code.
+package fixture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+)
+
+// Entry is one IFD entry. Values longer than four bytes are stored after
+// the directories and the entry points at them.
+type Entry struct {
+	Tag, Type uint16
+	Count     uint32
+	Value     []byte
+}
+
+// ASCII returns a NUL-terminated ASCII entry
+func ASCII(tag uint16, s string) Entry {
+	v := append([]byte(s), 0)
+	return Entry{tag, 2, uint32(len(v)), v}
+}
+
+// Undefined returns an UNDEFINED entry holding v
+func Undefined(tag uint16, v []byte) Entry {
+	return Entry{tag, 7, uint32(len(v)), v}
+}
+
+// Short returns a single SHORT entry
+func Short(order binary.ByteOrder, tag, n uint16) Entry {
+	v := make([]byte, 2)
+	order.PutUint16(v, n)
+	return Entry{tag, 3, 1, v}
+}
+
+// Long returns a single LONG entry
+func Long(order binary.ByteOrder, tag uint16, n uint32) Entry {
+	v := make([]byte, 4)
+	order.PutUint32(v, n)
+	return Entry{tag, 4, 1, v}
+}
+
+// Rational returns a RATIONAL entry from numerator, denominator pairs
+func Rational(order binary.ByteOrder, tag uint16, pairs ...uint32) Entry {
+	v := make([]byte, 4*len(pairs))
+	for i, n := range pairs {
+		order.PutUint32(v[4*i:], n)
+	}
+	return Entry{tag, 5, uint32(len(pairs) / 2), v}
+}
+
+// TIFF describes a TIFF structure: IFD0 with optional EXIF and GPS
+// sub-IFDs, and an optional IFD1 chained after IFD0. The EXIF and GPS
+// pointers are added to IFD0 when those IFDs are set.
+type TIFF struct {
+	Order                 binary.ByteOrder // little-endian when nil
+	IFD0, Exif, GPS, IFD1 []Entry
+	// Gap is the number of zero bytes between the directories and the
+	// out-of-line values, to place values far from their entries
+	Gap  int
+	Tail []byte // appended after the values
+}
+
+// Bytes lays out the header, IFD0, the EXIF IFD, the GPS IFD, IFD1 and
+// then the out-of-line values. Offsets are relative to the header.
+func (t TIFF) Bytes() []byte {
+	order := t.Order
+	if order == nil {
+		order = binary.LittleEndian
+	}
+
+	ifd0 := append([]Entry(nil), t.IFD0...)
+	ifds := [][]Entry{nil}
+	pointers := map[int]int{} // IFD0 entry index to the IFD it points at
+	if t.Exif != nil {
+		pointers[len(ifd0)] = len(ifds)
+		ifd0 = append(ifd0, Entry{0x8769, 4, 1, nil})
+		ifds = append(ifds, t.Exif)
+	}
+	if t.GPS != nil {
+		pointers[len(ifd0)] = len(ifds)
+		ifd0 = append(ifd0, Entry{0x8825, 4, 1, nil})
+		ifds = append(ifds, t.GPS)
+	}
+	if t.IFD1 != nil {
+		ifds = append(ifds, t.IFD1)
+	}
+	ifds[0] = ifd0
+
+	offsets := make([]int, len(ifds))
+	end := 8
+	for i, entries := range ifds {
+		offsets[i] = end
+		end += 2 + 12*len(entries) + 4
+	}
+	valueStart := end + t.Gap
+
+	var b, values bytes.Buffer
+	if order == binary.LittleEndian {
+		b.WriteString("II")
+	} else {
+		b.WriteString("MM")
+	}
+	binary.Write(&b, order, uint16(42))
+	binary.Write(&b, order, uint32(8))
+	for n, entries := range ifds {
+		binary.Write(&b, order, uint16(len(entries)))
+		for i, e := range entries {
+			binary.Write(&b, order, e.Tag)
+			binary.Write(&b, order, e.Type)
+			binary.Write(&b, order, e.Count)
+			if sub, ok := pointers[i]; ok && n == 0 {
+				binary.Write(&b, order, uint32(offsets[sub]))
+				continue
+			}
+			if len(e.Value) <= 4 {
+				v := make([]byte, 4)
+				copy(v, e.Value)
+				b.Write(v)
+				continue
+			}
+			binary.Write(&b, order, uint32(valueStart+values.Len()))
+			values.Write(e.Value)
+			if values.Len()%2 == 1 {
+				values.WriteByte(0)
+			}
+		}
+		next := uint32(0)
+		if n == 0 && t.IFD1 != nil {
+			next = uint32(offsets[len(offsets)-1])
+		}
+		binary.Write(&b, order, next)
+	}
+	b.Write(make([]byte, t.Gap))
+	b.Write(values.Bytes())
+	b.Write(t.Tail)
+	return b.Bytes()
+}
+
+// Sample returns a TIFF structure like a camera writes, with Make and
+// Model, capture dates, an artist, an orientation and a GPS position
+func Sample() TIFF {
+	le := binary.LittleEndian
+	return TIFF{
+		IFD0: []Entry{
+			ASCII(0x010f, "CanonMake"),
+			ASCII(0x0110, "EOS Model 5D"),
+			Short(le, 0x0112, 6),
+			ASCII(0x0132, "2023:06:14 18:42:07"),
+			ASCII(0x013b, "John Artist"),
+		},
+		Exif: []Entry{
+			ASCII(0x9003, "2023:06:14 18:42:07"),
+			ASCII(0xa431, "SERIAL-0042"),
+		},
+		GPS: []Entry{
+			ASCII(0x0001, "N"),
+			Rational(le, 0x0002, 51, 1, 30, 1, 1234, 100),
+			ASCII(0x0003, "W"),
+			Rational(le, 0x0004, 0, 1, 7, 1, 3901, 100),
+			Undefined(0x001b, []byte("ASCII\x00\x00\x00NETWORK-Somewhere")),
+		},
+	}
+}
+
+// JPEG returns a baseline JPEG of the given size with no metadata
+func JPEG(width, height int) []byte {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, gradient(width, height), nil); err != nil {
+		panic(err)
+	}
+	return b.Bytes()
+}
+
+// WithSegment inserts an APPn or COM segment with payload right after the
+// SOI marker of a JPEG
+func WithSegment(jpeg []byte, marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	out := append([]byte(nil), jpeg[:2]...)
+	out = append(out, seg...)
+	out = append(out, payload...)
+	return append(out, jpeg[2:]...)
+}
+
+// EXIFJPEG returns a JPEG with tiff in an APP1 EXIF segment
+func EXIFJPEG(tiff []byte) []byte {
+	return WithSegment(JPEG(16, 8), 0xE1, append([]byte("Exif\x00\x00"), tiff...))
+}
+
+// XMP returns the payload of an APP1 XMP segment holding an xpacket with
+// the given rdf:Description attributes and child elements
+func XMP(attributes, elements string) []byte {
+	return []byte("http://ns.adobe.com/xap/1.0/\x00" +
+		"<?xpacket begin='\xef\xbb\xbf' id='W5M0MpCehiHzreSzNTczkc9d'?>" +
+		`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description rdf:about="" ` + attributes + `>` + elements + `</rdf:Description>` +
+		`</rdf:RDF></x:xmpmeta><?xpacket end='w'?>`)
+}
+
+// Photoshop returns the payload of an APP13 segment holding the given
+// image resource blocks
+func Photoshop(resources ...[]byte) []byte {
+	return append([]byte("Photoshop 3.0\x00"), bytes.Join(resources, nil)...)
+}
+
+// Resource returns an 8BIM image resource block with an empty name
+func Resource(id uint16, data []byte) []byte {
+	out := append([]byte("8BIM"), byte(id>>8), byte(id), 0, 0)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(data)))
+	out = append(out, data...)
+	if len(data)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// Dataset returns an IPTC-IIM dataset
+func Dataset(record, number byte, value string) []byte {
+	out := []byte{0x1c, record, number}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(value)))
+	return append(out, value...)
+}
+
+// MPImage is a secondary image of a file built by MPF
+type MPImage struct {
+	Type uint32 // MP type, e.g. 0x020002 for a disparity image
+	Data []byte
+}
+
+// MPF returns primary, a JPEG, with an APP2 MPF index listing it and
+// images, which are appended after it
+func MPF(primary []byte, images ...MPImage) []byte {
+	le := binary.LittleEndian
+	n := 1 + len(images)
+	index := []byte("MPF\x00II*\x00\x08\x00\x00\x00")
+	index = le.AppendUint16(index, 3)
+	index = append(index, Entry{0xB000, 7, 4, []byte("0100")}.inline()...)
+	index = append(index, Long(le, 0xB001, uint32(n)).inline()...)
+	entries := 8 + 2 + 3*12 + 4 // after the IFD, from the MPF header
+	index = le.AppendUint16(index, 0xB002)
+	index = le.AppendUint16(index, 7)
+	index = le.AppendUint32(index, uint32(16*n))
+	index = le.AppendUint32(index, uint32(entries))
+	index = le.AppendUint32(index, 0) // no next IFD
+
+	// The segment goes right after the SOI, so the MPF header, which the
+	// offsets count from, is 10 bytes into the file
+	size := uint32(len(primary) + 4 + len(index) + 16*n)
+	index = le.AppendUint32(index, 0x20030000) // representative baseline primary
+	index = le.AppendUint32(index, size)
+	index = le.AppendUint32(index, 0)
+	index = append(index, 0, 0, 0, 0)
+	offset := size - 10
+	for _, image := range images {
+		index = le.AppendUint32(index, image.Type)
+		index = le.AppendUint32(index, uint32(len(image.Data)))
+		index = le.AppendUint32(index, offset)
+		index = append(index, 0, 0, 0, 0)
+		offset += uint32(len(image.Data))
+	}
+	out := WithSegment(primary, 0xE2, index)
+	for _, image := range images {
+		out = append(out, image.Data...)
+	}
+	return out
+}
+
+// inline returns the little-endian 12-byte IFD entry for a value of at
+// most four bytes
+func (e Entry) inline() []byte {
+	le := binary.LittleEndian
+	out := le.AppendUint16(nil, e.Tag)
+	out = le.AppendUint16(out, e.Type)
+	out = le.AppendUint32(out, e.Count)
+	v := make([]byte, 4)
+	copy(v, e.Value)
+	return append(out, v...)
+}
+
+// PNG returns an RGBA PNG of the given size with no metadata
+func PNG(width, height int) []byte {
+	var b bytes.Buffer
+	if err := png.Encode(&b, gradient(width, height)); err != nil {
+		panic(err)
+	}
+	return b.Bytes()
+}
+
+// Chunk returns a PNG chunk with its length and CRC
+func Chunk(typ string, data []byte) []byte {
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	out = append(out, typ...)
+	out = append(out, data...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out[4:]))
+}
+
+// WithChunks inserts chunks right after the IHDR chunk of a PNG
+func WithChunks(png []byte, chunks ...[]byte) []byte {
+	const ihdrEnd = 8 + 8 + 13 + 4
+	out := append([]byte(nil), png[:ihdrEnd]...)
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	return append(out, png[ihdrEnd:]...)
+}
+
+// Box returns an ISO-BMFF box with the concatenated payloads
+func Box(typ string, payload ...[]byte) []byte {
+	p := bytes.Join(payload, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(p)))
+	return append(append(out, typ...), p...)
+}
+
+// HEIC returns a minimal HEIF file whose only item is an Exif item holding
+// payload, stored in an mdat box or, with idat set, inside the meta box
+func HEIC(payload []byte, idat bool) []byte {
+	return HEICItems(idat, HEICItem{Type: "Exif", Data: payload})
+}
+
+// HEICItem is one item of a file built by HEICItems
+type HEICItem struct {
+	Type    string // item_type, e.g. "Exif", "mime" or "hvc1"
+	Content string // content_type of "mime" items
+	Data    []byte
+	// AuxOf is the number of the item this one is auxiliary to, which adds
+	// an auxl reference and an auxC property of type Aux
+	AuxOf int
+	Aux   string
+}
+
+// HEICItems returns a minimal HEIF file holding items, numbered from 1.
+// Their data is stored in an mdat box or, with idat set, inside the meta
+// box.
+func HEICItems(idat bool, items ...HEICItem) []byte {
+	ftyp := Box("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	var infes, data [][]byte
+	for i, item := range items {
+		infe := []byte{2, 0, 0, 0, byte((i + 1) >> 8), byte(i + 1), 0, 0}
+		infe = append(append(infe, item.Type...), 0)
+		if item.Type == "mime" {
+			infe = append(append(infe, item.Content...), 0)
+		}
+		infes = append(infes, Box("infe", infe))
+		data = append(data, item.Data)
+	}
+	iinf := Box("iinf", []byte{0, 0, 0, 0, byte(len(items) >> 8), byte(len(items))}, bytes.Join(infes, nil))
+	hdlr := Box("hdlr", make([]byte, 4), []byte("\x00\x00\x00\x00pict"), make([]byte, 13))
+
+	var refs, properties [][]byte
+	var ipma, iref []byte // iref and iprp, when any item is auxiliary
+	for i, item := range items {
+		if item.AuxOf == 0 {
+			continue
+		}
+		refs = append(refs, Box("auxl", []byte{byte((i + 1) >> 8), byte(i + 1), 0, 1, byte(item.AuxOf >> 8), byte(item.AuxOf)}))
+		properties = append(properties, Box("auxC", make([]byte, 4), []byte(item.Aux), []byte{0}))
+		ipma = append(ipma, byte((i+1)>>8), byte(i+1), 1, 0x80|byte(len(properties)))
+	}
+	if refs != nil {
+		ipma = append(binary.BigEndian.AppendUint32(make([]byte, 4), uint32(len(refs))), ipma...)
+		iprp := Box("iprp", Box("ipco", properties...), Box("ipma", ipma))
+		iref = append(Box("iref", make([]byte, 4), bytes.Join(refs, nil)), iprp...)
+	}
+	iloc := func(offset uint32) []byte {
+		var version byte
+		if idat {
+			version = 1
+		}
+		b := []byte{version, 0, 0, 0, 0x44, 0x00, byte(len(items) >> 8), byte(len(items))}
+		for i, item := range items {
+			b = append(b, byte((i+1)>>8), byte(i+1))
+			if idat {
+				b = append(b, 0, 1) // construction_method 1, the idat box
+			}
+			b = append(b, 0, 0, 0, 1)
+			b = binary.BigEndian.AppendUint32(b, offset)
+			b = binary.BigEndian.AppendUint32(b, uint32(len(item.Data)))
+			offset += uint32(len(item.Data))
+		}
+		return Box("iloc", b)
+	}
+	if idat {
+		return append(ftyp, Box("meta", make([]byte, 4), hdlr, iinf, iref, iloc(0), Box("idat", data...))...)
+	}
+	meta := Box("meta", make([]byte, 4), hdlr, iinf, iref, iloc(0))
+	offset := uint32(len(ftyp) + len(meta) + 8)
+	meta = Box("meta", make([]byte, 4), hdlr, iinf, iref, iloc(offset))
+	return append(append(ftyp, meta...), Box("mdat", data...)...)
+}
+
+// WebP returns an extended WebP file with metadata chunks, built with
+// WebPChunk, between the VP8X chunk and the image. The VP8X flags are set
+// for the EXIF, XMP and ICCP chunks among them. The image chunk holds only
+// a VP8L header, which is all the package reads of it.
+func WebP(chunks ...[]byte) []byte {
+	vp8x := make([]byte, 10) // 1x1 canvas: the sizes are stored less one
+	for _, c := range chunks {
+		switch string(c[:4]) {
+		case "ICCP":
+			vp8x[0] |= 0x20
+		case "EXIF":
+			vp8x[0] |= 0x08
+		case "XMP ":
+			vp8x[0] |= 0x04
+		}
+	}
+	body := append([]byte("WEBP"), WebPChunk("VP8X", vp8x)...)
+	body = append(body, bytes.Join(chunks, nil)...)
+	body = append(body, WebPChunk("VP8L", []byte{0x2f, 0, 0, 0, 0})...)
+	out := append([]byte("RIFF"), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(body)))
+	return append(out, body...)
+}
+
+// WebPChunk returns a RIFF chunk with its header and padding
+func WebPChunk(fourCC string, payload []byte) []byte {
+	out := append([]byte(fourCC), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(payload)))
+	out = append(out, payload...)
+	if len(payload)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// gradient returns an image with varied pixels, so encoders don't
+// collapse it into a degenerate stream
+func gradient(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(16 * x), uint8(32 * y), uint8(x ^ y), 255})
+		}
+	}
+	return img
+}
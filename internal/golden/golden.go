@@ -0,0 +1,259 @@
+// Package golden holds the golden corpus in testdata/golden: input
+// fixtures under inputs, and for every fixture and preset the SHA-256 of
+// the sanitized output and its Report as JSON under expected. The test of
+// this package checks the library against the corpus, and
+// internal/cmd/regen-golden rewrites it, printing what changed, so every
+// change in behavior shows up in review as a change to the corpus.
+package golden
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/renix-codex/exifremover"
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// Preset is a Config the corpus is run under, by a name used in file names
+type Preset struct {
+	Name   string
+	Config exifremover.Config
+}
+
+// Presets are the configs every fixture is run under. Renaming one or
+// changing its Config moves every golden file it names.
+var Presets = []Preset{
+	{"all", exifremover.Config{RemoveAll: true}},
+	{"gps", exifremover.Config{RemoveGPSInfo: true}},
+	{"camera", exifremover.Config{RemoveCameraInfo: true, RemoveUserInfo: true}},
+	{"dates", exifremover.Config{RemoveDateTime: true}},
+	{"rebuild", exifremover.Config{RemoveGPSInfo: true, RemoveComments: true, RemoveTextChunks: true, RemoveIPTC: true, RemoveThumbnail: true}},
+	{"scanned-document", exifremover.ScannedDocumentConfig()},
+	{"strict", exifremover.Config{RemoveCameraInfo: true, RemoveGPSInfo: true, RemoveCopyright: true, RemoveDateTime: true, RemoveUserInfo: true, RemoveTechnicalDetail: true, RemoveEditingInfo: true, RemoveFaceRegions: true, RemoveIPTC: true, RemoveComments: true, RemoveTextChunks: true, RemoveVendorSegments: true}},
+}
+
+// Inputs builds the input fixtures by file name. regen-golden writes the
+// ones missing from inputs; after that they are read from disk, so a
+// change to the fixture package or an encoder doesn't move the corpus.
+func Inputs() map[string][]byte {
+	tiff := fixture.Sample().Bytes()
+	xmp := fixture.XMP(`xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/" xmlns:exif="http://ns.adobe.com/exif/1.0/" photoshop:City="Shelbyville" exif:GPSLatitude="51,30.12N"`, "")
+	iptc := fixture.Photoshop(fixture.Resource(0x0404, append(
+		fixture.Dataset(2, 80, "John Artist"),
+		fixture.Dataset(2, 90, "Shelbyville")...,
+	)))
+	jpeg := fixture.WithSegment(fixture.JPEG(16, 16), 0xED, iptc)
+	jpeg = fixture.WithSegment(jpeg, 0xFE, []byte("golden comment"))
+	jpeg = fixture.WithSegment(jpeg, 0xE1, xmp)
+	jpeg = fixture.WithSegment(jpeg, 0xE1, append([]byte("Exif\x00\x00"), tiff...))
+
+	png := fixture.WithChunks(fixture.PNG(16, 16),
+		fixture.Chunk("eXIf", tiff),
+		fixture.Chunk("tEXt", []byte("Author\x00John Artist")),
+		fixture.Chunk("tEXt", []byte("Creation Time\x002023-06-14")),
+		fixture.Chunk("tEXt", []byte("Comment\x00golden comment")),
+	)
+	return map[string][]byte{
+		"sample.jpg":  jpeg,
+		"sample.png":  png,
+		"sample.tif":  tiff,
+		"sample.webp": fixture.WebP(fixture.WebPChunk("EXIF", tiff), fixture.WebPChunk("XMP ", xmp)),
+		"sample.heic": fixture.HEIC(append([]byte{0, 0, 0, 0}, tiff...), false),
+	}
+}
+
+// Result is what the corpus records of one fixture under one preset
+type Result struct {
+	SHA256 string          `json:"sha256,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Report json.RawMessage `json:"report,omitempty"`
+}
+
+// Run sanitizes input under p
+func Run(input []byte, p Preset) (Result, error) {
+	out, report, err := exifremover.RemoveEXIFFromBytesReport(input, p.Config)
+	var r Result
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		sum := sha256.Sum256(out)
+		r.SHA256 = hex.EncodeToString(sum[:])
+	}
+	if report != nil {
+		data, err := json.Marshal(report)
+		if err != nil {
+			return Result{}, err
+		}
+		r.Report = data
+	}
+	return r, nil
+}
+
+// Marshal encodes r as it is stored, indented for diffs in review
+func Marshal(r Result) ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := json.Indent(&b, data, "", "\t"); err != nil {
+		return nil, err
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// Load reads the Result stored at path
+func Load(path string) (Result, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Result{}, err
+	}
+	var r Result
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Result{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Path returns the golden file of the named fixture under the named
+// preset in the corpus at dir
+func Path(dir, input, preset string) string {
+	return filepath.Join(dir, "expected", input+"."+preset+".json")
+}
+
+// Fixtures lists the input fixtures of the corpus at dir, sorted
+func Fixtures(dir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, "inputs"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// report is the part of a Report that Diff describes, decoded from its
+// JSON with the carriers and categories left as their names
+type report struct {
+	BytesWritten int64
+	Removed      []struct {
+		Carrier    string
+		Tag        uint16
+		Name       string
+		Categories []string
+		Strength   int
+		Size       int64
+		Value      string
+		Count      int
+	}
+	Warnings []struct {
+		Code   string
+		Detail string
+	}
+}
+
+// Diff describes how got differs from want, one line per difference: the
+// output hash and size, errors, each item removed differently and each
+// warning gained or lost. It returns nil when they are the same.
+func Diff(want, got Result) []string {
+	var lines []string
+	if want.Error != got.Error {
+		lines = append(lines, fmt.Sprintf("error: %q, now %q", want.Error, got.Error))
+	}
+	if want.SHA256 != got.SHA256 {
+		lines = append(lines, fmt.Sprintf("output: %s, now %s", short(want.SHA256), short(got.SHA256)))
+	}
+	var w, g report
+	json.Unmarshal(want.Report, &w)
+	json.Unmarshal(got.Report, &g)
+	if w.BytesWritten != g.BytesWritten {
+		lines = append(lines, fmt.Sprintf("size: %d bytes, now %d (%+d)", w.BytesWritten, g.BytesWritten, g.BytesWritten-w.BytesWritten))
+	}
+
+	// Items are matched by carrier and name, so a change in how one is
+	// removed reads as one line rather than a removal and an addition
+	items := func(r report) (map[string][]string, []string) {
+		m := make(map[string][]string)
+		var keys []string
+		for _, item := range r.Removed {
+			key := item.Carrier + " " + item.Name
+			if _, ok := m[key]; !ok {
+				keys = append(keys, key)
+			}
+			m[key] = append(m[key], fmt.Sprintf("%s, strength %d, %d bytes", strings.Join(item.Categories, "+"), item.Strength, item.Size))
+		}
+		return m, keys
+	}
+	wantItems, wantKeys := items(w)
+	gotItems, gotKeys := items(g)
+	for _, key := range wantKeys {
+		switch was, now := wantItems[key], gotItems[key]; {
+		case now == nil:
+			lines = append(lines, fmt.Sprintf("no longer removed: %s (%s)", key, strings.Join(was, "; ")))
+		case strings.Join(was, "; ") != strings.Join(now, "; "):
+			lines = append(lines, fmt.Sprintf("removed differently: %s: %s, now %s", key, strings.Join(was, "; "), strings.Join(now, "; ")))
+		}
+	}
+	for _, key := range gotKeys {
+		if wantItems[key] == nil {
+			lines = append(lines, fmt.Sprintf("now removed: %s (%s)", key, strings.Join(gotItems[key], "; ")))
+		}
+	}
+
+	warnings := func(r report) map[string]bool {
+		m := make(map[string]bool)
+		for _, warning := range r.Warnings {
+			m[warning.Code+": "+warning.Detail] = true
+		}
+		return m
+	}
+	wantWarnings, gotWarnings := warnings(w), warnings(g)
+	for _, warning := range sortedKeys(wantWarnings) {
+		if !gotWarnings[warning] {
+			lines = append(lines, "warning gone: "+warning)
+		}
+	}
+	for _, warning := range sortedKeys(gotWarnings) {
+		if !wantWarnings[warning] {
+			lines = append(lines, "new warning: "+warning)
+		}
+	}
+
+	if lines == nil && !bytes.Equal(want.Report, got.Report) {
+		lines = append(lines, "report: other fields changed")
+	}
+	return lines
+}
+
+// short abbreviates a hash for Diff
+func short(sum string) string {
+	if sum == "" {
+		return "none"
+	}
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	return sum
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
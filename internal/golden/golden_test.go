@@ -0,0 +1,94 @@
+package golden
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// corpus is the corpus directory, from this package's
+const corpus = "../../testdata/golden"
+
+// TestGolden checks every fixture under every preset against its golden
+// file, and that no golden file is left without a fixture or preset
+func TestGolden(t *testing.T) {
+	inputs, err := Fixtures(corpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no fixtures in the corpus")
+	}
+	want := make(map[string]bool)
+	for _, name := range inputs {
+		input, err := os.ReadFile(filepath.Join(corpus, "inputs", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range Presets {
+			path := Path(corpus, name, p.Name)
+			want[filepath.Base(path)] = true
+			expected, err := Load(path)
+			if err != nil {
+				t.Errorf("%s under %s: %v; run go run ./internal/cmd/regen-golden", name, p.Name, err)
+				continue
+			}
+			got, err := Run(input, p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, _ := Marshal(expected)
+			current, err := Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(stored, current) {
+				t.Errorf("%s under %s differs from %s; if intended, run go run ./internal/cmd/regen-golden:\n\t%s",
+					name, p.Name, path, strings.Join(Diff(expected, got), "\n\t"))
+			}
+		}
+	}
+
+	goldens, err := os.ReadDir(filepath.Join(corpus, "expected"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range goldens {
+		if !want[e.Name()] {
+			t.Errorf("expected/%s has no fixture or preset", e.Name())
+		}
+	}
+}
+
+// TestInputsStored checks that every fixture Inputs builds is in the
+// corpus, so one added to Inputs isn't left unchecked until regenerated
+func TestInputsStored(t *testing.T) {
+	for name := range Inputs() {
+		if _, err := os.Stat(filepath.Join(corpus, "inputs", name)); err != nil {
+			t.Errorf("fixture %s: %v; run go run ./internal/cmd/regen-golden", name, err)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	want := Result{SHA256: "aaaa", Report: []byte(`{"BytesWritten":100,"Removed":[{"Carrier":"EXIF","Name":"Make","Categories":["CameraInfo"],"Strength":2,"Size":6},{"Carrier":"EXIF","Name":"Model","Strength":2,"Size":8}],"Warnings":[{"Code":"thumbnail","Detail":"kept"}]}`)}
+	got := Result{SHA256: "bbbb", Report: []byte(`{"BytesWritten":90,"Removed":[{"Carrier":"EXIF","Name":"Make","Categories":["CameraInfo"],"Strength":3,"Size":6},{"Carrier":"XMP","Name":"City","Strength":3,"Size":4}]}`)}
+	lines := strings.Join(Diff(want, got), "\n")
+	for _, s := range []string{
+		"output: aaaa, now bbbb",
+		"size: 100 bytes, now 90 (-10)",
+		"removed differently: EXIF Make",
+		"no longer removed: EXIF Model",
+		"now removed: XMP City",
+		"warning gone: thumbnail: kept",
+	} {
+		if !strings.Contains(lines, s) {
+			t.Errorf("Diff lacks %q:\n%s", s, lines)
+		}
+	}
+	if lines := Diff(want, want); lines != nil {
+		t.Errorf("Diff of equal results: %q", lines)
+	}
+}
@@ -0,0 +1,234 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// photoshopPrefix is the signature that starts a JPEG APP13 segment holding
+// Photoshop image resource blocks
+var photoshopPrefix = []byte("Photoshop 3.0\x00")
+
+// Photoshop image resources removed with IPTC: the IPTC-IIM record itself
+// and the digest of it, which would no longer match
+const (
+	resourceIPTC       = 0x0404
+	resourceIPTCDigest = 0x0425
+)
+
+// iptcDataset describes an IPTC-IIM dataset the library acts on
+type iptcDataset struct {
+	Record, Dataset byte
+	Name            string
+	Exiftool        string     // exiftool group:tag name for the same field
+	Categories      []Category // removed when any of these is enabled
+}
+
+// iptcDatasets is the decision table for the datasets of an IPTC-IIM
+// record. The location hierarchy names where the photo was taken, so it
+// goes with GPSInfo; Config.RemoveIPTC drops the whole record instead.
+var iptcDatasets = []iptcDataset{
+	{2, 90, "City", "IPTC:City", []Category{CategoryGPSInfo}},
+	{2, 92, "Sub-location", "IPTC:Sub-location", []Category{CategoryGPSInfo}},
+	{2, 95, "Province-State", "IPTC:Province-State", []Category{CategoryGPSInfo}},
+	{2, 100, "Country-PrimaryLocationCode", "IPTC:Country-PrimaryLocationCode", []Category{CategoryGPSInfo}},
+	{2, 101, "Country-PrimaryLocationName", "IPTC:Country-PrimaryLocationName", []Category{CategoryGPSInfo}},
+}
+
+// removed reports whether any of the dataset's categories is enabled
+func (d iptcDataset) removed(config Config) bool {
+	for _, c := range d.Categories {
+		if c.enabled(config) {
+			return true
+		}
+	}
+	return false
+}
+
+// tag returns the dataset's record and number as one value, 2:90 as 0x025A
+func (d iptcDataset) tag() uint16 {
+	return uint16(d.Record)<<8 | uint16(d.Dataset)
+}
+
+// modifiesIPTC reports whether config removes anything from an APP13
+// segment
+func modifiesIPTC(config Config) bool {
+	if config.RemoveIPTC {
+		return true
+	}
+	for _, d := range iptcDatasets {
+		if d.removed(config) {
+			return true
+		}
+	}
+	return false
+}
+
+// photoshopResource is one 8BIM image resource block of an APP13 payload
+type photoshopResource struct {
+	ID     uint16
+	Header []byte // signature, ID and padded Pascal name
+	Data   []byte // the resource data, without the size field and pad byte
+	Raw    []byte // the whole block as stored
+}
+
+// parsePhotoshopResources splits an APP13 payload into its resource
+// blocks. ok is false when the payload isn't a well-formed resource list.
+func parsePhotoshopResources(data []byte) (resources []photoshopResource, ok bool) {
+	if !bytes.HasPrefix(data, photoshopPrefix) {
+		return nil, false
+	}
+	for pos := len(photoshopPrefix); pos < len(data); {
+		start := pos
+		if len(data)-pos < 7 || !bytes.Equal(data[pos:pos+4], []byte("8BIM")) {
+			return nil, false
+		}
+		id := binary.BigEndian.Uint16(data[pos+4 : pos+6])
+		nameLen := int(data[pos+6])
+		pos += 6 + (nameLen+2)&^1 // Pascal name padded to even, length byte included
+		if len(data)-pos < 4 {
+			return nil, false
+		}
+		header := data[start:pos]
+		size := int64(binary.BigEndian.Uint32(data[pos : pos+4]))
+		end := int64(pos) + 4 + (size+1)&^1
+		if end > int64(len(data)) {
+			// Some writers omit the final pad byte
+			if int64(pos)+4+size != int64(len(data)) {
+				return nil, false
+			}
+			end = int64(len(data))
+		}
+		resources = append(resources, photoshopResource{ID: id, Header: header, Data: data[pos+4 : int64(pos)+4+size], Raw: data[start:end]})
+		pos = int(end)
+	}
+	return resources, true
+}
+
+// removeIPTCResources returns an APP13 payload without the IPTC data config
+// removes: the whole IPTC resource block under RemoveIPTC, otherwise the
+// datasets of iptcDatasets whose categories are enabled. Every other
+// resource (clipping paths, resolution info) is kept byte for byte, except
+// the IPTC digest, which would no longer match an edited record. ok is
+// false when the payload isn't a well-formed resource list, in which case
+// it is left alone; empty reports whether no resources remain, so the
+// segment can be dropped.
+func removeIPTCResources(data []byte, config Config, report *Report) (out []byte, empty, ok bool) {
+	resources, ok := parsePhotoshopResources(data)
+	if !ok {
+		return data, false, false
+	}
+	stale := config.RemoveIPTC // the digest no longer matches the record
+	var kept []photoshopResource
+	for _, res := range resources {
+		if res.ID == resourceIPTC {
+			if config.RemoveIPTC {
+				report.remove(RemovedItem{Carrier: CarrierIPTC, Tag: res.ID, Name: "IPTC-IIM", Strength: RemovalEliminated, Size: int64(len(res.Data))})
+				continue
+			}
+			if record, changed := removeIPTCDatasets(res.Data, config, report); changed {
+				res.Raw = res.withData(record)
+				stale = true
+			}
+		}
+		kept = append(kept, res)
+	}
+
+	out = append([]byte(nil), photoshopPrefix...)
+	remaining := 0
+	for _, res := range kept {
+		if res.ID == resourceIPTCDigest && stale {
+			continue
+		}
+		out = append(out, res.Raw...)
+		remaining++
+	}
+	return out, remaining == 0, true
+}
+
+// traceIPTC traces the datasets of an APP13 payload that config keeps
+// whole, leaving the payload as it is
+func traceIPTC(data []byte, config Config, report *Report) {
+	resources, ok := parsePhotoshopResources(data)
+	if !ok {
+		return
+	}
+	for _, res := range resources {
+		if res.ID == resourceIPTC {
+			removeIPTCDatasets(res.Data, config, report)
+		}
+	}
+}
+
+// withData returns the resource block rewritten to hold data
+func (res photoshopResource) withData(data []byte) []byte {
+	out := append([]byte(nil), res.Header...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(data)))
+	out = append(out, data...)
+	if len(data)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// removeIPTCDatasets returns an IPTC-IIM record without the datasets
+// config removes, and whether any were. A record that doesn't parse is
+// returned unchanged rather than cut at the damage.
+func removeIPTCDatasets(record []byte, config Config, report *Report) ([]byte, bool) {
+	type span struct{ start, end int }
+	var removed []span
+	for pos := 0; pos < len(record); {
+		if len(record)-pos < 5 || record[pos] != 0x1c {
+			return record, false
+		}
+		start := pos
+		rec, num := record[pos+1], record[pos+2]
+		size := int(binary.BigEndian.Uint16(record[pos+3 : pos+5]))
+		pos += 5
+		if size&0x8000 != 0 {
+			// Extended dataset: the low bits give the size of the
+			// length field that follows
+			n := size & 0x7fff
+			if n > 4 || len(record)-pos < n {
+				return record, false
+			}
+			size = 0
+			for _, b := range record[pos : pos+n] {
+				size = size<<8 | int(b)
+			}
+			pos += n
+		}
+		if size < 0 || size > len(record)-pos {
+			return record, false
+		}
+		pos += size
+		known := false
+		for _, d := range iptcDatasets {
+			if d.Record != rec || d.Dataset != num {
+				continue
+			}
+			known = true
+			if d.removed(config) {
+				report.remove(RemovedItem{Carrier: CarrierIPTC, Tag: d.tag(), Name: d.Name, Categories: d.Categories, Strength: RemovalEliminated, Size: int64(size)})
+				removed = append(removed, span{start, pos})
+			} else {
+				report.traceKept(CarrierIPTC, d.tag(), d.Name, "no enabled category")
+			}
+			break
+		}
+		if !known {
+			report.traceKept(CarrierIPTC, uint16(rec)<<8|uint16(num), fmt.Sprintf("dataset %d:%d", rec, num), "in no category")
+		}
+	}
+	if len(removed) == 0 {
+		return record, false
+	}
+	out := make([]byte, 0, len(record))
+	from := 0
+	for _, s := range removed {
+		out = append(out, record[from:s.start]...)
+		from = s.end
+	}
+	return append(out, record[from:]...), true
+}
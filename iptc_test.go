@@ -0,0 +1,146 @@
+package exifremover
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// locationJPEG returns a JPEG describing its location in every carrier: the
+// EXIF GPS IFD, the IPTC location datasets and XMP location properties,
+// next to fields that must survive RemoveGPSInfo
+func locationJPEG() []byte {
+	record := bytes.Join([][]byte{
+		fixture.Dataset(2, 120, "Kept caption"),
+		fixture.Dataset(2, 90, "Springfield"),
+		fixture.Dataset(2, 92, "Evergreen Terrace"),
+		fixture.Dataset(2, 95, "Oregon"),
+		fixture.Dataset(2, 100, "USA"),
+		fixture.Dataset(2, 101, "United States"),
+	}, nil)
+	app13 := fixture.Photoshop(
+		fixture.Resource(0x0425, bytes.Repeat([]byte{0xaa}, 16)),
+		fixture.Resource(0x0404, record),
+		fixture.Resource(0x07d0, []byte("clipping path")),
+	)
+	xmp := fixture.XMP(
+		`xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/" xmlns:exif="http://ns.adobe.com/exif/1.0/" photoshop:City="Shelbyville" photoshop:State="Kentucky" dc:format="image/jpeg" `+
+			`exif:GPSLatitude="51,30.2057N" exif:GPSLongitude="0,7.6502W" exif:GPSAltitudeRef="0" exif:GPSAltitude="8848/10" exif:ExposureTime="1/60"`,
+		`<photoshop:Country>Freedonia</photoshop:Country>`+
+			`<exif:GPSDestLatitude>40,26.767N</exif:GPSDestLatitude>`+
+			`<exif:GPSTimeStamp>2023-06-14T18:42:07Z</exif:GPSTimeStamp>`+
+			`<Iptc4xmpCore:Location>Main Street</Iptc4xmpCore:Location>`+
+			`<Iptc4xmpCore:CountryCode>FRE</Iptc4xmpCore:CountryCode>`+
+			`<Iptc4xmpExt:LocationShown><rdf:Bag><rdf:li Iptc4xmpExt:City="Capital City"/></rdf:Bag></Iptc4xmpExt:LocationShown>`+
+			`<dc:title>Kept title</dc:title>`,
+	)
+	in := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	in = fixture.WithSegment(in, 0xE1, xmp)
+	return fixture.WithSegment(in, 0xED, app13)
+}
+
+// locationStrings are the place names locationJPEG carries
+var locationStrings = []string{
+	"NETWORK-Somewhere", "Springfield", "Evergreen Terrace", "Oregon", "USA",
+	"United States", "Shelbyville", "Kentucky", "Freedonia", "Main Street",
+	"FRE", "Capital City", "51,30.2057N", "0,7.6502W", "8848/10", "40,26.767N",
+	"2023-06-14T18:42:07Z",
+}
+
+func TestRemoveGPSInfoClearsEveryLocationCarrier(t *testing.T) {
+	in := locationJPEG()
+	for _, s := range locationStrings {
+		if !bytes.Contains(in, []byte(s)) {
+			t.Fatalf("fixture lacks %q", s)
+		}
+	}
+
+	out, report := sanitize(t, in, Config{RemoveGPSInfo: true})
+	assertAbsent(t, out, locationStrings...)
+	for _, kept := range []string{"Kept caption", "Kept title", "clipping path", "CanonMake", "image/jpeg", `exif:ExposureTime="1/60"`} {
+		if !bytes.Contains(out, []byte(kept)) {
+			t.Errorf("%q was removed", kept)
+		}
+	}
+	if bytes.Contains(out, bytes.Repeat([]byte{0xaa}, 16)) {
+		t.Error("the digest of the edited IPTC record was kept")
+	}
+	if m := inspect(t, out); m.HasGPS || m.HasPosition {
+		t.Errorf("GPS IFD survived: %+v", m)
+	}
+
+	iptc := 0
+	for _, item := range report.Removed {
+		if item.Carrier == CarrierIPTC {
+			iptc++
+		}
+	}
+	if iptc != 5 {
+		t.Errorf("reported %d IPTC datasets removed, want 5: %v", iptc, report.Removed)
+	}
+}
+
+// TestRemoveGPSInfoClearsXMPGPS checks every exif:GPS property, written
+// as an attribute and as an element, against RemoveGPSInfo
+func TestRemoveGPSInfoClearsXMPGPS(t *testing.T) {
+	var attributes, elements, values []string
+	for i, p := range xmpProperties {
+		name := strings.TrimPrefix(p.Name, "exif:")
+		if !strings.HasPrefix(p.Name, "exif:GPS") {
+			continue
+		}
+		attribute, element := fmt.Sprintf("attr-%02d", i), fmt.Sprintf("elem-%02d", i)
+		attributes = append(attributes, fmt.Sprintf(`exif:%s="%s"`, name, attribute))
+		elements = append(elements, fmt.Sprintf(`<exif:%s>%s</exif:%s>`, name, element, name))
+		values = append(values, attribute, element)
+	}
+	if len(values) < 2*27 {
+		t.Fatalf("only %d exif:GPS properties in the table", len(values)/2)
+	}
+	xmp := fixture.XMP(`xmlns:exif="http://ns.adobe.com/exif/1.0/" `+strings.Join(attributes, " "), strings.Join(elements, ""))
+	in := fixture.WithSegment(fixture.JPEG(8, 8), 0xE1, xmp)
+	out, report := sanitize(t, in, Config{RemoveGPSInfo: true})
+	assertAbsent(t, out, values...)
+	if len(report.Removed) != len(values) {
+		t.Errorf("%d removals reported, want %d", len(report.Removed), len(values))
+	}
+	if out, _ := sanitize(t, in, Config{RemoveCameraInfo: true}); !bytes.Equal(out, in) {
+		t.Error("exif:GPS properties removed without RemoveGPSInfo")
+	}
+}
+
+func TestIPTCWithoutLocationChanges(t *testing.T) {
+	in := locationJPEG()
+	if out, _ := sanitize(t, in, Config{RemoveCameraInfo: true}); !bytes.Contains(out, []byte("Springfield")) || !bytes.Contains(out, bytes.Repeat([]byte{0xaa}, 16)) {
+		t.Error("IPTC record edited without RemoveGPSInfo")
+	}
+
+	out, _ := sanitize(t, in, Config{RemoveIPTC: true})
+	assertAbsent(t, out, "Kept caption", "Springfield")
+	if !bytes.Contains(out, []byte("clipping path")) {
+		t.Error("RemoveIPTC dropped the clipping path")
+	}
+}
+
+func TestIPTCMalformedRecordPassedThrough(t *testing.T) {
+	record := append(fixture.Dataset(2, 90, "Springfield"), 0x1c, 2) // truncated dataset
+	in := fixture.WithSegment(fixture.JPEG(8, 8), 0xED, fixture.Photoshop(fixture.Resource(0x0404, record)))
+	if out, _ := sanitize(t, in, Config{RemoveGPSInfo: true}); !bytes.Equal(out, in) {
+		t.Error("a record that doesn't parse was edited")
+	}
+}
+
+func TestExiftoolTagsCoverLocation(t *testing.T) {
+	names := map[string]bool{}
+	for _, n := range ExiftoolTags(CategoryGPSInfo) {
+		names[n] = true
+	}
+	for _, want := range []string{"GPS:*", "IPTC:City", "IPTC:Sub-location", "IPTC:Province-State", "IPTC:Country-PrimaryLocationName", "XMP-iptcCore:Location", "XMP-photoshop:City", "XMP-photoshop:State", "XMP-photoshop:Country", "XMP-exif:GPSLatitude", "XMP-exif:GPSAltitude"} {
+		if !names[want] {
+			t.Errorf("ExiftoolTags(GPSInfo) lacks %s", want)
+		}
+	}
+}
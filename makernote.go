@@ -0,0 +1,52 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// djiMakerNoteTags names the flight telemetry entries of a DJI maker note,
+// a plain IFD with offsets relative to the TIFF header, starting with a
+// Make entry holding "DJI"
+var djiMakerNoteTags = map[uint16]string{
+	0x0003: "SpeedX", 0x0004: "SpeedY", 0x0005: "SpeedZ",
+	0x0006: "Pitch", 0x0007: "Yaw", 0x0008: "Roll",
+	0x0009: "CameraPitch", 0x000a: "CameraYaw", 0x000b: "CameraRoll",
+}
+
+// djiMakerNoteCategories are the categories of the DJI telemetry: the
+// aircraft's speed and attitude track where it flew
+var djiMakerNoteCategories = []Category{CategoryGPSInfo}
+
+// modifyDJIMakerNote overwrites the telemetry values of the maker note
+// whose EXIF IFD entry is at pos, and reports whether it was a DJI maker
+// note. Other maker notes are left alone.
+func modifyDJIMakerNote(data []byte, pos int, order binary.ByteOrder, report *Report) bool {
+	count := int64(order.Uint32(data[pos+4 : pos+8]))
+	offset := int64(order.Uint32(data[pos+8 : pos+12]))
+	if order.Uint16(data[pos+2:pos+4]) != 7 || count < 14 || offset < 8 || offset+count > int64(len(data)) {
+		return false
+	}
+	note := int(offset)
+	numEntries := int(order.Uint16(data[note : note+2]))
+	first := note + 2
+	if order.Uint16(data[first:first+2]) != 0x0001 || order.Uint16(data[first+2:first+4]) != 2 ||
+		!bytes.HasPrefix(data[first+8:first+12], []byte("DJI")) {
+		return false
+	}
+
+	end := note + int(count)
+	for i, entry := 0, first; i < numEntries && entry+12 <= end; i, entry = i+1, entry+12 {
+		name, ok := djiMakerNoteTags[order.Uint16(data[entry:entry+2])]
+		if !ok || isEmptyEntry(data, entry, order) {
+			continue
+		}
+		size := entrySize(data, entry, order)
+		strength := RemovalUnlinked
+		if wipeValue(data, entry, order) {
+			strength = RemovalOverwritten
+		}
+		report.remove(RemovedItem{Carrier: CarrierMakerNote, Name: "DJI " + name, Categories: djiMakerNoteCategories, Strength: strength, Size: size})
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package exifremover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrMemoryUnavailable is returned when Config.MemoryGauge refuses the
+// memory a metadata buffer needs, wrapping the gauge's error; a service
+// answers it with 503 Service Unavailable
+var ErrMemoryUnavailable = errors.New("memory unavailable for metadata")
+
+// MemoryGauge accounts the metadata buffered in memory by every call that
+// shares it, for a service capping the total across its requests in
+// flight. Acquire is called with the size of each metadata buffer before
+// it is allocated, and may block until ctx, the call's context, ends or
+// refuse at once; an error fails the call with ErrMemoryUnavailable.
+// Release returns what was acquired, as soon as the buffer is done with
+// and on every return from the call, cancelled or not. A
+// *semaphore.Weighted from golang.org/x/sync satisfies it; it must be
+// sized for at least MaxMetadataSize, which bounds any one buffer.
+type MemoryGauge interface {
+	Acquire(ctx context.Context, n int64) error
+	Release(n int64)
+}
+
+// memoryAccount is one call's share of a MemoryGauge. The handlers are
+// done with a metadata buffer by the time they read the next, so only the
+// latest buffer is held; it is released before the next is acquired, so
+// a call never waits on memory it holds itself. A nil account, for a
+// Config without a gauge, accounts nothing.
+type memoryAccount struct {
+	gauge MemoryGauge
+	ctx   context.Context
+	held  int64
+}
+
+// withMemory returns config with an account of its MemoryGauge for one
+// call under ctx, and the function releasing it when the call returns
+func withMemory(ctx context.Context, config Config) (Config, func()) {
+	if config.MemoryGauge == nil {
+		return config, func() {}
+	}
+	config.memory = &memoryAccount{gauge: config.MemoryGauge, ctx: ctx}
+	return config, config.memory.release
+}
+
+// acquire accounts an n-byte buffer, releasing the last one
+func (m *memoryAccount) acquire(n int) error {
+	if m == nil {
+		return nil
+	}
+	m.release()
+	if err := m.gauge.Acquire(m.ctx, int64(n)); err != nil {
+		return fmt.Errorf("%w: %w", ErrMemoryUnavailable, err)
+	}
+	m.held = int64(n)
+	return nil
+}
+
+// release returns the bytes held to the gauge
+func (m *memoryAccount) release() {
+	if m != nil && m.held > 0 {
+		m.gauge.Release(m.held)
+		m.held = 0
+	}
+}
@@ -0,0 +1,246 @@
+package exifremover
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+var errGaugeFull = errors.New("gauge full")
+
+// capGauge is a MemoryGauge capped at limit bytes, as a weighted semaphore
+// is. Over the cap Acquire waits for a release, or with reject fails.
+type capGauge struct {
+	mu      sync.Mutex
+	limit   int64
+	reject  bool
+	held    int64
+	peak    int64
+	waits   int
+	changed chan struct{} // closed on every release
+}
+
+func newCapGauge(limit int64) *capGauge {
+	return &capGauge{limit: limit, changed: make(chan struct{})}
+}
+
+func (g *capGauge) Acquire(ctx context.Context, n int64) error {
+	for {
+		g.mu.Lock()
+		if g.held+n <= g.limit {
+			g.held += n
+			if g.held > g.peak {
+				g.peak = g.held
+			}
+			g.mu.Unlock()
+			return nil
+		}
+		if g.reject {
+			g.mu.Unlock()
+			return errGaugeFull
+		}
+		g.waits++
+		changed := g.changed
+		g.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (g *capGauge) Release(n int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.held -= n
+	if g.held < 0 {
+		panic("released more than acquired")
+	}
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+func (g *capGauge) state() (held, peak int64, waits int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.held, g.peak, g.waits
+}
+
+// slowReader delivers its input a little at a time, as a slow client does
+type slowReader struct {
+	r io.Reader
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(50 * time.Microsecond)
+	if len(p) > 2048 {
+		p = p[:2048]
+	}
+	return s.r.Read(p)
+}
+
+// stallReader delivers its input up to at, then stalls until resume is
+// closed or ctx ends, as a client that stops sending or disconnects
+type stallReader struct {
+	data    []byte
+	at      int
+	stalled chan struct{} // closed on reaching at
+	resume  chan struct{}
+	ctx     context.Context
+	once    sync.Once
+}
+
+func newStallReader(ctx context.Context, data []byte, at int) *stallReader {
+	return &stallReader{data: data, at: at, stalled: make(chan struct{}), resume: make(chan struct{}), ctx: ctx}
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	if len(s.data) == 0 {
+		return 0, io.EOF
+	}
+	if s.at == 0 {
+		s.once.Do(func() { close(s.stalled) })
+		select {
+		case <-s.resume:
+			s.at = -1
+		case <-s.ctx.Done():
+			return 0, s.ctx.Err()
+		}
+	}
+	if s.at > 0 && len(p) > s.at {
+		p = p[:s.at]
+	}
+	n := copy(p, s.data)
+	s.data = s.data[n:]
+	if s.at > 0 {
+		s.at -= n
+	}
+	return n, nil
+}
+
+// gaugePNG returns a PNG whose eXIf chunk is exifSize bytes, and the
+// offset of the middle of that chunk
+func gaugePNG(exifSize int) ([]byte, int) {
+	tiff := fixture.Sample().Bytes()
+	tiff = append(tiff, make([]byte, exifSize-len(tiff))...)
+	png := fixture.WithChunks(fixture.PNG(8, 8), fixture.Chunk("eXIf", tiff))
+	return png, bytes.Index(png, []byte("eXIf")) + exifSize/2
+}
+
+// TestMemoryGaugeCapsTotal runs many slow uploads at once under a gauge
+// holding three eXIf chunks' worth, and checks the cap held, that calls
+// waited for it rather than failing, and that everything was released
+func TestMemoryGaugeCapsTotal(t *testing.T) {
+	const size, calls = 64 << 10, 12
+	input, _ := gaugePNG(size)
+	gauge := newCapGauge(3 * size)
+	s, err := New(Config{RemoveGPSInfo: true, MemoryGauge: gauge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.RemoveContext(context.Background(), &slowReader{bytes.NewReader(input)}, io.Discard)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("RemoveContext: %v", err)
+		}
+	}
+	held, peak, waits := gauge.state()
+	if held != 0 {
+		t.Errorf("%d bytes still held after every call returned", held)
+	}
+	if peak > 3*size {
+		t.Errorf("peak %d over the cap of %d", peak, 3*size)
+	}
+	if waits == 0 {
+		t.Error("no call waited for the gauge, so the cap was never tested")
+	}
+}
+
+// TestMemoryGaugeRejects checks that a gauge refusing memory fails the
+// call with ErrMemoryUnavailable while a stalled call holds it, and that
+// the stalled call releases it once it completes
+func TestMemoryGaugeRejects(t *testing.T) {
+	const size = 64 << 10
+	input, mid := gaugePNG(size)
+	gauge := newCapGauge(size + 64) // one chunk, and the IHDR
+	gauge.reject = true
+	s, err := New(Config{RemoveGPSInfo: true, MemoryGauge: gauge})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stall := newStallReader(context.Background(), input, mid)
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.RemoveContext(context.Background(), stall, io.Discard)
+		done <- err
+	}()
+	<-stall.stalled
+
+	_, _, err = s.RemoveBytes(input)
+	if !errors.Is(err, ErrMemoryUnavailable) || !errors.Is(err, errGaugeFull) {
+		t.Errorf("RemoveBytes while the gauge is full: %v, want ErrMemoryUnavailable", err)
+	}
+	close(stall.resume)
+	if err := <-done; err != nil {
+		t.Errorf("stalled call: %v", err)
+	}
+	if held, _, _ := gauge.state(); held != 0 {
+		t.Errorf("%d bytes still held", held)
+	}
+}
+
+// TestMemoryGaugeCancel checks that a call waiting on the gauge gives up
+// when its context ends, and that a call whose client disconnects while
+// it holds a buffer releases it
+func TestMemoryGaugeCancel(t *testing.T) {
+	const size = 64 << 10
+	input, mid := gaugePNG(size)
+	gauge := newCapGauge(size + 64)
+	s, err := New(Config{RemoveGPSInfo: true, MemoryGauge: gauge})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	stall := newStallReader(ctx, input, mid)
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.RemoveContext(ctx, stall, io.Discard)
+		done <- err
+	}()
+	<-stall.stalled
+
+	waiting, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = s.RemoveContext(waiting, bytes.NewReader(input), io.Discard)
+	if !errors.Is(err, ErrMemoryUnavailable) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting call: %v, want ErrMemoryUnavailable and DeadlineExceeded", err)
+	}
+
+	disconnect()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("disconnected call: %v, want context.Canceled", err)
+	}
+	if held, _, _ := gauge.state(); held != 0 {
+		t.Errorf("%d bytes still held after the disconnect", held)
+	}
+}
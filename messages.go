@@ -0,0 +1,86 @@
+package exifremover
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MessageID identifies a user-facing message in a Catalog
+type MessageID string
+
+const (
+	MsgActionPreserve    MessageID = "action.preserve"
+	MsgActionRemove      MessageID = "action.remove"
+	MsgActionUnsupported MessageID = "action.unsupported"
+
+	// MsgExplainTag renders an EXIF tag: carrier, IFD, tag ID, name, action
+	MsgExplainTag MessageID = "explain.tag"
+	// MsgExplainItem renders any other item: carrier, name, action
+	MsgExplainItem MessageID = "explain.item"
+	// MsgExplainReason follows an item decided by a named rule: reason
+	MsgExplainReason MessageID = "explain.reason"
+)
+
+// Catalog maps message IDs to fmt templates in one language. Templates use
+// explicit argument indexes so translations can reorder arguments.
+type Catalog map[MessageID]string
+
+// defaultCatalog holds the English messages every other catalog falls back
+// to. Library errors are not localized and stay out of it.
+var defaultCatalog = Catalog{
+	MsgActionPreserve:    "preserved",
+	MsgActionRemove:      "removed",
+	MsgActionUnsupported: "not handled by this build",
+
+	MsgExplainTag:    "%[1]s %[2]s 0x%04[3]X %[4]s: %[5]s",
+	MsgExplainItem:   "%[1]s %[2]s: %[3]s",
+	MsgExplainReason: " (%[1]s)",
+}
+
+// catalogs holds the built-in translations by locale
+var catalogs = map[string]Catalog{
+	"en": defaultCatalog,
+}
+
+// message returns the template for id, preferring override, then the
+// built-in catalog for locale, then English
+func message(id MessageID, locale string, override Catalog) string {
+	if s, ok := override[id]; ok {
+		return s
+	}
+	if s, ok := catalogs[locale][id]; ok {
+		return s
+	}
+	return defaultCatalog[id]
+}
+
+// actionMessage maps an Action to its message ID
+func actionMessage(a Action) MessageID {
+	switch a {
+	case ActionRemove:
+		return MsgActionRemove
+	case ActionUnsupported:
+		return MsgActionUnsupported
+	}
+	return MsgActionPreserve
+}
+
+// Text renders the explanation one item per line in the given locale.
+// Messages missing from override and from the built-in catalog for locale
+// fall back to English.
+func (e Explanation) Text(locale string, override Catalog) string {
+	var b strings.Builder
+	for _, item := range e.Items {
+		action := message(actionMessage(item.Action), locale, override)
+		if item.IFD != "" {
+			fmt.Fprintf(&b, message(MsgExplainTag, locale, override), item.Carrier, item.IFD, item.Tag, item.Name, action)
+		} else {
+			fmt.Fprintf(&b, message(MsgExplainItem, locale, override), item.Carrier, item.Name, action)
+		}
+		if item.Reason != "" {
+			fmt.Fprintf(&b, message(MsgExplainReason, locale, override), item.Reason)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,73 @@
+package exifremover
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestCatalogComplete parses the package and checks that every MessageID
+// constant it declares, and so every one code can use, has an English
+// default
+func TestCatalogComplete(t *testing.T) {
+	pkgs, err := parser.ParseDir(token.NewFileSet(), ".", func(info fs.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := 0
+	for _, f := range pkgs["exifremover"].Files {
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				v := spec.(*ast.ValueSpec)
+				if typ, ok := v.Type.(*ast.Ident); !ok || typ.Name != "MessageID" {
+					continue
+				}
+				for i, name := range v.Names {
+					id, err := strconv.Unquote(v.Values[i].(*ast.BasicLit).Value)
+					if err != nil {
+						t.Fatal(err)
+					}
+					ids++
+					if _, ok := defaultCatalog[MessageID(id)]; !ok {
+						t.Errorf("%s (%q) is missing from the default catalog", name.Name, id)
+					}
+				}
+			}
+		}
+	}
+	if ids == 0 {
+		t.Fatal("no MessageID constants found")
+	}
+	if ids != len(defaultCatalog) {
+		t.Errorf("%d message IDs declared, %d in the default catalog", ids, len(defaultCatalog))
+	}
+}
+
+func TestExplanationTextRenders(t *testing.T) {
+	text := ExplainPolicy(Config{RemoveAll: true, RemoveIPTC: true, TextKeysToRemove: []string{"Author"}}).Text("en", nil)
+	if strings.Contains(text, "%!") {
+		t.Errorf("a template doesn't match its arguments:\n%s", text)
+	}
+}
+
+func TestExplanationTextOverride(t *testing.T) {
+	e := Explanation{Items: []ExplanationItem{{Carrier: CarrierComment, Name: "COM segments", Action: ActionRemove}}}
+	override := Catalog{MsgActionRemove: "entfernt", MsgExplainItem: "%[2]s (%[1]s): %[3]s"}
+	if got, want := e.Text("de", override), "COM segments (comment): entfernt\n"; got != want {
+		t.Errorf("override rendered %q, want %q", got, want)
+	}
+	// Messages the override and locale lack fall back to English
+	if got, want := e.Text("xx", Catalog{}), "comment COM segments: removed\n"; got != want {
+		t.Errorf("fallback rendered %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,296 @@
+package exifremover
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MIMEReport describes what SanitizeMIMEMessageReport changed
+type MIMEReport struct {
+	// Images holds a Report for every image part that was sanitized, in
+	// message order
+	Images []*Report
+	// Warnings lists parts that were passed through unmodified, such as
+	// multipart/signed bodies
+	Warnings []string
+}
+
+// SanitizeMIMEMessage copies the email message read from r to w, removing
+// metadata from image parts according to config. Image parts are found by
+// content sniffing and re-encoded with their original transfer encoding;
+// every other part, the headers, preambles and boundaries are copied
+// byte-for-byte.
+func SanitizeMIMEMessage(r io.Reader, w io.Writer, config Config) error {
+	_, err := SanitizeMIMEMessageReport(r, w, config)
+	return err
+}
+
+// SanitizeMIMEMessageReport is SanitizeMIMEMessage, additionally returning a
+// MIMEReport. Signed messages and signed subtrees are passed through
+// untouched with a warning, since modifying them breaks the signature.
+//
+// The message is read into memory whole before anything is written:
+// delimiters and headers are copied from the raw bytes around each part,
+// and a part that fails must not leave half a message behind. Peak memory
+// is the message plus each image part decoded and re-encoded in turn, so
+// a gateway should set Config.MaxFileSize, which bounds the message as it
+// does a single file.
+func SanitizeMIMEMessageReport(r io.Reader, w io.Writer, config Config) (*MIMEReport, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+	config = prepare(config)
+	if config.MaxFileSize > 0 {
+		r = &sizeLimitReader{r: r, limit: config.MaxFileSize}
+	}
+	message, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	report := &MIMEReport{}
+	var output bytes.Buffer
+	if err := sanitizeEntity(message, &output, config, report, 0); err != nil {
+		return nil, err
+	}
+	if _, err := output.WriteTo(w); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// maxMIMEDepth bounds multipart nesting
+const maxMIMEDepth = 32
+
+// sanitizeEntity writes a message or body part, headers included
+func sanitizeEntity(entity []byte, w *bytes.Buffer, config Config, report *MIMEReport, depth int) error {
+	rawHeader, body := splitEntity(entity)
+	headerReader := io.MultiReader(bytes.NewReader(rawHeader), strings.NewReader("\r\n\r\n"))
+	header, err := textproto.NewReader(bufio.NewReader(headerReader)).ReadMIMEHeader()
+	if err != nil && len(rawHeader) > 0 {
+		// Unparseable headers: leave the part alone
+		w.Write(entity)
+		return nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	switch {
+	case mediaType == "multipart/signed":
+		report.Warnings = append(report.Warnings, "multipart/signed part passed through unmodified")
+		w.Write(entity)
+		return nil
+	case strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "":
+		if depth >= maxMIMEDepth {
+			return fmt.Errorf("MIME nesting deeper than %d", maxMIMEDepth)
+		}
+		w.Write(entity[:len(entity)-len(body)])
+		return sanitizeMultipart(body, params["boundary"], w, config, report, depth+1)
+	case mediaType == "message/rfc822" && identityEncoding(header):
+		if depth >= maxMIMEDepth {
+			return fmt.Errorf("MIME nesting deeper than %d", maxMIMEDepth)
+		}
+		w.Write(entity[:len(entity)-len(body)])
+		return sanitizeEntity(body, w, config, report, depth+1)
+	}
+
+	sanitized, imageReport, err := sanitizeLeaf(header, body, config)
+	if err != nil {
+		return err
+	}
+	if imageReport == nil {
+		w.Write(entity)
+		return nil
+	}
+	report.Images = append(report.Images, imageReport)
+	w.Write(setContentLength(entity[:len(entity)-len(body)], len(sanitized)))
+	w.Write(sanitized)
+	return nil
+}
+
+// splitEntity separates the raw header block, including the blank line
+// that ends it, from the body
+func splitEntity(entity []byte) (header, body []byte) {
+	for _, blank := range [][]byte{[]byte("\r\n"), []byte("\n")} {
+		if bytes.HasPrefix(entity, blank) {
+			return entity[:0], entity[len(blank):]
+		}
+	}
+	end := -1
+	if i := bytes.Index(entity, []byte("\n\r\n")); i >= 0 {
+		end = i + 3
+	}
+	if i := bytes.Index(entity, []byte("\n\n")); i >= 0 && (end < 0 || i+2 < end) {
+		end = i + 2
+	}
+	if end < 0 {
+		return entity, entity[len(entity):]
+	}
+	return entity[:end], entity[end:]
+}
+
+// sanitizeMultipart walks the parts of a multipart body, copying the
+// preamble, delimiters and epilogue verbatim
+func sanitizeMultipart(body []byte, boundary string, w *bytes.Buffer, config Config, report *MIMEReport, depth int) error {
+	delim := []byte("--" + boundary)
+	_, end, closing, found := findDelimiter(body, delim, 0)
+	if !found {
+		w.Write(body)
+		return nil
+	}
+	w.Write(body[:end])
+	for !closing {
+		start, next, nextClosing, found := findDelimiter(body, delim, end)
+		if !found {
+			// Unterminated multipart: keep the rest as it is
+			w.Write(body[end:])
+			return nil
+		}
+		if err := sanitizeEntity(body[end:start], w, config, report, depth); err != nil {
+			return err
+		}
+		w.Write(body[start:next])
+		end, closing = next, nextClosing
+	}
+	w.Write(body[end:])
+	return nil
+}
+
+// findDelimiter finds the next delimiter line at or after from. start is
+// where the line break preceding the delimiter begins, and end is just past
+// the delimiter line.
+func findDelimiter(body, delim []byte, from int) (start, end int, closing, found bool) {
+	for search := from; search < len(body); {
+		i := bytes.Index(body[search:], delim)
+		if i < 0 {
+			break
+		}
+		i += search
+		search = i + 1
+		if i > 0 && body[i-1] != '\n' {
+			continue
+		}
+		after := i + len(delim)
+		closing = bytes.HasPrefix(body[after:], []byte("--"))
+		if closing {
+			after += 2
+		}
+		if after < len(body) && !strings.ContainsRune(" \t\r\n", rune(body[after])) {
+			continue
+		}
+
+		start = i
+		if start > from && body[start-1] == '\n' {
+			start--
+			if start > from && body[start-1] == '\r' {
+				start--
+			}
+		}
+		end = len(body)
+		if nl := bytes.IndexByte(body[after:], '\n'); nl >= 0 {
+			end = after + nl + 1
+		}
+		return start, end, closing, true
+	}
+	return 0, 0, false, false
+}
+
+// identityEncoding reports whether a part's body is not transfer-encoded
+func identityEncoding(header textproto.MIMEHeader) bool {
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "", "7bit", "8bit", "binary":
+		return true
+	}
+	return false
+}
+
+// sanitizeLeaf decodes a single part and, if it holds an image this
+// package handles, returns it sanitized and re-encoded. A nil Report means
+// the part is not such an image.
+func sanitizeLeaf(header textproto.MIMEHeader, body []byte, config Config) ([]byte, *Report, error) {
+	encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding")))
+	var decoded []byte
+	switch encoding {
+	case "base64":
+		clean := bytes.Map(func(r rune) rune {
+			if strings.ContainsRune(" \t\r\n", r) {
+				return -1
+			}
+			return r
+		}, body)
+		var err error
+		if decoded, err = base64.StdEncoding.DecodeString(string(clean)); err != nil {
+			return nil, nil, nil
+		}
+	case "quoted-printable":
+		var err error
+		if decoded, err = io.ReadAll(quotedprintable.NewReader(bytes.NewReader(body))); err != nil {
+			return nil, nil, nil
+		}
+	default:
+		if !identityEncoding(header) {
+			return nil, nil, nil
+		}
+		decoded = body
+	}
+
+	header12 := decoded
+	if len(header12) > 12 {
+		header12 = header12[:12]
+	}
+	if detectFormat(header12) == FormatUnknown {
+		return nil, nil, nil
+	}
+	var image bytes.Buffer
+	report, err := process(bytes.NewReader(decoded), header12, &image, config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("image part: %w", err)
+	}
+
+	newline := "\n"
+	if bytes.Contains(body, []byte("\r\n")) {
+		newline = "\r\n"
+	}
+	trailing := bytes.HasSuffix(body, []byte("\n"))
+	var out bytes.Buffer
+	switch encoding {
+	case "base64":
+		encoded := base64.StdEncoding.EncodeToString(image.Bytes())
+		for len(encoded) > 76 {
+			out.WriteString(encoded[:76] + newline)
+			encoded = encoded[76:]
+		}
+		out.WriteString(encoded)
+		if trailing {
+			out.WriteString(newline)
+		}
+	case "quoted-printable":
+		qp := quotedprintable.NewWriter(&out)
+		qp.Binary = true
+		qp.Write(image.Bytes())
+		qp.Close()
+		if trailing {
+			out.WriteString(newline)
+		}
+	default:
+		out.Write(image.Bytes())
+	}
+	return out.Bytes(), report, nil
+}
+
+var contentLengthLine = regexp.MustCompile(`(?im)^(Content-Length:[ \t]*)\d+`)
+
+// setContentLength rewrites a Content-Length header, if the part has one
+func setContentLength(rawHeader []byte, length int) []byte {
+	return contentLengthLine.ReplaceAll(rawHeader, []byte("${1}"+strconv.Itoa(length)))
+}
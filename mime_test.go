@@ -0,0 +1,222 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// wrapBase64 returns data base64-encoded in lines of 76 characters
+func wrapBase64(data []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var b strings.Builder
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+	return b.String()
+}
+
+// relatedMessage returns a mail as clients send an HTML message with an
+// inline image and an attachment: multipart/mixed holding a
+// multipart/related of the HTML and the image it references by cid:,
+// then a PNG attachment in quoted-printable
+func relatedMessage() string {
+	jpeg := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	png := fixture.WithChunks(fixture.PNG(8, 8), fixture.Chunk("eXIf", fixture.Sample().Bytes()))
+	var qp bytes.Buffer
+	w := quotedprintable.NewWriter(&qp)
+	w.Binary = true
+	w.Write(png)
+	w.Close()
+	return "From: a@example.com\r\n" +
+		"To: b@example.com\r\n" +
+		"Subject: Holiday\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+		"\r\n" +
+		"This is a multi-part message.\r\n" +
+		"--outer\r\n" +
+		"Content-Type: multipart/related; boundary=\"inner\"; type=\"text/html\"\r\n" +
+		"\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n" +
+		"\r\n" +
+		"<p>Look: <img src=\"cid:photo1@example.com\"></p>\r\n" +
+		"--inner\r\n" +
+		"Content-Type: image/jpeg\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-ID: <photo1@example.com>\r\n" +
+		"Content-Disposition: inline; filename=\"photo.jpg\"\r\n" +
+		"\r\n" +
+		wrapBase64(jpeg) +
+		"--inner--\r\n" +
+		"--outer\r\n" +
+		"Content-Type: image/png; name=\"map.png\"\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"Content-Disposition: attachment; filename=\"map.png\"\r\n" +
+		"\r\n" +
+		qp.String() + "\r\n" +
+		"--outer--\r\n" +
+		"epilogue\r\n"
+}
+
+// mimeImages walks a message and returns the decoded bodies of its image
+// parts by Content-ID or, without one, filename, failing the test if the
+// message doesn't parse
+func mimeImages(t *testing.T, message []byte) map[string][]byte {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := make(map[string][]byte)
+	var walk func(contentType string, body io.Reader)
+	walk = func(contentType string, body io.Reader) {
+		_, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			typ := part.Header.Get("Content-Type")
+			if strings.HasPrefix(typ, "multipart/") {
+				walk(typ, part)
+				continue
+			}
+			if !strings.HasPrefix(typ, "image/") {
+				continue
+			}
+			var r io.Reader = part
+			switch part.Header.Get("Content-Transfer-Encoding") {
+			case "base64":
+				r = base64.NewDecoder(base64.StdEncoding, part)
+			case "quoted-printable":
+				r = quotedprintable.NewReader(part)
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key := part.Header.Get("Content-ID")
+			if key == "" {
+				key = part.FileName()
+			}
+			images[key] = data
+		}
+	}
+	walk(msg.Header.Get("Content-Type"), msg.Body)
+	return images
+}
+
+// TestSanitizeMIMENested checks that images in nested multiparts are
+// sanitized in both transfer encodings, that the inline image keeps the
+// Content-ID its HTML references it by, and that everything else is
+// copied byte for byte
+func TestSanitizeMIMENested(t *testing.T) {
+	in := relatedMessage()
+	var out bytes.Buffer
+	report, err := SanitizeMIMEMessageReport(strings.NewReader(in), &out, Config{RemoveGPSInfo: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Images) != 2 || report.Images[0].Format != FormatJPEG || report.Images[1].Format != FormatPNG {
+		t.Fatalf("got %d image reports, want the JPEG's and the PNG's", len(report.Images))
+	}
+	if len(report.Warnings) != 0 {
+		t.Errorf("warnings: %v", report.Warnings)
+	}
+
+	images := mimeImages(t, out.Bytes())
+	photo, ok := images["<photo1@example.com>"]
+	if !ok {
+		t.Fatalf("inline image lost its Content-ID; parts: %d", len(images))
+	}
+	for name, data := range map[string][]byte{"photo": photo, "map.png": images["map.png"]} {
+		if len(data) == 0 {
+			t.Fatalf("%s missing", name)
+		}
+		assertAbsent(t, data, "NETWORK-Somewhere")
+		if m := inspect(t, data); !m.hasTag("IFD0", 0x010f) {
+			t.Errorf("%s lost the EXIF it should keep", name)
+		}
+	}
+
+	// The message outside the image bodies is unchanged
+	for _, kept := range []string{
+		in[:strings.Index(in, "--inner\r\nContent-Type: image/jpeg")],
+		"Content-ID: <photo1@example.com>\r\nContent-Disposition: inline; filename=\"photo.jpg\"\r\n\r\n",
+		"--inner--\r\n--outer\r\nContent-Type: image/png; name=\"map.png\"\r\n",
+		"\r\n--outer--\r\nepilogue\r\n",
+	} {
+		if !strings.Contains(out.String(), kept) {
+			t.Errorf("output lost %q", kept)
+		}
+	}
+}
+
+// TestSanitizeMIMESigned checks that a signed message, and a signed part
+// inside a message, are passed through untouched with a warning
+func TestSanitizeMIMESigned(t *testing.T) {
+	jpeg := wrapBase64(fixture.EXIFJPEG(fixture.Sample().Bytes()))
+	signed := "Content-Type: multipart/signed; boundary=\"sig\"; protocol=\"application/pgp-signature\"; micalg=pgp-sha256\r\n" +
+		"\r\n" +
+		"--sig\r\n" +
+		"Content-Type: image/jpeg\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		jpeg +
+		"--sig\r\n" +
+		"Content-Type: application/pgp-signature\r\n" +
+		"\r\n" +
+		"-----BEGIN PGP SIGNATURE-----\r\n-----END PGP SIGNATURE-----\r\n" +
+		"--sig--\r\n"
+	for name, in := range map[string]string{
+		"message": "From: a@example.com\r\nMIME-Version: 1.0\r\n" + signed,
+		"part": "From: a@example.com\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"outer\"\r\n\r\n" +
+			"--outer\r\n" + signed + "--outer--\r\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			report, err := SanitizeMIMEMessageReport(strings.NewReader(in), &out, Config{RemoveGPSInfo: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != in {
+				t.Error("signed content changed")
+			}
+			if len(report.Images) != 0 || len(report.Warnings) != 1 || !strings.Contains(report.Warnings[0], "multipart/signed") {
+				t.Errorf("report = %d images, warnings %v", len(report.Images), report.Warnings)
+			}
+		})
+	}
+}
+
+func TestSanitizeMIMEMaxFileSize(t *testing.T) {
+	in := relatedMessage()
+	var out bytes.Buffer
+	_, err := SanitizeMIMEMessageReport(strings.NewReader(in), &out, Config{RemoveGPSInfo: true, MaxFileSize: int64(len(in) / 2)})
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("err = %v, want ErrFileTooLarge", err)
+	}
+	if out.Len() != 0 {
+		t.Error("output written before failing")
+	}
+}
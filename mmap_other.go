@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package exifremover
+
+import "errors"
+
+// mapFile is unsupported on this platform; callers fall back to reading
+func mapFile(f file) ([]byte, func(), error) {
+	return nil, nil, errors.New("memory mapping not supported")
+}
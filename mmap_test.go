@@ -0,0 +1,107 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package exifremover
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// mmapInput writes a JPEG of several pages to a temp file, dated past the
+// quiet period so it can be mapped
+func mmapInput(t *testing.T) (string, []byte) {
+	in := fixture.WithSegment(fixture.EXIFJPEG(fixture.Sample().Bytes()), 0xFE, bytes.Repeat([]byte("c"), 60000))
+	path := filepath.Join(t.TempDir(), "in.jpg")
+	if err := os.WriteFile(path, in, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(path, past, past); err != nil {
+		t.Fatal(err)
+	}
+	return path, in
+}
+
+func TestUseMmapMatchesRead(t *testing.T) {
+	path, in := mmapInput(t)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, unmap, err := mapFile(f)
+	if err != nil {
+		t.Fatalf("mapFile: %v", err)
+	}
+	if !bytes.Equal(data, in) {
+		t.Error("mapping differs from the file")
+	}
+	unmap()
+
+	config := Config{RemoveGPSInfo: true, RemoveCameraInfo: true}
+	read, _ := sanitize(t, in, config)
+	out := filepath.Join(t.TempDir(), "out.jpg")
+	config.UseMmap = true
+	if _, err := RemoveEXIFSelectiveReport(path, out, config); err != nil {
+		t.Fatal(err)
+	}
+	if mapped, err := os.ReadFile(out); err != nil || !bytes.Equal(mapped, read) {
+		t.Errorf("mapped output differs from read output, err %v", err)
+	}
+}
+
+func TestMapFileRefusesRecentFiles(t *testing.T) {
+	path, in := mmapInput(t)
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, _, err := mapFile(f); err == nil {
+		t.Error("mapped a file modified just now")
+	}
+
+	// The path API falls back to reading it
+	out := filepath.Join(t.TempDir(), "out.jpg")
+	if _, err := RemoveEXIFSelectiveReport(path, out, Config{UseMmap: true, RemoveGPSInfo: true}); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := sanitize(t, in, Config{RemoveGPSInfo: true})
+	if got, err := os.ReadFile(out); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("fallback output differs, err %v", err)
+	}
+}
+
+func TestMappedFileTruncated(t *testing.T) {
+	path, _ := mmapInput(t)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, unmap, err := mapFile(f)
+	if err != nil {
+		t.Fatalf("mapFile: %v", err)
+	}
+	defer unmap()
+	if err := f.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pages past the new end fault; processing fails rather than
+	// crashing the process
+	_, err = processMapped(bytes.NewReader(data), data[:12], &bytes.Buffer{}, Config{RemoveGPSInfo: true})
+	if !errors.Is(err, ErrInputChanged) {
+		t.Errorf("got %v, want ErrInputChanged", err)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package exifremover
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// mmapQuietPeriod is how long a file must have gone unmodified to be
+// mapped; a file written more recently may still be growing or about to
+// be truncated by its writer
+const mmapQuietPeriod = 2 * time.Second
+
+// mapFile maps f read-only. The returned function unmaps it and must be
+// called once the data is no longer used.
+func mapFile(f file) ([]byte, func(), error) {
+	fd, ok := f.(interface{ Fd() uintptr })
+	if !ok {
+		return nil, nil, errors.New("file has no descriptor")
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if time.Since(info.ModTime()) < mmapQuietPeriod {
+		return nil, nil, errors.New("file modified too recently to map")
+	}
+	size := info.Size()
+	if size <= 0 || int64(int(size)) != size {
+		return nil, nil, errors.New("file size can't be mapped")
+	}
+	data, err := syscall.Mmap(int(fd.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	unmap := func() { syscall.Munmap(data) }
+
+	// A file still being written could be truncated under the mapping,
+	// which faults on access instead of returning an error; this catches
+	// a change while mapping, and processMapped one while processing
+	if info, err := f.Stat(); err != nil || info.Size() != size {
+		unmap()
+		return nil, nil, errors.New("file changed size while being mapped")
+	}
+	return data, unmap, nil
+}
@@ -0,0 +1,270 @@
+package exifremover
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// mpfPrefix starts the APP2 segment holding a JPEG's MP Index IFD
+var mpfPrefix = []byte("MPF\x00")
+
+// mpfRoleBytes is how much of a secondary image is read to learn its role
+// from its own XMP before the rest of it is copied or dropped
+const mpfRoleBytes = 64 << 10
+
+// mpfImage is one MP entry of an MP Index IFD
+type mpfImage struct {
+	Attribute uint32 // flags and MP type
+	Size      uint32
+	Offset    uint32 // from the MPF header; zero for the primary image
+}
+
+// mpfIndex is the MP Index of a JPEG, gathered from its APP2 segment under
+// RemoveAuxiliaryImages and used to walk the images after the primary.
+// images is nil when the index couldn't be parsed.
+type mpfIndex struct {
+	order  binary.ByteOrder
+	images []mpfImage
+	base   int64 // input offset of the MPF header, which offsets count from
+	// entries, count and size locate the MP entries, NumberOfImages and the
+	// MP entry byte count within the segment payload, where a segment kept
+	// for PreserveGainMaps is rewritten
+	entries, count, size int
+
+	// Under PreserveGainMaps the output from the MPF segment on is held,
+	// since the index can only be rewritten once the images it lists have
+	// been read
+	held    *bytes.Buffer
+	heldAt  int64     // output offset of held
+	segment int       // length of the MPF segment, marker included, at the start of held
+	dst     io.Writer // where output goes once held is released
+}
+
+// parseMPF reads the MP entries of an APP2 MPF payload. The index is only
+// used when it is well formed and lists the primary image first.
+func parseMPF(data []byte) *mpfIndex {
+	m := &mpfIndex{}
+	tiff := data[len(mpfPrefix):]
+	if len(tiff) < 8 {
+		return m
+	}
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		m.order = binary.LittleEndian
+	case "MM\x00*":
+		m.order = binary.BigEndian
+	default:
+		return m
+	}
+	ifd := int64(m.order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > int64(len(tiff)) {
+		return m
+	}
+	count := int64(m.order.Uint16(tiff[ifd:]))
+	if ifd+2+12*count > int64(len(tiff)) {
+		return m
+	}
+	for i := int64(0); i < count; i++ {
+		pos := ifd + 2 + 12*i
+		entry := tiff[pos:]
+		switch m.order.Uint16(entry) {
+		case 0xB001: // NumberOfImages
+			m.count = len(mpfPrefix) + int(pos) + 8
+		case 0xB002: // MPEntry
+			n, offset := int64(m.order.Uint32(entry[4:8])), int64(m.order.Uint32(entry[8:12]))
+			if n == 0 || n%16 != 0 || offset < 8 || offset+n > int64(len(tiff)) {
+				return m
+			}
+			m.entries, m.size = len(mpfPrefix)+int(offset), len(mpfPrefix)+int(pos)+4
+			for e := offset; e < offset+n; e += 16 {
+				m.images = append(m.images, mpfImage{
+					Attribute: m.order.Uint32(tiff[e:]),
+					Size:      m.order.Uint32(tiff[e+4:]),
+					Offset:    m.order.Uint32(tiff[e+8:]),
+				})
+			}
+		}
+	}
+	if len(m.images) == 0 || m.images[0].Offset != 0 {
+		m.images = nil
+	}
+	return m
+}
+
+// hold diverts output into memory from here on, for PreserveGainMaps
+func (m *mpfIndex) hold(output *bufio.Writer, dst *countingWriter) error {
+	if err := output.Flush(); err != nil {
+		return err
+	}
+	m.held, m.heldAt, m.dst = &bytes.Buffer{}, dst.n, dst
+	output.Reset(m.held)
+	return nil
+}
+
+// rewrite stores images as the MP entries of payload, the kept MPF segment
+func (m *mpfIndex) rewrite(payload []byte, images []mpfImage) {
+	entries := payload[m.entries : m.entries+16*len(m.images)]
+	for i := range entries {
+		entries[i] = 0
+	}
+	for i, image := range images {
+		m.order.PutUint32(entries[16*i:], image.Attribute)
+		m.order.PutUint32(entries[16*i+4:], image.Size)
+		m.order.PutUint32(entries[16*i+8:], image.Offset)
+	}
+	if m.count > 0 {
+		m.order.PutUint32(payload[m.count:], uint32(len(images)))
+	}
+	m.order.PutUint32(payload[m.size:], uint32(16*len(images)))
+}
+
+// mpfRole names a secondary image by what its head says it is, falling
+// back to its MP type
+func mpfRole(image mpfImage, head []byte) string {
+	if role := auxiliaryRole(head); role != "" {
+		return role
+	}
+	switch image.Attribute & 0xFFFFFF {
+	case 0x010001, 0x010002:
+		return "preview"
+	case 0x020002:
+		return "depth map" // disparity image of a stereo pair
+	case 0x020001, 0x020003:
+		return "frame"
+	}
+	return "image"
+}
+
+// auxiliaryRole recognizes an auxiliary image by the markers writers put
+// in its XMP or, for HEIF, its auxC type: gain maps, portrait mattes and
+// segmentation masks, depth or disparity maps, and alpha planes. It
+// returns "" for anything else.
+func auxiliaryRole(b []byte) string {
+	b = bytes.ToLower(b)
+	switch {
+	case bytes.Contains(b, []byte("hdrgm")) || bytes.Contains(b, []byte("hdrgainmap")):
+		return "gain map"
+	case bytes.Contains(b, []byte("matte")) || bytes.Contains(b, []byte("segmentation")):
+		return "matte"
+	case bytes.Contains(b, []byte("depth")) || bytes.Contains(b, []byte("disparity")) || bytes.Contains(b, []byte("auxid:2")):
+		return "depth map"
+	case bytes.Contains(b, []byte("auxiliary:alpha")) || bytes.Contains(b, []byte("auxid:1")):
+		return "alpha plane"
+	}
+	return ""
+}
+
+// copyImages copies the rest of the primary image, whose scan has begun,
+// through scan, and then walks the secondary images the index lists,
+// reporting each by role and size. Each is dropped, or kept when it is a
+// gain map under PreserveGainMaps; data between and after them goes too.
+// Without a usable index, or with one that doesn't match the file,
+// everything after the primary's EOI is dropped as a whole.
+func (m *mpfIndex) copyImages(r *countingReader, scan *eoiWriter, output *bufio.Writer, config Config, report *Report) error {
+	scan.cut = true
+	if m.images != nil && int64(m.images[0].Size) > r.n {
+		if _, err := copyWithProgress(config.progress.ctx, scan, r, int64(m.images[0].Size)-r.n, config.progress); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	if m.images == nil || !scan.seen || scan.dropped > 0 {
+		if _, err := copyWithProgress(config.progress.ctx, scan, r, -1, config.progress); err != nil {
+			return err
+		}
+		if scan.dropped > 0 {
+			report.remove(RemovedItem{Carrier: CarrierAuxiliaryImage, Name: "data after the primary image", Strength: RemovalEliminated, Size: scan.dropped})
+		}
+		return m.release(output, nil, report)
+	}
+	// held grows only when flushed, so it is flushed before measuring
+	held := func() int64 {
+		output.Flush()
+		return int64(m.held.Len())
+	}
+	var primaryEnd int64
+	if m.held != nil {
+		primaryEnd = m.heldAt + held()
+	}
+
+	images := append([]mpfImage(nil), m.images[1:]...)
+	sort.SliceStable(images, func(i, j int) bool { return images[i].Offset < images[j].Offset })
+	var kept []mpfImage
+	var skipped int64
+	for _, image := range images {
+		start := m.base + int64(image.Offset)
+		if start < r.n {
+			continue // overlaps the primary or an earlier image
+		}
+		n, err := copyWithProgress(config.progress.ctx, io.Discard, r, start-r.n, config.progress)
+		if skipped += n; err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		head := make([]byte, mpfRoleBytes)
+		if image.Size < mpfRoleBytes {
+			head = head[:image.Size]
+		}
+		read, err := io.ReadFull(r, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		head = head[:read]
+		rest := int64(image.Size) - int64(read)
+
+		role := mpfRole(image, head)
+		if role == "gain map" && m.held != nil {
+			// The MPF header sits 8 bytes into held, after the segment
+			// marker, its length and the MPF signature
+			image.Offset = uint32(held() - 8)
+			output.Write(head)
+			if _, err := copyWithProgress(config.progress.ctx, output, r, rest, config.progress); err != nil && err != io.EOF {
+				return err
+			}
+			kept = append(kept, image)
+			continue
+		}
+		if _, err := copyWithProgress(config.progress.ctx, io.Discard, r, rest, config.progress); err != nil && err != io.EOF {
+			return err
+		}
+		report.remove(RemovedItem{Carrier: CarrierAuxiliaryImage, Name: "MPF " + role, Strength: RemovalEliminated, Size: int64(image.Size)})
+	}
+	n, err := copyWithProgress(config.progress.ctx, io.Discard, r, -1, config.progress)
+	if err != nil {
+		return err
+	}
+	if skipped += n; skipped > 0 {
+		report.remove(RemovedItem{Carrier: CarrierAuxiliaryImage, Name: "data after the primary image", Strength: RemovalEliminated, Size: skipped})
+	}
+	if kept != nil {
+		primary := m.images[0]
+		primary.Size = uint32(primaryEnd)
+		kept = append([]mpfImage{primary}, kept...)
+	}
+	return m.release(output, kept, report)
+}
+
+// release writes out what PreserveGainMaps held back, with the MPF index
+// rewritten to list images, or dropped when there are none
+func (m *mpfIndex) release(output *bufio.Writer, images []mpfImage, report *Report) error {
+	if m.held == nil {
+		return nil
+	}
+	if err := output.Flush(); err != nil {
+		return err
+	}
+	held := m.held.Bytes()
+	if images == nil {
+		report.remove(RemovedItem{Carrier: CarrierAuxiliaryImage, Name: "MPF index", Strength: RemovalEliminated, Size: int64(m.segment - 4)})
+		held = held[m.segment:]
+	} else {
+		m.rewrite(held[4:m.segment], images)
+	}
+	output.Reset(m.dst)
+	_, err := output.Write(held)
+	return err
+}
@@ -0,0 +1,56 @@
+package exifremover
+
+import "errors"
+
+// ErrIncompatibleOptions is wrapped in an OptionsError for a Config whose
+// options contradict each other or the entry point they are passed to
+var ErrIncompatibleOptions = errors.New("incompatible options")
+
+// OptionsError names the pair of options that conflict. Second may name
+// the kind of input rather than an option, e.g. "stream input".
+type OptionsError struct {
+	First, Second string
+}
+
+func (e *OptionsError) Error() string {
+	return e.First + " with " + e.Second + ": " + ErrIncompatibleOptions.Error()
+}
+
+func (e *OptionsError) Unwrap() error { return ErrIncompatibleOptions }
+
+// validateConfig is checked before any image is processed, by New and by
+// every entry point that takes a Config. New checks with UseMmap cleared,
+// since a Sanitizer's RemoveFile honors it; anywhere else, seeing it means
+// the input was never a file that could be mapped.
+func validateConfig(config Config) error {
+	if err := validateValueRules(config.ValueRules); err != nil {
+		return err
+	}
+	if err := validateGPS(config); err != nil {
+		return err
+	}
+	if err := validateDateTime(config); err != nil {
+		return err
+	}
+	if err := validateText(config); err != nil {
+		return err
+	}
+	if config.UseMmap {
+		return &OptionsError{"UseMmap", "stream input"}
+	}
+	if config.StampProcessed {
+		if _, err := policyHash(config); err != nil {
+			return err
+		}
+	}
+	if config.RemoveAll {
+		for _, rule := range config.ValueRules {
+			if rule.Keep {
+				// The whole EXIF container is dropped, so there is
+				// nothing left to keep a matching tag in
+				return &OptionsError{"RemoveAll", "ValueRules with Keep"}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package exifremover
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// optionSettings are the settings the pairwise test combines, each valid
+// on its own
+var optionSettings = []struct {
+	name string
+	set  func(*Config)
+}{
+	{"RemoveAll", func(c *Config) { c.RemoveAll = true }},
+	{"RemoveGPSInfo", func(c *Config) { c.RemoveGPSInfo = true }},
+	{"RemoveCameraInfo", func(c *Config) { c.RemoveCameraInfo = true }},
+	{"RemoveDateTime", func(c *Config) { c.RemoveDateTime = true }},
+	{"UseMmap", func(c *Config) { c.UseMmap = true }},
+	{"ValueRules with Keep", func(c *Config) { c.ValueRules = []ValueRule{{Tag: 0x010f, Contains: "a", Keep: true}} }},
+	{"ValueRules", func(c *Config) { c.ValueRules = []ValueRule{{Tag: 0x0131, Regexp: "^Self"}} }},
+	{"GPSTruncate", func(c *Config) { c.RemoveGPSInfo, c.GPSAction, c.GPSPrecision = true, GPSTruncate, 2 }},
+	{"DateTimeShift", func(c *Config) {
+		c.RemoveDateTime, c.DateTimePolicy, c.DateTimeShiftBy = true, DateTimeShift, time.Hour
+	}},
+	{"DateTimeSetFixed", func(c *Config) {
+		c.RemoveDateTime, c.DateTimePolicy, c.DateTimeFixed = true, DateTimeSetFixed, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	}},
+	{"TextBlank", func(c *Config) { c.RemoveTextChunks, c.TextMode = true, TextBlank }},
+	{"PreserveOrientation", func(c *Config) { c.PreserveOrientation = true }},
+	{"PreserveModTime", func(c *Config) { c.PreserveModTime = true }},
+	{"InPlaceNonAtomic", func(c *Config) { c.InPlaceNonAtomic = true }},
+	{"RemoveIPTC", func(c *Config) { c.RemoveIPTC = true }},
+	{"RemoveComments", func(c *Config) { c.RemoveComments = true }},
+	{"Minify", func(c *Config) { c.Minify = true }},
+	{"RepairStructure", func(c *Config) { c.RepairStructure = true }},
+	{"StampProcessed", func(c *Config) { c.StampProcessed = true }},
+	{"Permissive", func(c *Config) { c.Permissive = true }},
+	{"Salvage", func(c *Config) { c.Salvage = true }},
+	{"DropEmptyMetadata", func(c *Config) { c.DropEmptyMetadata = true }},
+	{"RemoveAuxiliaryImages", func(c *Config) { c.RemoveAuxiliaryImages = true }},
+	{"RemoveEmbeddedPreviews", func(c *Config) { c.RemoveEmbeddedPreviews = true }},
+}
+
+// optionEntryPoints run a config through each kind of input; file says the
+// input is a file the path-based API can map
+var optionEntryPoints = []struct {
+	name string
+	file bool
+	run  func(t *testing.T, input []byte, config Config) error
+}{
+	{"bytes", false, func(t *testing.T, input []byte, config Config) error {
+		_, _, err := RemoveEXIFFromBytesReport(input, config)
+		return err
+	}},
+	{"stream", false, func(t *testing.T, input []byte, config Config) error {
+		return Remove(bytes.NewReader(input), &bytes.Buffer{}, config)
+	}},
+	{"blob", false, func(t *testing.T, input []byte, config Config) error {
+		_, _, err := SanitizeEXIFBlob(exifBlob(t, input), config)
+		return err
+	}},
+	{"path", true, func(t *testing.T, input []byte, config Config) error {
+		dir := t.TempDir()
+		in := filepath.Join(dir, "in.jpg")
+		if err := os.WriteFile(in, input, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return RemoveEXIFSelective(in, filepath.Join(dir, "out.jpg"), config)
+	}},
+	{"in place", true, func(t *testing.T, input []byte, config Config) error {
+		path := filepath.Join(t.TempDir(), "in.jpg")
+		if err := os.WriteFile(path, input, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return RemoveEXIFInPlace(path, config)
+	}},
+}
+
+// exifBlob returns the TIFF structure of a JPEG's first EXIF segment
+func exifBlob(t *testing.T, jpeg []byte) []byte {
+	i := bytes.Index(jpeg, exifPrefix)
+	if i < 4 {
+		t.Fatal("no EXIF segment")
+	}
+	length := int(jpeg[i-2])<<8 | int(jpeg[i-1])
+	return jpeg[i+len(exifPrefix) : i+length-2]
+}
+
+// conflict returns the OptionsError a pair of settings is rejected with,
+// or nil when the pair has defined behavior
+func conflict(a, b string, file bool) *OptionsError {
+	has := func(name string) bool { return a == name || b == name }
+	switch {
+	case has("UseMmap") && !file:
+		return &OptionsError{"UseMmap", "stream input"}
+	case has("RemoveAll") && has("ValueRules with Keep"):
+		return &OptionsError{"RemoveAll", "ValueRules with Keep"}
+	}
+	return nil
+}
+
+// TestOptionPairs runs every pair of settings, and every setting alone,
+// through every kind of input, so no combination is left without a defined
+// result: the pairs conflict lists fail with an OptionsError naming them,
+// and every other pair succeeds
+func TestOptionPairs(t *testing.T) {
+	input := selfTestFiles()[FormatJPEG]
+	for i, a := range optionSettings {
+		for _, b := range optionSettings[i:] {
+			var config Config
+			a.set(&config)
+			b.set(&config)
+			for _, entry := range optionEntryPoints {
+				err := entry.run(t, input, config)
+				want := conflict(a.name, b.name, entry.file)
+				var got *OptionsError
+				switch {
+				case want == nil && err != nil:
+					t.Errorf("%s + %s via %s: %v", a.name, b.name, entry.name, err)
+				case want != nil && !errors.As(err, &got):
+					t.Errorf("%s + %s via %s: got %v, want %v", a.name, b.name, entry.name, err, want)
+				case want != nil && *got != *want:
+					t.Errorf("%s + %s via %s: got %v, want %v", a.name, b.name, entry.name, got, want)
+				case want != nil && !errors.Is(err, ErrIncompatibleOptions):
+					t.Errorf("%s + %s via %s: %v doesn't wrap ErrIncompatibleOptions", a.name, b.name, entry.name, err)
+				}
+			}
+		}
+	}
+}
+
+// TestNewRejectsConflicts checks that a Sanitizer refuses a conflicting
+// config when it is built, not on its first call
+func TestNewRejectsConflicts(t *testing.T) {
+	_, err := New(Config{RemoveAll: true, ValueRules: []ValueRule{{Tag: 0x010f, Equals: "x", Keep: true}}})
+	if !errors.Is(err, ErrIncompatibleOptions) {
+		t.Errorf("New: %v, want ErrIncompatibleOptions", err)
+	}
+	s, err := New(Config{UseMmap: true})
+	if err != nil {
+		t.Fatalf("New with UseMmap: %v", err)
+	}
+	if _, _, err := s.RemoveBytes(selfTestFiles()[FormatJPEG]); !errors.Is(err, ErrIncompatibleOptions) {
+		t.Errorf("RemoveBytes with UseMmap: %v, want ErrIncompatibleOptions", err)
+	}
+}
@@ -0,0 +1,60 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// tagOrientation is the IFD0 tag recording how the image must be rotated
+// for display
+const tagOrientation = 0x0112
+
+// orientationEXIF returns an EXIF payload holding nothing but the IFD0
+// Orientation entry of data, for RemoveAll under PreserveOrientation. data
+// may start with "Exif\0\0" or be a bare TIFF structure; the result has the
+// same form and byte order. It is nil when data has no well-formed
+// Orientation entry.
+func orientationEXIF(data []byte) []byte {
+	prefixed := bytes.HasPrefix(data, exifPrefix)
+	tiff := bytes.TrimPrefix(data, exifPrefix)
+	if len(tiff) < 8 {
+		return nil
+	}
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return nil
+	}
+	numEntries := int(order.Uint16(tiff[offset : offset+2]))
+	for i, pos := 0, offset+2; i < numEntries && pos+12 <= len(tiff); i, pos = i+1, pos+12 {
+		if order.Uint16(tiff[pos:pos+2]) != tagOrientation ||
+			order.Uint16(tiff[pos+2:pos+4]) != 3 || // SHORT
+			order.Uint32(tiff[pos+4:pos+8]) != 1 {
+			continue
+		}
+
+		// Header, a one-entry IFD0 at offset 8 and a zero next-IFD
+		// offset. A single SHORT is stored inline, so the entry is
+		// copied as it is.
+		out := make([]byte, 0, len(exifPrefix)+26)
+		if prefixed {
+			out = append(out, exifPrefix...)
+		}
+		ifd := make([]byte, 26)
+		copy(ifd[0:4], tiff[0:4])
+		order.PutUint32(ifd[4:8], 8)
+		order.PutUint16(ifd[8:10], 1)
+		copy(ifd[10:22], tiff[pos:pos+12])
+		return append(out, ifd...)
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package exifremover
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// ErrUnsupportedPDFStructure is returned by SanitizeScannedPDF for PDFs
+// that are not the simple shape it handles
+var ErrUnsupportedPDFStructure = errors.New("unsupported PDF structure")
+
+// SanitizeScannedPDF removes metadata from the JPEG images of a PDF written
+// by a phone scanning app, where each page is one full-page DCTDecode
+// image. Only single-revision PDFs with a classic xref table and direct
+// stream lengths are handled; anything else fails with
+// ErrUnsupportedPDFStructure before output is written. Each sanitized
+// image is padded back to its original length, so stream lengths and xref
+// offsets stay valid and nothing but image bytes changes. The document's
+// Info dictionary and uncompressed XMP metadata streams are sanitized in
+// place the same way, their removed values blanked to spaces.
+func SanitizeScannedPDF(r io.Reader, w io.Writer, config Config) error {
+	_, err := SanitizeScannedPDFReport(r, w, config)
+	return err
+}
+
+// SanitizeScannedPDFReport is SanitizeScannedPDF, additionally returning a
+// Report for each image, in file order, followed by one of Format
+// FormatUnknown for the Info dictionary and XMP metadata of the document
+func SanitizeScannedPDFReport(r io.Reader, w io.Writer, config Config) ([]*Report, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+	config = prepare(config)
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) ||
+		bytes.Count(data, []byte("startxref")) != 1 || // incremental updates
+		bytes.Contains(data, []byte("/XRef")) || // xref streams
+		bytes.Contains(data, []byte("/ObjStm")) {
+		return nil, ErrUnsupportedPDFStructure
+	}
+
+	// Stamps and similar additions could make an image grow, which this
+	// path can't absorb
+	config.StampProcessed = false
+	config.RepairStructure = false
+
+	out := bytes.Clone(data)
+	var reports []*Report
+	document := &Report{}
+	if err := sanitizePDFInfo(data, out, config, document); err != nil {
+		return nil, err
+	}
+	for pos := 0; ; {
+		loc := pdfObject.FindIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		dictStart := pos + loc[1] - 2
+		dictEnd := pdfDictEnd(data, dictStart)
+		if dictEnd < 0 {
+			return nil, ErrUnsupportedPDFStructure
+		}
+		pos = dictEnd
+		streamStart, ok := pdfStreamStart(data, dictEnd)
+		if !ok {
+			continue // not a stream object
+		}
+
+		dict := data[dictStart:dictEnd]
+		length, direct := pdfLength(dict)
+		// Checked before streamStart+length is formed, which a huge
+		// /Length would overflow
+		fits := direct && length <= len(data)-streamStart
+		if !pdfDCTImage.Match(dict) {
+			if fits {
+				if pdfXMPMetadata.Match(dict) && !bytes.Contains(dict, []byte("/Filter")) {
+					modifyXMP(out[streamStart:streamStart+length], config, document)
+				}
+				pos = streamStart + length
+			} else if end := bytes.Index(data[streamStart:], []byte("endstream")); end >= 0 {
+				pos = streamStart + end
+			}
+			continue
+		}
+		if !fits || bytes.Contains(dict, []byte("/DecodeParms")) {
+			return nil, ErrUnsupportedPDFStructure
+		}
+
+		image := data[streamStart : streamStart+length]
+		var sanitized bytes.Buffer
+		report, err := process(bytes.NewReader(image), pdfHeader(image), &sanitized, config)
+		if err != nil {
+			return nil, err
+		}
+		if sanitized.Len() > length {
+			return nil, ErrUnsupportedPDFStructure
+		}
+		// Decoders stop at EOI, so the zero padding is never read
+		n := copy(out[streamStart:], sanitized.Bytes())
+		for i := streamStart + n; i < streamStart+length; i++ {
+			out[i] = 0
+		}
+		reports = append(reports, report)
+		pos = streamStart + length
+	}
+	if len(reports) == 0 {
+		return nil, ErrUnsupportedPDFStructure
+	}
+
+	if _, err := w.Write(out); err != nil {
+		return nil, err
+	}
+	return append(reports, document), nil
+}
+
+var (
+	pdfObject      = regexp.MustCompile(`\d+\s+\d+\s+obj\s*<<`)
+	pdfDCTImage    = regexp.MustCompile(`/Subtype\s*/Image\b[\s\S]*/Filter\s*(/DCTDecode|\[\s*/DCTDecode\s*\])|/Filter\s*(/DCTDecode|\[\s*/DCTDecode\s*\])[\s\S]*/Subtype\s*/Image\b`)
+	pdfLengthKey   = regexp.MustCompile(`/Length\s+(\d+)(\s+\d+\s+R)?`)
+	pdfXMPMetadata = regexp.MustCompile(`/Type\s*/Metadata\b[\s\S]*/Subtype\s*/XML\b|/Subtype\s*/XML\b[\s\S]*/Type\s*/Metadata\b`)
+	pdfInfoRef     = regexp.MustCompile(`/Info\s+(\d+)\s+(\d+)\s+R`)
+)
+
+// pdfInfoKey is a key of the document Info dictionary whose value belongs
+// to a removal category
+type pdfInfoKey struct {
+	Key        string
+	Categories []Category // removed when any of these is enabled
+}
+
+// pdfInfoKeys is the decision table for the Info dictionary, from the keys
+// the PDF spec defines. Title, Subject and Keywords describe the document
+// itself and are in no category; RemoveAll and RemoveTextChunks reach
+// them, as they reach every key.
+var pdfInfoKeys = []pdfInfoKey{
+	{"Author", []Category{CategoryUserInfo}},
+	{"CreationDate", []Category{CategoryDateTime}},
+	{"Creator", []Category{CategoryEditingInfo}},
+	{"Keywords", nil},
+	{"ModDate", []Category{CategoryDateTime}},
+	{"Producer", []Category{CategoryEditingInfo}},
+	{"Subject", nil},
+	{"Title", nil},
+}
+
+// removed reports whether the key's value goes under config
+func (k pdfInfoKey) removed(config Config) bool {
+	if config.RemoveAll || config.RemoveTextChunks {
+		return true
+	}
+	for _, c := range k.Categories {
+		if c.enabled(config) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizePDFInfo blanks in out the values of the Info dictionary the
+// trailer of data names, keeping every offset where it is
+func sanitizePDFInfo(data, out []byte, config Config, report *Report) error {
+	ref := pdfInfoRef.FindSubmatch(data[bytes.LastIndex(data, []byte("trailer"))+1:])
+	if ref == nil {
+		return nil
+	}
+	obj := regexp.MustCompile(`(?:^|[^\d])` + string(ref[1]) + `\s+` + string(ref[2]) + `\s+obj\s*<<`).FindIndex(data)
+	if obj == nil {
+		return ErrUnsupportedPDFStructure
+	}
+	start := obj[1] - 2
+	end := pdfDictEnd(data, start)
+	if end < 0 {
+		return ErrUnsupportedPDFStructure
+	}
+	for _, k := range pdfInfoKeys {
+		if !k.removed(config) {
+			continue
+		}
+		value, ok := pdfStringValue(data, start, end, k.Key)
+		if !ok {
+			continue
+		}
+		for i := value[0] + 1; i < value[1]-1; i++ {
+			out[i] = ' '
+		}
+		report.remove(RemovedItem{Carrier: CarrierText, Name: "PDF Info " + k.Key, Categories: k.Categories, Strength: RemovalOverwritten, Size: int64(value[1] - value[0] - 2)})
+	}
+	return nil
+}
+
+// pdfStringValue finds the string value of key in the dictionary between
+// start and end and returns where it begins and ends, its delimiters
+// included: a literal string in balanced parentheses, or a hex string
+func pdfStringValue(data []byte, start, end int, key string) ([2]int, bool) {
+	m := regexp.MustCompile(`/` + key + `\s*([(<])`).FindSubmatchIndex(data[start:end])
+	if m == nil {
+		return [2]int{}, false
+	}
+	open := start + m[2]
+	if data[open] == '<' {
+		n := bytes.IndexByte(data[open:end], '>')
+		if n < 0 {
+			return [2]int{}, false
+		}
+		return [2]int{open, open + n + 1}, true
+	}
+	depth := 0
+	for i := open; i < end; i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return [2]int{open, i + 1}, true
+			}
+		}
+	}
+	return [2]int{}, false
+}
+
+// pdfHeader returns the bytes format detection looks at
+func pdfHeader(image []byte) []byte {
+	if len(image) > 12 {
+		return image[:12]
+	}
+	return image
+}
+
+// pdfDictEnd returns the offset just past the dictionary starting at start,
+// or -1 if it is not closed
+func pdfDictEnd(data []byte, start int) int {
+	depth := 0
+	for i := start; i+1 < len(data); i++ {
+		switch {
+		case data[i] == '<' && data[i+1] == '<':
+			depth++
+			i++
+		case data[i] == '>' && data[i+1] == '>':
+			depth--
+			i++
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// pdfStreamStart returns where stream data begins if the dictionary ending
+// at pos is followed by the stream keyword
+func pdfStreamStart(data []byte, pos int) (int, bool) {
+	rest := bytes.TrimLeft(data[pos:], " \t\r\n")
+	if !bytes.HasPrefix(rest, []byte("stream")) {
+		return 0, false
+	}
+	start := len(data) - len(rest) + len("stream")
+	switch {
+	case bytes.HasPrefix(data[start:], []byte("\r\n")):
+		return start + 2, true
+	case bytes.HasPrefix(data[start:], []byte("\n")):
+		return start + 1, true
+	}
+	return 0, false
+}
+
+// pdfLength returns a stream dictionary's /Length and whether it is a
+// direct integer rather than a reference to another object
+func pdfLength(dict []byte) (int, bool) {
+	m := pdfLengthKey.FindSubmatch(dict)
+	if m == nil || m[2] != nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(m[1]))
+	return n, err == nil && n >= 0
+}
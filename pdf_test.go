@@ -0,0 +1,155 @@
+package exifremover
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"strings"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// pdfStream is a stream object of scanPDF: its dictionary entries and
+// data, with /Length written as length or, when that's empty, the size of
+// the data
+type pdfStream struct {
+	dict, length string
+	data         []byte
+}
+
+// scanPDF returns a PDF as a scan app writes it: one page drawing one
+// image, with an XMP metadata stream and an Info dictionary, and a classic
+// xref table with the offsets of its objects
+func scanPDF(image, content, metadata pdfStream, info string) []byte {
+	objects := [][]byte{
+		[]byte("<< /Type /Catalog /Pages 2 0 R /Metadata 5 0 R >>"),
+		[]byte("<< /Type /Pages /Kids [3 0 R] /Count 1 >>"),
+		[]byte("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 8 8] /Resources << /XObject << /Im0 4 0 R >> >> /Contents 6 0 R >>"),
+	}
+	for _, s := range []pdfStream{image, metadata, content} {
+		length := s.length
+		if length == "" {
+			length = fmt.Sprint(len(s.data))
+		}
+		obj := fmt.Sprintf("<< %s /Length %s >>\nstream\n", s.dict, length)
+		objects = append(objects, append(append([]byte(obj), s.data...), "\nendstream"...))
+	}
+	objects = append(objects, []byte("<< "+info+" >>"))
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info 7 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return b.Bytes()
+}
+
+// samplePDF returns scanPDF with a JPEG page carrying the sample EXIF
+func samplePDF() []byte {
+	return scanPDF(
+		pdfStream{dict: "/Type /XObject /Subtype /Image /Width 8 /Height 8 /BitsPerComponent 8 /ColorSpace /DeviceRGB /Filter /DCTDecode", data: fixture.EXIFJPEG(fixture.Sample().Bytes())},
+		pdfStream{data: []byte("q 8 0 0 8 0 0 cm /Im0 Do Q")},
+		pdfStream{dict: "/Type /Metadata /Subtype /XML", data: fixture.XMP(`xmlns:exif="http://ns.adobe.com/exif/1.0/" exif:GPSLatitude="51,30.12N"`, "")},
+		`/Author (John Artist) /Producer (ScanApp 2.1 \(iOS\)) /CreationDate (D:20230614184207) /Title <4C65617365>`,
+	)
+}
+
+func TestSanitizeScannedPDF(t *testing.T) {
+	in := samplePDF()
+	var out bytes.Buffer
+	reports, err := SanitizeScannedPDFReport(bytes.NewReader(in), &out, Config{RemoveGPSInfo: true, RemoveUserInfo: true, RemoveEditingInfo: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != len(in) {
+		t.Fatalf("output is %d bytes, want %d: offsets moved", out.Len(), len(in))
+	}
+	assertAbsent(t, out.Bytes(), "NETWORK-Somewhere", "John Artist", "ScanApp", "51,30.12N")
+	for _, kept := range []string{"q 8 0 0 8 0 0 cm /Im0 Do Q", "(D:20230614184207)", "<4C65617365>", "startxref"} {
+		if !bytes.Contains(out.Bytes(), []byte(kept)) {
+			t.Errorf("output lost %q", kept)
+		}
+	}
+	if len(reports) != 2 || reports[0].Format != FormatJPEG {
+		t.Fatalf("got %d reports, want the image's and the document's", len(reports))
+	}
+	var names []string
+	for _, item := range reports[1].Removed {
+		names = append(names, item.Name)
+	}
+	if got := strings.Join(names, ","); got != "PDF Info Author,PDF Info Producer,exif:GPSLatitude" {
+		t.Errorf("document removals = %s", got)
+	}
+
+	// The image is sanitized and padded back to its place
+	start := bytes.Index(in, []byte("stream\n\xff\xd8")) + len("stream\n")
+	end := start + bytes.Index(in[start:], []byte("\nendstream"))
+	if _, err := jpeg.Decode(bytes.NewReader(out.Bytes()[start:end])); err != nil {
+		t.Errorf("image doesn't decode: %v", err)
+	}
+
+	// Outside it, only blanked values changed
+	for i := range in {
+		if (i < start || i >= end) && in[i] != out.Bytes()[i] && out.Bytes()[i] != ' ' {
+			t.Fatalf("byte %d changed from %q to %q", i, in[i], out.Bytes()[i])
+		}
+	}
+}
+
+func TestSanitizeScannedPDFRemoveAll(t *testing.T) {
+	var out bytes.Buffer
+	if _, err := SanitizeScannedPDFReport(bytes.NewReader(samplePDF()), &out, Config{RemoveAll: true}); err != nil {
+		t.Fatal(err)
+	}
+	assertAbsent(t, out.Bytes(), "D:20230614184207", "4C65617365", "CanonMake")
+	if !bytes.Contains(out.Bytes(), []byte("/Title <          >")) {
+		t.Error("hex Title wasn't blanked in place")
+	}
+}
+
+func TestSanitizeScannedPDFRejected(t *testing.T) {
+	image := pdfStream{dict: "/Subtype /Image /Filter /DCTDecode", data: fixture.EXIFJPEG(fixture.Sample().Bytes())}
+	content := pdfStream{data: []byte("q Q")}
+	metadata := pdfStream{dict: "/Type /Metadata /Subtype /XML", data: []byte("<x/>")}
+	huge := "9223372036854775807"
+	for name, in := range map[string][]byte{
+		"not a PDF":              []byte("%!PS-Adobe-3.0\n"),
+		"no images":              scanPDF(content, content, metadata, ""),
+		"huge image /Length":     scanPDF(pdfStream{dict: image.dict, length: huge, data: image.data}, content, metadata, ""),
+		"image /Length past end": scanPDF(pdfStream{dict: image.dict, length: "999999", data: image.data}, content, metadata, ""),
+		"indirect image /Length": scanPDF(pdfStream{dict: image.dict, length: "9 0 R", data: image.data}, content, metadata, ""),
+		"incremental update":     append(samplePDF(), "startxref\n0\n%%EOF\n"...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			_, err := SanitizeScannedPDFReport(bytes.NewReader(in), &out, Config{RemoveGPSInfo: true})
+			if !errors.Is(err, ErrUnsupportedPDFStructure) {
+				t.Errorf("err = %v, want ErrUnsupportedPDFStructure", err)
+			}
+			if out.Len() != 0 {
+				t.Error("output written before failing")
+			}
+		})
+	}
+
+	// A bad /Length on a stream that isn't an image is skipped past by
+	// its endstream
+	for _, length := range []string{huge, "999999"} {
+		in := scanPDF(image, pdfStream{length: length, data: []byte("q Q")}, metadata, "")
+		var out bytes.Buffer
+		if _, err := SanitizeScannedPDFReport(bytes.NewReader(in), &out, Config{RemoveGPSInfo: true}); err != nil {
+			t.Errorf("content /Length %s: %v", length, err)
+		}
+		assertAbsent(t, out.Bytes(), "NETWORK-Somewhere")
+	}
+}
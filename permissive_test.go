@@ -0,0 +1,101 @@
+package exifremover
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// markedTIFF returns the sample TIFF with its byte order marker replaced,
+// as the photo frame firmware writes it
+func markedTIFF(marker string) []byte {
+	tiff := fixture.Sample().Bytes()
+	copy(tiff, marker)
+	return tiff
+}
+
+// permissiveInputs carry an EXIF container with the given byte order
+// marker in each format that holds one
+func permissiveInputs(marker string) map[string][]byte {
+	tiff := markedTIFF(marker)
+	return map[string][]byte{
+		"jpeg": fixture.EXIFJPEG(tiff),
+		"png":  fixture.WithChunks(fixture.PNG(8, 8), fixture.Chunk("eXIf", tiff)),
+		"webp": fixture.WebP(fixture.WebPChunk("EXIF", tiff)),
+	}
+}
+
+// decodable fails the test unless the image package decodes out; WebP has
+// no decoder in the standard library and is checked by Inspect alone
+func decodable(t *testing.T, format string, out []byte) {
+	t.Helper()
+	if format == "webp" {
+		return
+	}
+	if _, _, err := image.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("output doesn't decode: %v", err)
+	}
+}
+
+// TestPermissiveByteOrderCase checks that a marker in the wrong case is
+// read as meant, corrected and warned about under Permissive, and fails
+// the file otherwise
+func TestPermissiveByteOrderCase(t *testing.T) {
+	for _, marker := range []string{"ii", "iI", "Ii"} {
+		for format, in := range permissiveInputs(marker) {
+			t.Run(format+" "+marker, func(t *testing.T) {
+				if _, err := RemoveEXIFFromBytes(in, Config{RemoveGPSInfo: true}); !errors.Is(err, ErrCorruptImage) {
+					t.Errorf("without Permissive: %v, want ErrCorruptImage", err)
+				}
+
+				out, report := sanitize(t, in, Config{RemoveGPSInfo: true, Permissive: true})
+				if !report.hasWarning(WarnByteOrder) {
+					t.Error("no byte-order warning")
+				}
+				if bytes.Contains(out, []byte(marker+"*\x00")) || !bytes.Contains(out, []byte("II*\x00")) {
+					t.Error("marker wasn't corrected to II")
+				}
+				assertAbsent(t, out, "NETWORK-Somewhere")
+				m := inspect(t, out)
+				if m.hasTag("GPS", 0x0002) || !m.hasTag("IFD0", 0x0110) {
+					t.Error("EXIF wasn't sanitized as little-endian")
+				}
+				decodable(t, format, out)
+			})
+		}
+	}
+}
+
+// TestPermissiveByteOrderUnknown checks that a marker that can't be
+// recognized, here "II" with a bit flipped, drops the whole container
+// under Permissive and fails the file otherwise
+func TestPermissiveByteOrderUnknown(t *testing.T) {
+	for _, marker := range []string{"IH", "\x00\x00"} {
+		for format, in := range permissiveInputs(marker) {
+			t.Run(format+" "+marker, func(t *testing.T) {
+				if _, err := RemoveEXIFFromBytes(in, Config{RemoveGPSInfo: true}); !errors.Is(err, ErrCorruptImage) {
+					t.Errorf("without Permissive: %v, want ErrCorruptImage", err)
+				}
+
+				out, report := sanitize(t, in, Config{RemoveGPSInfo: true, Permissive: true})
+				assertAbsent(t, out, "CanonMake", "NETWORK-Somewhere", "SERIAL-0042")
+				var dropped bool
+				for _, item := range report.Removed {
+					dropped = dropped || item.Carrier == CarrierEXIF && item.Size >= int64(len(markedTIFF(marker)))
+				}
+				if !dropped {
+					t.Errorf("report doesn't list the EXIF container removed: %+v", report.Removed)
+				}
+				if m := inspect(t, out); len(m.Tags) != 0 {
+					t.Errorf("output still has %d tags", len(m.Tags))
+				}
+				decodable(t, format, out)
+			})
+		}
+	}
+}
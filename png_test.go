@@ -0,0 +1,373 @@
+package exifremover
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image/png"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// TestPNGLargeTextStreamed checks that text chunks are never held to
+// MaxMetadataSize, since only their keyword is read
+func TestPNGLargeTextStreamed(t *testing.T) {
+	text := append([]byte("Description\x00"), bytes.Repeat([]byte("long text "), 6000)...)
+	in := fixture.WithChunks(fixture.PNG(4, 4),
+		fixture.Chunk("tEXt", text),
+		fixture.Chunk("zTXt", append([]byte("Comment\x00\x00"), bytes.Repeat([]byte{0x78}, 5000)...)),
+	)
+	for _, config := range []Config{
+		{MaxMetadataSize: 1024},
+		{MaxMetadataSize: 1024, TextKeysToRemove: []string{"Author"}},
+	} {
+		out, _ := sanitize(t, in, config)
+		if !bytes.Equal(out, in) {
+			t.Errorf("%+v: text chunks changed", config)
+		}
+	}
+
+	out, report := sanitize(t, in, Config{MaxMetadataSize: 1024, TextKeysToRemove: []string{"Description"}})
+	assertAbsent(t, out, "long text")
+	if !bytes.Contains(out, []byte("Comment")) {
+		t.Error("zTXt Comment removed")
+	}
+	if len(report.Removed) != 1 || report.Removed[0].Name != "tEXt Description" || report.Removed[0].Size != int64(len(text)) {
+		t.Errorf("removed %+v", report.Removed)
+	}
+}
+
+func TestPNGLongKeyword(t *testing.T) {
+	// A keyword over 79 bytes is invalid; it is matched by its prefix
+	// and never read beyond it
+	keyword := bytes.Repeat([]byte("K"), 100)
+	in := fixture.WithChunks(fixture.PNG(4, 4), fixture.Chunk("tEXt", append(keyword, "\x00value"...)))
+	out, _ := sanitize(t, in, Config{TextKeysToRemove: []string{string(keyword)}})
+	if !bytes.Equal(out, in) {
+		t.Error("chunk with an overlong keyword changed")
+	}
+	out, _ = sanitize(t, in, Config{RemoveTextChunks: true})
+	assertAbsent(t, out, "value")
+}
+
+// textChunks returns the type and data of every textual chunk in a PNG
+func textChunks(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	chunks := map[string][]byte{}
+	for pos := 8; pos+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		body := data[pos+8 : pos+8+length]
+		if crc32.ChecksumIEEE(data[pos+4:pos+8+length]) != binary.BigEndian.Uint32(data[pos+8+length:]) {
+			t.Errorf("%s chunk has a bad CRC", typ)
+		}
+		if typ == "tEXt" || typ == "zTXt" || typ == "iTXt" {
+			keyword, _, _ := bytes.Cut(body, []byte{0})
+			chunks[typ+" "+string(keyword)] = body
+		}
+		pos += 12 + length
+	}
+	return chunks
+}
+
+func TestPNGBlankText(t *testing.T) {
+	in := fixture.WithChunks(fixture.PNG(4, 4),
+		fixture.Chunk("tEXt", []byte("Software\x00Some Editor 1.0")),
+		fixture.Chunk("zTXt", append([]byte("Comment\x00\x00"), zlibBytes(t, "a long private comment")...)),
+		fixture.Chunk("iTXt", []byte("Title\x00\x01\x00de\x00Titel\x00"+string(zlibBytes(t, "Familienurlaub")))),
+		fixture.Chunk("tEXt", []byte("parameters\x00seed 1234")),
+	)
+	policy := `{"TextKeyModes": {"Software": "blank", "Comment": "blank", "Title": "blank", "parameters": "drop"}}`
+	config, err := ParsePolicy(strings.NewReader(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, report := sanitize(t, in, config)
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("output doesn't decode: %v", err)
+	}
+	assertAbsent(t, out, "Some Editor")
+	assertAbsent(t, out, "seed 1234")
+
+	chunks := textChunks(t, out)
+	if len(chunks) != 3 {
+		t.Errorf("text chunks %q, want the three blanked", chunks)
+	}
+	if got := chunks["tEXt Software"]; string(got) != "Software\x00" {
+		t.Errorf("tEXt blanked to %q", got)
+	}
+	if got := chunks["zTXt Comment"]; !bytes.HasPrefix(got, []byte("Comment\x00\x00")) {
+		t.Errorf("zTXt blanked to %q", got)
+	} else if text, err := io.ReadAll(mustZlib(t, got[len("Comment\x00\x00"):])); err != nil || len(text) != 0 {
+		t.Errorf("zTXt text %q, %v", text, err)
+	}
+	if got := chunks["iTXt Title"]; string(got) != "Title\x00\x00\x00de\x00Titel\x00" {
+		t.Errorf("iTXt blanked to %q", got)
+	}
+
+	strengths := map[string]RemovalStrength{}
+	for _, item := range report.Removed {
+		strengths[item.Name] = item.Strength
+	}
+	want := map[string]RemovalStrength{
+		"tEXt Software":   RemovalOverwritten,
+		"zTXt Comment":    RemovalOverwritten,
+		"iTXt Title":      RemovalOverwritten,
+		"tEXt parameters": RemovalEliminated,
+	}
+	if !reflect.DeepEqual(strengths, want) {
+		t.Errorf("removed %v, want %v", strengths, want)
+	}
+}
+
+func TestPNGBlankTextMode(t *testing.T) {
+	in := fixture.WithChunks(fixture.PNG(4, 4),
+		fixture.Chunk("tEXt", []byte("Author\x00Someone")),
+		fixture.Chunk("tEXt", []byte("Software\x00Some Editor")),
+	)
+	out, _ := sanitize(t, in, Config{RemoveTextChunks: true, TextMode: TextBlank, TextKeyModes: map[string]TextMode{"Software": TextDrop}})
+	chunks := textChunks(t, out)
+	if len(chunks) != 1 || string(chunks["tEXt Author"]) != "Author\x00" {
+		t.Errorf("text chunks %q, want Author blanked and Software dropped", chunks)
+	}
+}
+
+func TestPNGLatin1Keyword(t *testing.T) {
+	// "Légende" in Latin-1, as the spec encodes keywords, matched by its
+	// UTF-8 spelling in the Config and reported in UTF-8
+	in := fixture.WithChunks(fixture.PNG(4, 4), fixture.Chunk("tEXt", []byte("L\xe9gende\x00caption")))
+	out, report := sanitize(t, in, Config{TextKeyModes: map[string]TextMode{"Légende": TextBlank}})
+	if got := textChunks(t, out)["tEXt L\xe9gende"]; string(got) != "L\xe9gende\x00" {
+		t.Errorf("chunk blanked to %q", got)
+	}
+	if len(report.Removed) != 1 || report.Removed[0].Name != "tEXt Légende" {
+		t.Errorf("removed %+v", report.Removed)
+	}
+}
+
+func TestPNGBlankTruncatedITXt(t *testing.T) {
+	// An iTXt ending inside its fields can't be blanked, so it is dropped
+	in := fixture.WithChunks(fixture.PNG(4, 4), fixture.Chunk("iTXt", []byte("Title\x00\x00\x00en")))
+	out, report := sanitize(t, in, Config{TextKeyModes: map[string]TextMode{"Title": TextBlank}})
+	if len(textChunks(t, out)) != 0 || len(report.Removed) != 1 || report.Removed[0].Strength != RemovalEliminated {
+		t.Errorf("removed %+v", report.Removed)
+	}
+}
+
+func TestPNGUnknownTextMode(t *testing.T) {
+	if _, err := ParsePolicy(strings.NewReader(`{"TextKeyModes": {"Software": "erase"}}`)); err == nil {
+		t.Error("unknown mode name accepted")
+	}
+	if _, err := New(Config{TextMode: lastTextMode + 1}); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
+
+func zlibBytes(t testing.TB, text string) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	if _, err := w.Write([]byte(text)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func mustZlib(t *testing.T, data []byte) io.Reader {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// chunkFlood generates a PNG with n one-byte tEXt chunks between its IHDR
+// and IDAT, so no file of that size is ever held in memory
+type chunkFlood struct {
+	head, tail []byte // the PNG up to and after IHDR
+	n          int
+	buf        []byte
+	read       int64
+}
+
+func newChunkFlood(n int) *chunkFlood {
+	image := fixture.PNG(4, 4)
+	ihdr := 8 + 12 + int(binary.BigEndian.Uint32(image[8:]))
+	return &chunkFlood{head: image[:ihdr], tail: image[ihdr:], n: n}
+}
+
+var floodChunk = fixture.Chunk("tEXt", []byte("k"))
+
+func (f *chunkFlood) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		switch {
+		case f.head != nil:
+			f.buf, f.head = f.head, nil
+		case f.n > 0:
+			f.buf = floodChunk
+			f.n--
+		case f.tail != nil:
+			f.buf, f.tail = f.tail, nil
+		default:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	f.read += int64(n)
+	return n, nil
+}
+
+func TestPNGChunkFlood(t *testing.T) {
+	const n = 200000
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	report, err := RemoveReport(newChunkFlood(n), io.Discard, Config{RemoveTextChunks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+	// Everything allocated counts, collected or not, so per-chunk
+	// buffers show however promptly the GC reclaims them
+	if total := after.TotalAlloc - before.TotalAlloc; total > 32<<20 {
+		t.Errorf("allocated %d bytes over %d chunks", total, n)
+	}
+
+	if len(report.Removed) != chunkListLimit+1 {
+		t.Fatalf("%d items removed, want %d", len(report.Removed), chunkListLimit+1)
+	}
+	combined := report.Removed[chunkListLimit]
+	if combined.Name != "tEXt ×199900" || combined.Count != n-chunkListLimit || combined.Size != n-chunkListLimit {
+		t.Errorf("combined item %+v", combined)
+	}
+	if report.RemovedBytes() != n {
+		t.Errorf("removed %d bytes, want %d", report.RemovedBytes(), n)
+	}
+	runtime.KeepAlive(report)
+}
+
+func TestPNGMaxChunks(t *testing.T) {
+	flood := newChunkFlood(1000000)
+	_, err := RemoveReport(flood, io.Discard, Config{RemoveTextChunks: true, MaxChunks: 1000})
+	if !errors.Is(err, ErrTooManyChunks) {
+		t.Fatalf("got %v, want ErrTooManyChunks", err)
+	}
+	if limit := int64(1001 * len(floodChunk)); flood.read > limit+64<<10 {
+		t.Errorf("read %d bytes before failing, want about %d", flood.read, limit)
+	}
+}
+
+// checkPNGCRCs fails the test for every chunk whose CRC doesn't match its
+// type and data, returning how many chunks it checked
+func checkPNGCRCs(t *testing.T, data []byte) int {
+	t.Helper()
+	n := 0
+	for pos := 8; pos+12 <= len(data); n++ {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 8 + length
+		if want := crc32.ChecksumIEEE(data[pos+4 : end]); binary.BigEndian.Uint32(data[end:]) != want {
+			t.Errorf("%s chunk CRC %08x, want %08x", data[pos+4:pos+8], binary.BigEndian.Uint32(data[end:]), want)
+		}
+		pos = end + 4
+	}
+	return n
+}
+
+func TestPNGRewrittenChunkCRCs(t *testing.T) {
+	// Every chunk the library rewrites: eXIf, iCCP and blanked text
+	profile := make([]byte, 128)
+	copy(profile[36:], "acsp")
+	copy(profile[24:], []byte{0x07, 0xe7, 0, 6, 0, 14, 18, 42, 7, 0})
+	in := fixture.WithChunks(fixture.PNG(8, 8),
+		fixture.Chunk("eXIf", fixture.Sample().Bytes()),
+		fixture.Chunk("iCCP", append([]byte("icc\x00\x00"), zlibBytes(t, string(profile))...)),
+		fixture.Chunk("tEXt", []byte("Author\x00Someone")),
+	)
+	config := Config{
+		RemoveGPSInfo:    true,
+		RemoveDateTime:   true,
+		ScrubICCProfile:  true,
+		RemoveTextChunks: true,
+		TextMode:         TextBlank,
+	}
+	out, report := sanitize(t, in, config)
+	if bytes.Equal(out, in) || len(report.Removed) == 0 {
+		t.Fatal("nothing rewritten")
+	}
+	if n := checkPNGCRCs(t, out); n < 6 {
+		t.Errorf("checked %d chunks, want all of them", n)
+	}
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("output doesn't decode: %v", err)
+	}
+}
+
+// TestPNGIHDRUnchanged checks that no preset touches the IHDR chunk,
+// whatever the bit depth, color type or interlacing it declares
+func TestPNGIHDRUnchanged(t *testing.T) {
+	const ihdrEnd = 8 + 8 + 13 + 4
+	headers := map[string][]byte{
+		"8-bit truecolor":        withIHDR(8, 8, 8, 2),
+		"16-bit truecolor alpha": withIHDR(8, 8, 16, 6),
+		"16-bit gray":            withIHDR(8, 8, 16, 0),
+		"1-bit gray":             withIHDR(8, 8, 1, 0),
+		"4-bit indexed":          withIHDR(8, 8, 4, 3),
+		"gray alpha":             withIHDR(8, 8, 8, 4),
+	}
+	interlaced := withIHDR(8, 8, 16, 6)
+	interlaced[8+8+12] = 1 // Adam7
+	binary.BigEndian.PutUint32(interlaced[ihdrEnd-4:], crc32.ChecksumIEEE(interlaced[12:ihdrEnd-4]))
+	headers["16-bit interlaced"] = interlaced
+
+	presets := append(selfTestPresets(), []struct {
+		name   string
+		config Config
+	}{
+		{"repair", Config{RemoveAll: true, RepairStructure: true}},
+		{"stamp", Config{RemoveAll: true, StampProcessed: true}},
+		{"unknown chunks", Config{RemoveAll: true, RemoveUnknownChunks: true, RemoveICCProfile: true}},
+	}...)
+	for name, png := range headers {
+		in := fixture.WithChunks(png,
+			fixture.Chunk("eXIf", fixture.Sample().Bytes()),
+			fixture.Chunk("tEXt", []byte("Author\x00Someone")),
+			fixture.Chunk("prVt", []byte("private")),
+		)
+		for _, preset := range presets {
+			t.Run(name+", "+preset.name, func(t *testing.T) {
+				out, _ := sanitize(t, in, preset.config)
+				if !bytes.Equal(out[:ihdrEnd], in[:ihdrEnd]) {
+					t.Errorf("IHDR = %x, want %x", out[8:ihdrEnd], in[8:ihdrEnd])
+				}
+			})
+		}
+	}
+}
+
+func FuzzRemovePNG(f *testing.F) {
+	tiff := fixture.Sample().Bytes()
+	png := fixture.WithChunks(fixture.PNG(8, 8),
+		fixture.Chunk("eXIf", tiff),
+		fixture.Chunk("tEXt", []byte("Author\x00Someone")),
+		fixture.Chunk("zTXt", append([]byte("Comment\x00\x00"), zlibBytes(f, "text")...)),
+		fixture.Chunk("iTXt", []byte("Title\x00\x00\x00en\x00Titel\x00text")),
+	)
+	f.Add(png, uint8(0))
+	f.Add(png, uint8(1))
+	f.Add(png[:len(png)-20], uint8(2))
+	f.Add(append(append([]byte(nil), png[:8]...), 0xFF, 0xFF, 0xFF, 0xF0, 'e', 'X', 'I', 'f'), uint8(0))
+	f.Add(append(append([]byte(nil), png[:8]...), 0x7F, 0xFF, 0xFF, 0xFF, 'i', 'C', 'C', 'P'), uint8(1))
+	f.Fuzz(func(t *testing.T, data []byte, mode uint8) {
+		fuzzRemove(t, data, mode)
+	})
+}
@@ -0,0 +1,158 @@
+package exifremover
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// TextMode is how a PNG text chunk chosen for removal is removed
+type TextMode int
+
+const (
+	// TextDrop removes the whole chunk
+	TextDrop TextMode = iota
+	// TextBlank keeps the chunk and its keyword with an empty text, for
+	// readers that expect the keyword to be present
+	TextBlank
+
+	lastTextMode = TextBlank
+)
+
+// String returns a lower-case name for the mode
+func (m TextMode) String() string {
+	switch m {
+	case TextDrop:
+		return "drop"
+	case TextBlank:
+		return "blank"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the mode by name
+func (m TextMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText decodes a mode name as returned by String
+func (m *TextMode) UnmarshalText(text []byte) error {
+	for mode := TextDrop; mode <= lastTextMode; mode++ {
+		if mode.String() == string(text) {
+			*m = mode
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown text mode %q", text)
+}
+
+// validateText checks the text modes
+func validateText(config Config) error {
+	if config.TextMode < TextDrop || config.TextMode > lastTextMode {
+		return fmt.Errorf("unknown text mode %d", int(config.TextMode))
+	}
+	for k, mode := range config.TextKeyModes {
+		if mode < TextDrop || mode > lastTextMode {
+			return fmt.Errorf("unknown text mode %d for %q", int(mode), k)
+		}
+	}
+	return nil
+}
+
+// pngTextKey is a registered PNG text keyword whose value belongs to a
+// removal category
+type pngTextKey struct {
+	Keyword    string
+	Exiftool   string     // exiftool group:tag name for the same field
+	Categories []Category // removed when any of these is enabled
+}
+
+// pngTextKeys is the decision table for PNG text chunks by keyword, from
+// the keywords the PNG spec registers. Title, Description and Comment hold
+// the image's own content and are in no category; RemoveTextChunks and
+// TextKeysToRemove reach them.
+var pngTextKeys = []pngTextKey{
+	{"Author", "PNG:Author", []Category{CategoryUserInfo}},
+	{"Copyright", "PNG:Copyright", []Category{CategoryCopyright}},
+	{"Creation Time", "PNG:CreationTime", []Category{CategoryDateTime}},
+	{"Software", "PNG:Software", []Category{CategoryEditingInfo}},
+	{"Source", "PNG:Source", []Category{CategoryCameraInfo}},
+}
+
+// removed reports whether any of the keyword's categories is enabled
+func (k pngTextKey) removed(config Config) bool {
+	for _, c := range k.Categories {
+		if c.enabled(config) {
+			return true
+		}
+	}
+	return false
+}
+
+// textKeyCategories returns the categories pngTextKeys gives keyword
+func textKeyCategories(keyword string) []Category {
+	for _, k := range pngTextKeys {
+		if k.Keyword == keyword {
+			return k.Categories
+		}
+	}
+	return nil
+}
+
+// latin1 decodes a keyword, which the PNG spec encodes in Latin-1 in every
+// textual chunk type, so it compares with the UTF-8 keywords of a Config
+func latin1(b []byte) string {
+	buf := make([]byte, 0, len(b))
+	for _, c := range b {
+		buf = utf8.AppendRune(buf, rune(c))
+	}
+	return string(buf)
+}
+
+// emptyZlib is a zlib stream of no bytes: the header, an empty fixed
+// Huffman block and the Adler-32 of nothing. zTXt has no uncompressed
+// form, so a blanked zTXt holds this.
+var emptyZlib = []byte{0x78, 0x9c, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01}
+
+// blankText returns the data of a textual chunk with its text emptied.
+// head is the start of the chunk's data, holding the keyword and its NUL;
+// an iTXt's language tag and translated keyword, kept byte for byte as the
+// translated keyword is UTF-8, are read on from r, no more than rest
+// bytes and never past limit in all. The text is written uncompressed. It
+// returns how many bytes it read, and false when the chunk ends before
+// its fields do.
+func blankText(chunkType string, head []byte, r io.Reader, rest, limit int) ([]byte, int, bool, error) {
+	i := bytes.IndexByte(head, 0)
+	if i < 0 {
+		return nil, 0, false, nil
+	}
+	keyword := head[:i+1]
+	switch chunkType {
+	case "tEXt":
+		return append([]byte(nil), keyword...), 0, true, nil
+	case "zTXt":
+		data := append(append([]byte(nil), keyword...), 0) // Compression method 0
+		return append(data, emptyZlib...), 0, true, nil
+	}
+
+	// iTXt: keyword, compression flag and method, language tag NUL,
+	// translated keyword NUL, text
+	fields := append([]byte(nil), head[i+1:]...)
+	read := 0
+	var b [1]byte
+	for len(fields) < 2 || bytes.Count(fields[2:], []byte{0}) < 2 {
+		if read == rest || len(head)+read >= limit {
+			return nil, read, false, nil
+		}
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, read, false, err
+		}
+		fields = append(fields, b[0])
+		read++
+	}
+	lang := bytes.IndexByte(fields[2:], 0) + 2
+	translated := bytes.IndexByte(fields[lang+1:], 0) + lang + 1
+	data := append(append([]byte(nil), keyword...), 0, 0) // Uncompressed
+	return append(data, fields[2:translated+1]...), read, true, nil
+}
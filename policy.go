@@ -0,0 +1,133 @@
+package exifremover
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxRuleValue caps how much of a tag value ValueRules look at, so huge
+// values can't make matching expensive
+const maxRuleValue = 4096
+
+// ValueRule refines the decision for one tag based on its decoded string
+// value. Rules apply to ASCII tags and to UNDEFINED tags using the
+// UserComment character-code prefix (ASCII or UNICODE). A rule with several
+// conditions matches only when all of them do.
+type ValueRule struct {
+	Tag      uint16
+	Equals   string
+	Contains string
+	Regexp   string // RE2 syntax
+	// Keep preserves the tag when its value matches, even if a category
+	// would remove it. Otherwise a match removes the tag even if no
+	// category covers it.
+	Keep bool
+
+	re *regexp.Regexp // Regexp compiled, set by New
+}
+
+// ParsePolicy reads a Config stored as JSON, keyed by Config field names,
+// and validates it, compiling every ValueRule regexp up front so a bad
+// policy fails at load time rather than on the first matching file
+func ParsePolicy(r io.Reader) (Config, error) {
+	var config Config
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return config, err
+	}
+	return config, validateValueRules(config.ValueRules)
+}
+
+// validateValueRules compiles every rule regexp
+func validateValueRules(rules []ValueRule) error {
+	for _, rule := range rules {
+		if rule.Regexp == "" {
+			continue
+		}
+		if _, err := regexp.Compile(rule.Regexp); err != nil {
+			return fmt.Errorf("value rule for tag 0x%04X: %w", rule.Tag, err)
+		}
+	}
+	return nil
+}
+
+// compileRules compiles the regexps of rules, which validateValueRules
+// has checked, into the rules themselves, so matching compiles nothing
+func compileRules(rules []ValueRule) {
+	for i := range rules {
+		if rules[i].Regexp != "" {
+			rules[i].re = regexp.MustCompile(rules[i].Regexp)
+		}
+	}
+}
+
+// matches reports whether value satisfies every condition of the rule
+func (rule ValueRule) matches(value string) bool {
+	if len(value) > maxRuleValue {
+		value = value[:maxRuleValue]
+	}
+	if rule.Equals != "" && value != rule.Equals {
+		return false
+	}
+	if rule.Contains != "" && !strings.Contains(value, rule.Contains) {
+		return false
+	}
+	if rule.Regexp != "" && !rule.re.MatchString(value) {
+		return false
+	}
+	return true
+}
+
+// removeEntry decides whether the IFD entry at pos should be removed:
+// the first ValueRule for its tag whose conditions match decides, and
+// otherwise its categories do. Tags keptTag protects override both.
+func removeEntry(data []byte, pos int, order binary.ByteOrder, config Config) bool {
+	remove, _ := entryDecision(data, pos, order, config)
+	return remove
+}
+
+// entryString decodes the string value of the entry at pos
+func entryString(data []byte, pos int, order binary.ByteOrder) (string, bool) {
+	typ := order.Uint16(data[pos+2 : pos+4])
+	if typ != 2 && typ != 7 { // ASCII, UNDEFINED
+		return "", false
+	}
+	count := int64(order.Uint32(data[pos+4 : pos+8]))
+	value := data[pos+8 : pos+12]
+	if count > 4 {
+		offset := int64(order.Uint32(value))
+		if offset+count > int64(len(data)) {
+			return "", false
+		}
+		value = data[offset : offset+count]
+	} else {
+		value = value[:count]
+	}
+
+	if typ == 2 {
+		if i := strings.IndexByte(string(value), 0); i >= 0 {
+			value = value[:i]
+		}
+		return string(value), true
+	}
+	if len(value) < 8 {
+		return "", false
+	}
+	switch string(value[:8]) {
+	case "ASCII\x00\x00\x00":
+		return strings.TrimRight(string(value[8:]), "\x00 "), true
+	case "UNICODE\x00":
+		units := make([]uint16, (len(value)-8)/2)
+		for i := range units {
+			units[i] = order.Uint16(value[8+2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00 "), true
+	}
+	return "", false
+}
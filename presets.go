@@ -0,0 +1,38 @@
+package exifremover
+
+// ScannedDocumentConfig returns a Config for TIFFs from office scanners and
+// multifunction copiers, which identify the device in IFD0 of every page:
+// its make and model, HostComputer (often a hostname or IP address),
+// Software (the firmware version), the scan timestamps, Copyright, and
+// vendor private tags in the 0x87xx range. The tags a printer needs to
+// render the scan, such as Compression, PhotometricInterpretation,
+// resolution and the strip and tile layout, belong to no category and are
+// kept.
+func ScannedDocumentConfig() Config {
+	return Config{
+		RemoveCameraInfo: true,
+		RemoveDateTime:   true,
+		RemoveCopyright:  true,
+		CategoryOverrides: map[Category][]uint16{
+			CategoryCameraInfo: scannerDeviceTags(),
+		},
+		MergeCategoryOverrides: true,
+	}
+}
+
+// scannerDeviceTags lists the device-identifying tags ScannedDocumentConfig
+// adds to CameraInfo
+func scannerDeviceTags() []uint16 {
+	tags := []uint16{
+		0x0131, // Software
+		0x013c, // HostComputer
+	}
+	for tag := uint16(0x8700); tag <= 0x87ff; tag++ {
+		switch tag {
+		case 0x8769, 0x8773: // EXIF IFD pointer, ICC profile
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
@@ -0,0 +1,91 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// scannerTIFF returns a bilevel CCITT Group 4 page as a multifunction
+// copier writes it, naming the device, its host and the scan time in IFD0
+func scannerTIFF() []byte {
+	le := binary.LittleEndian
+	strip := []byte{0x26, 0xa0, 0x00, 0x10, 0x01}
+	build := func(offset uint32) []byte {
+		t := fixture.TIFF{
+			IFD0: []fixture.Entry{
+				fixture.Long(le, 0x0100, 1700),
+				fixture.Long(le, 0x0101, 2200),
+				fixture.Short(le, 0x0103, 4), // CCITT Group 4
+				fixture.Short(le, 0x0106, 0), // WhiteIsZero
+				fixture.ASCII(0x010f, "OfficeJet"),
+				fixture.ASCII(0x0110, "MFP M480"),
+				fixture.Long(le, 0x0111, offset),
+				fixture.Short(le, 0x0115, 1),
+				fixture.Long(le, 0x0116, 2200),
+				fixture.Long(le, 0x0117, uint32(len(strip))),
+				fixture.Rational(le, 0x011a, 200, 1),
+				fixture.Rational(le, 0x011b, 200, 1),
+				fixture.Short(le, 0x0128, 2), // inch
+				fixture.ASCII(0x0131, "FW 2409.1"),
+				fixture.ASCII(0x0132, "2024:03:05 09:12:44"),
+				fixture.ASCII(0x013c, "scan-host.corp.example"),
+				fixture.ASCII(0x8298, "Example Corp"),
+				fixture.Undefined(0x8746, []byte("SN:CN12345678")),
+			},
+			Tail: strip,
+		}
+		return t.Bytes()
+	}
+	return build(uint32(len(build(0)) - len(strip)))
+}
+
+// TestScannedDocumentConfig checks that the preset strips what identifies
+// the scanner and keeps what a printer or viewer needs to render the page
+func TestScannedDocumentConfig(t *testing.T) {
+	in := scannerTIFF()
+	out, report := sanitize(t, in, ScannedDocumentConfig())
+	assertAbsent(t, out, "OfficeJet", "MFP M480", "FW 2409", "2024:03:05", "scan-host", "Example Corp", "CN12345678")
+
+	removed := make(map[uint16]bool)
+	for _, item := range report.Removed {
+		removed[item.Tag] = true
+	}
+	for tag, name := range map[uint16]string{
+		0x010f: "Make",
+		0x0110: "Model",
+		0x0131: "Software",
+		0x0132: "DateTime",
+		0x013c: "HostComputer",
+		0x8298: "Copyright",
+		0x8746: "vendor tag 0x8746",
+	} {
+		if !removed[tag] {
+			t.Errorf("%s not reported as removed", name)
+		}
+	}
+
+	for tag, name := range map[uint16]string{
+		0x0100: "ImageWidth",
+		0x0101: "ImageLength",
+		0x0103: "Compression",
+		0x0106: "PhotometricInterpretation",
+		0x0111: "StripOffsets",
+		0x0115: "SamplesPerPixel",
+		0x0116: "RowsPerStrip",
+		0x0117: "StripByteCounts",
+		0x011a: "XResolution",
+		0x011b: "YResolution",
+		0x0128: "ResolutionUnit",
+	} {
+		want := ifd0Value(in, tag)
+		if got := ifd0Value(out, tag); got == nil || !bytes.Equal(got, want) {
+			t.Errorf("%s = %x, want %x", name, got, want)
+		}
+	}
+	if !bytes.Equal(out[len(out)-5:], in[len(in)-5:]) {
+		t.Error("image data changed")
+	}
+}
@@ -0,0 +1,162 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strconv"
+)
+
+// tiffPreview is a JPEG a TIFF references as a preview of its image
+type tiffPreview struct {
+	ifd           string
+	start, length int64
+}
+
+// tiffPreviews returns the JPEG previews of a TIFF: those located by
+// JPEGInterchangeFormat (0x0201) and JPEGInterchangeFormatLength (0x0202),
+// and single-strip images with StripOffsets (0x0111) and StripByteCounts
+// (0x0117), in the IFDs chained after IFD0 and the SubIFDs (0x014A) below
+// any of them. IFD0 holds the primary image, so it is never a preview.
+// Regions that don't start with a JPEG SOI marker or don't fit in the file
+// are left out.
+func tiffPreviews(tiff []byte, order binary.ByteOrder) []tiffPreview {
+	var previews []tiffPreview
+	visited := make(map[int]bool)
+	var walk func(offset int, ifd string)
+	walk = func(offset int, ifd string) {
+		if len(visited) >= maxIFDs || offset < 8 || offset+2 > len(tiff) || visited[offset] {
+			return
+		}
+		visited[offset] = true
+		numEntries := int(order.Uint16(tiff[offset : offset+2]))
+		values := make(map[uint16]int64)
+		var subIFDs []int
+		for i, pos := 0, offset+2; i < numEntries && pos+12 <= len(tiff); i, pos = i+1, pos+12 {
+			tag := order.Uint16(tiff[pos : pos+2])
+			switch tag {
+			case 0x0111, 0x0117, 0x0201, 0x0202:
+				if v, ok := inlineUint(tiff, pos, order); ok && !isEmptyEntry(tiff, pos, order) {
+					values[tag] = v
+				}
+			case 0x014A:
+				subIFDs = append(subIFDs, subIFDOffsets(tiff, pos, order)...)
+			}
+		}
+		if ifd != "IFD0" {
+			for _, pair := range [][2]uint16{{0x0201, 0x0202}, {0x0111, 0x0117}} {
+				start, ok := values[pair[0]]
+				length, ok2 := values[pair[1]]
+				if ok && ok2 && start >= 8 && length >= 2 && start <= int64(len(tiff))-length &&
+					tiff[start] == 0xFF && tiff[start+1] == 0xD8 {
+					previews = append(previews, tiffPreview{ifd: ifd, start: start, length: length})
+				}
+			}
+		}
+		for i, sub := range subIFDs {
+			walk(sub, ifd+" SubIFD"+strconv.Itoa(i))
+		}
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	for n := 0; n < maxIFDs && offset >= 8 && offset+2 <= len(tiff) && !visited[offset]; n++ {
+		walk(offset, "IFD"+strconv.Itoa(n))
+		end := offset + 2 + 12*int(order.Uint16(tiff[offset:offset+2]))
+		if end+4 > len(tiff) {
+			break
+		}
+		offset = int(order.Uint32(tiff[end : end+4]))
+	}
+	return previews
+}
+
+// subIFDOffsets returns the IFD offsets a SubIFDs entry lists, as LONG or
+// IFD (type 13) values inline or out of line
+func subIFDOffsets(tiff []byte, pos int, order binary.ByteOrder) []int {
+	typ := order.Uint16(tiff[pos+2 : pos+4])
+	count := int64(order.Uint32(tiff[pos+4 : pos+8]))
+	if typ != 4 && typ != 13 || count == 0 || count > maxIFDs {
+		return nil
+	}
+	values := tiff[pos+8 : pos+12]
+	if count > 1 {
+		offset := int64(order.Uint32(values))
+		if offset < 8 || offset > int64(len(tiff))-4*count {
+			return nil
+		}
+		values = tiff[offset : offset+4*count]
+	}
+	offsets := make([]int, count)
+	for i := range offsets {
+		offsets[i] = int(order.Uint32(values[4*i:]))
+	}
+	return offsets
+}
+
+// grayJPEG is the 1×1 gray image Config.RemoveEmbeddedPreviews puts in
+// place of a preview
+var grayJPEG = func() []byte {
+	img := image.NewGray(image.Rect(0, 0, 1, 1))
+	img.SetGray(0, 0, color.Gray{Y: 0x80})
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, nil); err != nil {
+		panic(err)
+	}
+	return b.Bytes()
+}()
+
+// sanitizePreviews processes the JPEG previews of a TIFF in place, so no
+// offset in the file moves. Each is sanitized under config and zero-padded
+// to its length; one whose sanitized form is larger is kept with a
+// WarnPreview warning. Under Config.RemoveEmbeddedPreviews each is
+// replaced by grayJPEG instead, or zeroed when it is too small to hold it.
+func sanitizePreviews(tiff []byte, config Config, report *Report) {
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+
+	// A preview is part of the file: the checks and the stamp apply to
+	// the whole of it, and the callback to the TIFF's own bytes
+	inner := config
+	inner.Progress, inner.progress = nil, nil
+	inner.StampProcessed, inner.RepairStructure, inner.Trace = false, false, false
+	inner.AssertNoAdditions, inner.FailOnUnhandledMetadata, inner.MinRemovalStrength = false, false, 0
+
+	for _, p := range tiffPreviews(tiff, order) {
+		region := tiff[p.start : p.start+p.length]
+		name := p.ifd + " preview"
+		if config.RemoveEmbeddedPreviews {
+			n := 0
+			if len(grayJPEG) <= len(region) {
+				n = copy(region, grayJPEG)
+			}
+			clear(region[n:])
+			report.remove(RemovedItem{Carrier: CarrierThumbnail, Name: name, Strength: RemovalOverwritten, Size: p.length})
+			continue
+		}
+
+		var out bytes.Buffer
+		sub, err := process(bytes.NewReader(region), region[:2], &out, inner)
+		if err != nil || out.Len() > len(region) {
+			report.warn(WarnPreview, fmt.Sprintf("%s of %d bytes at offset %d kept: it can't be sanitized in place", name, p.length, p.start))
+			continue
+		}
+		clear(region[copy(region, out.Bytes()):])
+		for _, item := range sub.Removed {
+			item.Name = name + " " + item.Name
+			report.remove(item)
+		}
+		for _, w := range sub.Warnings {
+			report.warn(w.Code, name+": "+w.Detail)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package exifremover
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// copyBuffer is how much copyWithProgress moves between checks of its
+// context and calls of Config.Progress
+const copyBuffer = 32 << 10
+
+// copyPool holds the buffers of copyWithProgress, which the PNG handler
+// calls for every chunk
+var copyPool = sync.Pool{New: func() any { return new([copyBuffer]byte) }}
+
+// progress is one call's state for Config.Progress: the call's context
+// and the input bytes consumed so far. done only grows, so the callback
+// sees a monotonic count however the handlers interleave their copies. A
+// progress with no callback still carries the context.
+type progress struct {
+	ctx   context.Context
+	fn    func(done, total int64)
+	done  int64
+	total int64 // -1 when the input size isn't known
+}
+
+// withProgress returns config with the progress state of one call under
+// ctx over an input of total bytes, or -1 when that isn't known
+func withProgress(ctx context.Context, config Config, total int64) Config {
+	config.progress = &progress{ctx: ctx, fn: config.Progress, total: total}
+	return config
+}
+
+// advance counts n more bytes of input consumed and reports them
+func (p *progress) advance(n int64) {
+	p.done += n
+	if p.fn == nil {
+		return
+	}
+	total := p.total
+	if total >= 0 && p.done > total {
+		total = p.done // the file grew while it was read
+	}
+	p.fn(p.done, total)
+}
+
+// finish reports the call complete after read bytes of input, with done
+// equal to total: the bytes read outside the bulk copies, segment headers
+// and metadata, are only counted here
+func (p *progress) finish(read int64) {
+	if p.fn == nil {
+		return
+	}
+	if read > p.done {
+		p.done = read
+	}
+	if p.total > p.done {
+		p.done = p.total
+	}
+	p.total = p.done
+	p.fn(p.done, p.total)
+}
+
+// copyWithProgress copies n bytes from src to dst, or up to EOF when n is
+// negative, in copyBuffer pieces. ctx is checked before every piece, so
+// cancellation is seen within one buffer of IO however large the copy,
+// and each piece written advances progress. Like io.CopyN it returns
+// io.EOF when src ends before n bytes.
+func copyWithProgress(ctx context.Context, dst io.Writer, src io.Reader, n int64, progress *progress) (int64, error) {
+	pooled := copyPool.Get().(*[copyBuffer]byte)
+	defer copyPool.Put(pooled)
+	buf := pooled[:]
+	var written int64
+	for n < 0 || written < n {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		p := buf
+		if n >= 0 && n-written < int64(len(p)) {
+			p = p[:n-written]
+		}
+		read, err := src.Read(p)
+		if read > 0 {
+			w, werr := dst.Write(p[:read])
+			written += int64(w)
+			progress.advance(int64(w))
+			if werr != nil {
+				return written, werr
+			}
+			if w < read {
+				return written, io.ErrShortWrite
+			}
+		}
+		if err == io.EOF {
+			if n < 0 {
+				return written, nil
+			}
+			return written, io.EOF
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// readWhole reads all of r for the handlers that edit a whole file in
+// memory, through copyWithProgress so a large TIFF reports its progress
+// and stops on cancellation while it's read
+func readWhole(r io.Reader, config Config) ([]byte, error) {
+	var data bytes.Buffer
+	_, err := copyWithProgress(config.progress.ctx, &data, r, -1, config.progress)
+	return data.Bytes(), err
+}
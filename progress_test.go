@@ -0,0 +1,170 @@
+package exifremover
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// progressLog records the calls of a Config.Progress callback
+type progressLog struct {
+	calls [][2]int64
+}
+
+func (l *progressLog) record(done, total int64) {
+	l.calls = append(l.calls, [2]int64{done, total})
+}
+
+// check fails the test unless done never decreased and the last call
+// reported done equal to total, at want when that's known
+func (l *progressLog) check(t *testing.T, want int64) {
+	t.Helper()
+	if len(l.calls) == 0 {
+		t.Fatal("Progress was never called")
+	}
+	for i := 1; i < len(l.calls); i++ {
+		if l.calls[i][0] < l.calls[i-1][0] {
+			t.Fatalf("call %d went back from %d to %d", i, l.calls[i-1][0], l.calls[i][0])
+		}
+	}
+	last := l.calls[len(l.calls)-1]
+	if last[0] != last[1] || want >= 0 && last[0] != want {
+		t.Errorf("last call = %d of %d, want %d of %d", last[0], last[1], want, want)
+	}
+}
+
+// largeTIFF returns the sample TIFF followed by size bytes of image data
+func largeTIFF(size int) []byte {
+	tiff := fixture.Sample()
+	tiff.Tail = bytes.Repeat([]byte{0x5a}, size)
+	return tiff.Bytes()
+}
+
+func TestProgressMonotonicAndFinal(t *testing.T) {
+	tiff := largeTIFF(1 << 20)
+	jpeg := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	config := Config{RemoveGPSInfo: true}
+
+	t.Run("file", func(t *testing.T) {
+		in := filepath.Join(t.TempDir(), "large.tif")
+		if err := os.WriteFile(in, tiff, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var log progressLog
+		config := config
+		config.Progress = log.record
+		s, err := New(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.RemoveFile(in, in+".out"); err != nil {
+			t.Fatal(err)
+		}
+		log.check(t, int64(len(tiff)))
+		if len(log.calls) < len(tiff)/copyBuffer {
+			t.Errorf("%d calls for %d bytes, want one per %d", len(log.calls), len(tiff), copyBuffer)
+		}
+	})
+
+	for name, in := range map[string][]byte{"tiff": tiff, "jpeg": jpeg} {
+		t.Run(name+" bytes", func(t *testing.T) {
+			var log progressLog
+			config := config
+			config.Progress = log.record
+			if _, _, err := RemoveEXIFFromBytesReport(in, config); err != nil {
+				t.Fatal(err)
+			}
+			log.check(t, int64(len(in)))
+		})
+		t.Run(name+" stream", func(t *testing.T) {
+			// A reader with no Len has no known total
+			var log progressLog
+			config := config
+			config.Progress = log.record
+			if _, err := RemoveReport(io.MultiReader(bytes.NewReader(in)), io.Discard, config); err != nil {
+				t.Fatal(err)
+			}
+			log.check(t, -1)
+			if last := log.calls[len(log.calls)-1]; last[0] != int64(len(in)) {
+				t.Errorf("stream ended at %d, want %d", last[0], len(in))
+			}
+		})
+	}
+}
+
+// cancellingReader is an endless reader that cancels its context after
+// the first read
+type cancellingReader struct {
+	cancel context.CancelFunc
+	read   int64
+}
+
+func (c *cancellingReader) Read(p []byte) (int, error) {
+	c.cancel()
+	c.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestCopyWithProgressCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &cancellingReader{cancel: cancel}
+	var log progressLog
+	p := &progress{ctx: ctx, fn: log.record, total: -1}
+	n, err := copyWithProgress(ctx, io.Discard, src, -1, p)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if src.read > copyBuffer || n != src.read {
+		t.Errorf("copied %d of %d bytes read after cancelling, want at most %d", n, src.read, copyBuffer)
+	}
+	if len(log.calls) != 1 || log.calls[0][0] != n {
+		t.Errorf("progress calls = %v, want one of %d", log.calls, n)
+	}
+}
+
+func TestCopyWithProgressShort(t *testing.T) {
+	p := &progress{ctx: context.Background(), total: -1}
+	var dst bytes.Buffer
+	n, err := copyWithProgress(p.ctx, &dst, bytes.NewReader(make([]byte, 100)), 200, p)
+	if err != io.EOF || n != 100 || p.done != 100 {
+		t.Errorf("copy of 200 from 100 bytes = %d, %v, done %d; want 100, EOF, 100", n, err, p.done)
+	}
+	n, err = copyWithProgress(p.ctx, &dst, bytes.NewReader(make([]byte, 3*copyBuffer)), copyBuffer+1, p)
+	if err != nil || n != copyBuffer+1 {
+		t.Errorf("copy of %d = %d, %v", copyBuffer+1, n, err)
+	}
+}
+
+// TestProgressCancelLargeTIFF checks that cancelling from the callback
+// stops a TIFF being read whole within a buffer of the cancellation
+func TestProgressCancelLargeTIFF(t *testing.T) {
+	tiff := largeTIFF(4 << 20)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var at int64
+	config := Config{RemoveGPSInfo: true, Progress: func(done, total int64) {
+		if at == 0 && done >= 1<<20 {
+			at = done
+			cancel()
+		}
+	}}
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &countingReader{r: bytes.NewReader(tiff)}
+	_, err = s.RemoveContext(ctx, in, io.Discard)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if in.n > at+2*copyBuffer {
+		t.Errorf("read %d bytes after cancelling at %d", in.n, at)
+	}
+}
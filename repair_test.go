@@ -0,0 +1,189 @@
+package exifremover
+
+import (
+	"bytes"
+	"errors"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// hasWarning reports whether the report carries a warning with code
+func (r *Report) hasWarning(code WarningCode) bool {
+	for _, w := range r.Warnings {
+		if w.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func TestJPEGEndOfImage(t *testing.T) {
+	full := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	if !bytes.HasSuffix(full, []byte{0xFF, 0xD9}) {
+		t.Fatal("fixture doesn't end with EOI")
+	}
+	truncated := full[:len(full)-2]
+	garbage := []byte("\x00\x00trailing garbage\xff")
+
+	tests := []struct {
+		name    string
+		in      []byte
+		repair  bool
+		want    []byte
+		warning bool
+	}{
+		{"complete", full, false, full, false},
+		{"complete repaired", full, true, full, false},
+		{"garbage after EOI", append(append([]byte(nil), full...), garbage...), false, append(append([]byte(nil), full...), garbage...), false},
+		{"garbage after EOI repaired", append(append([]byte(nil), full...), garbage...), true, append(append([]byte(nil), full...), garbage...), false},
+		{"garbage ending in EOI bytes", append(append([]byte(nil), full...), 'x', 0xFF, 0xD9, 'y'), true, append(append([]byte(nil), full...), 'x', 0xFF, 0xD9, 'y'), false},
+		{"missing EOI", truncated, false, truncated, true},
+		{"missing EOI repaired", truncated, true, full, true},
+		{"garbage without EOI repaired", append(append([]byte(nil), truncated...), 0, 0, 0), true, append(append([]byte(nil), truncated...), 0, 0, 0, 0xFF, 0xD9), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, report := sanitize(t, tt.in, Config{RepairStructure: tt.repair})
+			if !bytes.Equal(out, tt.want) {
+				t.Errorf("output differs: got %d bytes ending % x, want %d bytes ending % x", len(out), out[len(out)-4:], len(tt.want), tt.want[len(tt.want)-4:])
+			}
+			if got := report.hasWarning(WarnMissingEOI); got != tt.warning {
+				t.Errorf("missing-eoi warning = %v, want %v", got, tt.warning)
+			}
+			if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil && tt.repair {
+				t.Errorf("repaired output doesn't decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestJPEGMissingEOIWithSanitizing(t *testing.T) {
+	full := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	out, report := sanitize(t, full[:len(full)-2], Config{RemoveCameraInfo: true, RepairStructure: true})
+	assertAbsent(t, out, "CanonMake")
+	if !bytes.HasSuffix(out, []byte{0xFF, 0xD9}) || bytes.HasSuffix(out, []byte{0xFF, 0xD9, 0xFF, 0xD9}) {
+		t.Errorf("output ends % x", out[len(out)-4:])
+	}
+	if !report.hasWarning(WarnMissingEOI) {
+		t.Error("no missing-eoi warning")
+	}
+	// A structural warning isn't unhandled metadata
+	if _, _, err := RemoveEXIFFromBytesReport(full[:len(full)-2], Config{FailOnUnhandledMetadata: true}); err != nil {
+		t.Errorf("FailOnUnhandledMetadata failed on a missing EOI: %v", err)
+	}
+}
+
+func TestPNGEndOfImage(t *testing.T) {
+	full := fixture.PNG(4, 4)
+	truncated := full[:len(full)-12]
+
+	out, report := sanitize(t, truncated, Config{})
+	if !bytes.Equal(out, truncated) || !report.hasWarning(WarnMissingIEND) {
+		t.Errorf("missing IEND: changed %v, warnings %v", !bytes.Equal(out, truncated), report.Warnings)
+	}
+	out, report = sanitize(t, truncated, Config{RepairStructure: true})
+	if !bytes.Equal(out, full) || !report.hasWarning(WarnMissingIEND) {
+		t.Error("IEND not repaired")
+	}
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("repaired output doesn't decode: %v", err)
+	}
+	out, report = sanitize(t, full, Config{RepairStructure: true})
+	if !bytes.Equal(out, full) || len(report.Warnings) != 0 {
+		t.Errorf("complete PNG changed or warned: %v", report.Warnings)
+	}
+}
+
+func TestPNGChunkTypeCorruption(t *testing.T) {
+	text := fixture.Chunk("tEXt", []byte("Comment\x00corrupted chunk"))
+	clean := fixture.WithChunks(fixture.PNG(4, 4), text)
+	at := bytes.Index(clean, []byte("tEXt"))
+
+	for pos := 0; pos < 4; pos++ {
+		for _, c := range []byte{0x00, '0', ' ', 0xE9, 0x7F} {
+			in := append([]byte(nil), clean...)
+			in[at+pos] = c
+
+			_, _, err := RemoveEXIFFromBytesReport(in, Config{})
+			if !errors.Is(err, ErrCorruptImage) {
+				t.Errorf("type byte %d = %#x: err %v, want ErrCorruptImage", pos, c, err)
+			}
+
+			out, report := sanitize(t, in, Config{Salvage: true})
+			if !report.hasWarning(WarnResynced) {
+				t.Errorf("type byte %d = %#x: no WarnResynced", pos, c)
+			}
+			if bytes.Contains(out, []byte("corrupted chunk")) {
+				t.Errorf("type byte %d = %#x: broken chunk kept", pos, c)
+			}
+			if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+				t.Errorf("type byte %d = %#x: salvaged output doesn't decode: %v", pos, c, err)
+			}
+		}
+	}
+}
+
+func TestPNGCorruptLengthResynced(t *testing.T) {
+	in := fixture.WithChunks(fixture.PNG(4, 4),
+		fixture.Chunk("tEXt", []byte("Comment\x00first")),
+		fixture.Chunk("tIME", []byte{0x07, 0xe7, 6, 14, 18, 42, 7}),
+	)
+	// Lengthen the tEXt chunk into the tIME chunk, so the next header read
+	// lands in its data
+	at := bytes.Index(in, []byte("tEXt")) - 4
+	in[at+3] += 6
+
+	if _, _, err := RemoveEXIFFromBytesReport(in, Config{}); !errors.Is(err, ErrCorruptImage) {
+		t.Fatalf("err %v, want ErrCorruptImage", err)
+	}
+	out, report := sanitize(t, in, Config{Salvage: true})
+	if !report.hasWarning(WarnResynced) || !bytes.Contains(out, []byte("IDAT")) || !bytes.HasSuffix(out, []byte("IEND\xae\x42\x60\x82")) {
+		t.Errorf("not resynchronized: warnings %v", report.Warnings)
+	}
+}
+
+func TestPNGUnknownChunks(t *testing.T) {
+	chunks := [][]byte{
+		fixture.Chunk("prVt", []byte("ancillary, safe to copy")),
+		fixture.Chunk("prVT", []byte("ancillary, unsafe to copy")),
+		fixture.Chunk("PRVt", []byte("critical")),
+	}
+	in := fixture.WithChunks(fixture.PNG(4, 4), chunks...)
+
+	out, _ := sanitize(t, in, Config{})
+	if !bytes.Equal(out, in) {
+		t.Error("unknown chunks changed without RemoveUnknownChunks")
+	}
+	out, report := sanitize(t, in, Config{RemoveUnknownChunks: true})
+	assertAbsent(t, out, "ancillary, safe to copy", "ancillary, unsafe to copy")
+	if !bytes.Contains(out, []byte("critical")) {
+		t.Error("unknown critical chunk removed")
+	}
+	if removed := removedNames(report, CarrierOther); len(removed) != 2 {
+		t.Errorf("removed %v, want the two ancillary chunks", removed)
+	}
+
+	// After a resynchronization only the chunks safe to copy are kept
+	broken := fixture.WithChunks(fixture.PNG(4, 4), append([][]byte{{0, 0, 0, 1, '#', '#', '#', '#', 0}}, chunks...)...)
+	out, _ = sanitize(t, broken, Config{Salvage: true})
+	assertAbsent(t, out, "ancillary, unsafe to copy")
+	for _, kept := range []string{"ancillary, safe to copy", "critical"} {
+		if !bytes.Contains(out, []byte(kept)) {
+			t.Errorf("%q removed after resynchronizing", kept)
+		}
+	}
+}
+
+// removedNames lists the names of the items removed from carrier
+func removedNames(report *Report, carrier Carrier) []string {
+	var names []string
+	for _, item := range report.Removed {
+		if item.Carrier == carrier {
+			names = append(names, item.Name)
+		}
+	}
+	return names
+}
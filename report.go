@@ -0,0 +1,328 @@
+package exifremover
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// Report describes an image processed by RemoveEXIFSelectiveReport and what
+// was removed from it. The image properties are read from the JPEG SOF
+// segment or the PNG IHDR chunk during the same pass that removes metadata,
+// so no decoding is needed.
+type Report struct {
+	Format     Format
+	Width      int
+	Height     int
+	BitDepth   int // bits per sample
+	Components int // samples per pixel
+
+	FaceRegions     int  // face/person regions removed from XMP
+	FaceRegionNames bool // whether any removed region carried a person name
+
+	Removed []RemovedItem
+	// WeakestRemoval is the lowest Strength in Removed, or zero if nothing
+	// was removed
+	WeakestRemoval RemovalStrength
+
+	// BytesWritten is the size of the sanitized output
+	BytesWritten int64
+
+	// Minified holds the bytes Config.Minify saved, by carrier
+	Minified map[Carrier]int64
+
+	// Warnings lists metadata that was passed through because the package
+	// can see it but not sanitize it, and structural problems tolerated in
+	// the file, such as a missing EOI
+	Warnings []Warning
+
+	// Incomplete is set on a Report returned together with an error:
+	// processing stopped partway, and the Report covers only what was
+	// found before it did
+	Incomplete bool
+
+	// Trace lists, under Config.Trace, the decision for every EXIF entry
+	// walked, every XMP property, IPTC dataset and PNG text or ancillary
+	// chunk kept, every removal and every warning, in the order processing
+	// made them. TraceTruncated is set when it was cut short.
+	Trace          []TraceEvent
+	TraceTruncated bool
+
+	tracer *tracer
+
+	// chunkRemovals counts the removals of each PNG chunk type, and
+	// combined indexes the item in Removed the ones past chunkListLimit
+	// were combined into
+	chunkRemovals map[string]int
+	combined      map[string]int
+}
+
+func (r *Report) minified(c Carrier, saved int) {
+	if r.Minified == nil {
+		r.Minified = make(map[Carrier]int64)
+	}
+	r.Minified[c] += int64(saved)
+}
+
+// WarningCode identifies the kind of a Warning. Codes are stable, so
+// pipelines can match on them.
+type WarningCode string
+
+const (
+	// WarnMakerNote is a kept maker note, whose vendor format isn't parsed
+	// so no category reaches inside it
+	WarnMakerNote WarningCode = "maker-note"
+	// WarnThumbnail is a kept IFD1 thumbnail image, which is not processed
+	WarnThumbnail WarningCode = "thumbnail"
+	// WarnPreview is a JPEG preview in a TIFF kept unsanitized because
+	// its sanitized form is larger than the space it occupies
+	WarnPreview WarningCode = "preview"
+	// WarnFPXR is a JPEG APP2 FlashPix extension segment, passed through
+	// unparsed
+	WarnFPXR WarningCode = "fpxr"
+	// WarnUnknownAPP1 is a JPEG APP1 segment holding neither EXIF nor XMP
+	WarnUnknownAPP1 WarningCode = "unknown-app1"
+	// WarnAudio is a vendor JPEG APPn segment holding a voice memo, kept
+	// because Config.RemoveVendorSegments is unset
+	WarnAudio WarningCode = "audio"
+	// WarnByteOrder is a TIFF byte order marker in the wrong case, read as
+	// meant and corrected under Config.Permissive
+	WarnByteOrder WarningCode = "byte-order"
+	// WarnMissingEOI is a JPEG that ends without an EOI marker, taken to
+	// end with the data and repaired under Config.RepairStructure
+	WarnMissingEOI WarningCode = "missing-eoi"
+	// WarnMissingIEND is a PNG that ends without an IEND chunk, handled as
+	// WarnMissingEOI is
+	WarnMissingIEND WarningCode = "missing-iend"
+	// WarnDuplicateTag is a tag repeated within one IFD. Each copy is
+	// decided on its own, so a removed tag loses every copy.
+	WarnDuplicateTag WarningCode = "duplicate-tag"
+	// WarnResynced is a broken PNG chunk stream that Config.Salvage picked
+	// up again at the next valid chunk, dropping the bytes in between
+	WarnResynced WarningCode = "resynced"
+)
+
+// structural reports whether the code is about the file's structure rather
+// than metadata passed through
+func (c WarningCode) structural() bool {
+	return c == WarnMissingEOI || c == WarnMissingIEND || c == WarnResynced || c == WarnDuplicateTag
+}
+
+// warningCarrier returns the carrier a warning is about
+func warningCarrier(code WarningCode) Carrier {
+	switch code {
+	case WarnMakerNote:
+		return CarrierMakerNote
+	case WarnThumbnail, WarnPreview:
+		return CarrierThumbnail
+	case WarnAudio:
+		return CarrierAudio
+	}
+	return CarrierEXIF
+}
+
+// Warning is an item of metadata passed through unsanitized, or a
+// structural problem with the file
+type Warning struct {
+	Code   WarningCode
+	Detail string
+}
+
+func (w Warning) String() string {
+	return string(w.Code) + ": " + w.Detail
+}
+
+// warn records a warning
+func (r *Report) warn(code WarningCode, detail string) {
+	r.Warnings = append(r.Warnings, Warning{Code: code, Detail: detail})
+	if code.structural() {
+		return // not a metadata item, so not a decision to trace
+	}
+	r.trace(TraceEvent{Carrier: warningCarrier(code), Name: string(code), Action: ActionUnsupported, Reason: detail})
+}
+
+// RemovalStrength says how thoroughly a removed item is gone from the output
+type RemovalStrength int
+
+const (
+	// RemovalUnlinked means only the reference was cut (an IFD entry's count
+	// or pointer zeroed); the data is still in the file and recoverable
+	RemovalUnlinked RemovalStrength = iota + 1
+	// RemovalOverwritten means the data's bytes were overwritten in place
+	RemovalOverwritten
+	// RemovalEliminated means the data's bytes are not in the output at all
+	RemovalEliminated
+)
+
+// String returns a lower-case name for the strength
+func (s RemovalStrength) String() string {
+	switch s {
+	case RemovalUnlinked:
+		return "unlinked"
+	case RemovalOverwritten:
+		return "overwritten"
+	case RemovalEliminated:
+		return "eliminated"
+	}
+	return "none"
+}
+
+// RemovedItem is one metadata item removed from the image
+type RemovedItem struct {
+	Carrier    Carrier
+	Tag        uint16 // EXIF tag ID, zero for other carriers
+	Name       string
+	Categories []Category
+	Strength   RemovalStrength
+	// Size is the number of metadata bytes dropped or overwritten
+	Size int64
+	// Value is the decoded text of a removed string EXIF tag, empty
+	// otherwise
+	Value string
+	// Count is the number of removals combined into the item, for the
+	// chunks of one type past chunkListLimit; it is zero for an item
+	// removed on its own
+	Count int
+}
+
+// String describes the item for logs, e.g.
+// `EXIF Make "Apple" (CameraInfo, 6 bytes, overwritten)`
+func (item RemovedItem) String() string {
+	var b strings.Builder
+	b.WriteString(item.Carrier.String())
+	b.WriteByte(' ')
+	b.WriteString(item.Name)
+	if item.Value != "" {
+		b.WriteByte(' ')
+		b.WriteString(strconv.Quote(item.Value))
+	}
+	b.WriteString(" (")
+	for _, c := range item.Categories {
+		b.WriteString(c.String())
+		b.WriteString(", ")
+	}
+	b.WriteString(strconv.FormatInt(item.Size, 10))
+	b.WriteString(" bytes, ")
+	b.WriteString(item.Strength.String())
+	b.WriteByte(')')
+	return b.String()
+}
+
+// RemovedBytes returns the total Size of the removed items
+func (r *Report) RemovedBytes() int64 {
+	var n int64
+	for _, item := range r.Removed {
+		n += item.Size
+	}
+	return n
+}
+
+// remove records a removed item
+func (r *Report) remove(item RemovedItem) {
+	r.Removed = append(r.Removed, item)
+	r.traceRemoval(item)
+}
+
+// chunkListLimit is how many removals of one PNG chunk type are listed one
+// by one; the rest are combined into a single item, so a file of millions
+// of tiny chunks can't grow the Report without bound
+const chunkListLimit = 100
+
+// removeChunk records a removed PNG chunk of chunkType. Past
+// chunkListLimit of the type, item is added to an item named for the type
+// and the count, e.g. "tEXt ×120000", holding their total size and
+// weakest strength.
+func (r *Report) removeChunk(chunkType string, item RemovedItem) {
+	if r.chunkRemovals == nil {
+		r.chunkRemovals, r.combined = make(map[string]int), make(map[string]int)
+	}
+	r.chunkRemovals[chunkType]++
+	if r.chunkRemovals[chunkType] <= chunkListLimit {
+		r.remove(item)
+		return
+	}
+	i, ok := r.combined[chunkType]
+	if !ok {
+		i = len(r.Removed)
+		r.combined[chunkType] = i
+		r.remove(RemovedItem{Carrier: item.Carrier, Name: chunkType, Strength: item.Strength})
+	}
+	c := &r.Removed[i]
+	c.Count++
+	c.Size += item.Size
+	if item.Strength < c.Strength {
+		c.Strength = item.Strength
+	}
+	c.Name = chunkType + " ×" + strconv.Itoa(c.Count)
+}
+
+// removeTag records a removed EXIF tag
+func (r *Report) removeTag(t *tagInfo, strength RemovalStrength, size int64) {
+	r.remove(RemovedItem{Carrier: CarrierEXIF, Tag: t.ID, Name: t.Name, Categories: t.Categories, Strength: strength, Size: size})
+}
+
+// removeEntry removes the value of the EXIF IFD entry at pos and records
+// it, with its size and, for string tags, its text
+func (r *Report) removeEntry(t *tagInfo, data []byte, pos int, order binary.ByteOrder) {
+	item := RemovedItem{Carrier: CarrierEXIF, Tag: t.ID, Name: t.Name, Categories: t.Categories, Size: entrySize(data, pos, order)}
+	item.Value, _ = entryString(data, pos, order)
+	item.Strength = removeValue(data, pos, order)
+	r.remove(item)
+}
+
+// weakestRemoval returns the lowest strength among the removed items
+func (r *Report) weakestRemoval() RemovalStrength {
+	var weakest RemovalStrength
+	for _, item := range r.Removed {
+		if weakest == 0 || item.Strength < weakest {
+			weakest = item.Strength
+		}
+	}
+	return weakest
+}
+
+// isSOF reports whether a JPEG marker starts a frame: SOF0 through SOF15,
+// excluding DHT (0xC4), JPG (0xC8) and DAC (0xCC) which share the range
+func isSOF(marker byte) bool {
+	return marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC
+}
+
+// readSOF records the frame header of a JPEG SOF segment payload
+func (r *Report) readSOF(data []byte) {
+	if len(data) < 6 {
+		return
+	}
+	r.BitDepth = int(data[0])
+	r.Height = int(binary.BigEndian.Uint16(data[1:3]))
+	r.Width = int(binary.BigEndian.Uint16(data[3:5]))
+	r.Components = int(data[5])
+}
+
+// readIHDR records the image header of a PNG IHDR chunk payload
+func (r *Report) readIHDR(data []byte) {
+	if len(data) < 13 {
+		return
+	}
+	r.Width = int(binary.BigEndian.Uint32(data[0:4]))
+	r.Height = int(binary.BigEndian.Uint32(data[4:8]))
+	r.BitDepth = int(data[8])
+	switch data[9] { // color type
+	case 0, 3: // grayscale, indexed
+		r.Components = 1
+	case 4: // grayscale with alpha
+		r.Components = 2
+	case 2: // truecolor
+		r.Components = 3
+	case 6: // truecolor with alpha
+		r.Components = 4
+	}
+}
+
+// readVP8X records the canvas size from a WebP VP8X chunk payload
+func (r *Report) readVP8X(data []byte) {
+	if len(data) < 10 {
+		return
+	}
+	r.Width = 1 + (int(data[4]) | int(data[5])<<8 | int(data[6])<<16)
+	r.Height = 1 + (int(data[7]) | int(data[8])<<8 | int(data[9])<<16)
+}
@@ -0,0 +1,65 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// withFrame returns a JPEG whose frame header is rewritten to SOF marker
+// with the given precision and size. The scan data is left as it is: the
+// header is only read, never decoded against it.
+func withFrame(marker byte, precision, width, height int) []byte {
+	jpeg := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	i := bytes.Index(jpeg, []byte{0xFF, 0xC0})
+	out := append([]byte(nil), jpeg...)
+	out[i+1] = marker
+	out[i+4] = byte(precision)
+	binary.BigEndian.PutUint16(out[i+5:], uint16(height))
+	binary.BigEndian.PutUint16(out[i+7:], uint16(width))
+	return out
+}
+
+// withIHDR returns a PNG whose IHDR chunk is replaced by one with the
+// given size, bit depth and color type
+func withIHDR(width, height, depth, colorType int) []byte {
+	png := fixture.PNG(8, 8)
+	ihdr := binary.BigEndian.AppendUint32(nil, uint32(width))
+	ihdr = binary.BigEndian.AppendUint32(ihdr, uint32(height))
+	ihdr = append(ihdr, byte(depth), byte(colorType), 0, 0, 0)
+	out := append([]byte(nil), png[:8]...)
+	out = append(out, fixture.Chunk("IHDR", ihdr)...)
+	return append(out, png[8+8+13+4:]...)
+}
+
+func TestReportDimensions(t *testing.T) {
+	for name, c := range map[string]struct {
+		in                               []byte
+		format                           Format
+		width, height, depth, components int
+	}{
+		"SOF0 baseline":            {withFrame(0xC0, 8, 640, 480), FormatJPEG, 640, 480, 8, 3},
+		"SOF1 extended 12-bit":     {withFrame(0xC1, 12, 4000, 3000), FormatJPEG, 4000, 3000, 12, 3},
+		"SOF2 progressive":         {withFrame(0xC2, 8, 1920, 1080), FormatJPEG, 1920, 1080, 8, 3},
+		"SOF3 lossless 16-bit":     {withFrame(0xC3, 16, 65535, 1), FormatJPEG, 65535, 1, 16, 3},
+		"SOF7 lossless arithmetic": {withFrame(0xC7, 2, 17, 33), FormatJPEG, 17, 33, 2, 3},
+		"SOF15":                    {withFrame(0xCF, 12, 100, 200), FormatJPEG, 100, 200, 12, 3},
+		"PNG truecolor alpha 16":   {withIHDR(3000, 2000, 16, 6), FormatPNG, 3000, 2000, 16, 4},
+		"PNG gray 1-bit":           {withIHDR(1, 70000, 1, 0), FormatPNG, 1, 70000, 1, 1},
+		"PNG indexed":              {withIHDR(256, 128, 8, 3), FormatPNG, 256, 128, 8, 1},
+		"PNG gray alpha":           {withIHDR(9, 9, 8, 4), FormatPNG, 9, 9, 8, 2},
+		"PNG truecolor":            {withIHDR(5, 7, 8, 2), FormatPNG, 5, 7, 8, 3},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, report := sanitize(t, c.in, Config{RemoveGPSInfo: true})
+			if report.Format != c.format || report.Width != c.width || report.Height != c.height ||
+				report.BitDepth != c.depth || report.Components != c.components {
+				t.Errorf("got %s %dx%d, %d bits, %d components; want %s %dx%d, %d bits, %d components",
+					report.Format, report.Width, report.Height, report.BitDepth, report.Components,
+					c.format, c.width, c.height, c.depth, c.components)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package exifremover
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sync"
+)
+
+// Sanitizer applies one Config to any number of images. New validates the
+// Config and resolves its category overrides once, so a long-running
+// service doesn't redo that work per call. A Sanitizer is safe for
+// concurrent use; the function-style API builds one per call.
+type Sanitizer struct {
+	config  Config
+	limiter *limiter // throttles file copies for BatchOptions.MaxBytesPerSecond
+}
+
+// New returns a Sanitizer for config, or the error any entry point would
+// return for it. config is copied, so later changes to its slices and maps
+// don't affect the Sanitizer.
+func New(config Config) (*Sanitizer, error) {
+	// UseMmap is checked per call, since only RemoveFile can honor it
+	check := config
+	check.UseMmap = false
+	if err := validateConfig(check); err != nil {
+		return nil, err
+	}
+	return &Sanitizer{config: prepare(config)}, nil
+}
+
+// prepare returns a valid config as a Sanitizer keeps it: copied, with its
+// category overrides resolved and its rule regexps compiled. The entry
+// points that don't build a Sanitizer prepare their config with it too.
+func prepare(config Config) Config {
+	config = cloneConfig(config)
+	config.tags = resolveTags(config)
+	compileRules(config.ValueRules)
+	return config
+}
+
+// Remove is RemoveReport with the Sanitizer's Config
+func (s *Sanitizer) Remove(r io.Reader, w io.Writer) (*Report, error) {
+	return s.RemoveContext(context.Background(), r, w)
+}
+
+// RemoveContext is RemoveReportContext with the Sanitizer's Config
+func (s *Sanitizer) RemoveContext(ctx context.Context, r io.Reader, w io.Writer) (*Report, error) {
+	if s.config.UseMmap {
+		return nil, &OptionsError{"UseMmap", "stream input"}
+	}
+	total := int64(-1) // a bytes or strings Reader knows its size
+	if l, ok := r.(interface{ Len() int }); ok {
+		total = int64(l.Len())
+	}
+	r, reset := timeoutReader(r, s.config.ReadTimeout)
+	defer reset()
+	config, release := withMemory(ctx, s.config)
+	defer release()
+	return removeStream(contextReader(ctx, r), w, withProgress(ctx, config, total))
+}
+
+// RemoveFile is RemoveEXIFSelectiveReport with the Sanitizer's Config
+func (s *Sanitizer) RemoveFile(inputPath, outputPath string) (*Report, error) {
+	return s.RemoveFileContext(context.Background(), inputPath, outputPath)
+}
+
+// RemoveFileContext is RemoveEXIFSelectiveReportContext with the
+// Sanitizer's Config
+func (s *Sanitizer) RemoveFileContext(ctx context.Context, inputPath, outputPath string) (*Report, error) {
+	inputPath, err := resolveInput(inputPath, s.config)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFileSize(inputPath, s.config); err != nil {
+		return nil, err
+	}
+	if sameFile(inputPath, outputPath) {
+		// Creating the output would truncate the input before it's read
+		return s.removeInPlace(ctx, inputPath)
+	}
+	inputFile, err := fsys.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer inputFile.Close()
+
+	// Only a regular file of ours is removed on failure, never a device
+	// or pipe the caller pointed the output at
+	info, err := fsys.Stat(outputPath)
+	created := err != nil || info.Mode().IsRegular()
+	outputFile, err := fsys.Create(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	report, err := s.removeFile(ctx, inputFile, outputFile)
+	if cerr := outputFile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil && created {
+		fsys.Remove(outputPath)
+	}
+	return report, err
+}
+
+// removeFile processes an open input file, mapping it under UseMmap
+// unless the copy is throttled
+func (s *Sanitizer) removeFile(ctx context.Context, inputFile file, w io.Writer) (*Report, error) {
+	config, release := withMemory(ctx, s.config)
+	defer release()
+	total := int64(-1)
+	if info, err := inputFile.Stat(); err == nil && info.Mode().IsRegular() {
+		total = info.Size()
+	}
+	config = withProgress(ctx, config, total)
+	r, reset := timeoutReader(inputFile, config.ReadTimeout)
+	defer reset()
+	if s.limiter != nil {
+		config.UseMmap = false
+		w = s.limiter.writer(w)
+		return removeStream(contextReader(ctx, s.limiter.reader(r)), w, config)
+	}
+	if config.UseMmap {
+		config.UseMmap = false
+		if data, unmap, err := mapFile(inputFile); err == nil {
+			defer unmap()
+			header := data
+			if len(header) > 12 {
+				header = header[:12]
+			}
+			return processMapped(contextReader(ctx, bytes.NewReader(data)), header, w, config)
+		}
+	}
+	return removeStream(contextReader(ctx, r), w, config)
+}
+
+// processMapped is process over a mapped file. A file truncated under the
+// mapping faults on the next access to a page past its end instead of
+// returning an error, so the fault is turned into a panic and recovered
+// as ErrInputChanged; the mapping is released by the caller on every path.
+func processMapped(r io.Reader, header []byte, w io.Writer, config Config) (report *Report, err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if p := recover(); p != nil {
+			fault, ok := p.(interface{ Addr() uintptr })
+			if !ok {
+				panic(p)
+			}
+			report, err = nil, fmt.Errorf("%w: fault at %#x", ErrInputChanged, fault.Addr())
+		}
+	}()
+	return process(r, header, w, config)
+}
+
+// RemoveBytes is RemoveEXIFFromBytesReport with the Sanitizer's Config
+func (s *Sanitizer) RemoveBytes(data []byte) ([]byte, *Report, error) {
+	if s.config.UseMmap {
+		return nil, nil, &OptionsError{"UseMmap", "stream input"}
+	}
+	header := data
+	if len(header) > 12 {
+		header = header[:12]
+	}
+	output := bytes.NewBuffer(make([]byte, 0, len(data)))
+	config := withProgress(context.Background(), s.config, int64(len(data)))
+	report, err := process(bytes.NewReader(data), header, output, config)
+	if err != nil {
+		return nil, report, err
+	}
+	return output.Bytes(), report, nil
+}
+
+// Inspect is the package-level Inspect, which needs no Config; it is here
+// so a Sanitizer can stand in for the whole API
+func (s *Sanitizer) Inspect(r io.Reader) (*MetadataReport, error) {
+	return Inspect(r)
+}
+
+// Verify is VerifyClean with the Sanitizer's Config
+func (s *Sanitizer) Verify(r io.Reader) ([]Violation, error) {
+	// Only the removal policy matters; nothing is written
+	config := s.config
+	config.StampProcessed = false
+	config.AssertNoAdditions = false
+	config.MinRemovalStrength = 0
+	config.FailOnUnhandledMetadata = false
+	// Shifting again would move every date, clean or not
+	config.DateTimeShiftBy = 0
+	return verifyReport(removeStream(r, io.Discard, config))
+}
+
+// readerPool recycles the buffered readers stream input is peeked through
+var readerPool = sync.Pool{New: func() any { return bufio.NewReaderSize(nil, 4096) }}
+
+// writerPool recycles the buffered writers the JPEG and PNG handlers
+// stream output through
+var writerPool = sync.Pool{New: func() any { return bufio.NewWriterSize(nil, 4096) }}
+
+func getWriter(w io.Writer) *bufio.Writer {
+	bw := writerPool.Get().(*bufio.Writer)
+	bw.Reset(w)
+	return bw
+}
+
+func putWriter(bw *bufio.Writer) {
+	bw.Reset(nil)
+	writerPool.Put(bw)
+}
+
+// removeStream identifies the format of r by peeking at its signature, so
+// the header bytes are still there for the handler, and processes it
+func removeStream(r io.Reader, w io.Writer, config Config) (*Report, error) {
+	br := readerPool.Get().(*bufio.Reader)
+	br.Reset(r)
+	defer func() {
+		br.Reset(nil)
+		readerPool.Put(br)
+	}()
+
+	header, err := br.Peek(12) // Enough to identify most formats
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return process(br, header, w, config)
+}
+
+// cloneConfig copies the slices and maps of config, so the copy can be
+// read concurrently however the caller reuses the original
+func cloneConfig(config Config) Config {
+	config.ValueRules = append([]ValueRule(nil), config.ValueRules...)
+	config.TextKeysToRemove = append([]string(nil), config.TextKeysToRemove...)
+	config.CustomTagsToRemove = append([]uint16(nil), config.CustomTagsToRemove...)
+	if config.TextKeyModes != nil {
+		modes := make(map[string]TextMode, len(config.TextKeyModes))
+		for k, mode := range config.TextKeyModes {
+			modes[k] = mode
+		}
+		config.TextKeyModes = modes
+	}
+	if config.CategoryOverrides != nil {
+		overrides := make(map[Category][]uint16, len(config.CategoryOverrides))
+		for c, ids := range config.CategoryOverrides {
+			overrides[c] = append([]uint16(nil), ids...)
+		}
+		config.CategoryOverrides = overrides
+	}
+	return config
+}
+
+// resolveTags applies config.CategoryOverrides to every tag in the table
+// and every tag the overrides name, for exifTag to look up instead of
+// rebuilding entries per IFD entry. Without overrides the table entries
+// are used as they are and nil is returned.
+func resolveTags(config Config) map[uint16]*tagInfo {
+	if len(config.CategoryOverrides) == 0 {
+		return nil
+	}
+	tags := make(map[uint16]*tagInfo)
+	for _, t := range exifTags {
+		tags[t.ID] = exifTag(t.ID, config)
+	}
+	for _, ids := range config.CategoryOverrides {
+		for _, id := range ids {
+			tags[id] = exifTag(id, config)
+		}
+	}
+	return tags
+}
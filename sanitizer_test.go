@@ -0,0 +1,105 @@
+package exifremover
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// ruleConfig removes the camera model by regexp and keeps the make by the
+// same means, so matching is exercised on every call
+func ruleConfig() Config {
+	return Config{
+		RemoveCameraInfo: true,
+		ValueRules: []ValueRule{
+			{Tag: 0x010f, Regexp: `^Canon`, Keep: true},
+			{Tag: 0x013b, Regexp: `(?i)^john\b`},
+		},
+		CategoryOverrides:      map[Category][]uint16{CategoryCameraInfo: {0xa431}},
+		MergeCategoryOverrides: true,
+	}
+}
+
+func TestRuleRegexpsCompiledByNew(t *testing.T) {
+	config := ruleConfig()
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, rule := range s.config.ValueRules {
+		if rule.re == nil || rule.re.String() != rule.Regexp {
+			t.Errorf("rule %d: regexp %v not compiled", i, rule.re)
+		}
+	}
+	// The Sanitizer keeps its own copy of the rules
+	config.ValueRules[0].Regexp = `^Nikon`
+	if s.config.ValueRules[0].re.String() != `^Canon` {
+		t.Error("changing the caller's rule changed the Sanitizer's")
+	}
+
+	in := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	out, _, err := s.RemoveBytes(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, got := range map[string][]byte{"Sanitizer": out, "function": mustRemove(t, in, ruleConfig())} {
+		if !bytes.Contains(got, []byte("CanonMake")) {
+			t.Errorf("%s: Make removed despite its Keep rule", name)
+		}
+		assertAbsent(t, got, "EOS Model 5D", "John Artist", "SERIAL-0042")
+	}
+	blob, _, err := SanitizeEXIFBlob(fixture.Sample().Bytes(), ruleConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertAbsent(t, blob, "John Artist")
+}
+
+func mustRemove(t *testing.T, data []byte, config Config) []byte {
+	t.Helper()
+	out, err := RemoveEXIFFromBytes(data, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// BenchmarkSanitizerRemove and BenchmarkFunctionRemove compare a
+// Sanitizer built once with the function API, which validates the Config,
+// resolves its overrides and compiles its regexps on every call
+func BenchmarkSanitizerRemove(b *testing.B) {
+	in := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	s, err := New(ruleConfig())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := s.RemoveBytes(in); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFunctionRemove(b *testing.B) {
+	in := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	config := ruleConfig()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := RemoveEXIFFromBytes(in, config); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkNew is the per-call overhead the function API adds
+func BenchmarkNew(b *testing.B) {
+	config := ruleConfig()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := New(config); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package exifremover
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	results := SelfTestReport()
+	if len(results) == 0 {
+		t.Fatal("self test ran no checks")
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s %s: %v", r.Format, r.Preset, r.Err)
+		}
+	}
+	if err := SelfTest(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSelfTestFilesCarryMetadata(t *testing.T) {
+	// The checks only mean something if there is metadata to remove
+	for f, input := range selfTestFiles() {
+		violations, err := VerifyClean(bytes.NewReader(input), Config{RemoveAll: true, RemoveGPSInfo: true})
+		if err != nil || len(violations) == 0 {
+			t.Errorf("%s: %d violations before sanitizing, err %v", f, len(violations), err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package exifremover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Version is the library version recorded in processing stamps
+const Version = "0.1.0"
+
+// stampKeyword starts every marker written under Config.StampProcessed. For
+// PNG it doubles as the tEXt keyword (terminated by the keyword's NUL).
+const stampKeyword = "exifremover"
+
+// isStamp reports whether a COM payload or tEXt chunk is a marker written
+// by this library, so that later runs replace it instead of stacking copies.
+func isStamp(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(stampKeyword+"\x00"))
+}
+
+// stampText returns the marker payload. It deliberately carries no
+// timestamps or host details, so stamped output stays deterministic.
+func stampText(config Config) []byte {
+	hash, _ := policyHash(config) // checked by validateConfig
+	return []byte(fmt.Sprintf("%s\x00sanitized v%s policy=%s", stampKeyword, Version, hash))
+}
+
+// policyHash returns a short, stable identifier for the removal policy
+func policyHash(config Config) (string, error) {
+	config.StampProcessed = false
+	return configHash(config)
+}
+
+// policy is the part of a Config that decides what processing writes: the
+// removal and rewrite options, with the floats and the time in forms JSON
+// always holds. Limits, checks and how the input is read or the output
+// replaced are left out, so turning on Trace or a MemoryGauge keeps the
+// hash of a policy.
+type policy struct {
+	RemoveCameraInfo, RemoveGPSInfo, RemoveCopyright, RemoveDateTime bool
+	RemoveUserInfo, RemoveTechnicalDetail, RemoveEditingInfo         bool
+	RemoveFaceRegions, RemoveAll, RemoveIPTC, RemoveComments         bool
+	RemoveThumbnail, RemoveVendorSegments, RemoveStructuralTags      bool
+	RemoveAuxiliaryImages, PreserveGainMaps, PreserveOrientation     bool
+	ScrubICCProfile, BlankICCDescription, RemoveICCProfile           bool
+	StampProcessed, RepairStructure, DropEmptyMetadata, Minify       bool
+	Permissive, Salvage, RemoveUnknownChunks, MergeCategoryOverrides bool
+	RemoveTextChunks, RemoveEmbeddedPreviews                         bool
+	ValueRules                                                       []ValueRule
+	CategoryOverrides                                                map[Category][]uint16
+	CustomTagsToRemove                                               []uint16
+	TextKeysToRemove                                                 []string
+	TextMode                                                         TextMode
+	TextKeyModes                                                     map[string]TextMode
+	GPSAction                                                        GPSAction
+	GPSPrecision                                                     int
+	GPSReplaceLatitude, GPSReplaceLongitude                          uint64 // math.Float64bits, as NaN has no JSON
+	DateTimePolicy                                                   DateTimePolicy
+	DateTimeShiftBy                                                  time.Duration
+	DateTimeFixed                                                    string // RFC 3339, for any year
+}
+
+// configHash returns a short, stable identifier for the policy of config.
+// It hashes the JSON encoding of policy, which sorts map keys, so equal
+// policies get the same hash in every process. An encoding failure is
+// returned rather than hashed, so it can't give unrelated policies one
+// hash.
+func configHash(config Config) (string, error) {
+	encoded, err := json.Marshal(policy{
+		RemoveCameraInfo:       config.RemoveCameraInfo,
+		RemoveGPSInfo:          config.RemoveGPSInfo,
+		RemoveCopyright:        config.RemoveCopyright,
+		RemoveDateTime:         config.RemoveDateTime,
+		RemoveUserInfo:         config.RemoveUserInfo,
+		RemoveTechnicalDetail:  config.RemoveTechnicalDetail,
+		RemoveEditingInfo:      config.RemoveEditingInfo,
+		RemoveFaceRegions:      config.RemoveFaceRegions,
+		RemoveAll:              config.RemoveAll,
+		RemoveIPTC:             config.RemoveIPTC,
+		RemoveComments:         config.RemoveComments,
+		RemoveThumbnail:        config.RemoveThumbnail,
+		RemoveVendorSegments:   config.RemoveVendorSegments,
+		RemoveStructuralTags:   config.RemoveStructuralTags,
+		RemoveAuxiliaryImages:  config.RemoveAuxiliaryImages,
+		PreserveGainMaps:       config.PreserveGainMaps,
+		PreserveOrientation:    config.PreserveOrientation,
+		ScrubICCProfile:        config.ScrubICCProfile,
+		BlankICCDescription:    config.BlankICCDescription,
+		RemoveICCProfile:       config.RemoveICCProfile,
+		StampProcessed:         config.StampProcessed,
+		RepairStructure:        config.RepairStructure,
+		DropEmptyMetadata:      config.DropEmptyMetadata,
+		Minify:                 config.Minify,
+		Permissive:             config.Permissive,
+		Salvage:                config.Salvage,
+		RemoveUnknownChunks:    config.RemoveUnknownChunks,
+		MergeCategoryOverrides: config.MergeCategoryOverrides,
+		RemoveTextChunks:       config.RemoveTextChunks,
+		RemoveEmbeddedPreviews: config.RemoveEmbeddedPreviews,
+		ValueRules:             config.ValueRules,
+		CategoryOverrides:      config.CategoryOverrides,
+		CustomTagsToRemove:     config.CustomTagsToRemove,
+		TextKeysToRemove:       config.TextKeysToRemove,
+		TextMode:               config.TextMode,
+		TextKeyModes:           config.TextKeyModes,
+		GPSAction:              config.GPSAction,
+		GPSPrecision:           config.GPSPrecision,
+		GPSReplaceLatitude:     math.Float64bits(config.GPSReplaceLatitude),
+		GPSReplaceLongitude:    math.Float64bits(config.GPSReplaceLongitude),
+		DateTimePolicy:         config.DateTimePolicy,
+		DateTimeShiftBy:        config.DateTimeShiftBy,
+		DateTimeFixed:          config.DateTimeFixed.Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return "", fmt.Errorf("hashing the policy: %w", err)
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// jpegStamp returns a complete COM segment holding the marker
+func jpegStamp(config Config) []byte {
+	text := stampText(config)
+	seg := make([]byte, 4, 4+len(text))
+	seg[0], seg[1] = 0xFF, 0xFE
+	binary.BigEndian.PutUint16(seg[2:4], uint16(len(text)+2))
+	return append(seg, text...)
+}
+
+// pngStamp returns a complete tEXt chunk holding the marker
+func pngStamp(config Config) []byte {
+	return pngChunk("tEXt", stampText(config))
+}
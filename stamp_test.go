@@ -0,0 +1,125 @@
+package exifremover
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// mustPolicyHash returns policyHash(config), failing the test on an error
+func mustPolicyHash(t *testing.T, config Config) string {
+	t.Helper()
+	hash, err := policyHash(config)
+	if err != nil {
+		t.Fatalf("policyHash: %v", err)
+	}
+	return hash
+}
+
+// chanGauge is a MemoryGauge JSON can't encode
+type chanGauge chan int64
+
+func (g chanGauge) Acquire(ctx context.Context, n int64) error { return nil }
+func (g chanGauge) Release(n int64)                            {}
+
+// TestPolicyHashPolicyOnly checks that the hash follows the removal and
+// rewrite options and nothing else: configs JSON can't encode whole still
+// hash by their policy, and the operational fields don't change it
+func TestPolicyHashPolicyOnly(t *testing.T) {
+	gauge := make(chanGauge)
+	gps := mustPolicyHash(t, Config{RemoveGPSInfo: true, MemoryGauge: gauge})
+	if all := mustPolicyHash(t, Config{RemoveCameraInfo: true, RemoveAll: true, MemoryGauge: gauge}); all == gps {
+		t.Error("two policies with an unencodable MemoryGauge have the same hash")
+	}
+	if nan := mustPolicyHash(t, Config{RemoveGPSInfo: true, GPSReplaceLatitude: math.NaN()}); nan == gps {
+		t.Error("a NaN replacement coordinate doesn't change the hash")
+	}
+
+	operational := Config{
+		RemoveGPSInfo:           true,
+		MemoryGauge:             gauge,
+		Progress:                func(done, total int64) {},
+		Trace:                   true,
+		ReadTimeout:             time.Second,
+		MaxFileSize:             1 << 20,
+		MaxMetadataSize:         1 << 10,
+		MaxChunks:               100,
+		UseMmap:                 true,
+		AllowFIFO:               true,
+		PreserveModTime:         true,
+		InPlaceNonAtomic:        true,
+		AssertNoAdditions:       true,
+		FailOnUnhandledMetadata: true,
+		MinRemovalStrength:      RemovalOverwritten,
+	}
+	if got := mustPolicyHash(t, operational); got != gps {
+		t.Errorf("operational fields change the hash from %s to %s", gps, got)
+	}
+}
+
+func TestPolicyHashDeterministic(t *testing.T) {
+	config := Config{
+		RemoveCameraInfo: true,
+		CategoryOverrides: map[Category][]uint16{
+			CategoryCameraInfo: {0x0131, 0x013c},
+			CategoryDateTime:   {0x9010},
+			CategoryUserInfo:   {0xa430},
+		},
+		MergeCategoryOverrides: true,
+		StampProcessed:         true,
+	}
+	want := mustPolicyHash(t, config)
+	for i := 0; i < 10; i++ {
+		s, err := New(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := mustPolicyHash(t, s.config); got != want {
+			t.Fatalf("hash of a resolved config is %s, want %s", got, want)
+		}
+	}
+
+	changed := config
+	changed.RemoveGPSInfo = true
+	if mustPolicyHash(t, changed) == want {
+		t.Error("a different policy has the same hash")
+	}
+	unstamped := config
+	unstamped.StampProcessed = false
+	if mustPolicyHash(t, unstamped) != want {
+		t.Error("StampProcessed changes the hash")
+	}
+}
+
+func TestStampIdenticalAcrossSanitizers(t *testing.T) {
+	in := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	config := Config{
+		RemoveGPSInfo:     true,
+		StampProcessed:    true,
+		CategoryOverrides: map[Category][]uint16{CategoryGPSInfo: {0x0001, 0x0002}},
+	}
+	var outputs [][]byte
+	for i := 0; i < 3; i++ {
+		s, err := New(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _, err := s.RemoveBytes(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, out)
+	}
+	for _, out := range outputs[1:] {
+		if !bytes.Equal(out, outputs[0]) {
+			t.Fatal("stamped output differs between sanitizers with the same policy")
+		}
+	}
+	if !bytes.Contains(outputs[0], []byte("policy="+mustPolicyHash(t, config))) {
+		t.Error("stamp doesn't carry the policy hash")
+	}
+}
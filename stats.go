@@ -0,0 +1,58 @@
+package exifremover
+
+import (
+	"io"
+)
+
+// SegStat totals one kind of segment or chunk in a file
+type SegStat struct {
+	Count int
+	Bytes int64 // on-disk size, including markers, lengths and CRCs
+}
+
+// SegmentStats reads an image from r and totals its top-level JPEG segments
+// or PNG chunks by name ("APP1", "COM", "tEXt", ...). A JPEG's "SOS" entry
+// covers the first scan and everything after it. Bytes that don't parse
+// are counted under "garbage"; the SOI marker and PNG signature are not
+// counted.
+func SegmentStats(r io.Reader) (map[string]SegStat, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []structure
+	var overhead int64 // framing bytes around each payload
+	header := data
+	if len(header) > 12 {
+		header = header[:12]
+	}
+	format := FormatUnknown
+	if len(data) >= 12 {
+		format = detectFormat(header)
+	}
+	switch format {
+	case FormatJPEG:
+		items, overhead = jpegStructure(data), 4
+	case FormatPNG:
+		items, overhead = pngStructure(data), 12
+	default:
+		return nil, formatError(header)
+	}
+
+	stats := make(map[string]SegStat)
+	for _, item := range items {
+		size := int64(len(item.Data)) + overhead
+		switch {
+		case item.Kind == "garbage" || item.Kind == "SOS":
+			size = int64(len(item.Data))
+		case format == FormatJPEG && item.Data == nil:
+			size = 2 // standalone marker
+		}
+		stat := stats[item.Kind]
+		stat.Count++
+		stat.Bytes += size
+		stats[item.Kind] = stat
+	}
+	return stats, nil
+}
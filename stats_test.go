@@ -0,0 +1,89 @@
+package exifremover
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// statsTotal returns the bytes stats account for
+func statsTotal(stats map[string]SegStat) int64 {
+	var total int64
+	for _, s := range stats {
+		total += s.Bytes
+	}
+	return total
+}
+
+func TestSegmentStatsJPEG(t *testing.T) {
+	exif := append([]byte("Exif\x00\x00"), fixture.Sample().Bytes()...)
+	xmp := fixture.XMP(`xmlns:dc="http://purl.org/dc/elements/1.1/" dc:format="image/jpeg"`, "")
+	in := fixture.WithSegment(fixture.JPEG(8, 8), 0xFE, []byte("a comment"))
+	in = fixture.WithSegment(in, 0xE1, xmp)
+	in = fixture.WithSegment(in, 0xE1, exif)
+
+	stats, err := SegmentStats(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stats["APP1"], (SegStat{2, int64(len(exif) + len(xmp) + 8)}); got != want {
+		t.Errorf("APP1 = %+v, want %+v", got, want)
+	}
+	if got, want := stats["COM"], (SegStat{1, int64(len("a comment") + 4)}); got != want {
+		t.Errorf("COM = %+v, want %+v", got, want)
+	}
+	if stats["SOS"].Count != 1 || stats["DQT"].Count == 0 || stats["SOF0"].Count != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if total := statsTotal(stats); total != int64(len(in))-2 {
+		t.Errorf("stats cover %d bytes of %d after SOI", total, len(in)-2)
+	}
+
+	// A length running past the end is counted as garbage
+	broken := append(fixture.WithSegment(fixture.JPEG(8, 8)[:2], 0xFE, []byte("x")), 0xFF, 0xE1, 0xFF, 0xFF, 'E')
+	stats, err = SegmentStats(bytes.NewReader(broken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stats["garbage"]; got != (SegStat{1, 5}) {
+		t.Errorf("garbage = %+v in %+v", got, stats)
+	}
+}
+
+func TestSegmentStatsPNG(t *testing.T) {
+	in := fixture.WithChunks(fixture.PNG(8, 8),
+		fixture.Chunk("tEXt", []byte("Comment\x00one")),
+		fixture.Chunk("tEXt", []byte("Author\x00two")),
+		fixture.Chunk("eXIf", fixture.Sample().Bytes()),
+	)
+	stats, err := SegmentStats(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stats["tEXt"], (SegStat{2, int64(len("Comment\x00one") + len("Author\x00two") + 24)}); got != want {
+		t.Errorf("tEXt = %+v, want %+v", got, want)
+	}
+	if got, want := stats["eXIf"], (SegStat{1, int64(len(fixture.Sample().Bytes()) + 12)}); got != want {
+		t.Errorf("eXIf = %+v, want %+v", got, want)
+	}
+	if stats["IHDR"] != (SegStat{1, 25}) || stats["IEND"] != (SegStat{1, 12}) {
+		t.Errorf("stats = %+v", stats)
+	}
+	if total := statsTotal(stats); total != int64(len(in))-8 {
+		t.Errorf("stats cover %d bytes of %d after the signature", total, len(in)-8)
+	}
+}
+
+func TestSegmentStatsUnsupported(t *testing.T) {
+	for name, in := range map[string][]byte{
+		"WebP":  fixture.WebP(fixture.WebPChunk("EXIF", fixture.Sample().Bytes())),
+		"short": {0xFF, 0xD8},
+		"text":  []byte("not an image at all"),
+	} {
+		if _, err := SegmentStats(bytes.NewReader(in)); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("%s: err = %v, want ErrUnsupportedFormat", name, err)
+		}
+	}
+}
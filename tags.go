@@ -0,0 +1,367 @@
+package exifremover
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Category groups metadata that a single Config flag removes
+type Category int
+
+const (
+	CategoryCameraInfo Category = iota
+	CategoryGPSInfo
+	CategoryCopyright
+	CategoryDateTime
+	CategoryUserInfo
+	CategoryTechnicalDetail
+	CategoryEditingInfo
+	CategoryFaceRegions
+
+	lastCategory = CategoryFaceRegions
+)
+
+// String returns the name of the Config flag controlling the category
+func (c Category) String() string {
+	switch c {
+	case CategoryCameraInfo:
+		return "CameraInfo"
+	case CategoryGPSInfo:
+		return "GPSInfo"
+	case CategoryCopyright:
+		return "Copyright"
+	case CategoryDateTime:
+		return "DateTime"
+	case CategoryUserInfo:
+		return "UserInfo"
+	case CategoryTechnicalDetail:
+		return "TechnicalDetail"
+	case CategoryEditingInfo:
+		return "EditingInfo"
+	case CategoryFaceRegions:
+		return "FaceRegions"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the category by name
+func (c Category) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText decodes a category name as returned by String
+func (c *Category) UnmarshalText(text []byte) error {
+	for cat := CategoryCameraInfo; cat <= lastCategory; cat++ {
+		if cat.String() == string(text) {
+			*c = cat
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown category %q", text)
+}
+
+// enabled reports whether config asks for the category to be removed
+func (c Category) enabled(config Config) bool {
+	switch c {
+	case CategoryCameraInfo:
+		return config.RemoveCameraInfo
+	case CategoryGPSInfo:
+		return config.RemoveGPSInfo
+	case CategoryCopyright:
+		return config.RemoveCopyright
+	case CategoryDateTime:
+		return config.RemoveDateTime
+	case CategoryUserInfo:
+		return config.RemoveUserInfo
+	case CategoryTechnicalDetail:
+		return config.RemoveTechnicalDetail
+	case CategoryEditingInfo:
+		return config.RemoveEditingInfo
+	case CategoryFaceRegions:
+		return config.RemoveFaceRegions
+	}
+	return false
+}
+
+// tagInfo describes an EXIF tag the library acts on
+type tagInfo struct {
+	ID         uint16
+	Name       string
+	Exiftool   string     // exiftool group:tag name for the same field
+	Categories []Category // removed when any of these is enabled
+}
+
+// exifTags is the decision table for IFD0 and the EXIF IFD. Categories
+// apply in every IFD the walkers visit, since writers don't always put tags
+// where the spec says they belong (Make/Model live in IFD0, the capture dates
+// in the EXIF IFD, and round-tripping tools duplicate both).
+var exifTags = []tagInfo{
+	{0x010f, "Make", "EXIF:Make", []Category{CategoryCameraInfo}},
+	{0x0110, "Model", "EXIF:Model", []Category{CategoryCameraInfo}},
+	{0x9000, "ExifVersion", "EXIF:ExifVersion", []Category{CategoryCameraInfo}},
+	{0xa000, "FlashpixVersion", "EXIF:FlashpixVersion", []Category{CategoryCameraInfo}},
+
+	{0x0132, "DateTime", "EXIF:ModifyDate", []Category{CategoryDateTime}},
+	{0x9003, "DateTimeOriginal", "EXIF:DateTimeOriginal", []Category{CategoryDateTime}},
+	{0x9004, "DateTimeDigitized", "EXIF:CreateDate", []Category{CategoryDateTime}},
+	{0x9010, "OffsetTime", "EXIF:OffsetTime", []Category{CategoryDateTime}},
+	{0x9011, "OffsetTimeOriginal", "EXIF:OffsetTimeOriginal", []Category{CategoryDateTime}},
+	{0x9012, "OffsetTimeDigitized", "EXIF:OffsetTimeDigitized", []Category{CategoryDateTime}},
+	{0x9290, "SubSecTime", "EXIF:SubSecTime", []Category{CategoryDateTime}},
+	{0x9291, "SubSecTimeOriginal", "EXIF:SubSecTimeOriginal", []Category{CategoryDateTime}},
+	{0x9292, "SubSecTimeDigitized", "EXIF:SubSecTimeDigitized", []Category{CategoryDateTime}},
+
+	{0x8298, "Copyright", "EXIF:Copyright", []Category{CategoryCopyright, CategoryUserInfo}},
+
+	{0x013b, "Artist", "EXIF:Artist", []Category{CategoryUserInfo}},
+	{0x9286, "UserComment", "EXIF:UserComment", []Category{CategoryUserInfo}},
+	{0x927c, "MakerNote", "MakerNotes:*", []Category{CategoryUserInfo}},
+	{0xa004, "RelatedSoundFile", "EXIF:RelatedSoundFile", []Category{CategoryUserInfo}},
+
+	{0x829a, "ExposureTime", "EXIF:ExposureTime", []Category{CategoryTechnicalDetail}},
+	{0x829d, "FNumber", "EXIF:FNumber", []Category{CategoryTechnicalDetail}},
+	{0x8822, "ExposureProgram", "EXIF:ExposureProgram", []Category{CategoryTechnicalDetail}},
+	{0x8827, "ISOSpeedRatings", "EXIF:ISO", []Category{CategoryTechnicalDetail}},
+	{0x9201, "ShutterSpeedValue", "EXIF:ShutterSpeedValue", []Category{CategoryTechnicalDetail}},
+	{0x9202, "ApertureValue", "EXIF:ApertureValue", []Category{CategoryTechnicalDetail}},
+	{0x9204, "ExposureBiasValue", "EXIF:ExposureCompensation", []Category{CategoryTechnicalDetail}},
+	{0x9205, "MaxApertureValue", "EXIF:MaxApertureValue", []Category{CategoryTechnicalDetail}},
+	{0x9206, "SubjectDistance", "EXIF:SubjectDistance", []Category{CategoryTechnicalDetail}},
+	{0x9207, "MeteringMode", "EXIF:MeteringMode", []Category{CategoryTechnicalDetail}},
+	{0x9209, "Flash", "EXIF:Flash", []Category{CategoryTechnicalDetail}},
+	{0x920a, "FocalLength", "EXIF:FocalLength", []Category{CategoryTechnicalDetail}},
+	{0xa405, "FocalLengthIn35mmFilm", "EXIF:FocalLengthIn35mmFormat", []Category{CategoryTechnicalDetail}},
+
+	{0x4746, "Rating", "EXIF:Rating", []Category{CategoryEditingInfo}},
+	{0x4749, "RatingPercent", "EXIF:RatingPercent", []Category{CategoryEditingInfo}},
+
+	{0x8825, "GPSInfo", "GPS:*", []Category{CategoryGPSInfo}}, // pointer to the GPS IFD, wiped and unlinked
+}
+
+// gpsTags is the decision table for entries inside the GPS IFD, whose tag
+// IDs are a separate namespace. Under RemoveGPSInfo every entry's value is
+// overwritten and the IFD unlinked, unless Config.GPSAction keeps the
+// position; these free-text entries are reported individually.
+var gpsTags = []tagInfo{
+	{0x001b, "GPSProcessingMethod", "GPS:GPSProcessingMethod", []Category{CategoryGPSInfo}},
+	{0x001c, "GPSAreaInformation", "GPS:GPSAreaInformation", []Category{CategoryGPSInfo}},
+}
+
+var (
+	gpsTagIndex  = indexTags(gpsTags)
+	exifTagsByID = sortTags(exifTags)
+)
+
+func indexTags(tags []tagInfo) map[uint16]*tagInfo {
+	index := make(map[uint16]*tagInfo, len(tags))
+	for i := range tags {
+		index[tags[i].ID] = &tags[i]
+	}
+	return index
+}
+
+// sortTags returns the entries of tags ordered by ID, for binary search
+func sortTags(tags []tagInfo) []*tagInfo {
+	sorted := make([]*tagInfo, len(tags))
+	for i := range tags {
+		sorted[i] = &tags[i]
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted
+}
+
+// TagName returns the name of an IFD0 or EXIF IFD tag, or its ID as
+// "0x%04X" for tags outside the table
+func TagName(id uint16) string {
+	if t := lookupTag(id); t != nil {
+		return t.Name
+	}
+	return string(appendHexTag(nil, id))
+}
+
+// AppendTagName appends the name TagName returns to dst, without
+// allocating when dst has room
+func AppendTagName(dst []byte, id uint16) []byte {
+	if t := lookupTag(id); t != nil {
+		return append(dst, t.Name...)
+	}
+	return appendHexTag(dst, id)
+}
+
+func lookupTag(id uint16) *tagInfo {
+	i := sort.Search(len(exifTagsByID), func(i int) bool { return exifTagsByID[i].ID >= id })
+	if i < len(exifTagsByID) && exifTagsByID[i].ID == id {
+		return exifTagsByID[i]
+	}
+	return nil
+}
+
+// appendHexTag appends id as 0x followed by four upper-case hex digits
+func appendHexTag(dst []byte, id uint16) []byte {
+	const digits = "0123456789ABCDEF"
+	return append(dst, '0', 'x',
+		digits[id>>12], digits[id>>8&0xf], digits[id>>4&0xf], digits[id&0xf])
+}
+
+// exifTag returns the table entry for an IFD0 or EXIF IFD tag, or a bare
+// entry named by its hex ID for tags outside the table, with
+// config.CategoryOverrides applied to its categories
+func exifTag(tag uint16, config Config) *tagInfo {
+	if t, ok := config.tags[tag]; ok {
+		return t
+	}
+	t := lookupTag(tag)
+	if t == nil {
+		t = &tagInfo{ID: tag, Name: string(appendHexTag(nil, tag))}
+	}
+	if len(config.CategoryOverrides) == 0 {
+		return t
+	}
+
+	overridden := *t
+	overridden.Categories = nil
+	for _, c := range t.Categories {
+		if _, replaced := config.CategoryOverrides[c]; !replaced || config.MergeCategoryOverrides {
+			overridden.Categories = append(overridden.Categories, c)
+		}
+	}
+	for c := CategoryCameraInfo; c <= lastCategory; c++ {
+		for _, id := range config.CategoryOverrides[c] {
+			if id == tag && !overridden.hasCategory(c) {
+				overridden.Categories = append(overridden.Categories, c)
+				break
+			}
+		}
+	}
+	return &overridden
+}
+
+func (t *tagInfo) hasCategory(c Category) bool {
+	for _, tc := range t.Categories {
+		if tc == c {
+			return true
+		}
+	}
+	return false
+}
+
+// removed reports whether any of the tag's categories is enabled in config
+func (t *tagInfo) removed(config Config) bool {
+	for _, c := range t.Categories {
+		if c.enabled(config) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeTag reports whether an IFD0 or EXIF IFD tag should be removed. The
+// walkers consult it for every entry, so a tag that a broken writer repeated
+// within one IFD has all of its copies removed, not just the first.
+func removeTag(tag uint16, config Config) bool {
+	remove, _ := tagDecision(tag, config)
+	return remove
+}
+
+// customTag reports whether config.CustomTagsToRemove lists tag
+func customTag(tag uint16, config Config) bool {
+	for _, id := range config.CustomTagsToRemove {
+		if id == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// structuralTags are the EXIF IFD entries EXIF 2.32 requires whenever an
+// EXIF IFD exists: ExifVersion, ComponentsConfiguration and
+// FlashpixVersion. Strict readers reject metadata without them, and some
+// rewriters regenerate them with fresh timestamps alongside.
+var structuralTags = map[uint16]bool{0x9000: true, 0x9101: true, 0xa000: true}
+
+// printSafeTags are the IFD entries a TIFF needs to still print as it did:
+// the separation's inks, dot range and transfer curves, and how its image
+// data is encoded, laid out and colour-managed. Prepress workflows send
+// CMYK TIFFs without an ICC profile, where losing InkSet or
+// TransferFunction silently changes the output on press. They are never
+// removed, whatever the overrides, custom tags and value rules say.
+var printSafeTags = map[uint16]string{
+	0x0103: "Compression",
+	0x0106: "PhotometricInterpretation",
+	0x0111: "StripOffsets",
+	0x0116: "RowsPerStrip",
+	0x0117: "StripByteCounts",
+	0x011a: "XResolution",
+	0x011b: "YResolution",
+	0x0128: "ResolutionUnit",
+	0x012d: "TransferFunction",
+	0x0142: "TileWidth",
+	0x0143: "TileLength",
+	0x0144: "TileOffsets",
+	0x0145: "TileByteCounts",
+	0x014c: "InkSet",
+	0x0150: "DotRange",
+	0x8773: "ICC_Profile",
+}
+
+// keptTag reports whether a tag is protected from category and value rule
+// removal: the print-safe tags, the orientation under PreserveOrientation,
+// and the structural tags unless RemoveStructuralTags is set. RemoveAll
+// still drops them with the rest of the container.
+func keptTag(tag uint16, config Config) bool {
+	_, printSafe := printSafeTags[tag]
+	return printSafe || tag == tagOrientation && config.PreserveOrientation ||
+		structuralTags[tag] && !config.RemoveStructuralTags
+}
+
+// removeGPSTag reports whether a GPS IFD entry should be wiped
+func removeGPSTag(tag uint16, config Config) bool {
+	if customTag(tag, config) {
+		return true
+	}
+	t, ok := gpsTagIndex[tag]
+	return ok && t.removed(config)
+}
+
+// ExiftoolTags returns the exiftool group:tag names of the fields removed
+// under category c in every carrier (EXIF, GPS, IPTC, XMP and PNG text), for checking
+// output with exiftool and for documenting what a category covers. A "*"
+// tag name stands for the whole group: the GPS IFD is unlinked entirely
+// under RemoveGPSInfo, and maker notes are removed as one block. The
+// structural tags, kept unless Config.RemoveStructuralTags is set, are left
+// out.
+func ExiftoolTags(c Category) []string {
+	var names []string
+	for i, tags := range [][]tagInfo{exifTags, gpsTags} {
+		for _, t := range tags {
+			if i == 0 && structuralTags[t.ID] {
+				continue
+			}
+			if t.hasCategory(c) {
+				names = append(names, t.Exiftool)
+			}
+		}
+	}
+	for _, d := range iptcDatasets {
+		for _, dc := range d.Categories {
+			if dc == c {
+				names = append(names, d.Exiftool)
+			}
+		}
+	}
+	for _, p := range xmpProperties {
+		for _, pc := range p.Categories {
+			if pc == c {
+				names = append(names, p.Exiftool)
+			}
+		}
+	}
+	for _, k := range pngTextKeys {
+		for _, kc := range k.Categories {
+			if kc == c {
+				names = append(names, k.Exiftool)
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,89 @@
+package exifremover
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// benchTagIDs mixes tags from the table with unknown ones, as a camera
+// file's IFDs do
+var benchTagIDs = []uint16{0x010f, 0x0110, 0x0132, 0x9003, 0xa431, 0x927c, 0xc4a5, 0x0001, 0xfe00, 0x8769}
+
+// mapTagName is the map lookup TagName replaced, with fmt for unknown tags,
+// kept as the baseline of the benchmarks
+var tagNames = func() map[uint16]string {
+	names := make(map[uint16]string, len(exifTags))
+	for _, t := range exifTags {
+		names[t.ID] = t.Name
+	}
+	return names
+}()
+
+func mapTagName(id uint16) string {
+	if name, ok := tagNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", id)
+}
+
+func TestTagNameMatchesMap(t *testing.T) {
+	for id := 0; id <= 0xffff; id++ {
+		if got, want := TagName(uint16(id)), mapTagName(uint16(id)); got != want {
+			t.Fatalf("TagName(0x%04X) = %q, want %q", id, got, want)
+		}
+		if got := string(AppendTagName([]byte("x"), uint16(id))); got != "x"+mapTagName(uint16(id)) {
+			t.Fatalf("AppendTagName(0x%04X) = %q", id, got)
+		}
+	}
+}
+
+func TestTagNameAllocations(t *testing.T) {
+	buf := make([]byte, 0, 64)
+	if n := testing.AllocsPerRun(100, func() {
+		for _, id := range benchTagIDs {
+			buf = AppendTagName(buf[:0], id)
+		}
+	}); n != 0 {
+		t.Errorf("AppendTagName allocates %v times per run", n)
+	}
+	if n := testing.AllocsPerRun(100, func() { _ = TagName(0x010f) }); n != 0 {
+		t.Errorf("TagName of a known tag allocates %v times", n)
+	}
+}
+
+func BenchmarkTagName(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = TagName(benchTagIDs[i%len(benchTagIDs)])
+	}
+}
+
+func BenchmarkAppendTagName(b *testing.B) {
+	b.ReportAllocs()
+	buf := make([]byte, 0, 64)
+	for i := 0; i < b.N; i++ {
+		buf = AppendTagName(buf[:0], benchTagIDs[i%len(benchTagIDs)])
+	}
+}
+
+func BenchmarkTagNameMap(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = mapTagName(benchTagIDs[i%len(benchTagIDs)])
+	}
+}
+
+// BenchmarkInspect measures the inventory path per file; divide by the
+// sample's dozen tags for the per-tag overhead
+func BenchmarkInspect(b *testing.B) {
+	in := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Inspect(bytes.NewReader(in)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package exifremover
+
+import (
+	"fmt"
+	"io"
+)
+
+// TeeSanitize reads an image from r once, copying the untouched bytes to
+// originalW and writing the sanitized image to sanitizedW. Memory is
+// bounded by the streaming core, so a slow writer holds back reading
+// rather than buffering the input. If either writer fails, reading stops
+// and nothing more is written to the other; the returned error says which
+// writer failed. On success originalW has received all of r, including any
+// bytes after the image.
+func TeeSanitize(r io.Reader, originalW, sanitizedW io.Writer, config Config) (*Report, error) {
+	original := &failWriter{w: originalW}
+	sanitized := &failWriter{w: sanitizedW}
+
+	report, err := RemoveReport(io.TeeReader(r, original), sanitized, config)
+	if err == nil {
+		_, err = io.Copy(original, r)
+	}
+	switch {
+	case original.err != nil:
+		return nil, fmt.Errorf("original writer: %w", original.err)
+	case sanitized.err != nil:
+		return nil, fmt.Errorf("sanitized writer: %w", sanitized.err)
+	case err != nil:
+		return nil, err
+	}
+	return report, nil
+}
+
+// failWriter remembers the first error from w and refuses writes after it
+type failWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (f *failWriter) Write(p []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	n, err := f.w.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	f.err = err
+	return n, err
+}
@@ -0,0 +1,150 @@
+package exifremover
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// teeInput is a JPEG with metadata and an entropy-coded scan large enough
+// that the streaming core needs many reads, followed by trailing bytes
+func teeInput() []byte {
+	in := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	scan := bytes.Repeat([]byte{0x12, 0x34, 0x56, 0x78}, 256<<10)
+	out := append(append([]byte(nil), in[:len(in)-2]...), scan...) // Before the EOI
+	return append(append(out, 0xFF, 0xD9), "trailer"...)
+}
+
+// failAfter accepts n bytes and fails every write past them
+type failAfter struct {
+	n       int
+	written bytes.Buffer
+	err     error
+}
+
+func (f *failAfter) Write(p []byte) (int, error) {
+	if f.written.Len()+len(p) > f.n {
+		k := f.n - f.written.Len()
+		f.written.Write(p[:k])
+		return k, f.err
+	}
+	return f.written.Write(p)
+}
+
+// countingSource counts the bytes read from r
+type countingSource struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingSource) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func TestTeeSanitize(t *testing.T) {
+	in := teeInput()
+	config := Config{RemoveGPSInfo: true, RemoveCameraInfo: true}
+	var original, sanitized bytes.Buffer
+	report, err := TeeSanitize(bytes.NewReader(in), &original, &sanitized, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(original.Bytes(), in) {
+		t.Error("original copy differs from the input")
+	}
+	want, _ := sanitize(t, in, config)
+	if !bytes.Equal(sanitized.Bytes(), want) {
+		t.Error("sanitized copy differs from RemoveEXIFFromBytes")
+	}
+	if report == nil || len(report.Removed) == 0 || report.BytesWritten != int64(len(want)) {
+		t.Errorf("report %+v", report)
+	}
+}
+
+func TestTeeSanitizePartialFailure(t *testing.T) {
+	in := teeInput()
+	broken := errors.New("disk full")
+	for _, c := range []struct {
+		name         string
+		failOriginal bool
+		attributed   string
+	}{
+		{"original", true, "original writer"},
+		{"sanitized", false, "sanitized writer"},
+	} {
+		failing := &failAfter{n: 10000, err: broken}
+		other := &failAfter{n: len(in)}
+		originalW, sanitizedW := io.Writer(failing), io.Writer(other)
+		if !c.failOriginal {
+			originalW, sanitizedW = other, failing
+		}
+		src := &countingSource{r: bytes.NewReader(in)}
+		report, err := TeeSanitize(src, originalW, sanitizedW, Config{RemoveGPSInfo: true})
+		if !errors.Is(err, broken) || !strings.HasPrefix(err.Error(), c.attributed) {
+			t.Errorf("%s: got %v, want %s: %v", c.name, err, c.attributed, broken)
+		}
+		if report != nil {
+			t.Errorf("%s: report returned with the error", c.name)
+		}
+		// The other writer is aborted: it stops well short of the input,
+		// as reading stopped
+		if other.written.Len() >= len(in)/2 || src.n.Load() >= int64(len(in))/2 {
+			t.Errorf("%s: other writer got %d and %d read of %d bytes after the failure", c.name, other.written.Len(), src.n.Load(), len(in))
+		}
+	}
+}
+
+// gate blocks every write until it is opened
+type gate struct {
+	open    chan struct{}
+	written atomic.Int64
+}
+
+func (g *gate) Write(p []byte) (int, error) {
+	<-g.open
+	g.written.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func TestTeeSanitizeBackpressure(t *testing.T) {
+	in := teeInput()
+	for _, blocked := range []string{"original", "sanitized"} {
+		g := &gate{open: make(chan struct{})}
+		var originalW, sanitizedW io.Writer = g, io.Discard
+		if blocked == "sanitized" {
+			originalW, sanitizedW = io.Discard, g
+		}
+		src := &countingSource{r: bytes.NewReader(in)}
+		done := make(chan error, 1)
+		go func() {
+			_, err := TeeSanitize(src, originalW, sanitizedW, Config{RemoveGPSInfo: true})
+			done <- err
+		}()
+
+		// A stalled writer stops reading within the bounded buffers
+		time.Sleep(50 * time.Millisecond)
+		if read := src.n.Load(); read > 128<<10 {
+			t.Errorf("%s stalled: %d of %d bytes read ahead", blocked, read, len(in))
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("%s stalled: finished early with %v", blocked, err)
+		default:
+		}
+		close(g.open)
+		if err := <-done; err != nil {
+			t.Errorf("%s: %v", blocked, err)
+		}
+		if src.n.Load() != int64(len(in)) {
+			t.Errorf("%s: read %d of %d bytes", blocked, src.n.Load(), len(in))
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package exifremover
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// limiter is a token bucket of bytes shared by every worker of a batch,
+// for BatchOptions.MaxBytesPerSecond. Waits end with ctx.Err() once the
+// batch's context ends.
+type limiter struct {
+	ctx   context.Context
+	rate  float64 // bytes per second
+	burst int     // the bucket size, and the most one read or write moves
+
+	mu     sync.Mutex
+	tokens float64 // negative while reservations are outstanding
+	last   time.Time
+}
+
+func newLimiter(ctx context.Context, bytesPerSecond int64) *limiter {
+	burst := int(bytesPerSecond) // one second's worth
+	if int64(burst) != bytesPerSecond {
+		burst = 1 << 30
+	}
+	return &limiter{ctx: ctx, rate: float64(bytesPerSecond), burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// wait reserves n bytes and sleeps until the bucket covers them. A wait
+// cut short by the context refunds its reservation, so a cancelled file
+// doesn't hold back the files still running.
+func (l *limiter) wait(n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-l.ctx.Done():
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+		return l.ctx.Err()
+	}
+}
+
+// reader throttles reads from r; a nil limiter leaves r as it is
+func (l *limiter) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{r: r, l: l}
+}
+
+// writer throttles writes to w; a nil limiter leaves w as it is
+func (l *limiter) writer(w io.Writer) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &limitedWriter{w: w, l: l}
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *limiter
+}
+
+// Read pays for what it read after reading it, no more than a bucket at
+// a time
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.l.burst {
+		p = p[:r.l.burst]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.wait(n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type limitedWriter struct {
+	w io.Writer
+	l *limiter
+}
+
+// Write pays for each bucket's worth of p before writing it
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > w.l.burst {
+			chunk = chunk[:w.l.burst]
+		}
+		if err := w.l.wait(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// batchRetries is how many times a batch retries a file after a
+// transient error
+const batchRetries = 3
+
+// transientError reports whether err is worth retrying: a resource that
+// was briefly unavailable, the process or system out of descriptors, or
+// an NFS handle gone stale under a concurrent change
+func transientError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EMFILE) ||
+		errors.Is(err, syscall.ENFILE) || errors.Is(err, syscall.ESTALE)
+}
+
+// backoff sleeps before retry attempt, a jittered delay doubling from
+// 10ms, and returns ctx.Err() if ctx ends first
+func backoff(ctx context.Context, attempt int) error {
+	delay := 10 * time.Millisecond << (attempt - 1)
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,22 @@
+package exifremover
+
+import "io"
+
+// processTIFF handles standalone TIFF files, which are the same structure an
+// EXIF payload holds, so the IFD walkers run over the whole file. Only tag
+// values are edited; strip and tile offsets are left alone and the image
+// data keeps decoding. The JPEG previews the file references are edited
+// in place by sanitizePreviews.
+func processTIFF(r io.Reader, w io.Writer, config Config, report *Report) error {
+	data, err := readWhole(r, config)
+	if err != nil {
+		return err
+	}
+	modified, err := modifyEXIFBlob(data, config, report)
+	if err != nil {
+		return err
+	}
+	sanitizePreviews(modified, config, report)
+	_, err = w.Write(modified)
+	return err
+}
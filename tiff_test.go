@@ -0,0 +1,262 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// cmykTIFF returns a 2x2 uncompressed CMYK TIFF as a print shop sends it:
+// separation tags with no ICC profile, next to an artist, a date and a
+// scanner serial number
+func cmykTIFF() []byte {
+	le := binary.LittleEndian
+	pixels := []byte{
+		0, 64, 128, 255, 10, 20, 30, 40,
+		255, 0, 0, 0, 5, 15, 25, 35,
+	}
+	curve := make([]byte, 2*256)
+	for i := 0; i < 256; i++ {
+		le.PutUint16(curve[2*i:], uint16(i*257))
+	}
+	build := func(offset uint32) []byte {
+		t := fixture.TIFF{
+			IFD0: []fixture.Entry{
+				fixture.Short(le, 0x0100, 2),
+				fixture.Short(le, 0x0101, 2),
+				{Tag: 0x0102, Type: 3, Count: 4, Value: []byte{8, 0, 8, 0, 8, 0, 8, 0}},
+				fixture.Short(le, 0x0103, 1),
+				fixture.Short(le, 0x0106, 5), // Separated
+				fixture.Long(le, 0x0111, offset),
+				fixture.Short(le, 0x0115, 4),
+				fixture.Short(le, 0x0116, 2),
+				fixture.Long(le, 0x0117, uint32(len(pixels))),
+				fixture.Rational(le, 0x011a, 300, 1),
+				fixture.Rational(le, 0x011b, 300, 1),
+				fixture.Short(le, 0x0128, 2),
+				{Tag: 0x012d, Type: 3, Count: 256, Value: curve},
+				fixture.ASCII(0x0131, "ScanStation 4.2"),
+				fixture.ASCII(0x0132, "2023:06:14 18:42:07"),
+				fixture.ASCII(0x013b, "John Artist"),
+				fixture.Short(le, 0x014c, 1), // CMYK
+				{Tag: 0x0150, Type: 1, Count: 2, Value: []byte{0, 255}},
+			},
+			Exif: []fixture.Entry{
+				fixture.ASCII(0x9003, "2023:06:14 18:42:07"),
+				fixture.ASCII(0xa431, "SERIAL-0042"),
+			},
+			Tail: pixels,
+		}
+		return t.Bytes()
+	}
+	return build(uint32(len(build(0)) - len(pixels)))
+}
+
+// ifd0Value returns the value bytes of tag in the IFD0 of a little-endian
+// TIFF, or nil if IFD0 has no such entry
+func ifd0Value(data []byte, tag uint16) []byte {
+	le := binary.LittleEndian
+	ifd := int(le.Uint32(data[4:]))
+	for i := 0; i < int(le.Uint16(data[ifd:])); i++ {
+		pos := ifd + 2 + 12*i
+		if le.Uint16(data[pos:]) != tag {
+			continue
+		}
+		size := int(typeSizes[le.Uint16(data[pos+2:])]) * int(le.Uint32(data[pos+4:]))
+		if size <= 4 {
+			return data[pos+8 : pos+8+size]
+		}
+		offset := int(le.Uint32(data[pos+8:]))
+		return data[offset : offset+size]
+	}
+	return nil
+}
+
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1}
+
+func TestCMYKTIFFPrintSafe(t *testing.T) {
+	in := cmykTIFF()
+	// The custom tags and overrides name print-safe tags, which are
+	// protected all the same
+	config := Config{
+		RemoveCameraInfo:       true,
+		RemoveUserInfo:         true,
+		RemoveDateTime:         true,
+		RemoveGPSInfo:          true,
+		CustomTagsToRemove:     []uint16{0xa431, 0x0131, 0x014c, 0x0150, 0x012d, 0x0106},
+		CategoryOverrides:      map[Category][]uint16{CategoryUserInfo: {0x0111, 0x0117}},
+		MergeCategoryOverrides: true,
+		ValueRules:             []ValueRule{{Tag: 0x0128, Contains: ""}},
+	}
+	out, _ := sanitize(t, in, config)
+	if len(out) != len(in) {
+		t.Fatalf("size changed from %d to %d", len(in), len(out))
+	}
+	for _, s := range []string{"John Artist", "2023:06:14", "SERIAL-0042", "ScanStation"} {
+		assertAbsent(t, out, s)
+	}
+	for id, name := range printSafeTags {
+		if want := ifd0Value(in, id); !bytes.Equal(ifd0Value(out, id), want) {
+			t.Errorf("%s changed", name)
+		}
+	}
+	if !bytes.Equal(out[len(out)-16:], in[len(in)-16:]) {
+		t.Error("pixel data changed")
+	}
+
+	// With ImageMagick installed, the channel statistics must match
+	identify, err := exec.LookPath("identify")
+	if err != nil {
+		t.Skip("ImageMagick not installed")
+	}
+	stats := func(data []byte) string {
+		path := filepath.Join(t.TempDir(), "cmyk.tif")
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		report, err := exec.Command(identify, "-verbose", path).Output()
+		if err != nil {
+			t.Fatalf("identify: %v", err)
+		}
+		_, stats, _ := strings.Cut(string(report), "Channel statistics:")
+		stats, _, _ = strings.Cut(stats, "Rendering intent")
+		return stats
+	}
+	if got, want := stats(out), stats(in); got != want {
+		t.Errorf("channel statistics changed:\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestExplainPrintSafeTags(t *testing.T) {
+	e := ExplainPolicy(Config{CustomTagsToRemove: []uint16{0x014c}})
+	found := 0
+	for _, item := range e.Items {
+		if _, ok := printSafeTags[item.Tag]; !ok || item.Carrier != CarrierEXIF {
+			continue
+		}
+		found++
+		if item.Action != ActionPreserve || item.Reason != "print-safe tag" {
+			t.Errorf("%s: %s (%s)", item.Name, item.Action, item.Reason)
+		}
+	}
+	if found != len(printSafeTags) {
+		t.Errorf("%d print-safe items explained, want %d", found, len(printSafeTags))
+	}
+}
+
+// previewTIFF returns a TIFF whose primary image has a JPEG preview, in
+// IFD1 through JPEGInterchangeFormat or, with sub, in a SubIFD as a single
+// JPEG-compressed strip, as raw files store them. It also returns where
+// the preview starts.
+func previewTIFF(preview []byte, sub bool) ([]byte, int) {
+	le := binary.LittleEndian
+	build := func(offset uint32) []byte {
+		t := fixture.TIFF{
+			IFD0: []fixture.Entry{
+				fixture.ASCII(0x010f, "CanonMake"),
+				fixture.ASCII(0x013b, "John Artist"),
+			},
+			Tail: preview,
+		}
+		if !sub {
+			t.IFD1 = []fixture.Entry{fixture.Long(le, 0x0201, offset), fixture.Long(le, 0x0202, uint32(len(preview)))}
+			return t.Bytes()
+		}
+		// The SubIFD is laid out in the tail, before the preview
+		ifd := make([]byte, 2+3*12+4)
+		le.PutUint16(ifd, 3)
+		for i, e := range []fixture.Entry{
+			fixture.Short(le, 0x0103, 7),
+			fixture.Long(le, 0x0111, offset),
+			fixture.Long(le, 0x0117, uint32(len(preview))),
+		} {
+			pos := 2 + 12*i
+			le.PutUint16(ifd[pos:], e.Tag)
+			le.PutUint16(ifd[pos+2:], e.Type)
+			le.PutUint32(ifd[pos+4:], e.Count)
+			copy(ifd[pos+8:], e.Value)
+		}
+		t.IFD0 = append(t.IFD0, fixture.Long(le, 0x014a, 0))
+		t.Tail = append(ifd, preview...)
+		tiff := t.Bytes()
+		le.PutUint32(tiff[8+2+2*12+8:], uint32(len(tiff)-len(t.Tail)))
+		return tiff
+	}
+	tiff := build(0)
+	start := len(tiff) - len(preview)
+	return build(uint32(start)), start
+}
+
+// TestTIFFPreviews checks that a preview carrying its own EXIF is
+// sanitized in place, the file keeping its size and the preview decoding,
+// and that RemoveEmbeddedPreviews leaves a 1×1 gray JPEG in its place
+func TestTIFFPreviews(t *testing.T) {
+	preview := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	for _, sub := range []bool{false, true} {
+		name := "IFD1 preview"
+		if sub {
+			name = "IFD0 SubIFD0 preview"
+		}
+		in, start := previewTIFF(preview, sub)
+		t.Run(name, func(t *testing.T) {
+			out, report := sanitize(t, in, Config{RemoveGPSInfo: true, RemoveCameraInfo: true})
+			if len(out) != len(in) {
+				t.Fatalf("output is %d bytes, want %d", len(out), len(in))
+			}
+			assertAbsent(t, out, "NETWORK-Somewhere", "CanonMake", "EOS Model 5D")
+			if _, err := jpeg.Decode(bytes.NewReader(out[start:])); err != nil {
+				t.Errorf("preview doesn't decode: %v", err)
+			}
+			var found bool
+			for _, item := range report.Removed {
+				found = found || strings.HasPrefix(item.Name, name+" ")
+			}
+			if !found {
+				t.Errorf("no removal from the %s in %+v", name, report.Removed)
+			}
+
+			out, report = sanitize(t, in, Config{RemoveGPSInfo: true, RemoveEmbeddedPreviews: true})
+			if len(out) != len(in) {
+				t.Fatalf("blanked output is %d bytes, want %d", len(out), len(in))
+			}
+			img, err := jpeg.Decode(bytes.NewReader(out[start:]))
+			if err != nil {
+				t.Fatalf("blanked preview doesn't decode: %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != 1 || b.Dy() != 1 {
+				t.Errorf("blanked preview is %v, want 1×1", b)
+			}
+			assertAbsent(t, out[start:], "CanonMake", "SERIAL-0042")
+			if len(report.Removed) == 0 || report.Removed[len(report.Removed)-1].Name != name {
+				t.Errorf("preview not reported in %+v", report.Removed)
+			}
+		})
+	}
+}
+
+// TestTIFFPreviewNotInPlace checks that a preview that can't be sanitized
+// is kept with a warning, and that one too small for the gray JPEG is
+// zeroed under RemoveEmbeddedPreviews
+func TestTIFFPreviewNotInPlace(t *testing.T) {
+	broken := []byte("\xff\xd8\xff\xe1\xff\xf0Exif\x00\x00SERIAL-0042")
+	in, start := previewTIFF(broken, false)
+	out, report := sanitize(t, in, Config{RemoveGPSInfo: true})
+	if !report.hasWarning(WarnPreview) {
+		t.Error("no preview warning")
+	}
+	if !bytes.Equal(out[start:], broken) {
+		t.Error("unsanitized preview changed")
+	}
+
+	out, _ = sanitize(t, in, Config{RemoveEmbeddedPreviews: true})
+	if !bytes.Equal(out[start:], make([]byte, len(broken))) {
+		t.Errorf("small preview = %q, want zeros", out[start:])
+	}
+}
@@ -0,0 +1,111 @@
+package exifremover
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrReadTimeout is returned when a read of the input takes longer than
+// Config.ReadTimeout
+var ErrReadTimeout = errors.New("read timed out")
+
+// readDeadliner is implemented by net.Conn, *os.File and other readers
+// whose reads can be given a deadline
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// timeoutBuffer bounds each read of the fallback, and so the memory a
+// read abandoned by a timeout holds until it returns
+const timeoutBuffer = 32 << 10
+
+// timeoutReader returns r failing with ErrReadTimeout once a read takes
+// longer than timeout, and a function that clears any deadline it set.
+// The handlers read each segment, chunk and box through their reader, so
+// the deadline is refreshed before every one of them. A reader with
+// SetReadDeadline is given deadlines; any other is read on a goroutine
+// abandoned when the timer fires. A zero timeout, or a regular file whose
+// reads can't stall, is given no wrapper.
+func timeoutReader(r io.Reader, timeout time.Duration) (io.Reader, func()) {
+	if timeout <= 0 {
+		return r, func() {}
+	}
+	if d, ok := r.(readDeadliner); ok {
+		switch err := d.SetReadDeadline(time.Time{}); {
+		case err == nil:
+			return &deadlineReader{r: r, d: d, timeout: timeout}, func() { d.SetReadDeadline(time.Time{}) }
+		case errors.Is(err, os.ErrNoDeadline):
+			if f, ok := r.(interface{ Stat() (os.FileInfo, error) }); ok {
+				if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+					return r, func() {}
+				}
+			}
+		}
+	}
+	return &timerReader{r: r, timeout: timeout}, func() {}
+}
+
+// deadlineReader sets a read deadline timeout from now before every read
+type deadlineReader struct {
+	r       io.Reader
+	d       readDeadliner
+	timeout time.Duration
+}
+
+func (t *deadlineReader) Read(p []byte) (int, error) {
+	if err := t.d.SetReadDeadline(time.Now().Add(t.timeout)); err != nil {
+		return 0, err
+	}
+	n, err := t.r.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = fmt.Errorf("%w after %v", ErrReadTimeout, t.timeout)
+	}
+	return n, err
+}
+
+// timerReader reads on a goroutine and gives up on a read the timer beats.
+// An abandoned read can't be interrupted, so its goroutine lives until the
+// underlying reader returns or is closed by the caller; it reads into a
+// buffer of its own, no larger than timeoutBuffer, so it never writes into
+// memory the caller has moved on to, and every later read fails.
+type timerReader struct {
+	r       io.Reader
+	timeout time.Duration
+	buf     []byte // reused while every read has returned
+	err     error  // ErrReadTimeout once a read was abandoned
+}
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+func (t *timerReader) Read(p []byte) (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	if len(p) > timeoutBuffer {
+		p = p[:timeoutBuffer]
+	}
+	if cap(t.buf) < len(p) {
+		t.buf = make([]byte, len(p))
+	}
+	done := make(chan readResult, 1)
+	go func(buf []byte) {
+		n, err := t.r.Read(buf)
+		done <- readResult{buf[:n], err}
+	}(t.buf[:len(p)])
+
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return copy(p, res.data), res.err
+	case <-timer.C:
+		t.err = fmt.Errorf("%w after %v", ErrReadTimeout, t.timeout)
+		return 0, t.err
+	}
+}
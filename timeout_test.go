@@ -0,0 +1,138 @@
+package exifremover
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// stallingReader returns data and then blocks until release is closed
+type stallingReader struct {
+	data    []byte
+	release chan struct{}
+}
+
+func (s *stallingReader) Read(p []byte) (int, error) {
+	if len(s.data) > 0 {
+		n := copy(p, s.data)
+		s.data = s.data[n:]
+		return n, nil
+	}
+	<-s.release
+	return 0, io.EOF
+}
+
+// stallAfter is how much of a JPEG a stalling peer sends: the SOI and the
+// start of the EXIF segment
+const stallAfter = 40
+
+func TestReadTimeoutFallback(t *testing.T) {
+	input := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	r := &stallingReader{data: input[:stallAfter], release: make(chan struct{})}
+	defer close(r.release)
+
+	start := time.Now()
+	_, err := RemoveReport(r, io.Discard, Config{RemoveAll: true, ReadTimeout: 50 * time.Millisecond})
+	if !errors.Is(err, ErrReadTimeout) {
+		t.Fatalf("stalled read returned %v, want ErrReadTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timed out after %v", elapsed)
+	}
+}
+
+func TestReadTimeoutDeadline(t *testing.T) {
+	input := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		server.Write(input[:stallAfter])
+		// then stall, holding the connection open
+	}()
+	defer server.Close()
+
+	start := time.Now()
+	_, err := RemoveReport(client, io.Discard, Config{RemoveAll: true, ReadTimeout: 50 * time.Millisecond})
+	if !errors.Is(err, ErrReadTimeout) {
+		t.Fatalf("stalled connection returned %v, want ErrReadTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timed out after %v", elapsed)
+	}
+	// The deadline is cleared, so the connection is usable afterwards
+	go server.Write([]byte{1})
+	if _, err := client.Read(make([]byte, 1)); err != nil {
+		t.Errorf("connection left with a deadline: %v", err)
+	}
+}
+
+func TestReadTimeoutPassesPromptReads(t *testing.T) {
+	input := fixture.EXIFJPEG(fixture.Sample().Bytes())
+	var out bytes.Buffer
+	r := &stallingReader{data: input, release: make(chan struct{})}
+	close(r.release)
+	if _, err := RemoveReport(r, &out, Config{RemoveAll: true, ReadTimeout: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := sanitize(t, input, Config{RemoveAll: true})
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Error("timed reads changed the output")
+	}
+}
+
+// stallFS is osFS opening every file as a stallingReader over its contents
+type stallFS struct {
+	osFS
+	release chan struct{}
+}
+
+func (s stallFS) Open(name string) (file, error) {
+	f, err := s.osFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &stallingFile{file: f, r: &stallingReader{data: data[:stallAfter], release: s.release}}, nil
+}
+
+// stallingFile hides the file's SetReadDeadline and stalls its reads
+type stallingFile struct {
+	file
+	r io.Reader
+}
+
+func (f *stallingFile) Read(p []byte) (int, error) { return f.r.Read(p) }
+
+func TestReadTimeoutRemovesOutput(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.jpg"), filepath.Join(dir, "out.jpg")
+	if err := os.WriteFile(in, fixture.EXIFJPEG(fixture.Sample().Bytes()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	defer close(release)
+	useFS(t, stallFS{release: release})
+
+	start := time.Now()
+	err := RemoveEXIFSelective(in, out, Config{RemoveAll: true, ReadTimeout: 50 * time.Millisecond})
+	if !errors.Is(err, ErrReadTimeout) {
+		t.Fatalf("stalled file returned %v, want ErrReadTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timed out after %v", elapsed)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("timed-out call left its output: %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package exifremover
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// maxTraceEvents bounds Report.Trace, so a file with huge IFDs can't make
+// tracing expensive. Later events are dropped and TraceTruncated set.
+const maxTraceEvents = 4096
+
+// TraceEvent records the decision processing made for one metadata item
+// and the rule that made it
+type TraceEvent struct {
+	Carrier Carrier
+	IFD     string // "IFD0", "IFD1" or "EXIF" for EXIF entries, empty otherwise
+	Tag     uint16
+	Name    string
+	Action  Action
+	// Reason names the Config field that decided, such as
+	// "RemoveCameraInfo" or "PreserveOrientation", or for
+	// ActionUnsupported what was left untouched
+	Reason string
+}
+
+// String describes the event on one line, e.g.
+// `EXIF IFD0 Make: remove (RemoveCameraInfo)`
+func (e TraceEvent) String() string {
+	var b strings.Builder
+	b.WriteString(e.Carrier.String())
+	b.WriteByte(' ')
+	if e.IFD != "" {
+		b.WriteString(e.IFD)
+		b.WriteByte(' ')
+	}
+	b.WriteString(e.Name)
+	b.WriteString(": ")
+	b.WriteString(e.Action.String())
+	b.WriteString(" (")
+	b.WriteString(e.Reason)
+	b.WriteByte(')')
+	return b.String()
+}
+
+// WriteTrace writes Report.Trace to w, one event per line
+func (r *Report) WriteTrace(w io.Writer) error {
+	for _, e := range r.Trace {
+		if _, err := io.WriteString(w, e.String()+"\n"); err != nil {
+			return err
+		}
+	}
+	if r.TraceTruncated {
+		_, err := io.WriteString(w, "trace truncated\n")
+		return err
+	}
+	return nil
+}
+
+// tracer records Report.Trace under Config.Trace
+type tracer struct {
+	config Config
+	// the rule behind the removal of tag about to be recorded, and its IFD
+	reason, ifd string
+	tag         uint16
+}
+
+// trace records an event, if tracing
+func (r *Report) trace(e TraceEvent) {
+	if r.tracer == nil {
+		return
+	}
+	if len(r.Trace) == maxTraceEvents {
+		r.TraceTruncated = true
+		return
+	}
+	r.Trace = append(r.Trace, e)
+}
+
+// decideEntry is removeEntry for the IFD entry at pos, tracing the
+// decision. A removal's reason is held for the RemovedItem the caller
+// records next.
+func (r *Report) decideEntry(ifd string, data []byte, pos int, order binary.ByteOrder, config Config) bool {
+	remove, reason := entryDecision(data, pos, order, config)
+	r.traceDecision(ifd, order.Uint16(data[pos:pos+2]), remove, reason, config)
+	return remove
+}
+
+// decideTag is removeTag, tracing the decision as decideEntry does
+func (r *Report) decideTag(ifd string, tag uint16, config Config) bool {
+	remove, reason := tagDecision(tag, config)
+	r.traceDecision(ifd, tag, remove, reason, config)
+	return remove
+}
+
+func (r *Report) traceDecision(ifd string, tag uint16, remove bool, reason string, config Config) {
+	if r.tracer == nil {
+		return
+	}
+	if remove {
+		r.tracer.reason, r.tracer.ifd, r.tracer.tag = reason, ifd, tag
+		return
+	}
+	r.trace(TraceEvent{Carrier: CarrierEXIF, IFD: ifd, Tag: tag, Name: exifTag(tag, config).Name, Action: ActionPreserve, Reason: reason})
+}
+
+// traceKept records an item other than an EXIF entry that processing
+// left in place, and the reason, as decideEntry does for entries
+func (r *Report) traceKept(carrier Carrier, tag uint16, name, reason string) {
+	if r.tracer == nil {
+		return
+	}
+	r.trace(TraceEvent{Carrier: carrier, Tag: tag, Name: name, Action: ActionPreserve, Reason: reason})
+}
+
+// traceRemoval records a removed item, with the reason decideEntry held
+// for it or else the Config field that removes its kind
+func (r *Report) traceRemoval(item RemovedItem) {
+	if r.tracer == nil {
+		return
+	}
+	reason, ifd := r.tracer.reason, r.tracer.ifd
+	if reason == "" || item.Carrier != CarrierEXIF || item.Tag != r.tracer.tag {
+		reason, ifd = removalReason(item, r.tracer.config), ""
+	} else {
+		r.tracer.reason, r.tracer.ifd = "", ""
+	}
+	r.trace(TraceEvent{Carrier: item.Carrier, IFD: ifd, Tag: item.Tag, Name: item.Name, Action: ActionRemove, Reason: reason})
+}
+
+// categoryFlags names the Config flag of each category
+var categoryFlags = [...]string{
+	CategoryCameraInfo:      "RemoveCameraInfo",
+	CategoryGPSInfo:         "RemoveGPSInfo",
+	CategoryCopyright:       "RemoveCopyright",
+	CategoryDateTime:        "RemoveDateTime",
+	CategoryUserInfo:        "RemoveUserInfo",
+	CategoryTechnicalDetail: "RemoveTechnicalDetail",
+	CategoryEditingInfo:     "RemoveEditingInfo",
+	CategoryFaceRegions:     "RemoveFaceRegions",
+}
+
+// carrierFlags names the Config field removing each carrier outright
+var carrierFlags = map[Carrier]string{
+	CarrierIPTC:           "RemoveIPTC",
+	CarrierText:           "RemoveTextChunks or TextKeysToRemove",
+	CarrierThumbnail:      "RemoveThumbnail",
+	CarrierMakerNote:      "RemoveUserInfo",
+	CarrierAuxiliaryImage: "RemoveAuxiliaryImages",
+	CarrierComment:        "RemoveComments",
+	CarrierAudio:          "RemoveVendorSegments",
+	CarrierICC:            "RemoveICCProfile",
+	CarrierOther:          "RemoveUnknownChunks",
+}
+
+// removalReason names the Config field behind a removal no entry decision
+// covers: whole containers, GPS IFD entries and the other carriers
+func removalReason(item RemovedItem, config Config) string {
+	for _, c := range item.Categories {
+		if c.enabled(config) {
+			return categoryFlags[c]
+		}
+	}
+	switch {
+	case strings.HasPrefix(item.Name, "empty "):
+		return "DropEmptyMetadata"
+	case item.Carrier == CarrierEXIF && item.Tag != 0:
+		return "CustomTagsToRemove"
+	case item.Carrier == CarrierEXIF && config.RemoveAll:
+		return "RemoveAll"
+	case item.Carrier == CarrierEXIF:
+		return "Permissive"
+	}
+	return carrierFlags[item.Carrier]
+}
+
+// tagDecision is removeTag with the rule that decided it
+func tagDecision(tag uint16, config Config) (bool, string) {
+	if _, ok := printSafeTags[tag]; ok {
+		return false, "print-safe tag"
+	}
+	switch {
+	case tag == tagOrientation && config.PreserveOrientation:
+		return false, "PreserveOrientation"
+	case structuralTags[tag] && !config.RemoveStructuralTags:
+		return false, "structural tag without RemoveStructuralTags"
+	case customTag(tag, config):
+		return true, "CustomTagsToRemove"
+	}
+	t := exifTag(tag, config)
+	for _, c := range t.Categories {
+		if !c.enabled(config) {
+			continue
+		}
+		if len(config.CategoryOverrides) > 0 {
+			if builtin := lookupTag(tag); builtin == nil || !builtin.hasCategory(c) {
+				return true, "CategoryOverrides"
+			}
+		}
+		return true, categoryFlags[c]
+	}
+	if len(t.Categories) == 0 {
+		return false, "in no category"
+	}
+	return false, "no enabled category"
+}
+
+// entryDecision is removeEntry with the rule that decided it
+func entryDecision(data []byte, pos int, order binary.ByteOrder, config Config) (bool, string) {
+	tag := order.Uint16(data[pos : pos+2])
+	if keptTag(tag, config) {
+		return tagDecision(tag, config)
+	}
+	for _, rule := range config.ValueRules {
+		if rule.Tag != tag {
+			continue
+		}
+		value, ok := entryString(data, pos, order)
+		if ok && rule.matches(value) {
+			if rule.Keep {
+				return false, "ValueRules keep"
+			}
+			return true, "ValueRules"
+		}
+	}
+	return tagDecision(tag, config)
+}
@@ -0,0 +1,101 @@
+package exifremover
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// keptEvents returns the reason of every ActionPreserve event of carrier
+// in report's trace, by item name
+func keptEvents(report *Report, carrier Carrier) map[string]string {
+	kept := make(map[string]string)
+	for _, e := range report.Trace {
+		if e.Carrier == carrier && e.Action == ActionPreserve {
+			kept[e.Name] = e.Reason
+		}
+	}
+	return kept
+}
+
+// checkKept fails the test unless kept holds exactly want
+func checkKept(t *testing.T, carrier Carrier, kept, want map[string]string) {
+	t.Helper()
+	for name, reason := range want {
+		if kept[name] != reason {
+			t.Errorf("%s %s kept for %q, want %q", carrier, name, kept[name], reason)
+		}
+	}
+	for name := range kept {
+		if _, ok := want[name]; !ok {
+			t.Errorf("%s %s traced as kept", carrier, name)
+		}
+	}
+}
+
+// TestTraceKeptJPEG checks that the trace of a JPEG lists every XMP
+// property and IPTC dataset left in the file, and none that was removed
+func TestTraceKeptJPEG(t *testing.T) {
+	in := locationJPEG()
+	_, report := sanitize(t, in, Config{RemoveGPSInfo: true, Trace: true})
+	checkKept(t, CarrierXMP, keptEvents(report, CarrierXMP), map[string]string{
+		"dc:format":         "in no category",
+		"exif:ExposureTime": "in no category",
+		"dc:title":          "in no category",
+	})
+	checkKept(t, CarrierIPTC, keptEvents(report, CarrierIPTC), map[string]string{
+		"dataset 2:120": "in no category",
+	})
+	if kept := keptEvents(report, CarrierEXIF); kept["Make"] != "no enabled category" {
+		t.Errorf("EXIF Make kept for %q", kept["Make"])
+	}
+
+	// A policy that leaves APP13 alone still traces its datasets
+	_, report = sanitize(t, in, Config{RemoveCameraInfo: true, Trace: true})
+	kept := keptEvents(report, CarrierIPTC)
+	if kept["City"] != "no enabled category" || kept["dataset 2:120"] != "in no category" || len(kept) != 6 {
+		t.Errorf("IPTC kept = %v", kept)
+	}
+	if kept := keptEvents(report, CarrierXMP); kept["photoshop:City"] != "no enabled category" || kept["Iptc4xmpExt:LocationShown"] != "no enabled category" {
+		t.Errorf("XMP kept = %v", kept)
+	}
+}
+
+// TestTraceKeptPNG checks that the trace of a PNG lists the text and
+// ancillary chunks passed through
+func TestTraceKeptPNG(t *testing.T) {
+	in := fixture.WithChunks(fixture.PNG(8, 8),
+		fixture.Chunk("tEXt", []byte("Comment\x00hello")),
+		fixture.Chunk("tEXt", []byte("Software\x00Editor 2")),
+		fixture.Chunk("tEXt", []byte("Author\x00John Artist")),
+		fixture.Chunk("tIME", []byte{0x07, 0xe7, 6, 14, 18, 42, 7}),
+		fixture.Chunk("prVt", []byte("private")),
+	)
+	_, report := sanitize(t, in, Config{RemoveUserInfo: true, Trace: true})
+	checkKept(t, CarrierText, keptEvents(report, CarrierText), map[string]string{
+		"tEXt Comment":  "in no category",
+		"tEXt Software": "no enabled category",
+	})
+	checkKept(t, CarrierOther, keptEvents(report, CarrierOther), map[string]string{
+		"tIME chunk": "registered chunk type",
+		"prVt chunk": "unknown chunk without RemoveUnknownChunks",
+	})
+
+	_, report = sanitize(t, in, Config{RemoveUnknownChunks: true, Trace: true})
+	if _, ok := keptEvents(report, CarrierOther)["prVt chunk"]; ok {
+		t.Error("removed prVt chunk traced as kept")
+	}
+}
+
+func TestXMPPropertyNames(t *testing.T) {
+	packet := fixture.XMP(`xmlns:dc="http://purl.org/dc/elements/1.1/" dc:format="image/jpeg" xml:lang="en"`,
+		`<dc:title><rdf:Alt><rdf:li xml:lang="x-default">Title</rdf:li></rdf:Alt></dc:title>`+
+			`<xmpMM:History><rdf:Seq><rdf:li><rdf:Description stEvt:action="saved"/></rdf:li></rdf:Seq></xmpMM:History>`+
+			`<xmp:Rating/>`)
+	got := xmpPropertyNames(packet)
+	want := []string{"dc:format", "dc:title", "xmpMM:History", "stEvt:action", "xmp:Rating"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("xmpPropertyNames = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// exifPrefix precedes the TIFF header in JPEG APP1 EXIF payloads
+var exifPrefix = []byte("Exif\x00\x00")
+
+// TranslateMetadata converts an EXIF payload from the conventions of one
+// container to another, so metadata that survived sanitization can be carried
+// into a re-encoded image. JPEG APP1 payloads start with "Exif\0\0"; PNG eXIf
+// and WebP EXIF chunks hold the bare TIFF structure; HEIC Exif items start
+// with a 4-byte offset to the TIFF header. The TIFF structure itself is copied
+// unchanged, and the result never aliases metadata.
+func TranslateMetadata(src, dst Format, metadata []byte) ([]byte, error) {
+	tiff, err := exifTIFF(src, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if len(tiff) < 8 || !(bytes.HasPrefix(tiff, []byte("II*\x00")) || bytes.HasPrefix(tiff, []byte("MM\x00*"))) {
+		return nil, corrupt("invalid TIFF header")
+	}
+
+	switch dst {
+	case FormatJPEG:
+		return append(append([]byte{}, exifPrefix...), tiff...), nil
+	case FormatPNG, FormatWebP:
+		return append([]byte{}, tiff...), nil
+	case FormatHEIC:
+		out := binary.BigEndian.AppendUint32(nil, uint32(len(exifPrefix)))
+		out = append(out, exifPrefix...)
+		return append(out, tiff...), nil
+	}
+	return nil, fmt.Errorf("%w: no EXIF payload convention for %s", ErrUnsupportedFormat, dst)
+}
+
+// exifTIFF returns the TIFF structure inside an EXIF payload from format f
+func exifTIFF(f Format, metadata []byte) ([]byte, error) {
+	switch f {
+	case FormatJPEG:
+		if !bytes.HasPrefix(metadata, exifPrefix) {
+			return nil, corrupt("missing Exif prefix")
+		}
+		return metadata[len(exifPrefix):], nil
+	case FormatPNG, FormatWebP:
+		// Some WebP writers keep the JPEG-style prefix
+		return bytes.TrimPrefix(metadata, exifPrefix), nil
+	case FormatHEIC:
+		if len(metadata) < 4 {
+			return nil, corrupt("truncated HEIC Exif item")
+		}
+		offset := int64(binary.BigEndian.Uint32(metadata))
+		if offset > int64(len(metadata)-4) {
+			return nil, corrupt("invalid HEIC Exif header offset")
+		}
+		return metadata[4+offset:], nil
+	}
+	return nil, fmt.Errorf("%w: no EXIF payload convention for %s", ErrUnsupportedFormat, f)
+}
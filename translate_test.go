@@ -0,0 +1,105 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/renix-codex/exifremover/internal/fixture"
+)
+
+// exifPayloads returns tiff in the EXIF payload convention of each
+// container TranslateMetadata converts between
+func exifPayloads(tiff []byte) map[Format][]byte {
+	return map[Format][]byte{
+		FormatJPEG: append([]byte("Exif\x00\x00"), tiff...),
+		FormatPNG:  tiff,
+		FormatWebP: tiff,
+		FormatHEIC: append([]byte("\x00\x00\x00\x06Exif\x00\x00"), tiff...),
+	}
+}
+
+func TestTranslateMetadata(t *testing.T) {
+	for _, order := range []string{"II", "MM"} {
+		tiff := fixture.Sample().Bytes()
+		if order == "MM" {
+			sample := fixture.Sample()
+			sample.Order = binary.BigEndian
+			tiff = sample.Bytes()
+		}
+		payloads := exifPayloads(tiff)
+		for src, in := range payloads {
+			for dst, want := range payloads {
+				t.Run(order+" "+src.String()+" to "+dst.String(), func(t *testing.T) {
+					snapshot := append([]byte(nil), in...)
+					got, err := TranslateMetadata(src, dst, in)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, want) {
+						t.Fatalf("got %q, want %q", got[:16], want[:16])
+					}
+					if len(got) > 0 {
+						got[len(got)-1] ^= 0xff
+					}
+					if !bytes.Equal(in, snapshot) {
+						t.Fatal("result aliases the input")
+					}
+					got[len(got)-1] ^= 0xff
+
+					back, err := TranslateMetadata(dst, src, got)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(back, in) {
+						t.Error("round trip changed the payload")
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestTranslateMetadataContainers checks that a translated payload is one
+// each container's handler reads, by sanitizing a file built around it
+func TestTranslateMetadataContainers(t *testing.T) {
+	jpegPayload := exifPayloads(fixture.Sample().Bytes())[FormatJPEG]
+	for dst, build := range map[Format]func([]byte) []byte{
+		FormatJPEG: func(p []byte) []byte { return fixture.WithSegment(fixture.JPEG(8, 8), 0xE1, p) },
+		FormatPNG:  func(p []byte) []byte { return fixture.WithChunks(fixture.PNG(8, 8), fixture.Chunk("eXIf", p)) },
+		FormatWebP: func(p []byte) []byte { return fixture.WebP(fixture.WebPChunk("EXIF", p)) },
+	} {
+		payload, err := TranslateMetadata(FormatJPEG, dst, jpegPayload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := build(payload)
+		if m := inspect(t, in); !m.hasTag("GPS", 0x0002) {
+			t.Fatalf("%s: translated EXIF not found", dst)
+		}
+		out, _ := sanitize(t, in, Config{RemoveGPSInfo: true})
+		assertAbsent(t, out, "NETWORK-Somewhere")
+	}
+}
+
+func TestTranslateMetadataRejected(t *testing.T) {
+	tiff := fixture.Sample().Bytes()
+	for name, c := range map[string]struct {
+		src, dst Format
+		in       []byte
+		want     error
+	}{
+		"unknown source":       {FormatTIFF, FormatJPEG, tiff, ErrUnsupportedFormat},
+		"unknown destination":  {FormatPNG, FormatUnknown, tiff, ErrUnsupportedFormat},
+		"JPEG without prefix":  {FormatJPEG, FormatPNG, tiff, ErrCorruptImage},
+		"not a TIFF":           {FormatPNG, FormatJPEG, []byte("GIF89a\x00\x00\x00\x00"), ErrCorruptImage},
+		"short TIFF":           {FormatWebP, FormatJPEG, []byte("II*\x00"), ErrCorruptImage},
+		"truncated HEIC":       {FormatHEIC, FormatJPEG, []byte{0, 0}, ErrCorruptImage},
+		"HEIC offset past end": {FormatHEIC, FormatJPEG, append([]byte{0xff, 0xff, 0xff, 0xff}, tiff...), ErrCorruptImage},
+	} {
+		if _, err := TranslateMetadata(c.src, c.dst, c.in); !errors.Is(err, c.want) {
+			t.Errorf("%s: err = %v, want %v", name, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package exifremover
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+)
+
+// Severity ranks how sensitive a piece of leftover metadata is
+type Severity int
+
+const (
+	SeverityLow Severity = iota
+	SeverityMedium
+	SeverityHigh
+)
+
+// String returns a lower-case name for the severity
+func (s Severity) String() string {
+	switch s {
+	case SeverityMedium:
+		return "medium"
+	case SeverityHigh:
+		return "high"
+	}
+	return "low"
+}
+
+// MarshalText encodes the severity by name
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Violation is metadata found in a file that the policy says must be gone
+type Violation struct {
+	Carrier  Carrier
+	Tag      uint16
+	Name     string
+	Severity Severity
+}
+
+// VerifyClean reports the metadata that processing r with config would
+// still remove. A file is clean under a policy when the list is empty, which
+// holds for this library's own output and can be checked on output produced
+// by any other tool.
+func VerifyClean(r io.Reader, config Config) ([]Violation, error) {
+	s, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	return s.Verify(r)
+}
+
+// verifyReport lists the removals of a report as violations
+func verifyReport(report *Report, err error) ([]Violation, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	var violations []Violation
+	for _, item := range report.Removed {
+		violations = append(violations, Violation{
+			Carrier:  item.Carrier,
+			Tag:      item.Tag,
+			Name:     item.Name,
+			Severity: itemSeverity(item),
+		})
+	}
+	return violations, nil
+}
+
+// itemSeverity ranks location, identity and hidden-image data highest
+func itemSeverity(item RemovedItem) Severity {
+	if item.Carrier == CarrierAuxiliaryImage || item.Carrier == CarrierThumbnail || item.Carrier == CarrierAudio {
+		return SeverityHigh
+	}
+	if item.Carrier == CarrierIPTC { // bylines, captions and places
+		return SeverityMedium
+	}
+	severity := SeverityLow
+	for _, c := range item.Categories {
+		switch c {
+		case CategoryGPSInfo, CategoryFaceRegions:
+			return SeverityHigh
+		case CategoryCameraInfo, CategoryUserInfo, CategoryCopyright:
+			severity = SeverityMedium
+		}
+	}
+	return severity
+}
+
+// AllowlistEntry marks one violation as acceptable for one exact file
+type AllowlistEntry struct {
+	SHA256 string `json:"sha256"` // hex digest of the file contents
+	Name   string `json:"name"`   // tag or element name, as in Violation.Name
+}
+
+// Allowlist holds known-acceptable violations, matched by file hash and name
+type Allowlist struct {
+	entries map[AllowlistEntry]bool
+}
+
+// LoadAllowlist reads an allowlist stored as a JSON array of entries
+func LoadAllowlist(r io.Reader) (*Allowlist, error) {
+	var entries []AllowlistEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, err
+	}
+	a := &Allowlist{entries: make(map[AllowlistEntry]bool, len(entries))}
+	for _, e := range entries {
+		a.entries[e] = true
+	}
+	return a, nil
+}
+
+// Allows reports whether v is allowlisted for the file with the given hash.
+// A nil Allowlist allows nothing.
+func (a *Allowlist) Allows(fileHash string, v Violation) bool {
+	return a != nil && a.entries[AllowlistEntry{SHA256: fileHash, Name: v.Name}]
+}
+
+// FileHash returns the hex SHA-256 of r's contents, as used in allowlists
+func FileHash(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
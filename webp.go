@@ -0,0 +1,133 @@
+package exifremover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// VP8X feature flags for the metadata chunks
+const (
+	vp8xXMP  = 0x04
+	vp8xEXIF = 0x08
+	vp8xICC  = 0x20
+)
+
+// processWebP handles WebP files. Chunks are copied through in order, with
+// their padding, so a file with nothing to remove comes out byte-identical.
+func processWebP(r io.Reader, w io.Writer, config Config, report *Report) error {
+	data, err := readWhole(r, config)
+	if err != nil {
+		return err
+	}
+	if len(data) < 12 {
+		return corrupt("truncated WebP header")
+	}
+	end := 8 + int64(binary.LittleEndian.Uint32(data[4:8]))
+	if end > int64(len(data)) {
+		return corrupt("RIFF size past end of file")
+	}
+	body, trailer := data[12:end], data[end:]
+
+	var output bytes.Buffer
+	output.Write(data[:12])
+	vp8x := -1 // offset of the VP8X flags byte in output
+	var dropped byte
+	for pos := 0; pos < len(body); {
+		if len(body)-pos < 8 {
+			return corrupt("truncated WebP chunk header")
+		}
+		fourCC := string(body[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(body[pos+4 : pos+8]))
+		next := pos + 8 + size + size&1
+		if size > len(body)-pos-8 {
+			return corrupt("WebP chunk past end of file")
+		}
+		if next > len(body) {
+			next = len(body) // final chunk missing its pad byte
+		}
+		chunk := body[pos:next]
+		payload := body[pos+8 : pos+8+size]
+		pos = next
+
+		switch fourCC {
+		case "VP8X":
+			vp8x = output.Len() + 8
+			report.readVP8X(payload)
+		case "EXIF":
+			if config.DropEmptyMetadata && isEmptyEXIF(payload) {
+				report.remove(RemovedItem{Carrier: CarrierEXIF, Name: "empty chunk", Strength: RemovalEliminated, Size: int64(size)})
+				dropped |= vp8xEXIF
+				continue
+			}
+			if config.RemoveAll {
+				report.remove(RemovedItem{Carrier: CarrierEXIF, Name: "EXIF chunk", Strength: RemovalEliminated, Size: int64(size)})
+				if kept := orientationEXIF(payload); config.PreserveOrientation && kept != nil {
+					output.Write(webpChunk("EXIF", kept))
+					continue
+				}
+				dropped |= vp8xEXIF
+				continue
+			}
+			modified, err := modifyEXIFBlob(payload, config, report)
+			if err == errByteOrder && config.Permissive {
+				report.remove(RemovedItem{Carrier: CarrierEXIF, Name: "EXIF chunk", Strength: RemovalEliminated, Size: int64(size)})
+				dropped |= vp8xEXIF
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			// Edits are in place, so the size and padding are unchanged
+			chunk = append([]byte(nil), chunk...)
+			copy(chunk[8:], modified)
+		case "XMP ":
+			if config.DropEmptyMetadata && isEmptyXMP(append(append([]byte(nil), xmpSegmentPrefix...), payload...)) {
+				report.remove(RemovedItem{Carrier: CarrierXMP, Name: "empty packet", Strength: RemovalEliminated, Size: int64(size)})
+				dropped |= vp8xXMP
+				continue
+			}
+			chunk = append([]byte(nil), chunk...)
+			modifyXMP(chunk[8:8+size], config, report)
+			if config.Minify {
+				if packet := minifyXMP(chunk[8:8+size], 0, report); len(packet) < size {
+					chunk = webpChunk(fourCC, packet)
+				}
+			}
+		case "ICCP":
+			if config.RemoveICCProfile {
+				report.remove(RemovedItem{Carrier: CarrierICC, Name: "ICCP chunk", Strength: RemovalEliminated, Size: int64(size)})
+				dropped |= vp8xICC
+				continue
+			}
+			if scrubICC(config) {
+				chunk = append([]byte(nil), chunk...)
+				scrubICCProfile(chunk[8:8+size], config, true)
+			}
+		}
+		output.Write(chunk)
+	}
+
+	out := output.Bytes()
+	if vp8x >= 0 && vp8x < len(out) {
+		out[vp8x] &^= dropped
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	if _, err := w.Write(out); err != nil {
+		return err
+	}
+	_, err = w.Write(trailer)
+	return err
+}
+
+// webpChunk builds a chunk with its header and padding
+func webpChunk(fourCC string, payload []byte) []byte {
+	chunk := make([]byte, 8, 8+len(payload)+1)
+	copy(chunk, fourCC)
+	binary.LittleEndian.PutUint32(chunk[4:8], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
@@ -0,0 +1,469 @@
+package exifremover
+
+import (
+	"bytes"
+	"strings"
+)
+
+// xmpSegmentPrefix is the namespace signature that starts JPEG APP1 XMP
+var xmpSegmentPrefix = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+// xmpExtensionPrefix starts the JPEG APP1 segments of extended XMP, which
+// carry a packet too large for one segment in chunks
+var xmpExtensionPrefix = []byte("http://ns.adobe.com/xmp/extension/\x00")
+
+// auxiliaryExtensions records, by GUID, which extended XMP packets of a
+// JPEG embed auxiliary images, as Google cameras store GDepth and GImage
+// data. Each packet is judged by its first chunk seen, normally the one at
+// offset zero, which opens the packet and declares its namespaces.
+type auxiliaryExtensions map[string]bool
+
+// auxiliary reports whether the extended XMP chunk in an APP1 payload
+// belongs to a packet embedding auxiliary images
+func (a auxiliaryExtensions) auxiliary(data []byte) bool {
+	chunk := data[len(xmpExtensionPrefix):]
+	if len(chunk) < 40 { // GUID, full length, offset
+		return false
+	}
+	guid := string(chunk[:32])
+	if aux, ok := a[guid]; ok {
+		return aux
+	}
+	aux := bytes.Contains(chunk[40:], []byte("http://ns.google.com/photos/1.0/depthmap/")) ||
+		bytes.Contains(chunk[40:], []byte("http://ns.google.com/photos/1.0/image/"))
+	a[guid] = aux
+	return aux
+}
+
+// isEmptyXMP reports whether an APP1 payload is an XMP packet with nothing
+// in it: only a byte order mark, whitespace padding, and the xpacket
+// processing instructions. Packets without the xpacket wrapper (a bare
+// rdf:RDF, as Lightroom sometimes writes) and malformed XML are never
+// treated as empty, so they are passed through rather than rejected.
+func isEmptyXMP(data []byte) bool {
+	if !bytes.HasPrefix(data, xmpSegmentPrefix) {
+		return false
+	}
+	body := data[len(xmpSegmentPrefix):]
+	for {
+		body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")) // UTF-8 BOM
+		body = bytes.TrimLeft(body, " \t\r\n\x00")
+		if len(body) == 0 {
+			return true
+		}
+		if !bytes.HasPrefix(body, []byte("<?xpacket")) {
+			return false
+		}
+		end := bytes.Index(body, []byte("?>"))
+		if end < 0 {
+			return false
+		}
+		body = body[end+2:]
+	}
+}
+
+// minifiedXMPPadding is the padding Minify leaves in an XMP packet, the
+// low end of the 2-4KB the XMP specification recommends so that editors can
+// update the packet in place
+const minifiedXMPPadding = 2048
+
+// minifyXMP trims the whitespace padding before the closing xpacket
+// instruction of the packet starting at offset start of data down to
+// minifiedXMPPadding bytes. data is returned unchanged if there is less
+// padding than that; otherwise the result is a new slice.
+func minifyXMP(data []byte, start int, report *Report) []byte {
+	packet := data[start:]
+	end := bytes.LastIndex(packet, []byte("<?xpacket end="))
+	if end < 0 {
+		return data
+	}
+	padding := end
+	for padding > 0 && bytes.IndexByte([]byte(" \t\r\n"), packet[padding-1]) >= 0 {
+		padding--
+	}
+	saved := end - padding - minifiedXMPPadding
+	if saved <= 0 {
+		return data
+	}
+
+	out := make([]byte, 0, len(data)-saved)
+	out = append(out, data[:start+padding+minifiedXMPPadding]...)
+	out = append(out, packet[end:]...)
+	report.minified(CarrierXMP, saved)
+	return out
+}
+
+// xmpProperty describes an XMP structure the library acts on
+type xmpProperty struct {
+	// Name is the qualified name as conventionally prefixed, or a prefix
+	// ending in ':' standing for every property of the namespace
+	Name       string
+	Exiftool   string     // exiftool group:tag name for the same property
+	Categories []Category // removed when any of these is enabled
+}
+
+// xmpProperties is the decision table for XMP packets. Properties are
+// matched by their conventional prefix, which is how every known writer
+// emits them, whether as elements or as attributes of rdf:Description.
+// The drone namespaces carry flight telemetry — position, altitude,
+// attitude and speed — more precise than the GPS IFD, the exif:GPS
+// properties copy the GPS IFD itself, and the IPTC and Photoshop location
+// fields name the place, so all of them go with it.
+var xmpProperties = []xmpProperty{
+	{"mwg-rs:Regions", "XMP-mwg-rs:RegionInfo", []Category{CategoryFaceRegions}},
+	{"MP:RegionInfo", "XMP-MP:RegionInfo", []Category{CategoryFaceRegions}},
+	{"drone-dji:", "XMP-drone-dji:*", []Category{CategoryGPSInfo}},
+	{"drone-parrot:", "XMP-drone-parrot:*", []Category{CategoryGPSInfo}},
+
+	{"exif:GPSVersionID", "XMP-exif:GPSVersionID", []Category{CategoryGPSInfo}},
+	{"exif:GPSLatitude", "XMP-exif:GPSLatitude", []Category{CategoryGPSInfo}},
+	{"exif:GPSLongitude", "XMP-exif:GPSLongitude", []Category{CategoryGPSInfo}},
+	{"exif:GPSAltitudeRef", "XMP-exif:GPSAltitudeRef", []Category{CategoryGPSInfo}},
+	{"exif:GPSAltitude", "XMP-exif:GPSAltitude", []Category{CategoryGPSInfo}},
+	{"exif:GPSTimeStamp", "XMP-exif:GPSTimeStamp", []Category{CategoryGPSInfo}},
+	{"exif:GPSSatellites", "XMP-exif:GPSSatellites", []Category{CategoryGPSInfo}},
+	{"exif:GPSStatus", "XMP-exif:GPSStatus", []Category{CategoryGPSInfo}},
+	{"exif:GPSMeasureMode", "XMP-exif:GPSMeasureMode", []Category{CategoryGPSInfo}},
+	{"exif:GPSDOP", "XMP-exif:GPSDOP", []Category{CategoryGPSInfo}},
+	{"exif:GPSSpeedRef", "XMP-exif:GPSSpeedRef", []Category{CategoryGPSInfo}},
+	{"exif:GPSSpeed", "XMP-exif:GPSSpeed", []Category{CategoryGPSInfo}},
+	{"exif:GPSTrackRef", "XMP-exif:GPSTrackRef", []Category{CategoryGPSInfo}},
+	{"exif:GPSTrack", "XMP-exif:GPSTrack", []Category{CategoryGPSInfo}},
+	{"exif:GPSImgDirectionRef", "XMP-exif:GPSImgDirectionRef", []Category{CategoryGPSInfo}},
+	{"exif:GPSImgDirection", "XMP-exif:GPSImgDirection", []Category{CategoryGPSInfo}},
+	{"exif:GPSMapDatum", "XMP-exif:GPSMapDatum", []Category{CategoryGPSInfo}},
+	{"exif:GPSDestLatitude", "XMP-exif:GPSDestLatitude", []Category{CategoryGPSInfo}},
+	{"exif:GPSDestLongitude", "XMP-exif:GPSDestLongitude", []Category{CategoryGPSInfo}},
+	{"exif:GPSDestBearingRef", "XMP-exif:GPSDestBearingRef", []Category{CategoryGPSInfo}},
+	{"exif:GPSDestBearing", "XMP-exif:GPSDestBearing", []Category{CategoryGPSInfo}},
+	{"exif:GPSDestDistanceRef", "XMP-exif:GPSDestDistanceRef", []Category{CategoryGPSInfo}},
+	{"exif:GPSDestDistance", "XMP-exif:GPSDestDistance", []Category{CategoryGPSInfo}},
+	{"exif:GPSProcessingMethod", "XMP-exif:GPSProcessingMethod", []Category{CategoryGPSInfo}},
+	{"exif:GPSAreaInformation", "XMP-exif:GPSAreaInformation", []Category{CategoryGPSInfo}},
+	{"exif:GPSDifferential", "XMP-exif:GPSDifferential", []Category{CategoryGPSInfo}},
+	{"exif:GPSHPositioningError", "XMP-exif:GPSHPositioningError", []Category{CategoryGPSInfo}},
+
+	{"Iptc4xmpCore:Location", "XMP-iptcCore:Location", []Category{CategoryGPSInfo}},
+	{"Iptc4xmpCore:CountryCode", "XMP-iptcCore:CountryCode", []Category{CategoryGPSInfo}},
+	{"Iptc4xmpExt:LocationCreated", "XMP-iptcExt:LocationCreated", []Category{CategoryGPSInfo}},
+	{"Iptc4xmpExt:LocationShown", "XMP-iptcExt:LocationShown", []Category{CategoryGPSInfo}},
+	{"photoshop:City", "XMP-photoshop:City", []Category{CategoryGPSInfo}},
+	{"photoshop:State", "XMP-photoshop:State", []Category{CategoryGPSInfo}},
+	{"photoshop:Country", "XMP-photoshop:Country", []Category{CategoryGPSInfo}},
+
+	{"xmpMM:History", "XMP-xmpMM:History", []Category{CategoryEditingInfo}},
+	{"xmpMM:DerivedFrom", "XMP-xmpMM:DerivedFrom", []Category{CategoryEditingInfo}},
+	{"photoshop:DocumentAncestors", "XMP-photoshop:DocumentAncestors", []Category{CategoryEditingInfo}},
+	{"xmp:Rating", "XMP-xmp:Rating", []Category{CategoryEditingInfo}},
+	{"MicrosoftPhoto:Rating", "XMP-microsoft:RatingPercent", []Category{CategoryEditingInfo}},
+}
+
+// xmpAuxiliaryProperties describe auxiliary images in XMP: the depth map
+// and original image GDepth and GImage embed, the Container directory
+// listing the images appended to the file, and the gain map parameters.
+// RemoveAuxiliaryImages removes them, except the gain map ones, which
+// PreserveGainMaps keeps along with the images they describe.
+var xmpAuxiliaryProperties = []xmpProperty{
+	{"GDepth:", "XMP-GDepth:*", nil},
+	{"GImage:", "XMP-GImage:*", nil},
+	{"Container:Directory", "XMP-GContainer:Directory", nil},
+	{"hdrgm:", "XMP-hdrgm:*", nil},
+	{"HDRGainMap:", "XMP-HDRGainMap:*", nil},
+}
+
+// xmpGainMapProperties are the xmpAuxiliaryProperties PreserveGainMaps keeps
+var xmpGainMapProperties = map[string]bool{"Container:Directory": true, "hdrgm:": true, "HDRGainMap:": true}
+
+// modifyXMP blanks the XMP properties config asks to remove, overwriting
+// each element or attribute and its content with spaces. Blanking keeps the
+// packet length, so the segment doesn't move, and leaves the surrounding
+// XML well formed.
+func modifyXMP(packet []byte, config Config, report *Report) {
+	for _, p := range xmpProperties {
+		if !p.removed(config) {
+			continue
+		}
+		for _, attr := range blankXMPAttributes(packet, p.Name) {
+			report.remove(RemovedItem{Carrier: CarrierXMP, Name: xmpMatchName(attr), Categories: p.Categories, Strength: RemovalOverwritten, Size: int64(len(attr))})
+		}
+		for _, element := range blankXMPElements(packet, p.Name) {
+			report.remove(RemovedItem{Carrier: CarrierXMP, Name: xmpMatchName(element), Categories: p.Categories, Strength: RemovalOverwritten, Size: int64(len(element))})
+			if p.Name == "mwg-rs:Regions" || p.Name == "MP:RegionInfo" {
+				report.FaceRegions += bytes.Count(element, []byte("<rdf:li"))
+				if bytes.Contains(element, []byte("mwg-rs:Name")) || bytes.Contains(element, []byte("MPReg:PersonDisplayName")) {
+					report.FaceRegionNames = true
+				}
+			}
+		}
+	}
+	if config.RemoveAuxiliaryImages {
+		for _, p := range xmpAuxiliaryProperties {
+			if config.PreserveGainMaps && xmpGainMapProperties[p.Name] {
+				continue
+			}
+			blanked := append(blankXMPAttributes(packet, p.Name), blankXMPElements(packet, p.Name)...)
+			for _, b := range blanked {
+				report.remove(RemovedItem{Carrier: CarrierAuxiliaryImage, Name: xmpMatchName(b), Strength: RemovalOverwritten, Size: int64(len(b))})
+			}
+		}
+	}
+	if report.tracer != nil {
+		for _, name := range xmpPropertyNames(packet) {
+			report.traceKept(CarrierXMP, 0, name, xmpKeptReason(name, config))
+		}
+	}
+}
+
+// xmpKeptReason names why the property called name was kept, in the terms
+// entryDecision uses for EXIF entries
+func xmpKeptReason(name string, config Config) string {
+	matches := func(p xmpProperty) bool {
+		return name == p.Name || isXMPNamespace(p.Name) && strings.HasPrefix(name, p.Name)
+	}
+	for _, p := range xmpAuxiliaryProperties {
+		if matches(p) {
+			if config.RemoveAuxiliaryImages {
+				return "PreserveGainMaps"
+			}
+			return "auxiliary image without RemoveAuxiliaryImages"
+		}
+	}
+	for _, p := range xmpProperties {
+		if matches(p) {
+			return "no enabled category"
+		}
+	}
+	return "in no category"
+}
+
+// xmpPropertyNames returns the names of the properties left in packet:
+// the attributes and child elements of every rdf:Description, in document
+// order. Namespace declarations and rdf: attributes aren't properties.
+func xmpPropertyNames(packet []byte) []string {
+	var names []string
+	var descriptions []int // the depths of the open rdf:Description elements
+	depth := 0
+	for i := 0; ; {
+		lt := bytes.IndexByte(packet[i:], '<')
+		if lt < 0 {
+			return names
+		}
+		i += lt
+		gt := bytes.IndexByte(packet[i:], '>')
+		if gt < 0 {
+			return names
+		}
+		tag := packet[i : i+gt+1]
+		i += gt + 1
+		switch {
+		case len(tag) < 3 || tag[1] == '?' || tag[1] == '!':
+		case tag[1] == '/':
+			depth--
+			if n := len(descriptions); n > 0 && descriptions[n-1] == depth {
+				descriptions = descriptions[:n-1]
+			}
+		default:
+			name := xmpName(tag, 1)
+			if n := len(descriptions); n > 0 && descriptions[n-1] == depth-1 {
+				names = append(names, name)
+			}
+			closed := tag[len(tag)-2] == '/'
+			if name == "rdf:Description" {
+				names = append(names, xmpAttributeNames(tag, 1+len(name))...)
+				if !closed {
+					descriptions = append(descriptions, depth)
+				}
+			}
+			if !closed {
+				depth++
+			}
+		}
+	}
+}
+
+// xmpAttributeNames returns the names of the property attributes of the
+// start tag from offset pos on
+func xmpAttributeNames(tag []byte, pos int) []string {
+	var names []string
+	for {
+		for pos < len(tag) && isXMPSpace(tag[pos]) {
+			pos++
+		}
+		name := xmpName(tag, pos)
+		if name == "" {
+			return names
+		}
+		end := xmpAttributeEnd(tag, pos+len(name))
+		if end < 0 {
+			return names
+		}
+		if strings.Contains(name, ":") && !strings.HasPrefix(name, "xmlns:") && !strings.HasPrefix(name, "rdf:") && !strings.HasPrefix(name, "xml:") {
+			names = append(names, name)
+		}
+		pos = end
+	}
+}
+
+// removed reports whether any of the property's categories is enabled
+func (p xmpProperty) removed(config Config) bool {
+	for _, c := range p.Categories {
+		if c.enabled(config) {
+			return true
+		}
+	}
+	return false
+}
+
+// blankXMPElements overwrites every element called name in packet, or
+// every element of the namespace when name ends in ':', with spaces and
+// returns copies of the blanked elements. Elements whose end can't be found
+// are left alone rather than guessing where they stop.
+func blankXMPElements(packet []byte, name string) [][]byte {
+	var blanked [][]byte
+	open := []byte("<" + name)
+	from := 0
+	for {
+		var start int
+		if isXMPNamespace(name) {
+			start = bytes.Index(packet[from:], open)
+		} else {
+			start = indexXMPTag(packet[from:], open)
+		}
+		if start < 0 {
+			return blanked
+		}
+		start += from
+		end := xmpElementEnd(packet, start, xmpName(packet, start+1))
+		if end < 0 {
+			return blanked
+		}
+		blanked = append(blanked, append([]byte{}, packet[start:end]...))
+		for i := start; i < end; i++ {
+			packet[i] = ' '
+		}
+		from = end
+	}
+}
+
+// blankXMPAttributes overwrites every attribute called name in packet, or
+// every attribute of the namespace when name ends in ':', with spaces and
+// returns copies of the blanked attributes, each a name="value" pair
+func blankXMPAttributes(packet []byte, name string) [][]byte {
+	var blanked [][]byte
+	for from := 0; ; {
+		i := bytes.Index(packet[from:], []byte(name))
+		if i < 0 {
+			return blanked
+		}
+		start := from + i
+		from = start + len(name)
+		if start == 0 || !isXMPSpace(packet[start-1]) {
+			continue
+		}
+		full := xmpName(packet, start)
+		if !isXMPNamespace(name) && full != name {
+			continue
+		}
+		end := xmpAttributeEnd(packet, start+len(full))
+		if end < 0 {
+			continue
+		}
+		blanked = append(blanked, append([]byte{}, packet[start:end]...))
+		for i := start; i < end; i++ {
+			packet[i] = ' '
+		}
+		from = end
+	}
+}
+
+// xmpAttributeEnd returns the offset just past the quoted value of the
+// attribute whose name ends at pos, or -1 if no value follows
+func xmpAttributeEnd(packet []byte, pos int) int {
+	for pos < len(packet) && isXMPSpace(packet[pos]) {
+		pos++
+	}
+	if pos == len(packet) || packet[pos] != '=' {
+		return -1
+	}
+	pos++
+	for pos < len(packet) && isXMPSpace(packet[pos]) {
+		pos++
+	}
+	if pos == len(packet) || (packet[pos] != '"' && packet[pos] != '\'') {
+		return -1
+	}
+	end := bytes.IndexByte(packet[pos+1:], packet[pos])
+	if end < 0 {
+		return -1
+	}
+	return pos + 1 + end + 1
+}
+
+// xmpName returns the qualified name starting at offset i of packet
+func xmpName(packet []byte, i int) string {
+	end := i
+	for end < len(packet) && !isXMPSpace(packet[end]) && bytes.IndexByte([]byte("=>/<\"'"), packet[end]) < 0 {
+		end++
+	}
+	return string(packet[i:end])
+}
+
+// xmpMatchName returns the name of a blanked element or attribute
+func xmpMatchName(match []byte) string {
+	return xmpName(match, len(match)-len(bytes.TrimPrefix(match, []byte("<"))))
+}
+
+func isXMPNamespace(name string) bool {
+	return name[len(name)-1] == ':'
+}
+
+func isXMPSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// indexXMPTag returns the index of the first start tag beginning with open
+// that is followed by whitespace, '>' or '/', so "<a:Regions" doesn't match
+// "<a:RegionsExtra"
+func indexXMPTag(data, open []byte) int {
+	from := 0
+	for {
+		i := bytes.Index(data[from:], open)
+		if i < 0 {
+			return -1
+		}
+		i += from
+		next := i + len(open)
+		if next < len(data) && bytes.IndexByte([]byte(" \t\r\n>/"), data[next]) >= 0 {
+			return i
+		}
+		from = next
+	}
+}
+
+// xmpElementEnd returns the offset just past the element starting at start,
+// handling self-closing tags and nested elements of the same name
+func xmpElementEnd(packet []byte, start int, name string) int {
+	gt := bytes.IndexByte(packet[start:], '>')
+	if gt < 0 {
+		return -1
+	}
+	if gt > 0 && packet[start+gt-1] == '/' {
+		return start + gt + 1
+	}
+	open, closing := []byte("<"+name), []byte("</"+name+">")
+	depth, pos := 1, start+gt+1
+	for depth > 0 {
+		c := bytes.Index(packet[pos:], closing)
+		if c < 0 {
+			return -1
+		}
+		if o := indexXMPTag(packet[pos:pos+c], open); o >= 0 {
+			depth++
+			pos += o + len(open)
+			continue
+		}
+		depth--
+		pos += c + len(closing)
+	}
+	return pos
+}